@@ -0,0 +1,318 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	db "github.com/led0nk/guestbook/internal/database"
+	"github.com/led0nk/guestbook/internal/i18n"
+	"github.com/led0nk/guestbook/internal/model"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// EntryPolicy defines what happens to the entries of a deleted account
+type EntryPolicy string
+
+const (
+	EntriesDelete    EntryPolicy = "delete"
+	EntriesAnonymize EntryPolicy = "anonymize"
+)
+
+func ParseEntryPolicy(policy string) (EntryPolicy, error) {
+	switch EntryPolicy(policy) {
+	case EntriesDelete, EntriesAnonymize:
+		return EntryPolicy(policy), nil
+	}
+	return "", errors.New("unknown entry policy: " + policy)
+}
+
+// name of the author of entries of deleted accounts
+const anonymousName = "Deleted user"
+
+// data of the account deletion form
+type deletePage struct {
+	ID          uuid.UUID
+	HasPassword bool
+	Grace       time.Duration
+	Errors      []i18n.Message
+}
+
+// profile of the user without secrets
+type profile struct {
+	ID           uuid.UUID        `json:"id"`
+	Name         string           `json:"name"`
+	Email        string           `json:"email"`
+	PendingEmail string           `json:"pendingemail,omitempty"`
+	IsAdmin      bool             `json:"isadmin"`
+	IsVerified   bool             `json:"isverified"`
+	HasPassword  bool             `json:"haspassword"`
+	Identities   []model.Identity `json:"identities,omitempty"`
+	Locale       string           `json:"locale,omitempty"`
+	DeleteAt     *time.Time       `json:"deleteat,omitempty"`
+}
+
+func newProfile(user *model.User) profile {
+	p := profile{
+		ID:           user.ID,
+		Name:         user.Name,
+		Email:        user.Email,
+		PendingEmail: user.PendingEmail,
+		IsAdmin:      user.IsAdmin,
+		IsVerified:   user.IsVerified,
+		HasPassword:  len(user.Password) > 0,
+		Identities:   user.Identities,
+		Locale:       user.Locale,
+	}
+	if !user.DeleteAt.IsZero() {
+		p.DeleteAt = &user.DeleteAt
+	}
+	return p
+}
+
+// personal data of a user for the download
+type accountExport struct {
+	ExportedAt time.Time               `json:"exportedat"`
+	Profile    profile                 `json:"profile"`
+	Entries    []*model.GuestbookEntry `json:"entries"`
+	Sessions   []model.Session         `json:"sessions"`
+	Passkeys   []*model.Credential     `json:"passkeys"`
+	// events of the audit log caused by or concerning the user
+	AuditEvents []*model.AuditEvent `json:"auditevents"`
+}
+
+// collects the personal data of the user
+func (s *Server) exportData(ctx context.Context, user *model.User) (*accountExport, error) {
+	entries, err := s.bookstore.GetEntryByID(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := s.tokenstore.ListSessions(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	passkeys, err := s.credentialstore.GetCredentialsByUserID(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	events, err := s.userEvents(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &accountExport{
+		ExportedAt:  time.Now(),
+		Profile:     newProfile(user),
+		Entries:     entries,
+		Sessions:    sessions,
+		Passkeys:    passkeys,
+		AuditEvents: events,
+	}, nil
+}
+
+// downloads the personal data of the session user as JSON
+func (s *Server) exportAccount(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.exportAccount")
+	defer span.End()
+
+	user, err := s.pathUser(r)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to get user", "error", err)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	export, err := s.exportData(ctx, user)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to export account", "error", err)
+		http.Error(w, "failed to export account", http.StatusInternalServerError)
+		return
+	}
+	s.record(ctx, r, userEvent(model.ActionAccountDataExport, user.ID), nil, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="guestbook-account.json"`)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "\t")
+	err = encoder.Encode(export)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to encode export", "error", err)
+		return
+	}
+}
+
+// shows the confirmation of the account deletion
+func (s *Server) accountDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.accountDeleteHandler")
+	defer span.End()
+
+	user, err := s.pathUser(r)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to get user", "error", err)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	err = s.render(w, r, s.templates().TmplDashboardUser, "account-delete", &deletePage{
+		ID:          user.ID,
+		HasPassword: len(user.Password) > 0,
+		Grace:       s.deletionGrace,
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to execute template", "error", err)
+		return
+	}
+}
+
+// schedules the deletion of the session user after the grace period, the user
+// confirms with the email and the password if there is one
+func (s *Server) requestAccountDeletion(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.requestAccountDeletion")
+	defer span.End()
+
+	user, err := s.pathUser(r)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to get user", "error", err)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	page := &deletePage{ID: user.ID, HasPassword: len(user.Password) > 0, Grace: s.deletionGrace}
+	switch {
+	case db.NormalizeEmail(r.FormValue("email")) != user.Email:
+		err = i18n.M("delete.error.email")
+	case page.HasPassword:
+		_, err = s.hasher.Verify(user.Password, []byte(r.FormValue("current")))
+		if err != nil {
+			err = i18n.M("password.error.current")
+		}
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.WarnContext(ctx, "account deletion not confirmed", "user", user.ID, "error", err)
+		page.Errors = []i18n.Message{i18n.From(err)}
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		err = s.render(w, r, s.templates().TmplDashboardUser, "account-delete", page)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			s.log.ErrorContext(ctx, "failed to execute template", "error", err)
+		}
+		return
+	}
+
+	before := *user
+	user.DeleteAt = time.Now().Add(s.deletionGrace)
+	err = s.userstore.UpdateUser(ctx, user)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to update user", "error", err)
+		http.Error(w, "failed to delete account", http.StatusInternalServerError)
+		return
+	}
+	s.log.InfoContext(ctx, "account deletion scheduled", "user", user.ID, "deleteat", user.DeleteAt)
+	s.record(ctx, r, userEvent(model.ActionDeletionRequest, user.ID), &before, user)
+	w.Header().Set("HX-Redirect", "/logout")
+	w.WriteHeader(http.StatusOK)
+}
+
+// cancels a scheduled deletion of the session user
+func (s *Server) cancelAccountDeletion(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.cancelAccountDeletion")
+	defer span.End()
+
+	user, err := s.pathUser(r)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to get user", "error", err)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	before := *user
+	user.DeleteAt = time.Time{}
+	err = s.userstore.UpdateUser(ctx, user)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to update user", "error", err)
+		http.Error(w, "failed to cancel deletion", http.StatusInternalServerError)
+		return
+	}
+	s.log.InfoContext(ctx, "account deletion canceled", "user", user.ID)
+	s.record(ctx, r, userEvent(model.ActionDeletionCancel, user.ID), &before, user)
+	w.Header().Set("HX-Redirect", "/user/dashboard")
+	w.WriteHeader(http.StatusOK)
+}
+
+// removes the user with the entries according to the entry policy, the
+// passkeys and the session
+func (s *Server) removeAccount(ctx context.Context, ID uuid.UUID) error {
+	var err error
+	switch s.entryPolicy {
+	case EntriesAnonymize:
+		err = s.bookstore.AnonymizeEntriesByUser(ctx, ID, anonymousName)
+	default:
+		err = s.bookstore.DeleteEntriesByUser(ctx, ID)
+	}
+	if err != nil {
+		return err
+	}
+	passkeys, err := s.credentialstore.GetCredentialsByUserID(ctx, ID)
+	if err != nil {
+		return err
+	}
+	for _, passkey := range passkeys {
+		if err := s.credentialstore.DeleteCredential(ctx, passkey.ID); err != nil {
+			return err
+		}
+	}
+	//NOTE: users without an active session have no token
+	_ = s.tokenstore.DeleteToken(ctx, ID)
+	return s.userstore.DeleteUser(ctx, ID)
+}
+
+// deletes the accounts whose grace period is over
+func (s *Server) purgeAccounts(ctx context.Context) error {
+	var span trace.Span
+	ctx, span = tracer.Start(ctx, "server.purgeAccounts")
+	defer span.End()
+
+	users, err := s.userstore.ListUser(ctx)
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		if user.DeleteAt.IsZero() || time.Now().Before(user.DeleteAt) {
+			continue
+		}
+		if err := s.removeAccount(ctx, user.ID); err != nil {
+			return err
+		}
+		s.log.InfoContext(ctx, "deleted account", "user", user.ID)
+		s.record(ctx, nil, userEvent(model.ActionUserDelete, user.ID), nil, nil)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/led0nk/guestbook/internal/model"
+)
+
+func TestExportAccount(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	f.user.Password = []byte("secret hash")
+	if _, err := f.bStore.CreateEntry(ctx, &model.GuestbookEntry{Name: f.user.Name, Message: "hello", UserID: f.user.ID}); err != nil {
+		t.Fatalf("Error creating entry: %v", err)
+	}
+	session, err := f.tStore.CreateToken(ctx, "session", testRPID, f.user.ID, false)
+	if err != nil {
+		t.Fatalf("Error creating session: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, testOrigin+"/user/dashboard/"+f.user.ID.String()+"/export", nil)
+	req.SetPathValue("ID", f.user.ID.String())
+	req.AddCookie(session)
+	rec := httptest.NewRecorder()
+	f.server.exportAccount(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), "secret hash") || strings.Contains(rec.Body.String(), session.Value) {
+		t.Errorf("Expected export without secrets, got %s", rec.Body.String())
+	}
+	var export accountExport
+	if err := json.Unmarshal(rec.Body.Bytes(), &export); err != nil {
+		t.Fatalf("Error decoding export: %v", err)
+	}
+	if export.Profile.Email != f.user.Email || !export.Profile.HasPassword {
+		t.Errorf("Expected profile of %s with password, got %+v", f.user.Email, export.Profile)
+	}
+	if len(export.Entries) != 1 || len(export.Sessions) != 1 {
+		t.Errorf("Expected 1 entry and 1 session, got %d and %d", len(export.Entries), len(export.Sessions))
+	}
+}
+
+func TestAccountDeletion(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy EntryPolicy
+		author string
+	}{
+		{name: "Delete entries", policy: EntriesDelete},
+		{name: "Anonymize entries", policy: EntriesAnonymize, author: anonymousName},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			f := newFixture(t, func(o *Options) {
+				o.EntryPolicy = test.policy
+				o.DeletionGrace = time.Hour
+			})
+			entryID, err := f.bStore.CreateEntry(ctx, &model.GuestbookEntry{Name: f.user.Name, Message: "hello", UserID: f.user.ID})
+			if err != nil {
+				t.Fatalf("Error creating entry: %v", err)
+			}
+			session, err := f.tStore.CreateToken(ctx, "session", testRPID, f.user.ID, false)
+			if err != nil {
+				t.Fatalf("Error creating session: %v", err)
+			}
+			remove := func(email string) *httptest.ResponseRecorder {
+				form := url.Values{"email": {email}}
+				req := httptest.NewRequest(http.MethodPost, testOrigin+"/user/dashboard/"+f.user.ID.String()+"/delete", strings.NewReader(form.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				req.SetPathValue("ID", f.user.ID.String())
+				req.AddCookie(session)
+				rec := httptest.NewRecorder()
+				f.server.requestAccountDeletion(rec, req)
+				return rec
+			}
+
+			if rec := remove("jane@roe.com"); rec.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("Expected status %d for a wrong email, got %d", http.StatusUnprocessableEntity, rec.Code)
+			}
+			if rec := remove("Jon@Doe.com"); rec.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+			}
+			if f.user.DeleteAt.IsZero() {
+				t.Fatalf("Expected deletion to be scheduled")
+			}
+
+			// nothing is removed during the grace period
+			if err := f.server.purgeAccounts(ctx); err != nil {
+				t.Fatalf("Error purging accounts: %v", err)
+			}
+			if user, _ := f.uStore.GetUserByID(ctx, f.user.ID); user.ID == uuid.Nil {
+				t.Fatalf("Expected user to exist during the grace period")
+			}
+
+			f.user.DeleteAt = time.Now().Add(-time.Minute)
+			if err := f.server.purgeAccounts(ctx); err != nil {
+				t.Fatalf("Error purging accounts: %v", err)
+			}
+			if user, _ := f.uStore.GetUserByID(ctx, f.user.ID); user.ID != uuid.Nil {
+				t.Errorf("Expected user to be deleted after the grace period")
+			}
+			entries, err := f.bStore.ListEntries(ctx)
+			if err != nil {
+				t.Fatalf("Error listing entries: %v", err)
+			}
+			switch {
+			case test.author == "" && len(entries) != 0:
+				t.Errorf("Expected entries to be deleted, got %d", len(entries))
+			case test.author != "" && (len(entries) != 1 || entries[0].ID != entryID || entries[0].Name != test.author || entries[0].UserID != uuid.Nil):
+				t.Errorf("Expected anonymized entry, got %+v", entries)
+			}
+		})
+	}
+}
@@ -0,0 +1,310 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/led0nk/guestbook/internal/middleware"
+	"github.com/led0nk/guestbook/internal/model"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maximum size of the body of an API request
+const maxAPIBody = 64 << 10
+
+// size of the pages of lists of the API
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+// page of a list of the API
+type page[T any] struct {
+	Items   []T `json:"items"`
+	Page    int `json:"page"`
+	PerPage int `json:"perpage"`
+	// number of items of all pages
+	Total int `json:"total"`
+}
+
+// fieldErrors are the reasons why fields of a request are invalid
+type fieldErrors map[string]string
+
+// apiError answers the request of the API with the error
+func apiError(w http.ResponseWriter, status int, message string, fields fieldErrors) {
+	middleware.WriteAPIError(w, &middleware.APIError{Status: status, Message: message, Fields: fields})
+}
+
+// invalid answers with 422 if there are field errors
+func invalid(w http.ResponseWriter, fields fieldErrors) bool {
+	if len(fields) == 0 {
+		return false
+	}
+	apiError(w, http.StatusUnprocessableEntity, "invalid request", fields)
+	return true
+}
+
+// decode reads the JSON body of r into v and answers with an error if the
+// body is no JSON object of v, unknown fields are rejected
+func decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		apiError(w, http.StatusUnsupportedMediaType, "content type must be application/json", nil)
+		return false
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBody))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+	if err == nil && decoder.Decode(&struct{}{}) != io.EOF {
+		err = errors.New("body must contain a single JSON object")
+	}
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		apiError(w, http.StatusRequestEntityTooLarge, "request body is too large", nil)
+		return false
+	case err != nil:
+		apiError(w, http.StatusBadRequest, "invalid JSON: "+err.Error(), nil)
+		return false
+	}
+	return true
+}
+
+// paginate returns the page of items selected by the query parameters page
+// and perpage, which are validated
+func paginate[T any](r *http.Request, items []T) (*page[T], fieldErrors) {
+	fields := fieldErrors{}
+	number := func(name string, fallback int, max int) int {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			return fallback
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > max {
+			fields[name] = "must be a number from 1 to " + strconv.Itoa(max)
+		}
+		return n
+	}
+	p := &page[T]{
+		Page:    number("page", 1, math.MaxInt),
+		PerPage: number("perpage", defaultPerPage, maxPerPage),
+		Total:   len(items),
+	}
+	if len(fields) > 0 {
+		return nil, fields
+	}
+	start := min((p.Page-1)*p.PerPage, len(items))
+	end := min(start+p.PerPage, len(items))
+	p.Items = append(make([]T, 0, end-start), items[start:end]...)
+	return p, nil
+}
+
+type apiUserKey struct{}
+
+// apiAuth passes requests of a valid session, sent as bearer token or
+// cookie, with admin the user of the session has to be an admin. The user is
+// returned by apiUser.
+func (s *Server) apiAuth(admin bool) func(h http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var span trace.Span
+			ctx := r.Context()
+			ctx, span = tracer.Start(ctx, "server.apiAuth")
+			defer span.End()
+
+			user, err := s.apiSession(ctx, middleware.SessionToken(r))
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+				s.log.WarnContext(ctx, "failed to authenticate API request", "error", err)
+				w.Header().Set("WWW-Authenticate", `Bearer realm="guestbook"`)
+				apiError(w, http.StatusUnauthorized, "authentication required", nil)
+				return
+			}
+			if admin && !user.IsAdmin {
+				s.log.WarnContext(ctx, "API request of a user without admin rights", "user", user.ID)
+				apiError(w, http.StatusForbidden, "admin rights required", nil)
+				return
+			}
+			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiUserKey{}, user)))
+		})
+	}
+}
+
+// apiSession returns the user of a valid session token
+func (s *Server) apiSession(ctx context.Context, token string) (*model.User, error) {
+	if token == "" {
+		return nil, errors.New("no session token")
+	}
+	valid, err := s.tokenstore.Valid(ctx, token)
+	if !valid {
+		return nil, err
+	}
+	userID, err := s.tokenstore.GetTokenValue(ctx, &http.Cookie{Value: token})
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.userstore.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.ID == uuid.Nil {
+		return nil, errors.New("user of the session doesn't exist")
+	}
+	return user, nil
+}
+
+// apiUser returns the user authenticated by apiAuth
+func apiUser(r *http.Request) *model.User {
+	user, _ := r.Context().Value(apiUserKey{}).(*model.User)
+	return user
+}
+
+// answers unknown paths of the API
+func (s *Server) apiNotFound(w http.ResponseWriter, r *http.Request) {
+	apiError(w, http.StatusNotFound, "no such endpoint: "+r.Method+" "+r.URL.Path, nil)
+}
+
+// credentials of a login of the API
+type loginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	Remember bool   `json:"remember"`
+}
+
+// session created by a login of the API, the token is sent as bearer token
+type loginResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresat"`
+	User      profile   `json:"user"`
+}
+
+// logs in with email and password and returns the session token, failed
+// attempts are throttled like the ones of the login form
+func (s *Server) apiLogin(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.apiLogin")
+	defer span.End()
+
+	var login loginRequest
+	if !decode(w, r, &login) {
+		return
+	}
+	fields := fieldErrors{}
+	if strings.TrimSpace(login.Email) == "" {
+		fields["email"] = "is required"
+	}
+	if login.Password == "" {
+		fields["password"] = "is required"
+	}
+	if invalid(w, fields) {
+		return
+	}
+	if s.throttle(w, r, accountKey(login.Email)) {
+		s.log.WarnContext(ctx, "login attempt throttled", "email", login.Email)
+		return
+	}
+	user, err := s.userstore.GetUserByEmail(ctx, login.Email)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.WarnContext(ctx, "failed to get user", "error", err)
+		apiError(w, http.StatusUnauthorized, "invalid email or password", nil)
+		return
+	}
+	rehash, err := s.hasher.Verify(user.Password, []byte(login.Password))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.WarnContext(ctx, "failed to compare passwords", "error", err)
+		s.attemptFailed(ctx, r, accountKey(login.Email))
+		s.record(ctx, r, userEvent(model.ActionLoginFailed, user.ID), nil, nil)
+		apiError(w, http.StatusUnauthorized, "invalid email or password", nil)
+		return
+	}
+	s.accountGuard.Reset(accountKey(login.Email))
+	if rehash {
+		s.rehashPassword(ctx, user, []byte(login.Password))
+	}
+	cookie, err := s.tokenstore.CreateToken(ctx, "session", s.domain, user.ID, login.Remember)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to create token", "error", err)
+		apiError(w, http.StatusInternalServerError, "failed to create session", nil)
+		return
+	}
+	event := userEvent(model.ActionLogin, user.ID)
+	event.Actor = user.ID
+	s.record(ctx, r, event, nil, nil)
+
+	session := loginResponse{Token: cookie.Value, ExpiresAt: cookie.Expires, User: newProfile(user)}
+	//NOTE: the cookie expires before a remembered session
+	if sessions, err := s.tokenstore.ListSessions(ctx, user.ID); err == nil && len(sessions) > 0 {
+		session.ExpiresAt = sessions[0].ExpiresAt
+	}
+	if err := writeJSON(w, http.StatusCreated, session); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to encode response", "error", err)
+	}
+}
+
+// lists the sessions of the user
+func (s *Server) apiSessions(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.apiSessions")
+	defer span.End()
+
+	sessions, err := s.tokenstore.ListSessions(ctx, apiUser(r).ID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to list sessions", "error", err)
+		apiError(w, http.StatusInternalServerError, "failed to list sessions", nil)
+		return
+	}
+	p, fields := paginate(r, sessions)
+	if invalid(w, fields) {
+		return
+	}
+	if err := writeJSON(w, http.StatusOK, p); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to encode response", "error", err)
+	}
+}
+
+// logs out the session of the request
+func (s *Server) apiLogout(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.apiLogout")
+	defer span.End()
+
+	user := apiUser(r)
+	err := s.tokenstore.DeleteToken(ctx, user.ID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to delete token", "error", err)
+		apiError(w, http.StatusInternalServerError, "failed to delete session", nil)
+		return
+	}
+	s.record(ctx, r, userEvent(model.ActionLogout, user.ID), nil, nil)
+	if _, err := r.Cookie("session"); err == nil {
+		middleware.SetCookie(w, r, &http.Cookie{Name: "session", Path: "/", MaxAge: -1, HttpOnly: true, SameSite: http.SameSiteLaxMode})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
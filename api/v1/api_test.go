@@ -0,0 +1,251 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/led0nk/guestbook/internal/middleware"
+	"github.com/led0nk/guestbook/internal/model"
+)
+
+// api serves a request of the method and the path of pattern by handler like
+// the routes of the API, the token is sent as bearer token
+func (f *fixture) api(pattern string, handler http.Handler, target string, body string, token string) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	mux.Handle(pattern, middleware.API(handler))
+	method, _, _ := strings.Cut(pattern, " ")
+	req := httptest.NewRequest(method, testOrigin+target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+// session returns a session token of the user
+func (f *fixture) session(t *testing.T, user *model.User) string {
+	t.Helper()
+	session, err := f.tStore.CreateToken(context.Background(), "session", testRPID, user.ID, false)
+	if err != nil {
+		t.Fatalf("Error creating session: %v", err)
+	}
+	return session.Value
+}
+
+// admin creates a verified admin
+func (f *fixture) admin(t *testing.T) *model.User {
+	t.Helper()
+	admin := &model.User{Name: "Jane Roe", Email: "jane@roe.com", IsVerified: true, IsAdmin: true}
+	if _, err := f.uStore.CreateUser(context.Background(), admin); err != nil {
+		t.Fatalf("Error creating admin: %v", err)
+	}
+	return admin
+}
+
+func decodeAPIError(t *testing.T, rec *httptest.ResponseRecorder) middleware.APIError {
+	t.Helper()
+	var apiErr middleware.APIError
+	if err := json.Unmarshal(rec.Body.Bytes(), &apiErr); err != nil {
+		t.Fatalf("Error decoding error body %q: %v", rec.Body.String(), err)
+	}
+	if apiErr.Status != rec.Code {
+		t.Errorf("Expected status %d in the body, got %d", rec.Code, apiErr.Status)
+	}
+	return apiErr
+}
+
+func TestAPILogin(t *testing.T) {
+	f := newFixture(t)
+	hash, err := f.server.hasher.Hash([]byte("password123"))
+	if err != nil {
+		t.Fatalf("Error hashing password: %v", err)
+	}
+	f.user.Password = hash
+	if err := f.uStore.UpdateUser(context.Background(), f.user); err != nil {
+		t.Fatalf("Error updating user: %v", err)
+	}
+	login := http.HandlerFunc(f.server.apiLogin)
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		fields []string
+	}{
+		{name: "Valid credentials", body: `{"email":"jon@doe.com","password":"password123"}`, status: http.StatusCreated},
+		{name: "Wrong password", body: `{"email":"jon@doe.com","password":"wrong"}`, status: http.StatusUnauthorized},
+		{name: "Unknown user", body: `{"email":"jane@roe.com","password":"password123"}`, status: http.StatusUnauthorized},
+		{name: "Missing fields", body: `{}`, status: http.StatusUnprocessableEntity, fields: []string{"email", "password"}},
+		{name: "Unknown field", body: `{"email":"jon@doe.com","password":"password123","admin":true}`, status: http.StatusBadRequest},
+		{name: "Malformed JSON", body: `{"email":`, status: http.StatusBadRequest},
+		{name: "Several objects", body: `{} {}`, status: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := f.api("POST /api/v1/sessions", login, "/api/v1/sessions", test.body, "")
+			if rec.Code != test.status {
+				t.Fatalf("Expected status %d, got %d: %s", test.status, rec.Code, rec.Body.String())
+			}
+			if rec.Code != http.StatusCreated {
+				apiErr := decodeAPIError(t, rec)
+				for _, field := range test.fields {
+					if apiErr.Fields[field] == "" {
+						t.Errorf("Expected an error of field %s, got %+v", field, apiErr.Fields)
+					}
+				}
+				return
+			}
+			var session loginResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &session); err != nil {
+				t.Fatalf("Error decoding session: %v", err)
+			}
+			if session.User.Email != f.user.Email || session.ExpiresAt.IsZero() {
+				t.Errorf("Expected session of %s with expiry, got %+v", f.user.Email, session)
+			}
+			rec = f.api("GET /api/v1/user", f.server.apiAuth(false)(http.HandlerFunc(f.server.apiProfile)), "/api/v1/user", "", session.Token)
+			if rec.Code != http.StatusOK {
+				t.Errorf("Expected status %d with the token, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestAPIContentType(t *testing.T) {
+	f := newFixture(t)
+	req := httptest.NewRequest(http.MethodPost, testOrigin+"/api/v1/sessions", strings.NewReader("email=jon@doe.com"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	middleware.API(http.HandlerFunc(f.server.apiLogin)).ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected status %d, got %d", http.StatusUnsupportedMediaType, rec.Code)
+	}
+	decodeAPIError(t, rec)
+
+	rec = f.api("POST /api/v1/sessions", http.HandlerFunc(f.server.apiLogin), "/api/v1/sessions", `{"email":"`+strings.Repeat("a", maxAPIBody)+`"}`, "")
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status %d, got %d", http.StatusRequestEntityTooLarge, rec.Code)
+	}
+}
+
+func TestAPIAuth(t *testing.T) {
+	f := newFixture(t)
+	admin := f.admin(t)
+	userToken := f.session(t, f.user)
+	adminToken := f.session(t, admin)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name   string
+		admin  bool
+		token  string
+		status int
+	}{
+		{name: "No token", token: "", status: http.StatusUnauthorized},
+		{name: "Invalid token", token: "invalid", status: http.StatusUnauthorized},
+		{name: "User", token: userToken, status: http.StatusNoContent},
+		{name: "User on admin endpoint", admin: true, token: userToken, status: http.StatusForbidden},
+		{name: "Admin on admin endpoint", admin: true, token: adminToken, status: http.StatusNoContent},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := f.api("GET /api/v1/users", f.server.apiAuth(test.admin)(ok), "/api/v1/users", "", test.token)
+			if rec.Code != test.status {
+				t.Fatalf("Expected status %d, got %d: %s", test.status, rec.Code, rec.Body.String())
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("Expected WWW-Authenticate header")
+			}
+			if rec.Code != http.StatusNoContent {
+				decodeAPIError(t, rec)
+			}
+		})
+	}
+}
+
+func TestAPILogout(t *testing.T) {
+	f := newFixture(t)
+	token := f.session(t, f.user)
+	logout := f.server.apiAuth(false)(http.HandlerFunc(f.server.apiLogout))
+
+	rec := f.api("DELETE /api/v1/sessions/current", logout, "/api/v1/sessions/current", "", token)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusNoContent, rec.Code, rec.Body.String())
+	}
+	rec = f.api("DELETE /api/v1/sessions/current", logout, "/api/v1/sessions/current", "", token)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d after logout, got %d", http.StatusUnauthorized, rec.Code)
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	items := make([]int, 45)
+	for i := range items {
+		items[i] = i
+	}
+
+	tests := []struct {
+		name    string
+		query   string
+		first   int
+		count   int
+		invalid string
+	}{
+		{name: "Default", query: "", first: 0, count: defaultPerPage},
+		{name: "Last page", query: "?page=3", first: 40, count: 5},
+		{name: "Beyond last page", query: "?page=4", count: 0},
+		{name: "Per page", query: "?page=2&perpage=10", first: 10, count: 10},
+		{name: "Page zero", query: "?page=0", invalid: "page"},
+		{name: "Not a number", query: "?page=first", invalid: "page"},
+		{name: "Too many per page", query: "?perpage=1000", invalid: "perpage"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, testOrigin+"/api/v1/entries"+test.query, nil)
+			p, fields := paginate(req, items)
+			if test.invalid != "" {
+				if fields[test.invalid] == "" {
+					t.Errorf("Expected an error of field %s, got %+v", test.invalid, fields)
+				}
+				return
+			}
+			if fields != nil {
+				t.Fatalf("Expected no errors, got %+v", fields)
+			}
+			if p.Total != len(items) || len(p.Items) != test.count {
+				t.Fatalf("Expected %d of %d items, got %d of %d", test.count, len(items), len(p.Items), p.Total)
+			}
+			if test.count > 0 && p.Items[0] != test.first {
+				t.Errorf("Expected first item %d, got %d", test.first, p.Items[0])
+			}
+		})
+	}
+}
+
+func TestAPIRateLimit(t *testing.T) {
+	f := newFixture(t)
+	limiter := middleware.NewRateLimiter(middleware.RatePolicy{
+		Name:  "test",
+		Rate:  0.001,
+		Burst: 1,
+		Key:   middleware.ByRoute(),
+	}, slog.Default())
+	handler := limiter.Middleware(http.HandlerFunc(f.server.apiListEntries))
+
+	f.api("GET /api/v1/entries", handler, "/api/v1/entries", "", "")
+	rec := f.api("GET /api/v1/entries", handler, "/api/v1/entries", "", "")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status %d, got %d", http.StatusTooManyRequests, rec.Code)
+	}
+	decodeAPIError(t, rec)
+}
@@ -0,0 +1,216 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/led0nk/guestbook/internal/model"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maximum length of the message of an entry in characters
+const maxMessageLength = 2000
+
+// errEntryNotFound is returned by apiEntry for unknown entries
+var errEntryNotFound = errors.New("entry not found")
+
+// body of creating or updating an entry, the author is the session user
+type entryRequest struct {
+	Message string `json:"message"`
+}
+
+// validate trims the message and returns the reasons why it is invalid
+func (e *entryRequest) validate() fieldErrors {
+	e.Message = strings.TrimSpace(e.Message)
+	fields := fieldErrors{}
+	switch {
+	case e.Message == "":
+		fields["message"] = "is required"
+	case utf8.RuneCountInString(e.Message) > maxMessageLength:
+		fields["message"] = "must not be longer than " + strconv.Itoa(maxMessageLength) + " characters"
+	}
+	return fields
+}
+
+// returns the entry of the ID in the path
+func (s *Server) apiEntry(r *http.Request) (*model.GuestbookEntry, error) {
+	entryID, err := uuid.Parse(r.PathValue("ID"))
+	if err != nil {
+		return nil, errEntryNotFound
+	}
+	entries, err := s.bookstore.ListEntries(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.ID == entryID {
+			return entry, nil
+		}
+	}
+	return nil, errEntryNotFound
+}
+
+// answers with the error of apiEntry
+func (s *Server) apiEntryError(w http.ResponseWriter, r *http.Request, span trace.Span, err error) {
+	if errors.Is(err, errEntryNotFound) {
+		apiError(w, http.StatusNotFound, "entry not found", nil)
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+	s.log.ErrorContext(r.Context(), "failed to get entry", "error", err)
+	apiError(w, http.StatusInternalServerError, "failed to get entry", nil)
+}
+
+// entries may be changed by their author and by admins
+func mayChange(user *model.User, entry *model.GuestbookEntry) bool {
+	return user.IsAdmin || entry.UserID == user.ID
+}
+
+// lists the entries, newest first, like the home page without a login
+func (s *Server) apiListEntries(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.apiListEntries")
+	defer span.End()
+
+	entries, err := s.bookstore.ListEntries(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to list entries", "error", err)
+		apiError(w, http.StatusInternalServerError, "failed to list entries", nil)
+		return
+	}
+	p, fields := paginate(r, entries)
+	if invalid(w, fields) {
+		return
+	}
+	if err := writeJSON(w, http.StatusOK, p); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to encode response", "error", err)
+	}
+}
+
+func (s *Server) apiGetEntry(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.apiGetEntry")
+	defer span.End()
+
+	entry, err := s.apiEntry(r)
+	if err != nil {
+		s.apiEntryError(w, r, span, err)
+		return
+	}
+	if err := writeJSON(w, http.StatusOK, entry); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to encode response", "error", err)
+	}
+}
+
+// creates an entry of the session user
+func (s *Server) apiCreateEntry(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.apiCreateEntry")
+	defer span.End()
+
+	var request entryRequest
+	if !decode(w, r, &request) || invalid(w, request.validate()) {
+		return
+	}
+	user := apiUser(r)
+	entry := model.GuestbookEntry{Name: user.Name, Message: request.Message, UserID: user.ID}
+	entryID, err := s.bookstore.CreateEntry(ctx, &entry)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to create entry", "error", err)
+		apiError(w, http.StatusInternalServerError, "failed to create entry", nil)
+		return
+	}
+	s.record(ctx, r, &model.AuditEvent{Action: model.ActionEntryCreate, Actor: user.ID, Target: entryID, TargetType: targetEntry}, nil, &entry)
+	w.Header().Set("Location", "/api/v1/entries/"+entryID.String())
+	if err := writeJSON(w, http.StatusCreated, &entry); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to encode response", "error", err)
+	}
+}
+
+// replaces the message of an entry
+func (s *Server) apiUpdateEntry(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.apiUpdateEntry")
+	defer span.End()
+
+	entry, err := s.apiEntry(r)
+	if err != nil {
+		s.apiEntryError(w, r, span, err)
+		return
+	}
+	user := apiUser(r)
+	if !mayChange(user, entry) {
+		s.log.WarnContext(ctx, "entry of another user", "user", user.ID, "entry", entry.ID)
+		apiError(w, http.StatusForbidden, "entry belongs to another user", nil)
+		return
+	}
+	var request entryRequest
+	if !decode(w, r, &request) || invalid(w, request.validate()) {
+		return
+	}
+	before := *entry
+	updated := model.GuestbookEntry{ID: entry.ID, Message: request.Message}
+	err = s.bookstore.UpdateEntry(ctx, &updated)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to update entry", "error", err)
+		apiError(w, http.StatusInternalServerError, "failed to update entry", nil)
+		return
+	}
+	s.record(ctx, r, &model.AuditEvent{Action: model.ActionEntryUpdate, Actor: user.ID, Target: entry.ID, TargetType: targetEntry}, &before, &updated)
+	if err := writeJSON(w, http.StatusOK, &updated); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to encode response", "error", err)
+	}
+}
+
+func (s *Server) apiDeleteEntry(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.apiDeleteEntry")
+	defer span.End()
+
+	entry, err := s.apiEntry(r)
+	if err != nil {
+		s.apiEntryError(w, r, span, err)
+		return
+	}
+	user := apiUser(r)
+	if !mayChange(user, entry) {
+		s.log.WarnContext(ctx, "entry of another user", "user", user.ID, "entry", entry.ID)
+		apiError(w, http.StatusForbidden, "entry belongs to another user", nil)
+		return
+	}
+	err = s.bookstore.DeleteEntry(ctx, entry.ID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to delete entry", "error", err)
+		apiError(w, http.StatusInternalServerError, "failed to delete entry", nil)
+		return
+	}
+	s.record(ctx, r, &model.AuditEvent{Action: model.ActionEntryDelete, Actor: user.ID, Target: entry.ID, TargetType: targetEntry}, entry, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,146 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/led0nk/guestbook/internal/model"
+)
+
+func TestAPIListEntries(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	for i := 0; i < 3; i++ {
+		if _, err := f.bStore.CreateEntry(ctx, &model.GuestbookEntry{Name: f.user.Name, Message: "hello", UserID: f.user.ID}); err != nil {
+			t.Fatalf("Error creating entry: %v", err)
+		}
+	}
+
+	rec := f.api("GET /api/v1/entries", http.HandlerFunc(f.server.apiListEntries), "/api/v1/entries?perpage=2", "", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var p page[model.GuestbookEntry]
+	if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+		t.Fatalf("Error decoding page: %v", err)
+	}
+	if p.Total != 3 || len(p.Items) != 2 || p.PerPage != 2 {
+		t.Errorf("Expected 2 of 3 entries, got %+v", p)
+	}
+	if rec.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("Expected Cache-Control no-store, got %q", rec.Header().Get("Cache-Control"))
+	}
+
+	rec = f.api("GET /api/v1/entries", http.HandlerFunc(f.server.apiListEntries), "/api/v1/entries?perpage=0", "", "")
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status %d, got %d", http.StatusUnprocessableEntity, rec.Code)
+	}
+}
+
+func TestAPICreateEntry(t *testing.T) {
+	f := newFixture(t)
+	token := f.session(t, f.user)
+	create := f.server.apiAuth(false)(http.HandlerFunc(f.server.apiCreateEntry))
+
+	tests := []struct {
+		name   string
+		body   string
+		token  string
+		status int
+	}{
+		{name: "Valid entry", body: `{"message":"  hello  "}`, token: token, status: http.StatusCreated},
+		{name: "Without session", body: `{"message":"hello"}`, status: http.StatusUnauthorized},
+		{name: "Empty message", body: `{"message":"   "}`, token: token, status: http.StatusUnprocessableEntity},
+		{name: "Too long message", body: `{"message":"` + strings.Repeat("a", maxMessageLength+1) + `"}`, token: token, status: http.StatusUnprocessableEntity},
+		{name: "Foreign author", body: `{"message":"hello","userid":"` + uuid.NewString() + `"}`, token: token, status: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := f.api("POST /api/v1/entries", create, "/api/v1/entries", test.body, test.token)
+			if rec.Code != test.status {
+				t.Fatalf("Expected status %d, got %d: %s", test.status, rec.Code, rec.Body.String())
+			}
+			if rec.Code != http.StatusCreated {
+				decodeAPIError(t, rec)
+				return
+			}
+			var entry model.GuestbookEntry
+			if err := json.Unmarshal(rec.Body.Bytes(), &entry); err != nil {
+				t.Fatalf("Error decoding entry: %v", err)
+			}
+			if entry.Message != "hello" || entry.UserID != f.user.ID || entry.Name != f.user.Name {
+				t.Errorf("Expected trimmed entry of %s, got %+v", f.user.Name, entry)
+			}
+			if rec.Header().Get("Location") != "/api/v1/entries/"+entry.ID.String() {
+				t.Errorf("Expected location of the entry, got %q", rec.Header().Get("Location"))
+			}
+		})
+	}
+}
+
+func TestAPIChangeEntry(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	admin := f.admin(t)
+	other := &model.User{Name: "Max Mustermann", Email: "max@mustermann.de", IsVerified: true}
+	if _, err := f.uStore.CreateUser(ctx, other); err != nil {
+		t.Fatalf("Error creating user: %v", err)
+	}
+	update := f.server.apiAuth(false)(http.HandlerFunc(f.server.apiUpdateEntry))
+	remove := f.server.apiAuth(false)(http.HandlerFunc(f.server.apiDeleteEntry))
+
+	tests := []struct {
+		name   string
+		user   *model.User
+		status int
+	}{
+		{name: "Author", user: f.user, status: http.StatusOK},
+		{name: "Admin", user: admin, status: http.StatusOK},
+		{name: "Other user", user: other, status: http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entryID, err := f.bStore.CreateEntry(ctx, &model.GuestbookEntry{Name: f.user.Name, Message: "hello", UserID: f.user.ID})
+			if err != nil {
+				t.Fatalf("Error creating entry: %v", err)
+			}
+			token := f.session(t, test.user)
+			target := "/api/v1/entries/" + entryID.String()
+
+			rec := f.api("PATCH /api/v1/entries/{ID}", update, target, `{"message":"changed"}`, token)
+			if rec.Code != test.status {
+				t.Fatalf("Expected status %d on update, got %d: %s", test.status, rec.Code, rec.Body.String())
+			}
+			rec = f.api("GET /api/v1/entries/{ID}", http.HandlerFunc(f.server.apiGetEntry), target, "", "")
+			var entry model.GuestbookEntry
+			if err := json.Unmarshal(rec.Body.Bytes(), &entry); err != nil {
+				t.Fatalf("Error decoding entry: %v", err)
+			}
+			if changed := entry.Message == "changed"; changed != (test.status == http.StatusOK) {
+				t.Errorf("Expected message to be changed: %v, got %q", test.status == http.StatusOK, entry.Message)
+			}
+
+			wantDelete := http.StatusNoContent
+			if test.status == http.StatusForbidden {
+				wantDelete = http.StatusForbidden
+			}
+			rec = f.api("DELETE /api/v1/entries/{ID}", remove, target, "", token)
+			if rec.Code != wantDelete {
+				t.Fatalf("Expected status %d on delete, got %d: %s", wantDelete, rec.Code, rec.Body.String())
+			}
+			rec = f.api("GET /api/v1/entries/{ID}", http.HandlerFunc(f.server.apiGetEntry), target, "", "")
+			if (rec.Code == http.StatusNotFound) != (wantDelete == http.StatusNoContent) {
+				t.Errorf("Expected entry to be deleted: %v, got status %d", wantDelete == http.StatusNoContent, rec.Code)
+			}
+		})
+	}
+
+	rec := f.api("PATCH /api/v1/entries/{ID}", update, "/api/v1/entries/"+uuid.NewString(), `{"message":"changed"}`, f.session(t, f.user))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for an unknown entry, got %d", http.StatusNotFound, rec.Code)
+	}
+}
@@ -0,0 +1,287 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+	"net/mail"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	db "github.com/led0nk/guestbook/internal/database"
+	"github.com/led0nk/guestbook/internal/i18n"
+	"github.com/led0nk/guestbook/internal/model"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// changes of the session user to the own profile, omitted fields are kept
+type profileRequest struct {
+	Name   *string `json:"name"`
+	Email  *string `json:"email"`
+	Locale *string `json:"locale"`
+}
+
+// changes of an admin to a user, omitted fields are kept
+type userRequest struct {
+	Email      *string `json:"email"`
+	IsAdmin    *bool   `json:"isadmin"`
+	IsVerified *bool   `json:"isverified"`
+}
+
+// validateEmail returns the reason why a new email is invalid, a new email is
+// only used after it was confirmed by its owner
+func validateEmail(fields fieldErrors, email *string) {
+	if email == nil {
+		return
+	}
+	*email = strings.TrimSpace(*email)
+	if _, err := mail.ParseAddress(*email); err != nil {
+		fields["email"] = "must be a valid email address"
+	}
+}
+
+// changeEmail requests the confirmation of email if it differs from the one of
+// user and returns the stored user with the pending email, it answers with an
+// error if that fails
+func (s *Server) changeEmail(w http.ResponseWriter, r *http.Request, span trace.Span, user *model.User, email *string) (*model.User, bool) {
+	if email == nil || db.NormalizeEmail(*email) == user.Email {
+		return user, true
+	}
+	err := s.requestEmailChange(r.Context(), r, user.ID, *email)
+	if err == nil {
+		user, err = s.userstore.GetUserByID(r.Context(), user.ID)
+	}
+	var message i18n.Message
+	switch {
+	case err == nil:
+		return user, true
+	case errors.Is(err, db.ErrEmailTaken):
+		apiError(w, http.StatusConflict, "email is already in use", fieldErrors{"email": "is already in use"})
+	case errors.As(err, &message):
+		apiError(w, http.StatusUnprocessableEntity, "invalid request", fieldErrors{"email": message.Error()})
+	default:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(r.Context(), "failed to request email change", "error", err)
+		apiError(w, http.StatusInternalServerError, "failed to request email change", nil)
+	}
+	return nil, false
+}
+
+// returns the profile of the session user
+func (s *Server) apiProfile(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.apiProfile")
+	defer span.End()
+
+	if err := writeJSON(w, http.StatusOK, newProfile(apiUser(r))); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to encode response", "error", err)
+	}
+}
+
+// changes name, email and locale of the session user like the dashboard
+func (s *Server) apiUpdateProfile(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.apiUpdateProfile")
+	defer span.End()
+
+	var request profileRequest
+	if !decode(w, r, &request) {
+		return
+	}
+	fields := fieldErrors{}
+	if request.Name != nil {
+		*request.Name = strings.TrimSpace(*request.Name)
+		if *request.Name == "" {
+			fields["name"] = "must not be empty"
+		}
+	}
+	if request.Locale != nil {
+		if _, ok := i18n.Parse(*request.Locale); !ok {
+			fields["locale"] = "is not supported"
+		}
+	}
+	validateEmail(fields, request.Email)
+	if invalid(w, fields) {
+		return
+	}
+
+	//NOTE: the email is changed first, the other fields are kept if it fails
+	user, ok := s.changeEmail(w, r, span, apiUser(r), request.Email)
+	if !ok {
+		return
+	}
+	before := *user
+	updated := *user
+	if request.Name != nil {
+		updated.Name = *request.Name
+	}
+	if request.Locale != nil {
+		updated.Locale = preferredLocale(*request.Locale, user.Locale)
+	}
+	err := s.userstore.UpdateUser(ctx, &updated)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to update user", "error", err)
+		apiError(w, http.StatusInternalServerError, "failed to update user", nil)
+		return
+	}
+	s.record(ctx, r, userEvent(model.ActionUserUpdate, user.ID), &before, &updated)
+	if err := writeJSON(w, http.StatusOK, newProfile(&updated)); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to encode response", "error", err)
+	}
+}
+
+// returns the user of the ID in the path, answers with an error if there is
+// none
+func (s *Server) apiPathUser(w http.ResponseWriter, r *http.Request, span trace.Span) (*model.User, bool) {
+	userID, err := uuid.Parse(r.PathValue("ID"))
+	if err != nil {
+		apiError(w, http.StatusNotFound, "user not found", nil)
+		return nil, false
+	}
+	user, err := s.userstore.GetUserByID(r.Context(), userID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.WarnContext(r.Context(), "failed to get user", "error", err)
+		apiError(w, http.StatusNotFound, "user not found", nil)
+		return nil, false
+	}
+	//NOTE: unknown IDs return an empty user
+	if user.ID == uuid.Nil {
+		apiError(w, http.StatusNotFound, "user not found", nil)
+		return nil, false
+	}
+	return user, true
+}
+
+// lists the profiles of all users by email
+func (s *Server) apiListUsers(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.apiListUsers")
+	defer span.End()
+
+	users, err := s.userstore.ListUser(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to list users", "error", err)
+		apiError(w, http.StatusInternalServerError, "failed to list users", nil)
+		return
+	}
+	profiles := make([]profile, 0, len(users))
+	for _, user := range users {
+		profiles = append(profiles, newProfile(user))
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Email < profiles[j].Email })
+	p, fields := paginate(r, profiles)
+	if invalid(w, fields) {
+		return
+	}
+	if err := writeJSON(w, http.StatusOK, p); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to encode response", "error", err)
+	}
+}
+
+func (s *Server) apiGetUser(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.apiGetUser")
+	defer span.End()
+
+	user, ok := s.apiPathUser(w, r, span)
+	if !ok {
+		return
+	}
+	if err := writeJSON(w, http.StatusOK, newProfile(user)); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to encode response", "error", err)
+	}
+}
+
+// changes the rights, the verification and the email of a user like the
+// admin dashboard
+func (s *Server) apiUpdateUser(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.apiUpdateUser")
+	defer span.End()
+
+	user, ok := s.apiPathUser(w, r, span)
+	if !ok {
+		return
+	}
+	var request userRequest
+	if !decode(w, r, &request) {
+		return
+	}
+	fields := fieldErrors{}
+	validateEmail(fields, request.Email)
+	if invalid(w, fields) {
+		return
+	}
+
+	//NOTE: the email is changed first, the other fields are kept if it fails
+	user, ok = s.changeEmail(w, r, span, user, request.Email)
+	if !ok {
+		return
+	}
+	before := *user
+	updated := *user
+	if request.IsAdmin != nil {
+		updated.IsAdmin = *request.IsAdmin
+	}
+	if request.IsVerified != nil {
+		updated.IsVerified = *request.IsVerified
+	}
+	err := s.userstore.UpdateUser(ctx, &updated)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to update user", "error", err)
+		apiError(w, http.StatusInternalServerError, "failed to update user", nil)
+		return
+	}
+	s.record(ctx, r, userEvent(model.ActionUserUpdate, user.ID), &before, &updated)
+	if err := writeJSON(w, http.StatusOK, newProfile(&updated)); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to encode response", "error", err)
+	}
+}
+
+// deletes a user right away with the entries according to the entry policy
+func (s *Server) apiDeleteUser(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.apiDeleteUser")
+	defer span.End()
+
+	user, ok := s.apiPathUser(w, r, span)
+	if !ok {
+		return
+	}
+	err := s.removeAccount(ctx, user.ID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to delete user", "error", err)
+		apiError(w, http.StatusInternalServerError, "failed to delete user", nil)
+		return
+	}
+	s.record(ctx, r, userEvent(model.ActionUserDelete, user.ID), nil, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,123 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/led0nk/guestbook/internal/model"
+)
+
+func TestAPIUpdateProfile(t *testing.T) {
+	f := newFixture(t)
+	f.admin(t)
+	token := f.session(t, f.user)
+	update := f.server.apiAuth(false)(http.HandlerFunc(f.server.apiUpdateProfile))
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		field  string
+	}{
+		{name: "Name and locale", body: `{"name":"Jonathan Doe","locale":"de"}`, status: http.StatusOK},
+		{name: "Taken email with name", body: `{"name":"Johnny","email":"jane@roe.com"}`, status: http.StatusConflict, field: "email"},
+		{name: "Empty name", body: `{"name":" "}`, status: http.StatusUnprocessableEntity, field: "name"},
+		{name: "Unsupported locale", body: `{"locale":"xx"}`, status: http.StatusUnprocessableEntity, field: "locale"},
+		{name: "Invalid email", body: `{"email":"jon"}`, status: http.StatusUnprocessableEntity, field: "email"},
+		{name: "New email", body: `{"email":"jonathan@doe.com"}`, status: http.StatusOK},
+		{name: "Rights", body: `{"isadmin":true}`, status: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := f.api("PATCH /api/v1/user", update, "/api/v1/user", test.body, token)
+			if rec.Code != test.status {
+				t.Fatalf("Expected status %d, got %d: %s", test.status, rec.Code, rec.Body.String())
+			}
+			if test.field != "" {
+				if apiErr := decodeAPIError(t, rec); apiErr.Fields[test.field] == "" {
+					t.Errorf("Expected an error of field %s, got %+v", test.field, apiErr.Fields)
+				}
+			}
+		})
+	}
+
+	user, err := f.uStore.GetUserByID(context.Background(), f.user.ID)
+	if err != nil {
+		t.Fatalf("Error getting user: %v", err)
+	}
+	if user.Name != "Jonathan Doe" || user.Locale != "de" || user.IsAdmin {
+		t.Errorf("Expected changed name and locale, got %+v", user)
+	}
+	if user.Email != "jon@doe.com" || user.PendingEmail != "jonathan@doe.com" || len(f.mailer.links["jonathan@doe.com"]) != 1 {
+		t.Errorf("Expected the new email to wait for its confirmation, got %q pending %q", user.Email, user.PendingEmail)
+	}
+}
+
+func TestAPIManageUsers(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	admin := f.admin(t)
+	token := f.session(t, admin)
+	target := "/api/v1/users/" + f.user.ID.String()
+
+	rec := f.api("GET /api/v1/users", f.server.apiAuth(true)(http.HandlerFunc(f.server.apiListUsers)), "/api/v1/users", "", token)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var p page[profile]
+	if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+		t.Fatalf("Error decoding page: %v", err)
+	}
+	if p.Total != 2 || p.Items[0].Email != admin.Email || p.Items[1].Email != f.user.Email {
+		t.Errorf("Expected users sorted by email, got %+v", p)
+	}
+
+	rec = f.api("PATCH /api/v1/users/{ID}", f.server.apiAuth(true)(http.HandlerFunc(f.server.apiUpdateUser)), target, `{"isadmin":true,"isverified":false}`, token)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	user, err := f.uStore.GetUserByID(ctx, f.user.ID)
+	if err != nil {
+		t.Fatalf("Error getting user: %v", err)
+	}
+	if !user.IsAdmin || user.IsVerified {
+		t.Errorf("Expected admin without verification, got %+v", user)
+	}
+	events, err := f.aStore.ListEvents(ctx, model.AuditFilter{Target: f.user.ID})
+	if err != nil {
+		t.Fatalf("Error listing events: %v", err)
+	}
+	if len(events) != 1 || events[0].Action != model.ActionUserUpdate || events[0].Actor != admin.ID {
+		t.Errorf("Expected 1 update by the admin, got %+v", events)
+	}
+
+	rec = f.api("PATCH /api/v1/users/{ID}", f.server.apiAuth(true)(http.HandlerFunc(f.server.apiUpdateUser)), target, `{"email":"jane@roe.com","isadmin":false}`, token)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("Expected status %d for a taken email, got %d: %s", http.StatusConflict, rec.Code, rec.Body.String())
+	}
+	user, err = f.uStore.GetUserByID(ctx, f.user.ID)
+	if err != nil {
+		t.Fatalf("Error getting user: %v", err)
+	}
+	if !user.IsAdmin {
+		t.Errorf("Expected the rights to be kept after a taken email, got %+v", user)
+	}
+	events, err = f.aStore.ListEvents(ctx, model.AuditFilter{Target: f.user.ID})
+	if err != nil {
+		t.Fatalf("Error listing events: %v", err)
+	}
+	if len(events) != 1 {
+		t.Errorf("Expected no update to be recorded after a taken email, got %+v", events)
+	}
+
+	rec = f.api("DELETE /api/v1/users/{ID}", f.server.apiAuth(true)(http.HandlerFunc(f.server.apiDeleteUser)), target, "", token)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusNoContent, rec.Code, rec.Body.String())
+	}
+	rec = f.api("GET /api/v1/users/{ID}", f.server.apiAuth(true)(http.HandlerFunc(f.server.apiGetUser)), target, "", token)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d after deletion, got %d", http.StatusNotFound, rec.Code)
+	}
+}
@@ -0,0 +1,206 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/led0nk/guestbook/internal/audit"
+	"github.com/led0nk/guestbook/internal/i18n"
+	"github.com/led0nk/guestbook/internal/middleware"
+	"github.com/led0nk/guestbook/internal/model"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// date format of the filter of the audit log
+const auditDate = "2006-01-02"
+
+// types of the targets of audit events
+const (
+	targetUser       = "user"
+	targetInvitation = "invitation"
+	targetCredential = "credential"
+	targetEntry      = "entry"
+)
+
+// returns an event of the action on the user
+func userEvent(action model.AuditAction, userID uuid.UUID) *model.AuditEvent {
+	return &model.AuditEvent{Action: action, Target: userID, TargetType: targetUser}
+}
+
+// data of the audit log of the admin dashboard
+type auditPage struct {
+	Events []*model.AuditEvent
+	// raw query of the filter for the export link
+	Query  string
+	Actor  string
+	Target string
+	Since  string
+	Until  string
+	Errors []i18n.Message
+}
+
+// returns the ID of the session user or uuid.Nil
+func (s *Server) sessionUser(r *http.Request) uuid.UUID {
+	token := middleware.SessionToken(r)
+	if token == "" {
+		return uuid.Nil
+	}
+	userID, err := s.tokenstore.GetTokenValue(r.Context(), &http.Cookie{Value: token})
+	if err != nil {
+		return uuid.Nil
+	}
+	return userID
+}
+
+// appends the event with the changes between before and after to the audit
+// log, r is nil for events of the system
+func (s *Server) record(ctx context.Context, r *http.Request, event *model.AuditEvent, before, after any) {
+	event.Time = time.Now()
+	event.Changes = audit.Diff(before, after)
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.HasTraceID() {
+		event.TraceID = spanContext.TraceID().String()
+	}
+	if r != nil {
+		event.IP = s.clientIP.IP(r)
+		if event.Actor == uuid.Nil {
+			event.Actor = s.sessionUser(r)
+		}
+	}
+	if err := s.auditstore.AppendEvent(ctx, event); err != nil {
+		s.log.ErrorContext(ctx, "failed to append audit event", "action", event.Action, "error", err)
+	}
+}
+
+// returns the events caused by or concerning the user, newest first
+func (s *Server) userEvents(ctx context.Context, userID uuid.UUID) ([]*model.AuditEvent, error) {
+	caused, err := s.auditstore.ListEvents(ctx, model.AuditFilter{Actor: userID})
+	if err != nil {
+		return nil, err
+	}
+	concerning, err := s.auditstore.ListEvents(ctx, model.AuditFilter{Target: userID})
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[uuid.UUID]bool, len(caused))
+	events := make([]*model.AuditEvent, 0, len(caused)+len(concerning))
+	for _, event := range append(caused, concerning...) {
+		if seen[event.ID] {
+			continue
+		}
+		seen[event.ID] = true
+		events = append(events, event)
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.After(events[j].Time) })
+	return events, nil
+}
+
+// returns the filter of the query, times are dates and until is inclusive
+func parseAuditFilter(query url.Values) (model.AuditFilter, error) {
+	var filter model.AuditFilter
+	var err error
+	if actor := query.Get("actor"); actor != "" {
+		if filter.Actor, err = uuid.Parse(actor); err != nil {
+			return filter, i18n.M("audit.error.actor")
+		}
+	}
+	if target := query.Get("target"); target != "" {
+		if filter.Target, err = uuid.Parse(target); err != nil {
+			return filter, i18n.M("audit.error.target")
+		}
+	}
+	if since := query.Get("since"); since != "" {
+		if filter.Since, err = time.ParseInLocation(auditDate, since, time.Local); err != nil {
+			return filter, i18n.M("audit.error.since")
+		}
+	}
+	if until := query.Get("until"); until != "" {
+		if filter.Until, err = time.ParseInLocation(auditDate, until, time.Local); err != nil {
+			return filter, i18n.M("audit.error.until")
+		}
+		filter.Until = filter.Until.AddDate(0, 0, 1)
+	}
+	return filter, nil
+}
+
+// shows the audit log filtered by actor, target and time
+func (s *Server) auditHandler(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.auditHandler")
+	defer span.End()
+
+	query := r.URL.Query()
+	page := &auditPage{
+		Query:  query.Encode(),
+		Actor:  query.Get("actor"),
+		Target: query.Get("target"),
+		Since:  query.Get("since"),
+		Until:  query.Get("until"),
+	}
+	filter, err := parseAuditFilter(query)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		page.Errors = []i18n.Message{i18n.From(err)}
+		w.WriteHeader(http.StatusBadRequest)
+	} else {
+		page.Events, err = s.auditstore.ListEvents(ctx, filter)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			s.log.ErrorContext(ctx, "failed to list audit events", "error", err)
+			http.Error(w, "failed to list audit events", http.StatusInternalServerError)
+			return
+		}
+	}
+	err = s.render(w, r, s.templates().TmplAudit, "", page)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to execute template", "error", err)
+		return
+	}
+}
+
+// downloads the filtered audit log as JSON
+func (s *Server) exportAudit(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.exportAudit")
+	defer span.End()
+
+	filter, err := parseAuditFilter(r.URL.Query())
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	events, err := s.auditstore.ListEvents(ctx, filter)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to list audit events", "error", err)
+		http.Error(w, "failed to list audit events", http.StatusInternalServerError)
+		return
+	}
+	s.record(ctx, r, &model.AuditEvent{Action: model.ActionAuditExport}, nil, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="guestbook-audit.json"`)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "\t")
+	err = encoder.Encode(events)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to encode audit events", "error", err)
+		return
+	}
+}
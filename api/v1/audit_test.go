@@ -0,0 +1,93 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/led0nk/guestbook/internal/model"
+)
+
+func TestAuditLog(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	f.user.DeleteAt = time.Now().Add(time.Hour)
+	if err := f.uStore.UpdateUser(ctx, f.user); err != nil {
+		t.Fatalf("Error updating user: %v", err)
+	}
+	session, err := f.tStore.CreateToken(ctx, "session", testRPID, f.user.ID, false)
+	if err != nil {
+		t.Fatalf("Error creating session: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, testOrigin+"/user/dashboard/"+f.user.ID.String()+"/delete", nil)
+	req.SetPathValue("ID", f.user.ID.String())
+	req.AddCookie(session)
+	rec := httptest.NewRecorder()
+	f.server.cancelAccountDeletion(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	events, err := f.aStore.ListEvents(ctx, model.AuditFilter{Target: f.user.ID})
+	if err != nil {
+		t.Fatalf("Error listing events: %v", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(events))
+	}
+	event := events[0]
+	if event.Action != model.ActionDeletionCancel || event.Actor != f.user.ID || event.IP == "" {
+		t.Errorf("Expected deletion cancel by the user with IP, got %+v", event)
+	}
+	if len(event.Changes) != 1 || event.Changes[0].Field != "deleteat" {
+		t.Errorf("Expected change of deleteat, got %+v", event.Changes)
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		status   int
+		expected int
+	}{
+		{name: "By target", query: "target=" + f.user.ID.String(), status: http.StatusOK, expected: 1},
+		{name: "Before the event", query: "until=2000-01-01", status: http.StatusOK, expected: 0},
+		{name: "Invalid actor", query: "actor=nobody", status: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, testOrigin+"/admin/audit/export?"+test.query, nil)
+			rec := httptest.NewRecorder()
+			f.server.exportAudit(rec, req)
+			if rec.Code != test.status {
+				t.Fatalf("Expected status %d, got %d", test.status, rec.Code)
+			}
+			if test.status != http.StatusOK {
+				return
+			}
+			var exported []*model.AuditEvent
+			if err := json.Unmarshal(rec.Body.Bytes(), &exported); err != nil {
+				t.Fatalf("Error decoding export: %v", err)
+			}
+			if len(exported) != test.expected {
+				t.Errorf("Expected %d events, got %d", test.expected, len(exported))
+			}
+		})
+	}
+
+	req = httptest.NewRequest(http.MethodGet, testOrigin+"/user/dashboard/"+f.user.ID.String()+"/export", nil)
+	req.SetPathValue("ID", f.user.ID.String())
+	req.AddCookie(session)
+	rec = httptest.NewRecorder()
+	f.server.exportAccount(rec, req)
+	var export accountExport
+	if err := json.Unmarshal(rec.Body.Bytes(), &export); err != nil {
+		t.Fatalf("Error decoding account export: %v", err)
+	}
+	if len(export.AuditEvents) != 1 || export.AuditEvents[0].Action != model.ActionDeletionCancel {
+		t.Errorf("Expected the deletion cancel in the account export, got %+v", export.AuditEvents)
+	}
+}
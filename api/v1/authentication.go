@@ -1,27 +1,51 @@
 package v1
 
 import (
+	"context"
 	"errors"
-	"html"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/led0nk/guestbook/cmd/utils"
+	db "github.com/led0nk/guestbook/internal/database"
 	"github.com/led0nk/guestbook/internal/database/jsondb"
+	"github.com/led0nk/guestbook/internal/i18n"
+	"github.com/led0nk/guestbook/internal/middleware"
 	"github.com/led0nk/guestbook/internal/model"
+	"github.com/led0nk/guestbook/internal/secrets"
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
-	"golang.org/x/crypto/bcrypt"
 )
 
+// resets the password of the session user
 func (s *Server) passwordReset(w http.ResponseWriter, r *http.Request) {
 	var span trace.Span
 	ctx := r.Context()
 	ctx, span = tracer.Start(ctx, "server.passwordReset")
 	defer span.End()
 
+	user, err := s.pathUser(r)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to get user", "error", err)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if err := s.resetPassword(ctx, r, user); err != nil {
+		http.Error(w, "failed to reset password", http.StatusInternalServerError)
+	}
+}
+
+// resets the password of any user for the admin
+func (s *Server) adminPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.adminPasswordReset")
+	defer span.End()
+
 	userID, err := uuid.Parse(r.PathValue("ID"))
 	if err != nil {
 		span.RecordError(err)
@@ -36,30 +60,50 @@ func (s *Server) passwordReset(w http.ResponseWriter, r *http.Request) {
 		s.log.ErrorContext(ctx, "failed to get user", "error", err)
 		return
 	}
-	newPW := utils.RandomString(8)
-	user.Password = []byte(newPW)
-	hashedpassword, err := bcrypt.GenerateFromPassword([]byte(newPW), 14)
+	if err := s.resetPassword(ctx, r, user); err != nil {
+		http.Error(w, "failed to reset password", http.StatusInternalServerError)
+	}
+}
+
+// mails a generated password to user and stores its hash, the plaintext is
+// never assigned to the stored user, errors are logged before they are returned
+func (s *Server) resetPassword(ctx context.Context, r *http.Request, user *model.User) error {
+	var span trace.Span
+	ctx, span = tracer.Start(ctx, "server.resetPassword")
+	defer span.End()
+
+	newPW, err := s.generatePassword(user)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to generate password", "error", err)
+		return err
+	}
+	hashedpassword, err := s.hasher.Hash([]byte(newPW))
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
 		s.log.ErrorContext(ctx, "failed to gernerate hashed password", "error", err)
-		return
+		return err
 	}
-	err = s.mailer.SendPWMail(user, s.templates)
+	updated := *user
+	updated.Password = hashedpassword
+	err = s.mailer().SendPWMail(&updated, newPW, s.templates().Mail)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
 		s.log.ErrorContext(ctx, "failed to send password-mail", "error", err)
-		return
+		return err
 	}
-	user.Password = hashedpassword
-	err = s.userstore.UpdateUser(ctx, user)
+	err = s.userstore.UpdateUser(ctx, &updated)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
 		s.log.ErrorContext(ctx, "failed to update user", "error", err)
-		return
+		return err
 	}
+	s.record(ctx, r, userEvent(model.ActionPasswordReset, updated.ID), user, &updated)
+	return nil
 }
 
 // login authentication and check if user exists
@@ -70,6 +114,10 @@ func (s *Server) loginAuth(w http.ResponseWriter, r *http.Request) {
 	defer span.End()
 
 	email := r.FormValue("email")
+	if s.throttle(w, r, accountKey(email)) {
+		s.log.WarnContext(ctx, "login attempt throttled", "email", email)
+		return
+	}
 	user, err := s.userstore.GetUserByEmail(ctx, email)
 	if err != nil {
 		span.RecordError(err)
@@ -79,13 +127,22 @@ func (s *Server) loginAuth(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := bcrypt.CompareHashAndPassword(user.Password, []byte(r.FormValue("password"))); err != nil {
+	rehash, err := s.hasher.Verify(user.Password, []byte(r.FormValue("password")))
+	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
 		s.log.ErrorContext(ctx, "failed to compare passwords", "error", err)
+		s.attemptFailed(ctx, r, accountKey(email))
+		s.record(ctx, r, userEvent(model.ActionLoginFailed, user.ID), nil, nil)
 		http.Redirect(w, r, "/login", http.StatusFound)
 		return
 	}
+	s.accountGuard.Reset(accountKey(email))
+	//NOTE: hashes of outdated algorithms or parameters are replaced while the
+	// plain password is known, failing to do so doesn't prevent the login
+	if rehash {
+		s.rehashPassword(ctx, user, []byte(r.FormValue("password")))
+	}
 	cookie, err := s.tokenstore.CreateToken(ctx, "session", s.domain, user.ID, utils.FormValueBool(r.FormValue("Rememberme")))
 	if err != nil {
 		span.RecordError(err)
@@ -93,14 +150,42 @@ func (s *Server) loginAuth(w http.ResponseWriter, r *http.Request) {
 		s.log.ErrorContext(ctx, "failed to create token", "error", err)
 		return
 	}
+	event := userEvent(model.ActionLogin, user.ID)
+	event.Actor = user.ID
+	s.record(ctx, r, event, nil, nil)
 
-	http.SetCookie(w, cookie)
+	middleware.SetCookie(w, r, cookie)
+	setPreferredLocale(w, r, user)
 	if user.IsAdmin {
 		http.Redirect(w, r, "/admin/dashboard", http.StatusFound)
 	}
 	http.Redirect(w, r, "/user/verify", http.StatusFound)
 }
 
+// replaces the password hash of user by one of the preferred algorithm
+func (s *Server) rehashPassword(ctx context.Context, user *model.User, password []byte) {
+	var span trace.Span
+	ctx, span = tracer.Start(ctx, "server.rehashPassword")
+	defer span.End()
+
+	hashedpassword, err := s.hasher.Hash(password)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to rehash password", "error", err)
+		return
+	}
+	user.Password = hashedpassword
+	err = s.userstore.UpdateUser(ctx, user)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to update user", "error", err)
+		return
+	}
+	s.log.InfoContext(ctx, "upgraded password hash", "user", user.ID)
+}
+
 // logoutAuth and deleting session-cookie
 func (s *Server) logoutAuth(w http.ResponseWriter, r *http.Request) {
 	var span trace.Span
@@ -137,8 +222,9 @@ func (s *Server) logoutAuth(w http.ResponseWriter, r *http.Request) {
 		s.log.ErrorContext(ctx, "failed to delete token", "error", err)
 		return
 	}
+	s.record(ctx, r, userEvent(model.ActionLogout, userID), nil, nil)
 	cookie.MaxAge = -1
-	http.SetCookie(w, cookie)
+	middleware.SetCookie(w, r, cookie)
 	http.Redirect(w, r, "/login", http.StatusFound)
 }
 
@@ -156,41 +242,92 @@ func (s *Server) signupAuth(w http.ResponseWriter, r *http.Request) {
 		s.log.ErrorContext(ctx, "failed to parse form", "error", err)
 		return
 	}
-	err = jsondb.ValidateUserInput(r.Form)
+	if s.registration == RegistrationClosed {
+		s.rejectSignup(w, r, http.StatusForbidden, i18n.M("signup.error.closed"))
+		return
+	}
+	err = jsondb.ValidateUserInput(r.Form, s.hasher.MaxLength())
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
 		s.log.ErrorContext(ctx, "failed to validate user input", "error", err)
-		http.Redirect(w, r, "/signup", http.StatusFound)
+		s.rejectSignup(w, r, http.StatusUnprocessableEntity, err)
+		return
+	}
+	//NOTE: checked before an invitation is redeemed, CreateUser checks again
+	existing, err := s.userstore.GetUserByEmail(ctx, r.FormValue("email"))
+	if err == nil && existing.ID != uuid.Nil {
+		s.log.WarnContext(ctx, "email is already in use")
+		s.rejectSignup(w, r, http.StatusConflict, i18n.M("error.email.taken"))
 		return
 	}
 	joinedName := strings.Join([]string{utils.Capitalize(r.FormValue("firstname")), utils.Capitalize(r.FormValue("lastname"))}, " ")
-	hashedpassword, err := bcrypt.GenerateFromPassword([]byte(r.Form.Get("password")), 14)
+	err = s.policy.Check(r.Form.Get("password"), joinedName, r.FormValue("email"))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.WarnContext(ctx, "password violates policy", "error", err)
+		s.rejectSignup(w, r, http.StatusUnprocessableEntity, err)
+		return
+	}
+	hashedpassword, err := s.hasher.Hash([]byte(r.Form.Get("password")))
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
 		s.log.ErrorContext(ctx, "failed to generate password", "error", err)
 		return
 	}
+	verificationCode, err := secrets.VerificationCode.Generate()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to generate verification code", "error", err)
+		return
+	}
+	invite := strings.TrimSpace(r.FormValue("invite"))
+	if s.registration == RegistrationInvite {
+		err = s.invitationstore.RedeemInvitation(ctx, invite, r.FormValue("email"))
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			s.log.WarnContext(ctx, "failed to redeem invitation", "error", err)
+			s.rejectSignup(w, r, http.StatusForbidden, i18n.M("signup.error.invitation"))
+			return
+		}
+	}
 	newUser := model.User{
-		Email:            html.EscapeString(r.FormValue("email")),
-		Name:             html.EscapeString(joinedName),
+		Email:            r.FormValue("email"),
+		Name:             joinedName,
 		Password:         hashedpassword,
 		IsAdmin:          false,
 		IsVerified:       false,
-		VerificationCode: utils.RandomString(6),
+		VerificationCode: verificationCode,
 		ExpirationTime:   time.Now().Add(time.Minute * 5),
+		Locale:           string(middleware.Locale(r)),
 	}
 	_, err = s.userstore.CreateUser(ctx, &newUser)
+	if err != nil && s.registration == RegistrationInvite {
+		s.refundInvitation(ctx, invite)
+	}
+	if errors.Is(err, db.ErrEmailTaken) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.WarnContext(ctx, "email is already in use", "error", err)
+		s.rejectSignup(w, r, http.StatusConflict, i18n.M("error.email.taken"))
+		return
+	}
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
 		s.log.ErrorContext(ctx, "failed to create user", "error", err)
-		http.Redirect(w, r, "/signup", http.StatusFound)
-		w.WriteHeader(http.StatusUnauthorized)
+		http.Error(w, "failed to create user", http.StatusInternalServerError)
+		return
 	}
+	event := userEvent(model.ActionSignup, newUser.ID)
+	event.Actor = newUser.ID
+	s.record(ctx, r, event, nil, &newUser)
 
-	err = s.mailer.SendVerMail(&newUser, s.domain, s.templates)
+	err = s.mailer().SendVerMail(&newUser, s.domain, s.templates().Mail)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
@@ -227,10 +364,15 @@ func (s *Server) verifyAuth(w http.ResponseWriter, r *http.Request) {
 		s.log.ErrorContext(ctx, "failed to get token value", "error", err)
 		return
 	}
+	if s.throttle(w, r, verifyKey(userID)) {
+		s.log.WarnContext(ctx, "verification attempt throttled", "user", userID)
+		return
+	}
 	ok, err := s.userstore.CodeValidation(ctx, userID, r.FormValue("code"))
 	if !ok {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
+		s.attemptFailed(ctx, r, verifyKey(userID))
 		http.Redirect(w, r, "/user/verify", http.StatusFound)
 		s.log.ErrorContext(ctx, "verification code is not matching", "error", err)
 		return
@@ -241,6 +383,8 @@ func (s *Server) verifyAuth(w http.ResponseWriter, r *http.Request) {
 		s.log.ErrorContext(ctx, "failed to validate verification code", "error", err)
 		return
 	}
+	s.accountGuard.Reset(verifyKey(userID))
+	s.record(ctx, r, userEvent(model.ActionVerify, userID), nil, nil)
 	http.Redirect(w, r, "/user/dashboard", http.StatusFound)
 }
 
@@ -257,13 +401,14 @@ func (s *Server) deleteUser(w http.ResponseWriter, r *http.Request) {
 		s.log.ErrorContext(ctx, "failed to parse uuid", "error", err)
 		return
 	}
-	err = s.userstore.DeleteUser(ctx, ID)
+	err = s.removeAccount(ctx, ID)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
 		s.log.ErrorContext(ctx, "failed to delete user", "error", err)
 		return
 	}
+	s.record(ctx, r, userEvent(model.ActionUserDelete, ID), nil, nil)
 }
 
 func (s *Server) updateUser(w http.ResponseWriter, r *http.Request) {
@@ -286,7 +431,7 @@ func (s *Server) updateUser(w http.ResponseWriter, r *http.Request) {
 		s.log.ErrorContext(ctx, "failed to get user", "error", err)
 		return
 	}
-	err = s.templates.TmplAdminUser.ExecuteTemplate(w, "user-update", &user)
+	err = s.render(w, r, s.templates().TmplAdminUser, "user-update", &user)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
@@ -324,16 +469,43 @@ func (s *Server) saveUser(w http.ResponseWriter, r *http.Request) {
 		s.log.ErrorContext(ctx, "failed to get user", "error", err)
 		return
 	}
+	//NOTE: a new email is only used after it was confirmed by its owner, it is
+	// requested first so a taken or invalid one leaves the user unchanged
+	if email := r.FormValue("Email"); db.NormalizeEmail(email) != user.Email {
+		err = s.requestEmailChange(ctx, r, user.ID, email)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			s.log.ErrorContext(ctx, "failed to request email change", "error", err)
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		user, err = s.userstore.GetUserByID(ctx, userID)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			s.log.ErrorContext(ctx, "failed to get user", "error", err)
+			return
+		}
+	}
+	before := *user
 
 	updatedUser := model.User{
-		ID:               user.ID,
-		Email:            r.FormValue("Email"),
-		Name:             user.Name,
-		Password:         user.Password,
-		IsAdmin:          utils.FormValueBool(r.FormValue("Admin")),
-		IsVerified:       utils.FormValueBool(r.FormValue("Verified")),
-		VerificationCode: user.VerificationCode,
-		ExpirationTime:   user.ExpirationTime,
+		ID:                    user.ID,
+		Email:                 user.Email,
+		Name:                  user.Name,
+		Password:              user.Password,
+		IsAdmin:               utils.FormValueBool(r.FormValue("Admin")),
+		IsVerified:            utils.FormValueBool(r.FormValue("Verified")),
+		VerificationCode:      user.VerificationCode,
+		ExpirationTime:        user.ExpirationTime,
+		Identities:            user.Identities,
+		VerificationAttempts:  user.VerificationAttempts,
+		PendingEmail:          user.PendingEmail,
+		EmailChangeToken:      user.EmailChangeToken,
+		EmailChangeExpiration: user.EmailChangeExpiration,
+		Locale:                user.Locale,
+		DeleteAt:              user.DeleteAt,
 	}
 	err = s.userstore.UpdateUser(ctx, &updatedUser)
 	if err != nil {
@@ -342,7 +514,8 @@ func (s *Server) saveUser(w http.ResponseWriter, r *http.Request) {
 		s.log.ErrorContext(ctx, "failed to update user", "error", err)
 		return
 	}
-	err = s.templates.TmplAdminUser.ExecuteTemplate(w, "user", &updatedUser)
+	s.record(ctx, r, userEvent(model.ActionUserUpdate, user.ID), &before, &updatedUser)
+	err = s.render(w, r, s.templates().TmplAdminUser, "user", &updatedUser)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
@@ -371,9 +544,17 @@ func (s *Server) resendVer(w http.ResponseWriter, r *http.Request) {
 		s.log.ErrorContext(ctx, "failed to get user", "error", err)
 		return
 	}
-	user.VerificationCode = utils.RandomString(6)
+	before := *user
+	user.VerificationCode, err = secrets.VerificationCode.Generate()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to generate verification code", "error", err)
+		return
+	}
 	user.ExpirationTime = time.Now().Add(time.Minute * 5)
-	err = s.mailer.SendVerMail(user, s.domain, s.templates)
+	user.VerificationAttempts = 0
+	err = s.mailer().SendVerMail(user, s.domain, s.templates().Mail)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
@@ -387,7 +568,8 @@ func (s *Server) resendVer(w http.ResponseWriter, r *http.Request) {
 		s.log.ErrorContext(ctx, "failed to update user", "error", err)
 		return
 	}
-	err = s.templates.TmplAdminUser.ExecuteTemplate(w, "user", &user)
+	s.record(ctx, r, userEvent(model.ActionUserUpdate, user.ID), &before, user)
+	err = s.render(w, r, s.templates().TmplAdminUser, "user", &user)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
@@ -399,7 +581,7 @@ func (s *Server) resendVer(w http.ResponseWriter, r *http.Request) {
 func (s *Server) forgotPW(w http.ResponseWriter, r *http.Request) {
 	var span trace.Span
 	ctx := r.Context()
-	ctx, span = tracer.Start(ctx, "server.resendVer")
+	ctx, span = tracer.Start(ctx, "server.forgotPW")
 	defer span.End()
 
 	user, err := s.userstore.GetUserByEmail(ctx, r.FormValue("email"))
@@ -409,23 +591,12 @@ func (s *Server) forgotPW(w http.ResponseWriter, r *http.Request) {
 		s.log.ErrorContext(ctx, "failed to get user", "error", err)
 		return
 	}
-	newPW := utils.RandomString(8)
-	user.Password = []byte(newPW)
-	hashedpassword, _ := bcrypt.GenerateFromPassword([]byte(newPW), 14)
-	err = s.mailer.SendPWMail(user, s.templates)
-	if err != nil {
-		span.RecordError(err)
-		span.SetStatus(codes.Error, err.Error())
-		s.log.ErrorContext(ctx, "failed to generate password", "error", err)
-		return
-	}
-	user.Password = hashedpassword
-	err = s.userstore.UpdateUser(ctx, user)
-	if err != nil {
-		span.RecordError(err)
-		span.SetStatus(codes.Error, err.Error())
-		s.log.ErrorContext(ctx, "failed to update user", "error", err)
-		return
+	//NOTE: unknown emails are answered like known ones
+	if user.ID != uuid.Nil {
+		if err := s.resetPassword(ctx, r, user); err != nil {
+			http.Error(w, "failed to reset password", http.StatusInternalServerError)
+			return
+		}
 	}
 	http.Redirect(w, r, "/login", http.StatusFound)
 }
@@ -444,7 +615,7 @@ func (s *Server) search(w http.ResponseWriter, r *http.Request) {
 		s.log.ErrorContext(ctx, "failed to get entry", "error", err)
 		return
 	}
-	err = s.templates.TmplSearchResult.ExecuteTemplate(w, "result", &entry)
+	err = s.render(w, r, s.templates().TmplSearchResult, "result", &entry)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
@@ -459,29 +630,59 @@ func (s *Server) submitUserData(w http.ResponseWriter, r *http.Request) {
 	ctx, span = tracer.Start(ctx, "server.submitUserData")
 	defer span.End()
 
-	userID, err := uuid.Parse(r.PathValue("ID"))
-	if err != nil {
-		span.RecordError(err)
-		span.SetStatus(codes.Error, err.Error())
-		s.log.ErrorContext(ctx, "failed to parse uuid", "error", err)
-		return
-	}
-	user, err := s.userstore.GetUserByID(ctx, userID)
+	user, err := s.pathUser(r)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
 		s.log.ErrorContext(ctx, "failed to get user", "error", err)
+		http.Error(w, "forbidden", http.StatusForbidden)
 		return
 	}
+	page := &userPage{User: user}
+	//NOTE: a new email is only used after it was confirmed by its owner, it is
+	// requested first so a taken or invalid one leaves the user unchanged
+	if email := r.FormValue("Email"); db.NormalizeEmail(email) != user.Email {
+		err = s.requestEmailChange(ctx, r, user.ID, email)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			s.log.ErrorContext(ctx, "failed to request email change", "error", err)
+			page.Errors = []i18n.Message{i18n.From(err)}
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			err = s.render(w, r, s.templates().TmplDashboardUser, "user-update", page)
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+				s.log.ErrorContext(ctx, "failed to execute template", "error", err)
+			}
+			return
+		}
+		user, err = s.userstore.GetUserByID(ctx, user.ID)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			s.log.ErrorContext(ctx, "failed to get user", "error", err)
+			return
+		}
+		page.Message = i18n.M("dashboard.email.sent", user.PendingEmail)
+	}
+	before := *user
 	updatedUser := model.User{
-		ID:               user.ID,
-		Password:         user.Password,
-		Name:             html.EscapeString(r.FormValue("Name")),
-		Email:            html.EscapeString(r.FormValue("Email")),
-		IsAdmin:          user.IsAdmin,
-		IsVerified:       user.IsVerified,
-		VerificationCode: user.VerificationCode,
-		ExpirationTime:   user.ExpirationTime,
+		ID:                    user.ID,
+		Password:              user.Password,
+		Name:                  r.FormValue("Name"),
+		Email:                 user.Email,
+		IsAdmin:               user.IsAdmin,
+		IsVerified:            user.IsVerified,
+		VerificationCode:      user.VerificationCode,
+		ExpirationTime:        user.ExpirationTime,
+		Identities:            user.Identities,
+		VerificationAttempts:  user.VerificationAttempts,
+		PendingEmail:          user.PendingEmail,
+		EmailChangeToken:      user.EmailChangeToken,
+		EmailChangeExpiration: user.EmailChangeExpiration,
+		Locale:                preferredLocale(r.FormValue("Locale"), user.Locale),
+		DeleteAt:              user.DeleteAt,
 	}
 	err = s.userstore.UpdateUser(ctx, &updatedUser)
 	if err != nil {
@@ -490,7 +691,10 @@ func (s *Server) submitUserData(w http.ResponseWriter, r *http.Request) {
 		s.log.ErrorContext(ctx, "failed to update user", "error", err)
 		return
 	}
-	err = s.templates.TmplDashboardUser.ExecuteTemplate(w, "user", &updatedUser)
+	s.record(ctx, r, userEvent(model.ActionUserUpdate, user.ID), &before, &updatedUser)
+	setPreferredLocale(w, r, &updatedUser)
+	page.User = &updatedUser
+	err = s.render(w, r, s.templates().TmplDashboardUser, "user", page)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
@@ -0,0 +1,285 @@
+package v1
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/led0nk/guestbook/internal/model"
+	"github.com/led0nk/guestbook/internal/password"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLoginUpgradesPasswordHash(t *testing.T) {
+	f := newFixture(t)
+	hash, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Error hashing password: %v", err)
+	}
+	f.user.Password = hash
+	if err := f.uStore.UpdateUser(context.Background(), f.user); err != nil {
+		t.Fatalf("Error updating user: %v", err)
+	}
+
+	rec := f.login("jon@doe.com", "password123")
+	if findCookie(rec, "session") == nil {
+		t.Fatalf("Expected login to succeed, got %d", rec.Code)
+	}
+	user, err := f.uStore.GetUserByID(context.Background(), f.user.ID)
+	if err != nil {
+		t.Fatalf("Error getting user: %v", err)
+	}
+	if !strings.HasPrefix(string(user.Password), "$argon2id$") {
+		t.Errorf("Expected password hash to be upgraded to argon2id, got %s", user.Password)
+	}
+	if rec := f.login("jon@doe.com", "password123"); findCookie(rec, "session") == nil {
+		t.Errorf("Expected login with upgraded hash to succeed, got %d", rec.Code)
+	}
+}
+
+func TestSignupPasswordPolicy(t *testing.T) {
+	f := newFixture(t)
+	signup := func(password string) *httptest.ResponseRecorder {
+		form := url.Values{
+			"firstname": {"jane"}, "lastname": {"roe"}, "email": {"jane@roe.com"},
+			"password": {password, password},
+		}
+		req := httptest.NewRequest(http.MethodPost, testOrigin+"/signup", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		f.server.signupAuth(rec, req)
+		return rec
+	}
+
+	rec := signup("janeroe123")
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected status %d, got %d", http.StatusUnprocessableEntity, rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "password must not contain your name or email") {
+		t.Errorf("Expected explanation in signup form, got %s", rec.Body.String())
+	}
+
+	rec = signup("correct-horse-7")
+	if rec.Code != http.StatusFound {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusFound, rec.Code, rec.Body.String())
+	}
+}
+
+func TestSignupPolicyLength(t *testing.T) {
+	f := newFixture(t, func(o *Options) { o.Policy = &password.Policy{MinLength: 6, MinClasses: 2} })
+	signup := func(password string) *httptest.ResponseRecorder {
+		form := url.Values{
+			"firstname": {"jane"}, "lastname": {"roe"}, "email": {"jane@roe.com"},
+			"password": {password, password},
+		}
+		req := httptest.NewRequest(http.MethodPost, testOrigin+"/signup", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		f.server.signupAuth(rec, req)
+		return rec
+	}
+
+	rec := signup("abc1")
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected status %d, got %d", http.StatusUnprocessableEntity, rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "at least 6 characters") {
+		t.Errorf("Expected the length of the policy, got %s", rec.Body.String())
+	}
+	rec = signup("abc123")
+	if rec.Code != http.StatusFound {
+		t.Fatalf("Expected a password of the policy length to be accepted, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	f := newFixture(t)
+	hash, err := f.server.hasher.Hash([]byte("password123"))
+	if err != nil {
+		t.Fatalf("Error hashing password: %v", err)
+	}
+	f.user.Password = hash
+	if err := f.uStore.UpdateUser(context.Background(), f.user); err != nil {
+		t.Fatalf("Error updating user: %v", err)
+	}
+	session, err := f.tStore.CreateToken(context.Background(), "session", testRPID, f.user.ID, false)
+	if err != nil {
+		t.Fatalf("Error creating session: %v", err)
+	}
+
+	change := func(path string, current string, password string) *httptest.ResponseRecorder {
+		form := url.Values{"current": {current}, "password": {password, password}}
+		req := httptest.NewRequest(http.MethodPut, testOrigin+"/user/dashboard/"+path+"/password", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetPathValue("ID", path)
+		req.AddCookie(session)
+		rec := httptest.NewRecorder()
+		f.server.changePassword(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		current  string
+		password string
+		expected string
+	}{
+		{name: "Other user", path: uuid.NewString(), current: "password123", password: "correct-horse-7", expected: "forbidden"},
+		{name: "Wrong current password", current: "password124", password: "correct-horse-7", expected: "current password is wrong"},
+		{name: "Weak password", current: "password123", password: "short", expected: "password is too short"},
+		{name: "Valid change", current: "password123", password: "correct-horse-7", expected: "Your password was changed."},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := test.path
+			if path == "" {
+				path = f.user.ID.String()
+			}
+			rec := change(path, test.current, test.password)
+			if !strings.Contains(rec.Body.String(), test.expected) {
+				t.Errorf("Expected %q in response, got %s", test.expected, rec.Body.String())
+			}
+		})
+	}
+
+	user, err := f.uStore.GetUserByID(context.Background(), f.user.ID)
+	if err != nil {
+		t.Fatalf("Error getting user: %v", err)
+	}
+	if _, err := f.server.hasher.Verify(user.Password, []byte("correct-horse-7")); err != nil {
+		t.Errorf("Expected new password to be stored: %v", err)
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	other := &model.User{Name: "Jane Roe", Email: "jane@roe.com", Password: []byte("hash"), IsVerified: true}
+	if _, err := f.uStore.CreateUser(ctx, other); err != nil {
+		t.Fatalf("Error creating user: %v", err)
+	}
+	session, err := f.tStore.CreateToken(ctx, "session", testRPID, f.user.ID, false)
+	if err != nil {
+		t.Fatalf("Error creating session: %v", err)
+	}
+
+	reset := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, testOrigin+"/user/dashboard/"+path+"/password-reset", nil)
+		req.SetPathValue("ID", path)
+		req.AddCookie(session)
+		rec := httptest.NewRecorder()
+		f.server.passwordReset(rec, req)
+		return rec
+	}
+
+	rec := reset(other.ID.String())
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("Expected status %d for another user, got %d", http.StatusForbidden, rec.Code)
+	}
+	user, err := f.uStore.GetUserByID(ctx, other.ID)
+	if err != nil {
+		t.Fatalf("Error getting user: %v", err)
+	}
+	if string(user.Password) != "hash" {
+		t.Errorf("Expected password of the other user to be unchanged, got %q", user.Password)
+	}
+	if _, ok := f.mailer.passwords[other.Email]; ok {
+		t.Errorf("Expected no mail to the other user")
+	}
+
+	stored, err := f.uStore.GetUserByID(ctx, f.user.ID)
+	if err != nil {
+		t.Fatalf("Error getting user: %v", err)
+	}
+	previous := stored.Password
+	rec = reset(f.user.ID.String())
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	password, ok := f.mailer.passwords[f.user.Email]
+	if !ok {
+		t.Fatalf("Expected a mail with the new password")
+	}
+	if string(stored.Password) != string(previous) {
+		t.Errorf("Expected the previously stored user to be left alone, got %q", stored.Password)
+	}
+	user, err = f.uStore.GetUserByID(ctx, f.user.ID)
+	if err != nil {
+		t.Fatalf("Error getting user: %v", err)
+	}
+	if string(user.Password) == password {
+		t.Fatalf("Expected the hash of the new password to be stored, got the plaintext")
+	}
+	if _, err := f.server.hasher.Verify(user.Password, []byte(password)); err != nil {
+		t.Errorf("Expected new password to be stored: %v", err)
+	}
+}
+
+func TestForgotPassword(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	forgot := func(email string) *httptest.ResponseRecorder {
+		form := url.Values{"email": {email}}
+		req := httptest.NewRequest(http.MethodPost, testOrigin+"/forgot-pw", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		f.server.forgotPW(rec, req)
+		return rec
+	}
+
+	rec := forgot("nobody@doe.com")
+	if rec.Code != http.StatusFound {
+		t.Fatalf("Expected status %d for an unknown email, got %d", http.StatusFound, rec.Code)
+	}
+	if len(f.mailer.passwords) != 0 {
+		t.Errorf("Expected no mail for an unknown email, got %v", f.mailer.passwords)
+	}
+
+	stored, err := f.uStore.GetUserByID(ctx, f.user.ID)
+	if err != nil {
+		t.Fatalf("Error getting user: %v", err)
+	}
+	previous := string(stored.Password)
+	rec = forgot(f.user.Email)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusFound, rec.Code, rec.Body.String())
+	}
+	password, ok := f.mailer.passwords[f.user.Email]
+	if !ok {
+		t.Fatalf("Expected a mail with the new password")
+	}
+	if string(stored.Password) != previous {
+		t.Errorf("Expected the previously stored user to be left alone, got %q", stored.Password)
+	}
+	user, err := f.uStore.GetUserByID(ctx, f.user.ID)
+	if err != nil {
+		t.Fatalf("Error getting user: %v", err)
+	}
+	if _, err := f.server.hasher.Verify(user.Password, []byte(password)); err != nil {
+		t.Errorf("Expected new password to be stored: %v", err)
+	}
+}
+
+func TestSignupEmailTaken(t *testing.T) {
+	f := newFixture(t)
+	form := url.Values{
+		"firstname": {"jon"}, "lastname": {"doe"}, "email": {"Jon@Doe.com"},
+		"password": {"correct-horse-7", "correct-horse-7"},
+	}
+	req := httptest.NewRequest(http.MethodPost, testOrigin+"/signup", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	f.server.signupAuth(rec, req)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("Expected status %d, got %d", http.StatusConflict, rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "email is already in use") {
+		t.Errorf("Expected explanation in signup form, got %s", rec.Body.String())
+	}
+}
@@ -0,0 +1,95 @@
+package v1
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ceremonies only live between the begin and finish request of a passkey
+// or OpenID Connect login
+const ceremonyTimeout = 5 * time.Minute
+
+type ceremony[T any] struct {
+	data    T
+	expires time.Time
+}
+
+// in-memory storage for pending login ceremonies, keyed by a random value
+// which is handed to the browser as cookie
+type ceremonyStore[T any] struct {
+	ceremonies map[string]*ceremony[T]
+	timeout    time.Duration
+	mu         sync.Mutex
+}
+
+func newCeremonyStore[T any](timeout time.Duration) *ceremonyStore[T] {
+	return &ceremonyStore[T]{
+		ceremonies: make(map[string]*ceremony[T]),
+		timeout:    timeout,
+	}
+}
+
+// stores the ceremony data and returns the key to look it up again
+func (c *ceremonyStore[T]) put(data T) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.purge()
+	key, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	c.ceremonies[key] = &ceremony[T]{
+		data:    data,
+		expires: time.Now().Add(c.timeout),
+	}
+	return key, nil
+}
+
+// sweep deletes the expired ceremonies and returns their number
+func (c *ceremonyStore[T]) sweep() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.purge()
+}
+
+func (c *ceremonyStore[T]) purge() int {
+	purged := 0
+	for key, cer := range c.ceremonies {
+		if cer.expires.Before(time.Now()) {
+			delete(c.ceremonies, key)
+			purged++
+		}
+	}
+	return purged
+}
+
+// returns the ceremony data for key, every ceremony can only be taken once
+func (c *ceremonyStore[T]) take(key string) (T, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var empty T
+	cer, exists := c.ceremonies[key]
+	if !exists {
+		return empty, errors.New("ceremony doesn't exist")
+	}
+	delete(c.ceremonies, key)
+	if cer.expires.Before(time.Now()) {
+		return empty, errors.New("ceremony expired")
+	}
+	return cer.data, nil
+}
+
+// returns 32 random bytes encoded as URL-safe base64
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
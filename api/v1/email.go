@@ -0,0 +1,92 @@
+package v1
+
+import (
+	"context"
+	"net/http"
+	"net/mail"
+	"net/url"
+
+	"github.com/google/uuid"
+	"github.com/led0nk/guestbook/internal/i18n"
+	"github.com/led0nk/guestbook/internal/model"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// data of the personal data blocks of the dashboard
+type userPage struct {
+	*model.User
+	Message i18n.Message
+	Errors  []i18n.Message
+}
+
+// returns the link which confirms the pending email of the user
+func (s *Server) emailChangeLink(ID uuid.UUID, token string) string {
+	return s.domain + "/user/email/confirm?" + url.Values{"id": {ID.String()}, "token": {token}}.Encode()
+}
+
+// stores email as pending address of the user, sends the confirmation link
+// to it and a notice to the current address
+func (s *Server) requestEmailChange(ctx context.Context, r *http.Request, ID uuid.UUID, email string) error {
+	if _, err := mail.ParseAddress(email); err != nil {
+		return i18n.M("error.email.format")
+	}
+	user, err := s.userstore.GetUserByID(ctx, ID)
+	if err != nil {
+		return err
+	}
+	before := *user
+	token, err := s.userstore.RequestEmailChange(ctx, ID, email)
+	if err != nil {
+		return err
+	}
+	user, err = s.userstore.GetUserByID(ctx, ID)
+	if err != nil {
+		return err
+	}
+	s.record(ctx, r, userEvent(model.ActionEmailChange, ID), &before, user)
+	err = s.mailer().SendEmailChangeMail(user, s.emailChangeLink(user.ID, token), s.templates().Mail)
+	if err != nil {
+		return err
+	}
+	return s.mailer().SendEmailChangeNotice(user, s.templates().Mail)
+}
+
+// commits the pending email after the link sent to the new address is opened
+func (s *Server) confirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.confirmEmailChange")
+	defer span.End()
+
+	userID, err := uuid.Parse(r.URL.Query().Get("id"))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to parse uuid", "error", err)
+		http.Error(w, "the link is invalid or expired", http.StatusBadRequest)
+		return
+	}
+	before, err := s.userstore.GetUserByID(ctx, userID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to get user", "error", err)
+		http.Error(w, "the link is invalid or expired", http.StatusBadRequest)
+		return
+	}
+	previous := *before
+	user, err := s.userstore.ConfirmEmailChange(ctx, userID, r.URL.Query().Get("token"))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to confirm email change", "error", err)
+		http.Error(w, "the link is invalid or expired", http.StatusBadRequest)
+		return
+	}
+	s.log.InfoContext(ctx, "changed email", "user", user.ID)
+	event := userEvent(model.ActionEmailConfirm, user.ID)
+	event.Actor = user.ID
+	s.record(ctx, r, event, &previous, user)
+	http.Redirect(w, r, "/user/dashboard", http.StatusFound)
+}
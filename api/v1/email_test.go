@@ -0,0 +1,147 @@
+package v1
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/led0nk/guestbook/internal/model"
+)
+
+func TestEmailChange(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	other := &model.User{Name: "Jane Roe", Email: "jane@roe.com", IsVerified: true}
+	if _, err := f.uStore.CreateUser(ctx, other); err != nil {
+		t.Fatalf("Error creating user: %v", err)
+	}
+	session, err := f.tStore.CreateToken(ctx, "session", testRPID, f.user.ID, false)
+	if err != nil {
+		t.Fatalf("Error creating session: %v", err)
+	}
+
+	submit := func(email string) *httptest.ResponseRecorder {
+		form := url.Values{"Name": {f.user.Name}, "Email": {email}}
+		req := httptest.NewRequest(http.MethodPut, testOrigin+"/user/dashboard/"+f.user.ID.String(), strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetPathValue("ID", f.user.ID.String())
+		req.AddCookie(session)
+		rec := httptest.NewRecorder()
+		f.server.submitUserData(rec, req)
+		return rec
+	}
+
+	rec := submit("jane@roe.com")
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected status %d for a taken email, got %d", http.StatusUnprocessableEntity, rec.Code)
+	}
+	rec = submit("jon@new.com")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	user, err := f.uStore.GetUserByID(ctx, f.user.ID)
+	if err != nil {
+		t.Fatalf("Error getting user: %v", err)
+	}
+	if user.Email != "jon@doe.com" || user.PendingEmail != "jon@new.com" {
+		t.Fatalf("Expected pending change to jon@new.com, got email %q pending %q", user.Email, user.PendingEmail)
+	}
+	if len(f.mailer.notices) != 1 || f.mailer.notices[0] != "jon@doe.com" {
+		t.Errorf("Expected notice to the old address, got %v", f.mailer.notices)
+	}
+	links := f.mailer.links["jon@new.com"]
+	if len(links) != 1 {
+		t.Fatalf("Expected one link to the new address, got %v", links)
+	}
+
+	confirm := func(link string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, strings.TrimPrefix(link, testRPID), nil)
+		rec := httptest.NewRecorder()
+		f.server.confirmEmailChange(rec, req)
+		return rec
+	}
+	rec = confirm(strings.Replace(links[0], "token=", "token=x", 1))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d for a wrong token, got %d", http.StatusBadRequest, rec.Code)
+	}
+	rec = confirm(links[0])
+	if rec.Code != http.StatusFound {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusFound, rec.Code, rec.Body.String())
+	}
+	user, err = f.uStore.GetUserByID(ctx, f.user.ID)
+	if err != nil {
+		t.Fatalf("Error getting user: %v", err)
+	}
+	if user.Email != "jon@new.com" || user.PendingEmail != "" {
+		t.Errorf("Expected email jon@new.com without pending change, got email %q pending %q", user.Email, user.PendingEmail)
+	}
+	rec = confirm(links[0])
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected link to be usable once, got status %d", rec.Code)
+	}
+}
+
+func TestEmailChangeFirst(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	admin := f.admin(t)
+	session, err := f.tStore.CreateToken(ctx, "session", testRPID, f.user.ID, false)
+	if err != nil {
+		t.Fatalf("Error creating session: %v", err)
+	}
+	put := func(handler http.HandlerFunc, form url.Values, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, testOrigin+"/dashboard/"+f.user.ID.String(), strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetPathValue("ID", f.user.ID.String())
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+	stored := func() *model.User {
+		user, err := f.uStore.GetUserByID(ctx, f.user.ID)
+		if err != nil {
+			t.Fatalf("Error getting user: %v", err)
+		}
+		return user
+	}
+
+	rec := put(f.server.submitUserData, url.Values{"Name": {"Johnny"}, "Email": {admin.Email}}, session)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected status %d for a taken email, got %d", http.StatusUnprocessableEntity, rec.Code)
+	}
+	if user := stored(); user.Name != "Jon Doe" {
+		t.Errorf("Expected the name to be kept after a taken email, got %q", user.Name)
+	}
+	rec = put(f.server.saveUser, url.Values{"Admin": {"true"}, "Verified": {"true"}, "Email": {admin.Email}}, nil)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected status %d for a taken email, got %d", http.StatusUnprocessableEntity, rec.Code)
+	}
+	if user := stored(); user.IsAdmin {
+		t.Error("Expected the rights to be kept after a taken email")
+	}
+	events, err := f.aStore.ListEvents(ctx, model.AuditFilter{Target: f.user.ID})
+	if err != nil {
+		t.Fatalf("Error listing events: %v", err)
+	}
+	if len(events) != 0 {
+		t.Errorf("Expected no update to be recorded, got %+v", events)
+	}
+
+	//NOTE: another letter case is the same email
+	rec = put(f.server.submitUserData, url.Values{"Name": {"Johnny"}, "Email": {"JON@Doe.com"}}, session)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if user := stored(); user.Name != "Johnny" || user.PendingEmail != "" {
+		t.Errorf("Expected the name to change without an email change, got %q pending %q", user.Name, user.PendingEmail)
+	}
+	if len(f.mailer.links) != 0 {
+		t.Errorf("Expected no confirmation link, got %v", f.mailer.links)
+	}
+}
@@ -0,0 +1,170 @@
+package v1
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	templates "github.com/led0nk/guestbook/internal"
+	"github.com/led0nk/guestbook/internal/database/jsondb"
+	"github.com/led0nk/guestbook/internal/model"
+	"github.com/led0nk/guestbook/internal/password"
+	"github.com/led0nk/guestbook/token"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// cheap parameters to keep the tests fast
+var testArgon2id = password.Argon2id{Time: 1, Memory: 64, Threads: 1, SaltLen: 16, KeyLen: 32}
+
+// fixture holds a server backed by temporary stores and one verified user
+type fixture struct {
+	server *Server
+	user   *model.User
+	bStore *jsondb.BookStorage
+	uStore *jsondb.UserStorage
+	cStore *jsondb.CredentialStorage
+	iStore *jsondb.InvitationStorage
+	aStore *jsondb.AuditStorage
+	tStore *token.TokenStorage
+	mailer *fakeMailer
+}
+
+// fakeMailer records the sent links, passwords, notices and digests instead of
+// sending mails
+type fakeMailer struct {
+	links     map[string][]string
+	passwords map[string]string
+	notices   []string
+	digests   map[string]*model.Digest
+}
+
+func (m *fakeMailer) SendVerMail(*model.User, string, *templates.MailTemplates) error {
+	return nil
+}
+
+func (m *fakeMailer) SendPWMail(user *model.User, password string, _ *templates.MailTemplates) error {
+	m.passwords[user.Email] = password
+	return nil
+}
+
+func (m *fakeMailer) SendInvitationMail(invitation *model.Invitation, link string, _ *templates.MailTemplates) error {
+	m.links[invitation.Email] = append(m.links[invitation.Email], link)
+	return nil
+}
+
+func (m *fakeMailer) SendEmailChangeMail(user *model.User, link string, _ *templates.MailTemplates) error {
+	m.links[user.PendingEmail] = append(m.links[user.PendingEmail], link)
+	return nil
+}
+
+func (m *fakeMailer) SendEmailChangeNotice(user *model.User, _ *templates.MailTemplates) error {
+	m.notices = append(m.notices, user.Email)
+	return nil
+}
+
+func (m *fakeMailer) SendMagicLinkMail(user *model.User, link string, _ *templates.MailTemplates) error {
+	m.links[user.Email] = append(m.links[user.Email], link)
+	return nil
+}
+
+func (m *fakeMailer) SendDigestMail(admin *model.User, digest *model.Digest, _ string, _ *templates.MailTemplates) error {
+	m.digests[admin.Email] = digest
+	return nil
+}
+
+// newFixture creates the stores and a server, configure sets the options the
+// test needs on top of them
+func newFixture(t *testing.T, configure ...func(*Options)) *fixture {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	bStore, err := jsondb.CreateBookStorage(dir + "/entries.json")
+	if err != nil {
+		t.Fatalf("Error creating entry storage: %v", err)
+	}
+	uStore, err := jsondb.CreateUserStorage(dir + "/user.json")
+	if err != nil {
+		t.Fatalf("Error creating user storage: %v", err)
+	}
+	cStore, err := jsondb.CreateCredentialStorage(dir + "/credentials.json")
+	if err != nil {
+		t.Fatalf("Error creating credential storage: %v", err)
+	}
+	iStore, err := jsondb.CreateInvitationStorage(dir + "/invitations.json")
+	if err != nil {
+		t.Fatalf("Error creating invitation storage: %v", err)
+	}
+	aStore, err := jsondb.CreateAuditStorage(dir + "/audit.jsonl")
+	if err != nil {
+		t.Fatalf("Error creating audit storage: %v", err)
+	}
+	tStore, err := token.CreateTokenService("secret")
+	if err != nil {
+		t.Fatalf("Error creating token service: %v", err)
+	}
+	wAuthn, err := webauthn.New(&webauthn.Config{
+		RPID:          testRPID,
+		RPDisplayName: "guestbook",
+		RPOrigins:     []string{testOrigin},
+	})
+	if err != nil {
+		t.Fatalf("Error creating webauthn config: %v", err)
+	}
+
+	user := &model.User{Name: "Jon Doe", Email: "jon@doe.com", IsVerified: true}
+	if _, err := uStore.CreateUser(ctx, user); err != nil {
+		t.Fatalf("Error creating user: %v", err)
+	}
+
+	mailer := &fakeMailer{links: make(map[string][]string), passwords: make(map[string]string), digests: make(map[string]*model.Digest)}
+	opts := Options{
+		Domain:      testRPID,
+		Mailer:      mailer,
+		Entries:     bStore,
+		Users:       uStore,
+		Tokens:      tStore,
+		Credentials: cStore,
+		Invitations: iStore,
+		Audit:       aStore,
+		WebAuthn:    wAuthn,
+		MagicLink:   true,
+		Hasher:      password.NewHasher(testArgon2id, password.Bcrypt{Cost: bcrypt.MinCost}),
+	}
+	for _, c := range configure {
+		c(&opts)
+	}
+	return &fixture{
+		server: NewServer(opts),
+		user:   user,
+		bStore: bStore,
+		uStore: uStore,
+		cStore: cStore,
+		iStore: iStore,
+		aStore: aStore,
+		tStore: tStore,
+		mailer: mailer,
+	}
+}
+
+func (f *fixture) do(handler http.HandlerFunc, body []byte, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, testOrigin, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func findCookie(rec *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == name && cookie.MaxAge >= 0 {
+			return cookie
+		}
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/led0nk/guestbook/internal/i18n"
+	"github.com/led0nk/guestbook/internal/middleware"
+	"github.com/led0nk/guestbook/internal/model"
+	"github.com/led0nk/guestbook/internal/secrets"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RegistrationMode defines who is allowed to sign up
+type RegistrationMode string
+
+const (
+	RegistrationOpen   RegistrationMode = "open"
+	RegistrationInvite RegistrationMode = "invite"
+	RegistrationClosed RegistrationMode = "closed"
+)
+
+func ParseRegistrationMode(mode string) (RegistrationMode, error) {
+	switch RegistrationMode(mode) {
+	case RegistrationOpen, RegistrationInvite, RegistrationClosed:
+		return RegistrationMode(mode), nil
+	}
+	return "", errors.New("unknown registration mode: " + mode)
+}
+
+const (
+	defaultInvitationUses = 1
+	defaultInvitationDays = 7
+)
+
+// data of the signup form
+type signupPage struct {
+	Rules        []i18n.Message
+	Errors       []i18n.Message
+	Invite       string
+	Registration RegistrationMode
+	// identity providers, their signup carries the invitation in invite mode
+	Providers []string
+}
+
+// data of the invitation management
+type invitationsPage struct {
+	Invitations []*model.Invitation
+	Domain      string
+	Errors      []i18n.Message
+	Message     i18n.Message
+}
+
+func (s *Server) invitationLink(code string) string {
+	return s.domain + "/signup?" + url.Values{"invite": {code}}.Encode()
+}
+
+// gives back the use of an invitation if the signup failed after it was
+// redeemed
+func (s *Server) refundInvitation(ctx context.Context, code string) {
+	if err := s.invitationstore.RefundInvitation(ctx, code); err != nil {
+		s.log.ErrorContext(ctx, "failed to refund invitation", "error", err)
+	}
+}
+
+// renders the invitations of the admin dashboard
+func (s *Server) renderInvitations(w http.ResponseWriter, r *http.Request, page *invitationsPage) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.renderInvitations")
+	defer span.End()
+
+	invitations, err := s.invitationstore.ListInvitations(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to list invitations", "error", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	page.Invitations = invitations
+	page.Domain = s.domain
+	err = s.render(w, r, s.templates().TmplInvitations, "", page)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to execute template", "error", err)
+		return
+	}
+}
+
+func (s *Server) invitationsHandler(w http.ResponseWriter, r *http.Request) {
+	s.renderInvitations(w, r, &invitationsPage{})
+}
+
+// creates an invitation and mails it if an address is given
+func (s *Server) createInvitation(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.createInvitation")
+	defer span.End()
+
+	err := r.ParseForm()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to parse form", "error", err)
+		return
+	}
+	maxUses, days := defaultInvitationUses, defaultInvitationDays
+	if value := r.FormValue("maxuses"); value != "" {
+		maxUses, err = strconv.Atoi(value)
+	}
+	if value := r.FormValue("days"); err == nil && value != "" {
+		days, err = strconv.Atoi(value)
+	}
+	if err != nil || maxUses < 1 || days < 1 {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		s.renderInvitations(w, r, &invitationsPage{Errors: []i18n.Message{i18n.M("invitations.error.numbers")}})
+		return
+	}
+
+	session, err := r.Cookie("session")
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to find cookie", "error", err)
+		return
+	}
+	adminID, err := s.tokenstore.GetTokenValue(ctx, session)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to get token value", "error", err)
+		return
+	}
+	code, err := secrets.InviteCode.Generate()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to generate invitation code", "error", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	invitation := &model.Invitation{
+		Code:      code,
+		Email:     strings.TrimSpace(r.FormValue("email")),
+		Locale:    preferredLocale(r.FormValue("locale"), string(middleware.Locale(r))),
+		MaxUses:   maxUses,
+		ExpiresAt: time.Now().Add(time.Duration(days) * 24 * time.Hour),
+		CreatedBy: adminID,
+	}
+	invitationID, err := s.invitationstore.CreateInvitation(ctx, invitation)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to create invitation", "error", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	s.record(ctx, r, &model.AuditEvent{Action: model.ActionInvitationCreate, Actor: adminID, Target: invitationID, TargetType: targetInvitation}, nil, invitation)
+
+	page := &invitationsPage{Message: i18n.M("invitations.created", code)}
+	if invitation.Email != "" {
+		err = s.mailer().SendInvitationMail(invitation, s.invitationLink(code), s.templates().Mail)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			s.log.ErrorContext(ctx, "failed to send invitation mail", "error", err)
+			page.Errors = []i18n.Message{i18n.M("invitations.error.mail")}
+		} else {
+			page.Message = i18n.M("invitations.sent", code, invitation.Email)
+		}
+	}
+	s.renderInvitations(w, r, page)
+}
+
+func (s *Server) deleteInvitation(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.deleteInvitation")
+	defer span.End()
+
+	invitationID, err := uuid.Parse(r.PathValue("ID"))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to parse uuid", "error", err)
+		return
+	}
+	err = s.invitationstore.DeleteInvitation(ctx, invitationID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to delete invitation", "error", err)
+		return
+	}
+	s.record(ctx, r, &model.AuditEvent{Action: model.ActionInvitationDelete, Target: invitationID, TargetType: targetInvitation}, nil, nil)
+}
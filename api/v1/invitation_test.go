@@ -0,0 +1,82 @@
+package v1
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/led0nk/guestbook/internal/model"
+)
+
+func TestInviteOnlySignup(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t, func(o *Options) { o.Registration = RegistrationInvite })
+	signup := func(invite string) *httptest.ResponseRecorder {
+		form := url.Values{
+			"firstname": {"jane"}, "lastname": {"roe"}, "email": {"jane@roe.com"},
+			"password": {"correct-horse-7", "correct-horse-7"}, "invite": {invite},
+		}
+		req := httptest.NewRequest(http.MethodPost, testOrigin+"/signup", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		f.server.signupAuth(rec, req)
+		return rec
+	}
+
+	invitation := &model.Invitation{Code: "ABCD-EFGH", MaxUses: 1, ExpiresAt: time.Now().Add(time.Hour)}
+	if _, err := f.iStore.CreateInvitation(ctx, invitation); err != nil {
+		t.Fatalf("Error creating invitation: %v", err)
+	}
+
+	rec := signup("")
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("Expected status %d without invitation, got %d", http.StatusForbidden, rec.Code)
+	}
+	rec = signup("WRONG")
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("Expected status %d with unknown invitation, got %d", http.StatusForbidden, rec.Code)
+	}
+	rec = signup(invitation.Code)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusFound, rec.Code, rec.Body.String())
+	}
+	if invitation.Uses != 1 {
+		t.Errorf("Expected invitation to be used once, got %d", invitation.Uses)
+	}
+
+	//NOTE: the use is refunded if the user can't be stored
+	refunded := &model.Invitation{Code: "IJKL-MNOP", MaxUses: 1, ExpiresAt: time.Now().Add(time.Hour)}
+	if _, err := f.iStore.CreateInvitation(ctx, refunded); err != nil {
+		t.Fatalf("Error creating invitation: %v", err)
+	}
+	jane, err := f.uStore.GetUserByEmail(ctx, "jane@roe.com")
+	if err != nil {
+		t.Fatalf("Error getting user: %v", err)
+	}
+	if err := f.uStore.DeleteUser(ctx, jane.ID); err != nil {
+		t.Fatalf("Error deleting user: %v", err)
+	}
+	if err := f.uStore.Close(); err != nil {
+		t.Fatalf("Error closing user storage: %v", err)
+	}
+	rec = signup(refunded.Code)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected status %d without user storage, got %d", http.StatusInternalServerError, rec.Code)
+	}
+	if refunded.Uses != 0 {
+		t.Errorf("Expected invitation use to be refunded, got %d", refunded.Uses)
+	}
+
+	f.server.registration = RegistrationClosed
+	rec = signup(invitation.Code)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("Expected status %d while closed, got %d", http.StatusForbidden, rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "Registration is closed") {
+		t.Errorf("Expected closed notice in signup form, got %s", rec.Body.String())
+	}
+}
@@ -0,0 +1,228 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/led0nk/guestbook/internal/model"
+	"github.com/led0nk/guestbook/internal/scheduler"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// names of the periodic jobs
+const (
+	jobPurgeAccounts   = "purge-accounts"
+	jobPurgeUnverified = "purge-unverified"
+	jobPurgeSessions   = "purge-sessions"
+	jobCompactStorage  = "compact-storage"
+	jobDigest          = "digest"
+)
+
+const (
+	// interval in which accounts are deleted after their grace period
+	purgeInterval   = time.Hour
+	sessionInterval = 10 * time.Minute
+	compactInterval = 6 * time.Hour
+	// unverified accounts are kept for a while after their code expired, so
+	// the admin can still send a new one
+	unverifiedGrace = 24 * time.Hour
+	// expired invitations stay visible to the admins for a while
+	invitationRetention = 30 * 24 * time.Hour
+)
+
+// data of the jobs page of the admin dashboard
+type jobsPage struct {
+	Jobs []scheduler.Status
+}
+
+// registers the periodic jobs of the server, the digest only runs if it has
+// an interval
+func (s *Server) registerJobs() {
+	s.scheduler.Add(scheduler.Job{Name: jobPurgeAccounts, Interval: purgeInterval, Run: s.purgeAccounts})
+	s.scheduler.Add(scheduler.Job{Name: jobPurgeUnverified, Interval: purgeInterval, Run: s.purgeUnverified})
+	s.scheduler.Add(scheduler.Job{Name: jobPurgeSessions, Interval: sessionInterval, Run: s.purgeSessions})
+	s.scheduler.Add(scheduler.Job{Name: jobCompactStorage, Interval: compactInterval, Run: s.compactStorage})
+	if s.digestInterval > 0 {
+		s.scheduler.Add(scheduler.Job{Name: jobDigest, Interval: s.digestInterval, Run: s.sendDigests})
+	}
+}
+
+// deletes the unverified accounts whose verification code expired more than
+// unverifiedGrace ago
+func (s *Server) purgeUnverified(ctx context.Context) error {
+	users, err := s.userstore.ListUser(ctx)
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		if user.IsVerified || user.IsAdmin || user.ExpirationTime.IsZero() ||
+			time.Now().Before(user.ExpirationTime.Add(unverifiedGrace)) {
+			continue
+		}
+		if err := s.removeAccount(ctx, user.ID); err != nil {
+			return err
+		}
+		s.log.InfoContext(ctx, "deleted unverified account", "user", user.ID)
+		s.record(ctx, nil, userEvent(model.ActionUserDelete, user.ID), nil, nil)
+	}
+	return nil
+}
+
+// deletes the expired sessions
+func (s *Server) purgeSessions(ctx context.Context) error {
+	purged, err := s.tokenstore.PurgeExpired(ctx)
+	if err != nil {
+		return err
+	}
+	s.log.InfoContext(ctx, "purged expired sessions", "sessions", purged)
+	return nil
+}
+
+// drops data which is of no use anymore: pending login ceremonies, expired
+// email changes and old invitations
+func (s *Server) compactStorage(ctx context.Context) error {
+	ceremonies := s.ceremonies.sweep() + s.oidcLogins.sweep()
+
+	users, err := s.userstore.ListUser(ctx)
+	if err != nil {
+		return err
+	}
+	emailChanges := 0
+	for _, user := range users {
+		if user.PendingEmail == "" || time.Now().Before(user.EmailChangeExpiration) {
+			continue
+		}
+		user.PendingEmail = ""
+		user.EmailChangeToken = ""
+		user.EmailChangeExpiration = time.Time{}
+		if err := s.userstore.UpdateUser(ctx, user); err != nil {
+			return err
+		}
+		emailChanges++
+	}
+
+	invitations, err := s.invitationstore.ListInvitations(ctx)
+	if err != nil {
+		return err
+	}
+	deleted := 0
+	for _, invitation := range invitations {
+		if time.Now().Before(invitation.ExpiresAt.Add(invitationRetention)) {
+			continue
+		}
+		if err := s.invitationstore.DeleteInvitation(ctx, invitation.ID); err != nil {
+			return err
+		}
+		deleted++
+	}
+	s.log.InfoContext(ctx, "compacted storage", "ceremonies", ceremonies, "emailchanges", emailChanges, "invitations", deleted)
+	return nil
+}
+
+// collects the entries and signups since the time
+func (s *Server) digest(ctx context.Context, since time.Time) (*model.Digest, error) {
+	digest := &model.Digest{Since: since, Entries: []*model.GuestbookEntry{}}
+	entries, err := s.bookstore.ListEntries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		createdAt, err := time.Parse(time.RFC850, entry.CreatedAt)
+		if err != nil || createdAt.Before(since) {
+			continue
+		}
+		digest.Entries = append(digest.Entries, entry)
+	}
+	events, err := s.auditstore.ListEvents(ctx, model.AuditFilter{Since: since})
+	if err != nil {
+		return nil, err
+	}
+	for _, event := range events {
+		if event.Action == model.ActionSignup {
+			digest.Signups++
+		}
+	}
+	return digest, nil
+}
+
+// mails the activity of the last digest interval to the verified admins
+func (s *Server) sendDigests(ctx context.Context) error {
+	digest, err := s.digest(ctx, time.Now().Add(-s.digestInterval))
+	if err != nil {
+		return err
+	}
+	if digest.Empty() {
+		return nil
+	}
+	users, err := s.userstore.ListUser(ctx)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, user := range users {
+		if !user.IsAdmin || !user.IsVerified {
+			continue
+		}
+		if err := s.mailer().SendDigestMail(user, digest, s.domain+"/admin/dashboard", s.templates().Mail); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// shows the status of the periodic jobs
+func (s *Server) jobsHandler(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.jobsHandler")
+	defer span.End()
+
+	err := s.render(w, r, s.templates().TmplJobs, "", &jobsPage{Jobs: s.scheduler.Status()})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to execute template", "error", err)
+		return
+	}
+}
+
+// runs the job immediately and renders its status
+func (s *Server) runJob(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.runJob")
+	defer span.End()
+
+	name := r.PathValue("name")
+	err := s.scheduler.RunNow(ctx, name)
+	switch {
+	case errors.Is(err, scheduler.ErrUnknownJob):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, scheduler.ErrRunning), errors.Is(err, scheduler.ErrStopped):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		//NOTE: the failure is part of the rendered status
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "job failed", "job", name, "error", err)
+	}
+	s.log.InfoContext(ctx, "job run manually", "job", name)
+
+	for _, status := range s.scheduler.Status() {
+		if status.Name != name {
+			continue
+		}
+		err = s.render(w, r, s.templates().TmplJobs, "job", status)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			s.log.ErrorContext(ctx, "failed to execute template", "error", err)
+		}
+		return
+	}
+}
@@ -0,0 +1,126 @@
+package v1
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/led0nk/guestbook/internal/model"
+)
+
+func TestPurgeUnverified(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	expired := time.Now().Add(-unverifiedGrace - time.Minute)
+	users := map[string]*model.User{
+		"expired": {Name: "Expired", Email: "expired@doe.com", ExpirationTime: expired},
+		"pending": {Name: "Pending", Email: "pending@doe.com", ExpirationTime: time.Now().Add(-time.Minute)},
+		"admin":   {Name: "Admin", Email: "admin@doe.com", IsAdmin: true, ExpirationTime: expired},
+	}
+	for _, user := range users {
+		if _, err := f.uStore.CreateUser(ctx, user); err != nil {
+			t.Fatalf("Error creating user: %v", err)
+		}
+	}
+
+	if err := f.server.scheduler.RunNow(ctx, jobPurgeUnverified); err != nil {
+		t.Fatalf("Error running job: %v", err)
+	}
+	remaining, err := f.uStore.ListUser(ctx)
+	if err != nil {
+		t.Fatalf("Error listing users: %v", err)
+	}
+	kept := map[string]bool{}
+	for _, user := range remaining {
+		kept[user.Email] = true
+	}
+	for name, user := range users {
+		if kept[user.Email] != (name != "expired") {
+			t.Errorf("Expected %s to be kept: %v, got %v", name, name != "expired", kept[user.Email])
+		}
+	}
+	if !kept[f.user.Email] {
+		t.Errorf("Expected verified user to stay")
+	}
+}
+
+func TestPurgeSessions(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	if _, err := f.tStore.CreateToken(ctx, "session", testRPID, f.user.ID, false); err != nil {
+		t.Fatalf("Error creating session: %v", err)
+	}
+	f.tStore.Tokens[f.user.ID].Expiration = time.Now().Add(-time.Minute)
+
+	if err := f.server.scheduler.RunNow(ctx, jobPurgeSessions); err != nil {
+		t.Fatalf("Error running job: %v", err)
+	}
+	sessions, err := f.tStore.ListSessions(ctx, f.user.ID)
+	if err != nil {
+		t.Fatalf("Error listing sessions: %v", err)
+	}
+	if len(sessions) != 0 {
+		t.Errorf("Expected expired session to be purged, got %v", sessions)
+	}
+}
+
+func TestDigest(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t, func(o *Options) { o.DigestInterval = 24 * time.Hour })
+	admin := &model.User{Name: "Admin", Email: "admin@doe.com", IsAdmin: true, IsVerified: true}
+	if _, err := f.uStore.CreateUser(ctx, admin); err != nil {
+		t.Fatalf("Error creating admin: %v", err)
+	}
+
+	if err := f.server.scheduler.RunNow(ctx, jobDigest); err != nil {
+		t.Fatalf("Error running job: %v", err)
+	}
+	if len(f.mailer.digests) != 0 {
+		t.Errorf("Expected no digest without activity, got %v", f.mailer.digests)
+	}
+
+	if _, err := f.bStore.CreateEntry(ctx, &model.GuestbookEntry{Name: f.user.Name, Message: "hello", UserID: f.user.ID}); err != nil {
+		t.Fatalf("Error creating entry: %v", err)
+	}
+	f.server.record(ctx, nil, userEvent(model.ActionSignup, f.user.ID), nil, nil)
+	if err := f.server.scheduler.RunNow(ctx, jobDigest); err != nil {
+		t.Fatalf("Error running job: %v", err)
+	}
+	digest := f.mailer.digests[admin.Email]
+	if digest == nil || len(digest.Entries) != 1 || digest.Signups != 1 {
+		t.Errorf("Expected digest with 1 entry and 1 signup for the admin, got %+v", digest)
+	}
+	if _, sent := f.mailer.digests[f.user.Email]; sent {
+		t.Errorf("Expected no digest for users")
+	}
+}
+
+func TestRunJob(t *testing.T) {
+	f := newFixture(t)
+	tests := []struct {
+		name   string
+		job    string
+		status int
+	}{
+		{name: "Known job", job: jobCompactStorage, status: http.StatusOK},
+		{name: "Unknown job", job: "unknown", status: http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, testOrigin+"/admin/jobs/"+test.job, nil)
+			req.SetPathValue("name", test.job)
+			rec := httptest.NewRecorder()
+			f.server.runJob(rec, req)
+			if rec.Code != test.status {
+				t.Errorf("Expected status %d, got %d", test.status, rec.Code)
+			}
+		})
+	}
+	for _, status := range f.server.scheduler.Status() {
+		if status.Name == jobCompactStorage && status.Runs != 1 {
+			t.Errorf("Expected 1 run of %s, got %d", jobCompactStorage, status.Runs)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package v1
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/google/uuid"
+	"github.com/led0nk/guestbook/internal/i18n"
+	"github.com/led0nk/guestbook/internal/middleware"
+	"github.com/led0nk/guestbook/internal/model"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// preferredLocale returns tag if it is supported and current otherwise
+func preferredLocale(tag string, current string) string {
+	if locale, ok := i18n.Parse(tag); ok {
+		return string(locale)
+	}
+	return current
+}
+
+// setPreferredLocale sets the locale cookie to the preference of the user, so
+// the pages are shown in it after the login on any device
+func setPreferredLocale(w http.ResponseWriter, r *http.Request, user *model.User) {
+	if locale, ok := i18n.Parse(user.Locale); ok {
+		middleware.SetLocale(w, r, locale)
+	}
+}
+
+// sameOriginReferer returns the path of the referer of r if it belongs to the
+// guestbook and "/" otherwise
+func sameOriginReferer(r *http.Request) string {
+	referer, err := url.Parse(r.Referer())
+	if err != nil || referer.Host != r.Host || referer.Path == "" {
+		return "/"
+	}
+	return referer.RequestURI()
+}
+
+// switches the locale of the pages and stores it as preference of the
+// session user
+func (s *Server) localeHandler(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.localeHandler")
+	defer span.End()
+
+	locale, ok := i18n.Parse(r.FormValue("locale"))
+	if !ok {
+		s.log.WarnContext(ctx, "unsupported locale", "locale", r.FormValue("locale"))
+		http.Error(w, "unsupported locale", http.StatusBadRequest)
+		return
+	}
+	middleware.SetLocale(w, r, locale)
+
+	if userID := s.sessionUser(r); userID != uuid.Nil {
+		user, err := s.userstore.GetUserByID(ctx, userID)
+		if err == nil && user.Locale != string(locale) {
+			before := *user
+			user.Locale = string(locale)
+			err = s.userstore.UpdateUser(ctx, user)
+			if err == nil {
+				s.record(ctx, r, userEvent(model.ActionUserUpdate, user.ID), &before, user)
+			}
+		}
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			s.log.ErrorContext(ctx, "failed to store preferred locale", "error", err)
+		}
+	}
+	http.Redirect(w, r, sameOriginReferer(r), http.StatusSeeOther)
+}
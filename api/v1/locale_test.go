@@ -0,0 +1,121 @@
+package v1
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/led0nk/guestbook/internal/middleware"
+)
+
+func TestLoginPageLocale(t *testing.T) {
+	f := newFixture(t)
+	handler := middleware.Localize()(http.HandlerFunc(f.server.loginHandler))
+
+	tests := []struct {
+		name           string
+		acceptLanguage string
+		cookie         string
+		expected       string
+		lang           string
+	}{
+		{name: "Default", expected: "Forgot Password?", lang: "en"},
+		{name: "Accept-Language", acceptLanguage: "de-AT,de;q=0.9,en;q=0.8", expected: "Passwort vergessen?", lang: "de"},
+		{name: "Quality", acceptLanguage: "fr, de;q=0.5, en;q=0.7", expected: "Forgot Password?", lang: "en"},
+		{name: "Unsupported", acceptLanguage: "fr", expected: "Forgot Password?", lang: "en"},
+		{name: "Cookie over Accept-Language", acceptLanguage: "de", cookie: "en", expected: "Forgot Password?", lang: "en"},
+		{name: "Invalid cookie", acceptLanguage: "de", cookie: "xx", expected: "Passwort vergessen?", lang: "de"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, testOrigin+"/login", nil)
+			if test.acceptLanguage != "" {
+				req.Header.Set("Accept-Language", test.acceptLanguage)
+			}
+			if test.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: middleware.LocaleCookie, Value: test.cookie})
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+			}
+			body := rec.Body.String()
+			if !strings.Contains(body, test.expected) {
+				t.Errorf("Expected %q in the page", test.expected)
+			}
+			if !strings.Contains(body, `<html lang="`+test.lang+`">`) {
+				t.Errorf("Expected the page in language %s", test.lang)
+			}
+			if !strings.Contains(rec.Header().Get("Vary"), "Accept-Language") {
+				t.Errorf("Expected Vary on Accept-Language, got %q", rec.Header().Get("Vary"))
+			}
+		})
+	}
+}
+
+func TestSwitchLocale(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	session, err := f.tStore.CreateToken(ctx, "session", testRPID, f.user.ID, false)
+	if err != nil {
+		t.Fatalf("Error creating session: %v", err)
+	}
+
+	switchLocale := func(locale string, referer string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		form := url.Values{"locale": {locale}}
+		req := httptest.NewRequest(http.MethodPost, testOrigin+"/locale", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Referer", referer)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		f.server.localeHandler(rec, req)
+		return rec
+	}
+
+	if rec := switchLocale("fr", testOrigin+"/login"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an unsupported locale, got %d", http.StatusBadRequest, rec.Code)
+	}
+
+	rec := switchLocale("de", "https://evil.example/phish")
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("Expected status %d, got %d", http.StatusSeeOther, rec.Code)
+	}
+	if location := rec.Header().Get("Location"); location != "/" {
+		t.Errorf("Expected redirect to / for a foreign referer, got %q", location)
+	}
+	if cookie := findCookie(rec, middleware.LocaleCookie); cookie == nil || cookie.Value != "de" {
+		t.Errorf("Expected locale cookie de, got %v", cookie)
+	}
+
+	rec = switchLocale("de", testOrigin+"/user/dashboard?tab=1", session)
+	if location := rec.Header().Get("Location"); location != "/user/dashboard?tab=1" {
+		t.Errorf("Expected redirect to the referer, got %q", location)
+	}
+	user, err := f.uStore.GetUserByID(ctx, f.user.ID)
+	if err != nil {
+		t.Fatalf("Error getting user: %v", err)
+	}
+	if user.Locale != "de" {
+		t.Errorf("Expected preferred locale de, got %q", user.Locale)
+	}
+
+	//NOTE: the preference is restored at the login on another device
+	hash, err := f.server.hasher.Hash([]byte("password123"))
+	if err != nil {
+		t.Fatalf("Error hashing password: %v", err)
+	}
+	user.Password = hash
+	if err := f.uStore.UpdateUser(ctx, user); err != nil {
+		t.Fatalf("Error updating user: %v", err)
+	}
+	rec = f.login("jon@doe.com", "password123")
+	if cookie := findCookie(rec, middleware.LocaleCookie); cookie == nil || cookie.Value != "de" {
+		t.Errorf("Expected locale cookie de after the login, got %v", cookie)
+	}
+}
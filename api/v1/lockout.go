@@ -0,0 +1,105 @@
+package v1
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	db "github.com/led0nk/guestbook/internal/database"
+	"github.com/led0nk/guestbook/internal/middleware"
+	"github.com/led0nk/guestbook/internal/model"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// guard key for login attempts against an account
+func accountKey(email string) string {
+	return "account:" + db.NormalizeEmail(email)
+}
+
+// guard key for attempts to enter the verification code of a user
+func verifyKey(userID uuid.UUID) string {
+	return "verify:" + userID.String()
+}
+
+// throttle answers with 429 if the account or the client has to back off
+// before the next attempt
+func (s *Server) throttle(w http.ResponseWriter, r *http.Request, key string) bool {
+	wait := s.accountGuard.Check(key)
+	if ipWait := s.ipGuard.Check(s.clientIP.IP(r)); ipWait > wait {
+		wait = ipWait
+	}
+	if wait <= 0 {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	middleware.Error(w, r, "too many failed attempts, try again later", http.StatusTooManyRequests)
+	return true
+}
+
+// attemptFailed counts a failed attempt for the account and the client
+func (s *Server) attemptFailed(ctx context.Context, r *http.Request, key string) {
+	if until, locked := s.accountGuard.Fail(ctx, key); locked {
+		s.log.WarnContext(ctx, "account locked", "key", key, "until", until)
+	}
+	ip := s.clientIP.IP(r)
+	if until, locked := s.ipGuard.Fail(ctx, ip); locked {
+		s.log.WarnContext(ctx, "client locked", "ip", ip, "until", until)
+	}
+}
+
+// lifts the lockout of a user
+func (s *Server) unlockUser(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.unlockUser")
+	defer span.End()
+
+	userID, err := uuid.Parse(r.PathValue("ID"))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to parse uuid", "error", err)
+		return
+	}
+	user, err := s.userstore.GetUserByID(ctx, userID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to get user", "error", err)
+		return
+	}
+	s.accountGuard.Reset(accountKey(user.Email))
+	s.accountGuard.Reset(verifyKey(user.ID))
+	user.VerificationAttempts = 0
+	err = s.userstore.UpdateUser(ctx, user)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to update user", "error", err)
+		return
+	}
+	s.log.InfoContext(ctx, "account unlocked", "user", user.ID)
+	s.record(ctx, r, userEvent(model.ActionUserUnlock, user.ID), nil, nil)
+
+	user.LockedUntil = s.lockedUntil(user)
+	err = s.render(w, r, s.templates().TmplAdminUser, "user", user)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to execute template", "error", err)
+		return
+	}
+}
+
+// lockedUntil returns the end of the latest lockout of user
+func (s *Server) lockedUntil(user *model.User) time.Time {
+	until, _ := s.accountGuard.Locked(accountKey(user.Email))
+	if verify, locked := s.accountGuard.Locked(verifyKey(user.ID)); locked && verify.After(until) {
+		until = verify
+	}
+	return until
+}
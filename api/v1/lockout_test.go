@@ -0,0 +1,42 @@
+package v1
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/led0nk/guestbook/internal/lockout"
+)
+
+func (f *fixture) login(email string, password string) *httptest.ResponseRecorder {
+	form := url.Values{"email": {email}, "password": {password}}
+	req := httptest.NewRequest(http.MethodPost, testOrigin+"/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	f.server.loginAuth(rec, req)
+	return rec
+}
+
+func TestLoginBackoff(t *testing.T) {
+	f := newFixture(t)
+
+	for i := 0; i <= lockout.AccountPolicy.FreeAttempts; i++ {
+		if rec := f.login("jon@doe.com", "wrong"); rec.Code != http.StatusFound {
+			t.Fatalf("Expected status %d for attempt %d, got %d", http.StatusFound, i, rec.Code)
+		}
+	}
+	rec := f.login("JON@doe.com", "wrong")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status %d, got %d", http.StatusTooManyRequests, rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected Retry-After header")
+	}
+
+	f.server.accountGuard.Reset(accountKey("jon@doe.com"))
+	if rec := f.login("jon@doe.com", "wrong"); rec.Code != http.StatusFound {
+		t.Errorf("Expected status %d after unlock, got %d", http.StatusFound, rec.Code)
+	}
+}
@@ -0,0 +1,214 @@
+package v1
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/led0nk/guestbook/internal/i18n"
+	"github.com/led0nk/guestbook/internal/middleware"
+	"github.com/led0nk/guestbook/internal/model"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// maximum of login links sent to one address within magicLinkWindow
+	magicLinkLimit  = 3
+	magicLinkWindow = 15 * time.Minute
+)
+
+// data of the login form
+type loginPage struct {
+	Providers []string
+	MagicLink bool
+	Message   i18n.Message
+}
+
+// addressLimiter allows limit requests per address within a sliding window
+type addressLimiter struct {
+	limit    int
+	window   time.Duration
+	requests map[string][]time.Time
+	mu       sync.Mutex
+}
+
+func newAddressLimiter(limit int, window time.Duration) *addressLimiter {
+	return &addressLimiter{
+		limit:    limit,
+		window:   window,
+		requests: make(map[string][]time.Time),
+	}
+}
+
+// allow records a request for address and reports if it is within the limit
+func (l *addressLimiter) allow(address string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for k, requests := range l.requests {
+		recent := requests[:0]
+		for _, request := range requests {
+			if now.Sub(request) < l.window {
+				recent = append(recent, request)
+			}
+		}
+		if len(recent) == 0 {
+			delete(l.requests, k)
+			continue
+		}
+		l.requests[k] = recent
+	}
+	if len(l.requests[address]) >= l.limit {
+		return false
+	}
+	l.requests[address] = append(l.requests[address], now)
+	return true
+}
+
+// sends a login link to the given address if it belongs to a user, the
+// response doesn't reveal whether the address is registered
+func (s *Server) requestMagicLink(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.requestMagicLink")
+	defer span.End()
+
+	if !s.magicLink {
+		http.NotFound(w, r)
+		return
+	}
+	email := strings.TrimSpace(r.FormValue("email"))
+	if email == "" {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	if !s.magicLimiter.allow(strings.ToLower(email)) {
+		s.log.WarnContext(ctx, "too many login links requested", "email", email)
+		http.Error(w, "too many login links requested, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	user, err := s.userstore.GetUserByEmail(ctx, email)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to get user", "error", err)
+	}
+	if err == nil && user.ID != uuid.Nil {
+		token, err := s.tokenstore.CreateMagicLink(ctx, user.ID)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			s.log.ErrorContext(ctx, "failed to create magic link", "error", err)
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		link := s.domain + "/login/magic?" + url.Values{"token": {token}}.Encode()
+		err = s.mailer().SendMagicLinkMail(user, link, s.templates().Mail)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			s.log.ErrorContext(ctx, "failed to send magic link", "error", err)
+		}
+	}
+
+	err = s.render(w, r, s.templates().TmplLogin, "", &loginPage{
+		Providers: s.providerNames(),
+		MagicLink: s.magicLink,
+		Message:   i18n.M("login.magic.sent"),
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to execute template", "error", err)
+		return
+	}
+}
+
+// shows a confirmation page for the link, so that mail scanners following
+// the link don't use it up
+func (s *Server) magicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	_, span = tracer.Start(ctx, "server.magicLinkHandler")
+	defer span.End()
+
+	token := r.URL.Query().Get("token")
+	if !s.magicLink || token == "" {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	err := s.render(w, r, s.templates().TmplMagicLink, "", token)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		w.WriteHeader(http.StatusBadGateway)
+		s.log.ErrorContext(ctx, "failed to execute template", "error", err)
+		return
+	}
+}
+
+// redeems the login link and creates the session
+func (s *Server) confirmMagicLink(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.confirmMagicLink")
+	defer span.End()
+
+	if !s.magicLink {
+		http.NotFound(w, r)
+		return
+	}
+	userID, err := s.tokenstore.RedeemMagicLink(ctx, r.FormValue("token"))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.WarnContext(ctx, "failed to redeem magic link", "error", err)
+		http.Error(w, "login link is invalid or expired", http.StatusUnauthorized)
+		return
+	}
+	user, err := s.userstore.GetUserByID(ctx, userID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to get user", "error", err)
+		http.Error(w, "login link is invalid or expired", http.StatusUnauthorized)
+		return
+	}
+	// the link was delivered to the address, so it is verified as well
+	if !user.IsVerified {
+		user.IsVerified = true
+		err = s.userstore.UpdateUser(ctx, user)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			s.log.ErrorContext(ctx, "failed to update user", "error", err)
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	session, err := s.tokenstore.CreateToken(ctx, "session", s.domain, user.ID, false)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to create token", "error", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	event := userEvent(model.ActionLogin, user.ID)
+	event.Actor = user.ID
+	s.record(ctx, r, event, nil, nil)
+	middleware.SetCookie(w, r, session)
+	setPreferredLocale(w, r, user)
+	if user.IsAdmin {
+		http.Redirect(w, r, "/admin/dashboard", http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, "/user/dashboard", http.StatusFound)
+}
@@ -0,0 +1,158 @@
+package v1
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func (f *fixture) requestMagicLink(email string) *httptest.ResponseRecorder {
+	form := url.Values{"email": {email}}
+	req := httptest.NewRequest(http.MethodPost, testOrigin+"/login/magic", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	f.server.requestMagicLink(rec, req)
+	return rec
+}
+
+func (f *fixture) confirmMagicLink(token string) *httptest.ResponseRecorder {
+	form := url.Values{"token": {token}}
+	req := httptest.NewRequest(http.MethodPost, testOrigin+"/login/magic/confirm", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	f.server.confirmMagicLink(rec, req)
+	return rec
+}
+
+func TestMagicLinkLogin(t *testing.T) {
+	f := newFixture(t)
+
+	rec := f.requestMagicLink("jon@doe.com")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	links := f.mailer.links["jon@doe.com"]
+	if len(links) != 1 {
+		t.Fatalf("Expected one login link to be sent, got %d", len(links))
+	}
+	link, err := url.Parse(links[0])
+	if err != nil {
+		t.Fatalf("Error parsing link: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, testOrigin+"/login/magic?"+link.RawQuery, nil)
+	rec = httptest.NewRecorder()
+	f.server.magicLinkHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected confirmation page, got %d", rec.Code)
+	}
+	if findCookie(rec, "session") != nil {
+		t.Fatalf("Expected no session before confirmation")
+	}
+
+	token := link.Query().Get("token")
+	rec = f.confirmMagicLink(token)
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/user/dashboard" {
+		t.Fatalf("Expected redirect to /user/dashboard, got %d %q: %s", rec.Code, rec.Header().Get("Location"), rec.Body.String())
+	}
+	session := findCookie(rec, "session")
+	if session == nil {
+		t.Fatalf("Expected session cookie to be set")
+	}
+	userID, err := f.tStore.GetTokenValue(req.Context(), session)
+	if err != nil {
+		t.Fatalf("Error getting token value: %v", err)
+	}
+	if userID != f.user.ID {
+		t.Errorf("Expected session for user %s, got %s", f.user.ID, userID)
+	}
+
+	rec = f.confirmMagicLink(token)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d for a replayed link, got %d", http.StatusUnauthorized, rec.Code)
+	}
+}
+
+func TestMagicLinkUnknownAddress(t *testing.T) {
+	f := newFixture(t)
+
+	known := f.requestMagicLink("jon@doe.com")
+	unknown := f.requestMagicLink("jane@doe.com")
+	if unknown.Code != known.Code || unknown.Body.String() != known.Body.String() {
+		t.Errorf("Expected the same response for unknown addresses")
+	}
+	if len(f.mailer.links["jane@doe.com"]) != 0 {
+		t.Errorf("Expected no link to be sent to an unknown address")
+	}
+}
+
+func TestMagicLinkRateLimit(t *testing.T) {
+	f := newFixture(t)
+
+	for i := 0; i < magicLinkLimit; i++ {
+		if rec := f.requestMagicLink("jon@doe.com"); rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d for request %d, got %d", http.StatusOK, i, rec.Code)
+		}
+	}
+	if rec := f.requestMagicLink("JON@doe.com"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d, got %d", http.StatusTooManyRequests, rec.Code)
+	}
+	if len(f.mailer.links["jon@doe.com"]) != magicLinkLimit {
+		t.Errorf("Expected %d links to be sent, got %d", magicLinkLimit, len(f.mailer.links["jon@doe.com"]))
+	}
+}
+
+func TestMagicLinkInvalidTokens(t *testing.T) {
+	f := newFixture(t)
+	sign := func(secret string, claims jwt.MapClaims) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+		if err != nil {
+			t.Fatalf("Error signing token: %v", err)
+		}
+		return token
+	}
+	session, err := f.tStore.CreateToken(context.Background(), "session", testRPID, f.user.ID, false)
+	if err != nil {
+		t.Fatalf("Error creating session: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{
+			name: "Expired",
+			token: sign("secret", jwt.MapClaims{
+				"id": f.user.ID.String(), "jti": "a", "purpose": "magiclink", "exp": time.Now().Add(-time.Minute).Unix(),
+			}),
+		},
+		{
+			name: "Wrong secret",
+			token: sign("other", jwt.MapClaims{
+				"id": f.user.ID.String(), "jti": "b", "purpose": "magiclink", "exp": time.Now().Add(time.Minute).Unix(),
+			}),
+		},
+		{
+			name:  "Session token",
+			token: session.Value,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := f.confirmMagicLink(test.token)
+			if rec.Code != http.StatusUnauthorized {
+				t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, rec.Code)
+			}
+			if findCookie(rec, "session") != nil {
+				t.Errorf("Expected no session cookie")
+			}
+		})
+	}
+}
@@ -5,8 +5,13 @@ import (
 	"github.com/led0nk/guestbook/internal/model"
 )
 
-// interface for Mailerservice for Verification-Mail and Reset-PW-Mail
+// interface for Mailerservice for Verification-Mail, Reset-PW-Mail, Magic-Link-Mail, Invitation-Mail, Email-Change-Mails and Digest-Mail
 type Mailerservice interface {
-	SendVerMail(*model.User, string, *templates.TemplateHandler) error
-	SendPWMail(*model.User, *templates.TemplateHandler) error
+	SendVerMail(*model.User, string, *templates.MailTemplates) error
+	SendPWMail(*model.User, string, *templates.MailTemplates) error
+	SendMagicLinkMail(*model.User, string, *templates.MailTemplates) error
+	SendInvitationMail(*model.Invitation, string, *templates.MailTemplates) error
+	SendEmailChangeMail(*model.User, string, *templates.MailTemplates) error
+	SendEmailChangeNotice(*model.User, *templates.MailTemplates) error
+	SendDigestMail(*model.User, *model.Digest, string, *templates.MailTemplates) error
 }
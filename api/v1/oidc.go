@@ -0,0 +1,261 @@
+package v1
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/led0nk/guestbook/internal/i18n"
+	"github.com/led0nk/guestbook/internal/middleware"
+	"github.com/led0nk/guestbook/internal/model"
+	"github.com/led0nk/guestbook/internal/oidc"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// oidcLogin holds everything needed to finish the login in the callback
+type oidcLogin struct {
+	provider string
+	nonce    string
+	verifier string
+	// code of the invitation which allows a new user in invite mode
+	invite string
+}
+
+func (s *Server) providerNames() []string {
+	names := make([]string, 0, len(s.providers))
+	for name := range s.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// redirect to the identity provider
+func (s *Server) oidcLogin(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.oidcLogin")
+	defer span.End()
+
+	provider, exists := s.providers[r.PathValue("provider")]
+	if !exists {
+		err := errors.New("unknown identity provider")
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to get provider", "provider", r.PathValue("provider"), "error", err)
+		http.NotFound(w, r)
+		return
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to create nonce", "error", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	login := &oidcLogin{
+		provider: provider.Name(),
+		nonce:    nonce,
+		verifier: oidc.GenerateVerifier(),
+		invite:   strings.TrimSpace(r.URL.Query().Get("invite")),
+	}
+	state, err := s.oidcLogins.put(login)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to store oidc login", "error", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	//NOTE: the callback is a cross-site navigation, so the cookie has to be lax
+	middleware.SetCookie(w, r, &http.Cookie{
+		Name:     "oidc",
+		Value:    state,
+		Path:     "/login/oidc",
+		MaxAge:   int(ceremonyTimeout.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, provider.AuthCodeURL(state, login.nonce, login.verifier), http.StatusFound)
+}
+
+// verify the response of the identity provider and create the session
+func (s *Server) oidcCallback(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.oidcCallback")
+	defer span.End()
+
+	provider, exists := s.providers[r.PathValue("provider")]
+	if !exists {
+		err := errors.New("unknown identity provider")
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to get provider", "provider", r.PathValue("provider"), "error", err)
+		http.NotFound(w, r)
+		return
+	}
+	query := r.URL.Query()
+	if query.Get("error") != "" {
+		err := errors.New(query.Get("error"))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "identity provider returned an error", "provider", provider.Name(), "error", err)
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	cookie, err := r.Cookie("oidc")
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "could not find cookie", "error", err)
+		http.Error(w, "login expired", http.StatusBadRequest)
+		return
+	}
+	middleware.SetCookie(w, r, &http.Cookie{
+		Name:     "oidc",
+		Path:     "/login/oidc",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	state := query.Get("state")
+	if subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		err := errors.New("state doesn't match")
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to validate state", "error", err)
+		http.Error(w, "invalid state", http.StatusBadRequest)
+		return
+	}
+	login, err := s.oidcLogins.take(state)
+	if err != nil || login == nil || login.provider != provider.Name() {
+		if err == nil {
+			err = errors.New("state was issued for another provider")
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to get oidc login", "error", err)
+		http.Error(w, "login expired", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := provider.Exchange(ctx, query.Get("code"), login.verifier, login.nonce)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to exchange code", "provider", provider.Name(), "error", err)
+		http.Error(w, "login failed", http.StatusUnauthorized)
+		return
+	}
+	if !claims.EmailVerified {
+		err := errors.New("email is not verified by identity provider")
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to link user", "provider", provider.Name(), "error", err)
+		http.Error(w, "email is not verified", http.StatusUnauthorized)
+		return
+	}
+	user, err := s.linkOIDCUser(ctx, provider.Name(), claims, login.invite, middleware.Locale(r))
+	var message i18n.Message
+	if errors.As(err, &message) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.WarnContext(ctx, "failed to sign up user", "provider", provider.Name(), "error", err)
+		s.rejectSignup(w, r, http.StatusForbidden, message)
+		return
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to link user", "provider", provider.Name(), "error", err)
+		http.Error(w, "login failed", http.StatusUnauthorized)
+		return
+	}
+
+	session, err := s.tokenstore.CreateToken(ctx, "session", s.domain, user.ID, false)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to create token", "error", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	event := userEvent(model.ActionLogin, user.ID)
+	event.Actor = user.ID
+	s.record(ctx, r, event, nil, nil)
+	middleware.SetCookie(w, r, session)
+	setPreferredLocale(w, r, user)
+	if user.IsAdmin {
+		http.Redirect(w, r, "/admin/dashboard", http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, "/user/dashboard", http.StatusFound)
+}
+
+// creates a new user for the claims or links the identity to the existing
+// user with the same email, new users prefer the locale of the login and need
+// the invitation in invite mode
+func (s *Server) linkOIDCUser(ctx context.Context, provider string, claims *oidc.Claims, invite string, locale i18n.Locale) (*model.User, error) {
+	identity := model.Identity{Provider: provider, Subject: claims.Subject}
+
+	user, err := s.userstore.GetUserByEmail(ctx, claims.Email)
+	if err != nil {
+		return nil, err
+	}
+	if user.ID == uuid.Nil {
+		name := claims.Name
+		if name == "" {
+			name = strings.TrimSpace(claims.GivenName + " " + claims.FamilyName)
+		}
+		if name == "" {
+			name = claims.Email
+		}
+		newUser := &model.User{
+			Email:      claims.Email,
+			Name:       name,
+			IsVerified: true,
+			Identities: []model.Identity{identity},
+			Locale:     string(locale),
+		}
+		switch s.registration {
+		case RegistrationClosed:
+			return nil, i18n.M("signup.error.closed")
+		case RegistrationInvite:
+			if err := s.invitationstore.RedeemInvitation(ctx, invite, claims.Email); err != nil {
+				s.log.WarnContext(ctx, "failed to redeem invitation", "error", err)
+				return nil, i18n.M("signup.error.invitation")
+			}
+		}
+		_, err = s.userstore.CreateUser(ctx, newUser)
+		if err != nil {
+			if s.registration == RegistrationInvite {
+				s.refundInvitation(ctx, invite)
+			}
+			return nil, err
+		}
+		return newUser, nil
+	}
+
+	for _, linked := range user.Identities {
+		if linked.Provider == provider {
+			if linked.Subject != claims.Subject {
+				return nil, errors.New("email is linked to another account of this provider")
+			}
+			return user, nil
+		}
+	}
+	user.Identities = append(user.Identities, identity)
+	user.IsVerified = true
+	err = s.userstore.UpdateUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
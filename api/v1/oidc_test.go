@@ -0,0 +1,359 @@
+package v1
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/led0nk/guestbook/internal/model"
+	"github.com/led0nk/guestbook/internal/oidc"
+)
+
+const testClientID = "guestbook"
+
+// mockIssuer is an in-process OpenID Connect provider which issues an ID
+// token with the configured claims for every authorization request
+type mockIssuer struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims jwt.MapClaims
+	// overrides the nonce of the issued ID token if set
+	nonce string
+
+	mu    sync.Mutex
+	codes map[string]url.Values
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	m := &mockIssuer{key: key, codes: make(map[string]url.Values)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                m.URL,
+			"authorization_endpoint":                m.URL + "/authorize",
+			"token_endpoint":                        m.URL + "/token",
+			"jwks_uri":                              m.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test",
+				"alg": "RS256",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("GET /authorize", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		code := uuid.NewString()
+		m.mu.Lock()
+		m.codes[code] = query
+		m.mu.Unlock()
+		redirect, _ := url.Parse(query.Get("redirect_uri"))
+		redirect.RawQuery = url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		authorization, exists := m.codes[r.FormValue("code")]
+		delete(m.codes, r.FormValue("code"))
+		m.mu.Unlock()
+		if !exists {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		challenge := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if authorization.Get("code_challenge_method") != "S256" ||
+			authorization.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(challenge[:]) {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+
+		claims := jwt.MapClaims{
+			"iss":   m.URL,
+			"aud":   authorization.Get("client_id"),
+			"iat":   time.Now().Unix(),
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"nonce": authorization.Get("nonce"),
+		}
+		if m.nonce != "" {
+			claims["nonce"] = m.nonce
+		}
+		for k, v := range m.claims {
+			claims[k] = v
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test"
+		idToken, err := token.SignedString(m.key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   60,
+			"id_token":     idToken,
+		})
+	})
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+func (m *mockIssuer) provider(t *testing.T) *oidc.Provider {
+	provider, err := oidc.NewProvider(context.Background(), oidc.Config{
+		Name:        "mock",
+		Issuer:      m.URL,
+		ClientID:    testClientID,
+		RedirectURL: testOrigin + "/login/oidc/mock/callback",
+	})
+	if err != nil {
+		t.Fatalf("Error creating provider: %v", err)
+	}
+	return provider
+}
+
+// runs the authorization code flow through the mock issuer and returns the
+// response of the callback
+func (f *fixture) loginOIDC(t *testing.T, tamper func(callback *url.URL, state *http.Cookie)) *httptest.ResponseRecorder {
+	return f.loginOIDCInvite(t, "", tamper)
+}
+
+// runs the authorization code flow like loginOIDC with the invitation code of
+// the signup page
+func (f *fixture) loginOIDCInvite(t *testing.T, invite string, tamper func(callback *url.URL, state *http.Cookie)) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, testOrigin+"/login/oidc/mock?"+url.Values{"invite": {invite}}.Encode(), nil)
+	req.SetPathValue("provider", "mock")
+	rec := httptest.NewRecorder()
+	f.server.oidcLogin(rec, req)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("Expected status %d on login, got %d: %s", http.StatusFound, rec.Code, rec.Body.String())
+	}
+	state := findCookie(rec, "oidc")
+	if state == nil {
+		t.Fatalf("Expected oidc state cookie to be set")
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("Error calling authorization endpoint: %v", err)
+	}
+	resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("Error parsing callback: %v", err)
+	}
+	if tamper != nil {
+		tamper(callback, state)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, callback.String(), nil)
+	req.SetPathValue("provider", "mock")
+	req.AddCookie(state)
+	rec = httptest.NewRecorder()
+	f.server.oidcCallback(rec, req)
+	return rec
+}
+
+func TestOIDCLoginCreatesUser(t *testing.T) {
+	issuer := newMockIssuer(t)
+	issuer.claims = jwt.MapClaims{"sub": "mock-1", "email": "jane@doe.com", "email_verified": true, "name": "Jane Doe"}
+	f := newFixture(t, func(o *Options) { o.Providers = []*oidc.Provider{issuer.provider(t)} })
+
+	rec := f.loginOIDC(t, nil)
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/user/dashboard" {
+		t.Fatalf("Expected redirect to /user/dashboard, got %d %q: %s", rec.Code, rec.Header().Get("Location"), rec.Body.String())
+	}
+	session := findCookie(rec, "session")
+	if session == nil {
+		t.Fatalf("Expected session cookie to be set")
+	}
+
+	ctx := context.Background()
+	user, err := f.uStore.GetUserByEmail(ctx, "jane@doe.com")
+	if err != nil {
+		t.Fatalf("Error getting user: %v", err)
+	}
+	if user.ID == uuid.Nil || user.Name != "Jane Doe" || !user.IsVerified {
+		t.Errorf("Expected verified user Jane Doe, got %+v", user)
+	}
+	if len(user.Identities) != 1 || user.Identities[0].Provider != "mock" || user.Identities[0].Subject != "mock-1" {
+		t.Errorf("Expected identity mock/mock-1, got %+v", user.Identities)
+	}
+	userID, err := f.tStore.GetTokenValue(ctx, session)
+	if err != nil {
+		t.Fatalf("Error getting token value: %v", err)
+	}
+	if userID != user.ID {
+		t.Errorf("Expected session for user %s, got %s", user.ID, userID)
+	}
+}
+
+func TestOIDCSignupRegistration(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name         string
+		registration RegistrationMode
+		email        string
+		invite       string
+		status       int
+		created      bool
+	}{
+		{name: "Closed", registration: RegistrationClosed, email: "jane@doe.com", status: http.StatusForbidden},
+		{name: "Closed existing user", registration: RegistrationClosed, email: "jon@doe.com", status: http.StatusFound},
+		{name: "Invite without code", registration: RegistrationInvite, email: "jane@doe.com", status: http.StatusForbidden},
+		{name: "Invite with unknown code", registration: RegistrationInvite, email: "jane@doe.com", invite: "WRONG", status: http.StatusForbidden},
+		{name: "Invite with code", registration: RegistrationInvite, email: "jane@doe.com", invite: "ABCD-EFGH", status: http.StatusFound, created: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			issuer := newMockIssuer(t)
+			issuer.claims = jwt.MapClaims{"sub": "mock-1", "email": test.email, "email_verified": true, "name": "Jane Doe"}
+			f := newFixture(t, func(o *Options) {
+				o.Providers = []*oidc.Provider{issuer.provider(t)}
+				o.Registration = test.registration
+			})
+			invitation := &model.Invitation{Code: "ABCD-EFGH", MaxUses: 1, ExpiresAt: time.Now().Add(time.Hour)}
+			if _, err := f.iStore.CreateInvitation(ctx, invitation); err != nil {
+				t.Fatalf("Error creating invitation: %v", err)
+			}
+
+			rec := f.loginOIDCInvite(t, test.invite, nil)
+			if rec.Code != test.status {
+				t.Fatalf("Expected status %d, got %d: %s", test.status, rec.Code, rec.Body.String())
+			}
+			users, err := f.uStore.ListUser(ctx)
+			if err != nil {
+				t.Fatalf("Error listing users: %v", err)
+			}
+			expected := 1
+			if test.created {
+				expected = 2
+			}
+			if len(users) != expected {
+				t.Errorf("Expected %d users, got %d", expected, len(users))
+			}
+			if test.created && invitation.Uses != 1 {
+				t.Errorf("Expected invitation to be used once, got %d", invitation.Uses)
+			}
+		})
+	}
+}
+
+func TestOIDCSignupPage(t *testing.T) {
+	issuer := newMockIssuer(t)
+	f := newFixture(t, func(o *Options) {
+		o.Providers = []*oidc.Provider{issuer.provider(t)}
+		o.Registration = RegistrationInvite
+	})
+	rec := httptest.NewRecorder()
+	f.server.signupHandler(rec, httptest.NewRequest(http.MethodGet, testOrigin+"/signup?invite=ABCD-EFGH", nil))
+	if !strings.Contains(rec.Body.String(), `href="/login/oidc/mock?invite=ABCD-EFGH"`) {
+		t.Errorf("Expected the provider link to carry the invitation, got %s", rec.Body.String())
+	}
+}
+
+func TestOIDCLoginLinksExistingUser(t *testing.T) {
+	issuer := newMockIssuer(t)
+	issuer.claims = jwt.MapClaims{"sub": "mock-2", "email": "jon@doe.com", "email_verified": true}
+	f := newFixture(t, func(o *Options) { o.Providers = []*oidc.Provider{issuer.provider(t)} })
+
+	rec := f.loginOIDC(t, nil)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusFound, rec.Code, rec.Body.String())
+	}
+	users, err := f.uStore.ListUser(context.Background())
+	if err != nil {
+		t.Fatalf("Error listing users: %v", err)
+	}
+	if len(users) != 1 {
+		t.Fatalf("Expected existing user to be linked, got %d users", len(users))
+	}
+	if len(users[0].Identities) != 1 || users[0].Identities[0].Subject != "mock-2" {
+		t.Errorf("Expected identity mock-2 to be linked, got %+v", users[0].Identities)
+	}
+
+	issuer.claims["sub"] = "mock-3"
+	rec = f.loginOIDC(t, nil)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d for another subject with the same email, got %d", http.StatusUnauthorized, rec.Code)
+	}
+}
+
+func TestOIDCLoginFailures(t *testing.T) {
+	tests := []struct {
+		name     string
+		claims   jwt.MapClaims
+		nonce    string
+		tamper   func(callback *url.URL, state *http.Cookie)
+		expected int
+	}{
+		{
+			name:     "Unverified email",
+			claims:   jwt.MapClaims{"sub": "mock-1", "email": "jane@doe.com", "email_verified": false},
+			expected: http.StatusUnauthorized,
+		},
+		{
+			name:     "Nonce mismatch",
+			claims:   jwt.MapClaims{"sub": "mock-1", "email": "jane@doe.com", "email_verified": true},
+			nonce:    "replayed",
+			expected: http.StatusUnauthorized,
+		},
+		{
+			name:   "State mismatch",
+			claims: jwt.MapClaims{"sub": "mock-1", "email": "jane@doe.com", "email_verified": true},
+			tamper: func(callback *url.URL, state *http.Cookie) {
+				state.Value = "forged"
+			},
+			expected: http.StatusBadRequest,
+		},
+		{
+			name:     "Wrong audience",
+			claims:   jwt.MapClaims{"sub": "mock-1", "email": "jane@doe.com", "email_verified": true, "aud": "someone-else"},
+			expected: http.StatusUnauthorized,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			issuer := newMockIssuer(t)
+			issuer.claims = test.claims
+			issuer.nonce = test.nonce
+			f := newFixture(t, func(o *Options) { o.Providers = []*oidc.Provider{issuer.provider(t)} })
+
+			rec := f.loginOIDC(t, test.tamper)
+			if rec.Code != test.expected {
+				t.Errorf("Expected status %d, got %d: %s", test.expected, rec.Code, rec.Body.String())
+			}
+			if findCookie(rec, "session") != nil {
+				t.Errorf("Expected no session cookie")
+			}
+		})
+	}
+}
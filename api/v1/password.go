@@ -0,0 +1,181 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/led0nk/guestbook/internal/i18n"
+	"github.com/led0nk/guestbook/internal/model"
+	"github.com/led0nk/guestbook/internal/password"
+	"github.com/led0nk/guestbook/internal/secrets"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// data of the password change form
+type passwordPage struct {
+	ID      uuid.UUID
+	Rules   []i18n.Message
+	Errors  []i18n.Message
+	Message i18n.Message
+}
+
+// explanations returns the messages shown to the user for err
+func explanations(err error) []i18n.Message {
+	var policyErr *password.PolicyError
+	if errors.As(err, &policyErr) {
+		return policyErr.Violations
+	}
+	return []i18n.Message{i18n.From(err)}
+}
+
+// renders the signup form with the reasons the input was rejected
+func (s *Server) rejectSignup(w http.ResponseWriter, r *http.Request, status int, err error) {
+	ctx := r.Context()
+	w.WriteHeader(status)
+	err = s.render(w, r, s.templates().TmplSignUp, "", &signupPage{
+		Rules:        s.policy.Describe(),
+		Errors:       explanations(err),
+		Invite:       r.FormValue("invite"),
+		Registration: s.registration,
+		Providers:    s.providerNames(),
+	})
+	if err != nil {
+		s.log.ErrorContext(ctx, "failed to execute template", "error", err)
+	}
+}
+
+// generatePassword returns a random password which satisfies the policy
+func (s *Server) generatePassword(user *model.User) (string, error) {
+	for i := 0; i < 100; i++ {
+		newPW, err := secrets.Password.Generate()
+		if err != nil {
+			return "", err
+		}
+		if s.policy.Check(newPW, user.Name, user.Email) == nil {
+			return newPW, nil
+		}
+	}
+	return "", errors.New("failed to generate a password satisfying the policy")
+}
+
+// returns the session user if it matches the ID of the path
+func (s *Server) pathUser(r *http.Request) (*model.User, error) {
+	session, err := r.Cookie("session")
+	if err != nil {
+		return nil, err
+	}
+	userID, err := s.tokenstore.GetTokenValue(r.Context(), session)
+	if err != nil {
+		return nil, err
+	}
+	if r.PathValue("ID") != userID.String() {
+		return nil, errors.New("path doesn't belong to the session user")
+	}
+	return s.userstore.GetUserByID(r.Context(), userID)
+}
+
+// shows the form to change the password
+func (s *Server) passwordChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.passwordChangeHandler")
+	defer span.End()
+
+	user, err := s.pathUser(r)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to get user", "error", err)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	err = s.render(w, r, s.templates().TmplDashboardUser, "password-change", &passwordPage{
+		ID:    user.ID,
+		Rules: s.policy.Describe(),
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to execute template", "error", err)
+		return
+	}
+}
+
+// changes the password of the session user after checking the current one
+func (s *Server) changePassword(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.changePassword")
+	defer span.End()
+
+	user, err := s.pathUser(r)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to get user", "error", err)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	err = r.ParseForm()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to parse form", "error", err)
+		return
+	}
+
+	page := &passwordPage{ID: user.ID, Rules: s.policy.Describe()}
+	newPW := r.Form["password"]
+	switch {
+	case len(newPW) != 2 || newPW[0] != newPW[1]:
+		err = i18n.M("password.error.mismatch")
+	case len(newPW[0]) > s.hasher.MaxLength():
+		err = i18n.M("password.error.long")
+	default:
+		err = s.policy.Check(newPW[0], user.Name, user.Email)
+	}
+	//NOTE: users which only logged in with passkeys or external providers
+	// have no password to confirm
+	if err == nil && len(user.Password) > 0 {
+		if _, verifyErr := s.hasher.Verify(user.Password, []byte(r.FormValue("current"))); verifyErr != nil {
+			err = i18n.M("password.error.current")
+		}
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.WarnContext(ctx, "password change rejected", "error", err)
+		page.Errors = explanations(err)
+	} else {
+		hashedpassword, err := s.hasher.Hash([]byte(newPW[0]))
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			s.log.ErrorContext(ctx, "failed to hash password", "error", err)
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		before := *user
+		user.Password = hashedpassword
+		err = s.userstore.UpdateUser(ctx, user)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			s.log.ErrorContext(ctx, "failed to update user", "error", err)
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		s.record(ctx, r, userEvent(model.ActionPasswordChange, user.ID), &before, user)
+		page.Message = i18n.M("password.changed")
+	}
+
+	err = s.render(w, r, s.templates().TmplDashboardUser, "password-change", page)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to execute template", "error", err)
+		return
+	}
+}
@@ -0,0 +1,211 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+
+	templates "github.com/led0nk/guestbook/internal"
+	"github.com/led0nk/guestbook/internal/middleware"
+	"github.com/led0nk/guestbook/internal/model"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrNoLoader is returned by Reload if the server has nothing to reload from
+var ErrNoLoader = errors.New("reloading is not configured")
+
+const targetConfig = "config"
+
+// Settings can be replaced at runtime by Reload
+type Settings struct {
+	Mailer     Mailerservice
+	Templates  *templates.TemplateHandler
+	RateLimits middleware.RateLimits
+	// theme of the templates and static assets
+	Theme templates.Theme
+	// applies what isn't part of the server, e.g. the log level, it is only
+	// called once the settings replaced the current ones
+	OnSwap func()
+}
+
+func (s *Settings) validate() error {
+	if s.Mailer == nil {
+		return errors.New("no mailer")
+	}
+	if err := s.Templates.Loaded(); err != nil {
+		return err
+	}
+	if err := s.Theme.Validate(); err != nil {
+		return err
+	}
+	return s.RateLimits.Validate()
+}
+
+// Loader builds new settings, e.g. from the config file, it returns an error
+// instead of settings which are not valid
+type Loader func(context.Context) (*Settings, error)
+
+// limiters of the rate limits, their rates are updated by Reload
+type limiters struct {
+	auth   *middleware.RateLimiter
+	signup *middleware.RateLimiter
+	search *middleware.RateLimiter
+	entry  *middleware.RateLimiter
+}
+
+func (s *Server) newLimiters(limits middleware.RateLimits) *limiters {
+	policy := func(name string, limit middleware.Limit, key middleware.KeyFunc) *middleware.RateLimiter {
+		return middleware.NewRateLimiter(middleware.RatePolicy{
+			Name: name, Rate: 1 / limit.Interval.Seconds(), Burst: limit.Burst, Key: key,
+		}, s.log)
+	}
+	return &limiters{
+		auth:   policy("auth", limits.Auth, middleware.ByIP(s.clientIP)),
+		signup: policy("signup", limits.Signup, middleware.ByIP(s.clientIP)),
+		search: policy("search", limits.Search, middleware.ByUser(s.tokenstore, s.clientIP)),
+		entry:  policy("entry", limits.Entry, middleware.ByUser(s.tokenstore, s.clientIP)),
+	}
+}
+
+func (l *limiters) set(limits middleware.RateLimits) {
+	l.auth.SetRate(1/limits.Auth.Interval.Seconds(), limits.Auth.Burst)
+	l.signup.SetRate(1/limits.Signup.Interval.Seconds(), limits.Signup.Burst)
+	l.search.SetRate(1/limits.Search.Interval.Seconds(), limits.Search.Burst)
+	l.entry.SetRate(1/limits.Entry.Interval.Seconds(), limits.Entry.Burst)
+}
+
+// mailer, templates and assets return the current settings, a handler should
+// call them once as they might change between two calls. In the dev mode of
+// the theme the templates are parsed and the assets loaded again once a file
+// of the theme changed.
+func (s *Server) mailer() Mailerservice {
+	return s.settings.Load().Mailer
+}
+
+func (s *Server) templates() *templates.TemplateHandler {
+	settings := s.settings.Load()
+	if !settings.Theme.Dev {
+		return settings.Templates
+	}
+	s.dev.mu.Lock()
+	defer s.dev.mu.Unlock()
+	s.dev.update(settings.Theme)
+	if s.dev.templates == nil && s.dev.templatesErr == nil {
+		s.dev.templates, s.dev.templatesErr = templates.ParseTheme(settings.Theme)
+		if s.dev.templatesErr != nil {
+			s.log.Error("failed to parse theme, using the loaded templates", "error", s.dev.templatesErr)
+		}
+	}
+	if s.dev.templatesErr != nil {
+		return settings.Templates
+	}
+	return s.dev.templates
+}
+
+func (s *Server) assets() *templates.Assets {
+	settings := s.settings.Load()
+	if !settings.Theme.Dev {
+		return settings.Templates.Assets
+	}
+	s.dev.mu.Lock()
+	defer s.dev.mu.Unlock()
+	s.dev.update(settings.Theme)
+	if s.dev.assets == nil && s.dev.assetsErr == nil {
+		s.dev.assets, s.dev.assetsErr = templates.LoadAssets(settings.Theme)
+		if s.dev.assetsErr != nil {
+			s.log.Error("failed to load assets of the theme, using the loaded assets", "error", s.dev.assetsErr)
+		}
+	}
+	if s.dev.assetsErr != nil {
+		return settings.Templates.Assets
+	}
+	return s.dev.assets
+}
+
+// devTheme keeps the templates and assets of a theme in dev mode, including
+// the errors of a broken theme, until a file of the theme changes
+type devTheme struct {
+	mu           sync.Mutex
+	theme        templates.Theme
+	stamp        string
+	templates    *templates.TemplateHandler
+	templatesErr error
+	assets       *templates.Assets
+	assetsErr    error
+}
+
+// update drops the kept templates and assets if the theme or its files
+// changed
+func (d *devTheme) update(theme templates.Theme) {
+	stamp, err := theme.Stamp()
+	if err == nil && theme == d.theme && stamp == d.stamp {
+		return
+	}
+	d.theme, d.stamp = theme, stamp
+	d.templates, d.templatesErr = nil, nil
+	d.assets, d.assetsErr = nil, nil
+}
+
+// serves the static assets of the current theme
+func (s *Server) staticHandler(w http.ResponseWriter, r *http.Request) {
+	s.assets().ServeHTTP(w, r)
+}
+
+// Reload replaces the settings by the ones of the loader, on failure the
+// current settings are kept. Sessions and other state are not affected.
+func (s *Server) Reload(ctx context.Context) error {
+	return s.reload(ctx, nil)
+}
+
+func (s *Server) reload(ctx context.Context, r *http.Request) error {
+	var span trace.Span
+	ctx, span = tracer.Start(ctx, "server.Reload")
+	defer span.End()
+
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	err := ErrNoLoader
+	var settings *Settings
+	if s.loader != nil {
+		settings, err = s.loader(ctx)
+	}
+	if err == nil {
+		err = settings.validate()
+	}
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	if s.reloads != nil {
+		s.reloads.Add(ctx, 1, metric.WithAttributes(attribute.String("result", result)))
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to reload, keeping the current settings", "error", err)
+		return err
+	}
+
+	previous := s.settings.Swap(settings)
+	s.limiters.set(settings.RateLimits)
+	if settings.OnSwap != nil {
+		settings.OnSwap()
+	}
+	s.record(ctx, r, &model.AuditEvent{Action: model.ActionConfigReload, TargetType: targetConfig}, previous.RateLimits, settings.RateLimits)
+	s.log.InfoContext(ctx, "settings reloaded")
+	return nil
+}
+
+// reloads the settings on behalf of an admin
+func (s *Server) reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if err := s.reload(r.Context(), r); err != nil {
+		http.Error(w, "failed to reload: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_, _ = w.Write([]byte("settings reloaded\n"))
+}
@@ -0,0 +1,161 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	templates "github.com/led0nk/guestbook/internal"
+	"github.com/led0nk/guestbook/internal/middleware"
+	"github.com/led0nk/guestbook/internal/model"
+)
+
+func TestReload(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	initial := f.server.settings.Load()
+
+	if err := f.server.Reload(ctx); !errors.Is(err, ErrNoLoader) {
+		t.Errorf("Expected %v without loader, got %v", ErrNoLoader, err)
+	}
+
+	limits := middleware.DefaultRateLimits
+	limits.Entry = middleware.Limit{Interval: time.Minute, Burst: 1}
+	mailer := &fakeMailer{links: make(map[string][]string), passwords: make(map[string]string), digests: make(map[string]*model.Digest)}
+	swaps := 0
+	onSwap := func() { swaps++ }
+
+	tests := []struct {
+		name     string
+		settings *Settings
+		err      error
+	}{
+		{name: "Loader fails", err: errors.New("invalid config")},
+		{name: "Templates missing", settings: &Settings{Mailer: mailer, RateLimits: limits, OnSwap: onSwap}},
+		{name: "Invalid rate limit", settings: &Settings{Mailer: mailer, Templates: initial.Templates, RateLimits: middleware.RateLimits{}, OnSwap: onSwap}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f.server.loader = func(context.Context) (*Settings, error) {
+				return test.settings, test.err
+			}
+			if err := f.server.Reload(ctx); err == nil {
+				t.Fatal("Expected reload to fail")
+			}
+			if f.server.settings.Load() != initial {
+				t.Error("Expected the current settings to be kept")
+			}
+			if swaps != 0 {
+				t.Error("Expected rejected settings not to be applied")
+			}
+		})
+	}
+
+	reloaded, err := templates.ParseTemplates()
+	if err != nil {
+		t.Fatalf("Error parsing templates: %v", err)
+	}
+	f.server.loader = func(context.Context) (*Settings, error) {
+		return &Settings{Mailer: mailer, Templates: reloaded, RateLimits: limits, OnSwap: onSwap}, nil
+	}
+	req := httptest.NewRequest(http.MethodPost, testOrigin+"/admin/reload", nil)
+	rec := httptest.NewRecorder()
+	f.server.reloadHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if f.server.mailer() != mailer || f.server.templates() != reloaded {
+		t.Error("Expected mailer and templates to be replaced")
+	}
+	if swaps != 1 {
+		t.Errorf("Expected the settings to be applied once, got %d", swaps)
+	}
+
+	entry := f.server.limiters.entry.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	codes := []int{}
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		entry.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, testOrigin+"/user/create", nil))
+		codes = append(codes, rec.Code)
+	}
+	if codes[0] != http.StatusNoContent || codes[1] != http.StatusTooManyRequests {
+		t.Errorf("Expected the reloaded burst of 1, got %v", codes)
+	}
+
+	events, err := f.aStore.ListEvents(ctx, model.AuditFilter{})
+	if err != nil {
+		t.Fatalf("Error listing events: %v", err)
+	}
+	if len(events) != 1 || events[0].Action != model.ActionConfigReload {
+		t.Fatalf("Expected one reload event, got %+v", events)
+	}
+	if len(events[0].Changes) != 1 || events[0].Changes[0].Field != "entry" {
+		t.Errorf("Expected change of the entry limit, got %+v", events[0].Changes)
+	}
+
+	f.server.loader = func(context.Context) (*Settings, error) {
+		return nil, errors.New("invalid config")
+	}
+	rec = httptest.NewRecorder()
+	f.server.reloadHandler(rec, req)
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status %d, got %d", http.StatusInternalServerError, rec.Code)
+	}
+	if f.server.mailer() != mailer {
+		t.Error("Expected the reloaded settings to be kept")
+	}
+}
+
+func TestThemeDevMode(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "static"), 0o755); err != nil {
+		t.Fatalf("Error creating theme: %v", err)
+	}
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("Error writing %s: %v", name, err)
+		}
+	}
+	write("header.html", `{{ define "header" }}<header>first</header>{{ end }}`)
+	write("static/style.css", "body { color: teal; }")
+
+	f := newFixture(t)
+	settings := *f.server.settings.Load()
+	settings.Theme = templates.Theme{Dir: dir, Dev: true}
+	f.server.settings.Store(&settings)
+
+	login := func() string {
+		rec := httptest.NewRecorder()
+		f.server.loginHandler(rec, httptest.NewRequest(http.MethodGet, testOrigin+"/login", nil))
+		return rec.Body.String()
+	}
+	if body := login(); !strings.Contains(body, "<header>first</header>") {
+		t.Fatalf("Expected the header of the theme, got %s", body)
+	}
+	if f.server.templates() != f.server.templates() || f.server.assets() != f.server.assets() {
+		t.Error("Expected the theme to be parsed once while its files don't change")
+	}
+	write("header.html", `{{ define "header" }}<header>second</header>{{ end }}`)
+	if body := login(); !strings.Contains(body, "<header>second</header>") {
+		t.Error("Expected the changed header in dev mode")
+	}
+	write("header.html", `{{ define "header" }}`)
+	if body := login(); !strings.Contains(body, "Login") {
+		t.Error("Expected the loaded templates if the theme is invalid")
+	}
+
+	rec := httptest.NewRecorder()
+	static := http.StripPrefix("/static/", http.HandlerFunc(f.server.staticHandler))
+	static.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, testOrigin+"/static/style.css", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "teal") {
+		t.Errorf("Expected the stylesheet of the theme, got %d %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,61 @@
+package v1
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+
+	templates "github.com/led0nk/guestbook/internal"
+	"github.com/led0nk/guestbook/internal/middleware"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maximum size of a CSP violation report
+const maxReportSize = 64 << 10
+
+// render executes the template, or its associated template name, with the
+// nonce of the Content-Security-Policy and the locale of r
+func (s *Server) render(w http.ResponseWriter, r *http.Request, tmpl *template.Template, name string, data any) error {
+	return templates.Execute(w, tmpl, name, templates.Request{Nonce: middleware.Nonce(r), Locale: middleware.Locale(r)}, data)
+}
+
+// cspReport is sent by browsers to the report-uri of the
+// Content-Security-Policy
+type cspReport struct {
+	Report struct {
+		DocumentURI        string `json:"document-uri"`
+		ViolatedDirective  string `json:"violated-directive"`
+		EffectiveDirective string `json:"effective-directive"`
+		BlockedURI         string `json:"blocked-uri"`
+		SourceFile         string `json:"source-file"`
+		LineNumber         int    `json:"line-number"`
+	} `json:"csp-report"`
+}
+
+// logs violations of the Content-Security-Policy
+func (s *Server) cspReportHandler(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.cspReport")
+	defer span.End()
+
+	var report cspReport
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxReportSize)).Decode(&report)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.WarnContext(ctx, "failed to decode CSP report", "error", err)
+		http.Error(w, "invalid report", http.StatusBadRequest)
+		return
+	}
+	s.log.WarnContext(ctx, "content security policy violation",
+		"document", report.Report.DocumentURI,
+		"directive", report.Report.ViolatedDirective,
+		"effective", report.Report.EffectiveDirective,
+		"blocked", report.Report.BlockedURI,
+		"source", report.Report.SourceFile,
+		"line", report.Report.LineNumber,
+	)
+	w.WriteHeader(http.StatusNoContent)
+}
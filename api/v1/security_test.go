@@ -0,0 +1,75 @@
+package v1
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/led0nk/guestbook/internal/middleware"
+)
+
+func TestLoginPageNonce(t *testing.T) {
+	f := newFixture(t)
+	handler := middleware.Security(f.server.security)(http.HandlerFunc(f.server.loginHandler))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, testOrigin+"/login", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	match := regexp.MustCompile(`'nonce-([A-Za-z0-9]+)'`).FindStringSubmatch(rec.Header().Get("Content-Security-Policy"))
+	if match == nil {
+		t.Fatalf("Expected a nonce in the CSP, got %q", rec.Header().Get("Content-Security-Policy"))
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, `<script nonce="`+match[1]+`">`) {
+		t.Errorf("Expected the inline script to carry the nonce %s", match[1])
+	}
+	if strings.Contains(body, "onclick=") {
+		t.Error("Expected no inline event handlers")
+	}
+	//NOTE: scripts of a CDN are allowed by their URL instead of the whole host
+	csp := strings.Fields(strings.ReplaceAll(rec.Header().Get("Content-Security-Policy"), ";", " "))
+	for _, src := range regexp.MustCompile(`<script src="(https://[^"]+)"([^>]*)>`).FindAllStringSubmatch(body, -1) {
+		if !slices.Contains(csp, src[1]) {
+			t.Errorf("Expected the CSP to allow exactly the script %s, got %v", src[1], csp)
+		}
+		if !strings.Contains(src[2], "integrity=") {
+			t.Errorf("Expected the script %s to be loaded with its integrity", src[1])
+		}
+	}
+	if slices.Contains(csp, "https://unpkg.com") {
+		t.Error("Expected the CSP not to allow every script of unpkg")
+	}
+}
+
+func TestCSPReport(t *testing.T) {
+	f := newFixture(t)
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{
+			name:   "Violation",
+			body:   `{"csp-report":{"document-uri":"https://guestbook/login","violated-directive":"script-src","blocked-uri":"inline"}}`,
+			status: http.StatusNoContent,
+		},
+		{name: "Invalid", body: `csp`, status: http.StatusBadRequest},
+		{name: "Too large", body: `{"csp-report":{"blocked-uri":"` + strings.Repeat("a", maxReportSize) + `"}}`, status: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, testOrigin+"/csp-report", strings.NewReader(test.body))
+			req.Header.Set("Content-Type", "application/csp-report")
+			rec := httptest.NewRecorder()
+			f.server.cspReportHandler(rec, req)
+			if rec.Code != test.status {
+				t.Errorf("Expected status %d, got %d", test.status, rec.Code)
+			}
+		})
+	}
+}
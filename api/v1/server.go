@@ -1,17 +1,28 @@
 package v1
 
 import (
-	"html"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
-	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/google/uuid"
 	templates "github.com/led0nk/guestbook/internal"
 	db "github.com/led0nk/guestbook/internal/database"
+	"github.com/led0nk/guestbook/internal/health"
+	"github.com/led0nk/guestbook/internal/lockout"
 	"github.com/led0nk/guestbook/internal/middleware"
 	"github.com/led0nk/guestbook/internal/model"
+	"github.com/led0nk/guestbook/internal/oidc"
+	"github.com/led0nk/guestbook/internal/password"
+	"github.com/led0nk/guestbook/internal/scheduler"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	sloghttp "github.com/samber/slog-http"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
@@ -24,39 +35,186 @@ import (
 var tracer = otel.GetTracerProvider().Tracer("github.com/led0nk/guestbook/api/v1")
 var meter = otel.GetMeterProvider().Meter("github.com/led0nk/guestbook/api/v1")
 
+// TLS configures HTTPS, without Config the server listens in plaintext, e.g.
+// behind a proxy terminating TLS
+type TLS struct {
+	Config *tls.Config
+	// address of a plaintext listener redirecting to HTTPS, empty disables it
+	RedirectAddr string
+	// max-age of Strict-Transport-Security, 0 disables it
+	HSTS time.Duration
+}
+
 type Server struct {
-	addr       string
-	mailer     Mailerservice
-	domain     string
-	templates  *templates.TemplateHandler
-	log        *slog.Logger
-	bookstore  db.GuestBookStore
-	userstore  db.UserStore
-	tokenstore db.TokenStore
+	addr            string
+	domain          string
+	log             *slog.Logger
+	bookstore       db.GuestBookStore
+	userstore       db.UserStore
+	tokenstore      db.TokenStore
+	credentialstore db.CredentialStore
+	invitationstore db.InvitationStore
+	auditstore      db.AuditStore
+	webauthn        *webauthn.WebAuthn
+	ceremonies      *ceremonyStore[*webauthn.SessionData]
+	providers       map[string]*oidc.Provider
+	oidcLogins      *ceremonyStore[*oidcLogin]
+	magicLink       bool
+	magicLimiter    *addressLimiter
+	accountGuard    *lockout.Guard
+	ipGuard         *lockout.Guard
+	clientIP        *middleware.ClientIP
+	hasher          *password.Hasher
+	policy          *password.Policy
+	registration    RegistrationMode
+	entryPolicy     EntryPolicy
+	deletionGrace   time.Duration
+	digestInterval  time.Duration
+	scheduler       *scheduler.Scheduler
+	// time to finish running requests and jobs on shutdown
+	drainTimeout time.Duration
+	health       *health.Registry
+	// time between failing the readiness probe and closing the listener
+	shutdownDelay time.Duration
+	// mailer, templates and rate limits, replaced as a whole by Reload
+	settings atomic.Pointer[Settings]
+	limiters *limiters
+	loader   Loader
+	reloadMu sync.Mutex
+	// templates and assets of the theme in dev mode
+	dev      devTheme
+	reloads  metric.Int64Counter
+	tls      TLS
+	security middleware.SecurityPolicy
+}
+
+// Options of NewServer, the stores are required, other fields fall back to
+// their defaults if they are not set
+type Options struct {
+	Addr   string
+	Domain string
+	Mailer Mailerservice
+	// embedded templates if not set
+	Templates *templates.TemplateHandler
+	Theme     templates.Theme
+
+	Entries     db.GuestBookStore
+	Users       db.UserStore
+	Tokens      db.TokenStore
+	Credentials db.CredentialStore
+	Invitations db.InvitationStore
+	Audit       db.AuditStore
+
+	WebAuthn  *webauthn.WebAuthn
+	Providers []*oidc.Provider
+	MagicLink bool
+	// client IPs of the requests, the remote address if not set
+	ClientIP *middleware.ClientIP
+	Hasher   *password.Hasher
+	Policy   *password.Policy
+
+	Registration RegistrationMode
+	EntryPolicy  EntryPolicy
+	// time until a deleted account is removed, 0 removes it right away
+	DeletionGrace time.Duration
+	// interval of the activity digest, 0 disables it
+	DigestInterval time.Duration
+	// time to finish running requests and jobs on shutdown
+	DrainTimeout time.Duration
+	// time between failing the readiness probe and closing the listener
+	ShutdownDelay time.Duration
+
+	Health     *health.Registry
+	RateLimits middleware.RateLimits
+	// builds the settings on reload, reloading fails if not set
+	Loader   Loader
+	TLS      TLS
+	Security middleware.SecurityPolicy
 }
 
-func NewServer(
-	address string,
-	mailer Mailerservice,
-	domain string,
-	templates *templates.TemplateHandler,
-	bStore db.GuestBookStore,
-	uStore db.UserStore,
-	tStore db.TokenStore,
-) *Server {
-	return &Server{
-		addr:       address,
-		mailer:     mailer,
-		domain:     domain,
-		templates:  templates,
-		log:        slog.Default().WithGroup("http"),
-		bookstore:  bStore,
-		userstore:  uStore,
-		tokenstore: tStore,
+func NewServer(opts Options) *Server {
+	if opts.Templates == nil {
+		opts.Templates = templates.NewTemplateHandler()
+	}
+	if opts.Hasher == nil {
+		opts.Hasher = password.NewHasher(password.DefaultArgon2id, password.DefaultBcrypt)
+	}
+	if opts.Policy == nil {
+		opts.Policy = &password.DefaultPolicy
+	}
+	if opts.Registration == "" {
+		opts.Registration = RegistrationOpen
+	}
+	if opts.EntryPolicy == "" {
+		opts.EntryPolicy = EntriesDelete
+	}
+	if opts.Health == nil {
+		opts.Health = health.NewRegistry()
+	}
+	if opts.RateLimits == (middleware.RateLimits{}) {
+		opts.RateLimits = middleware.DefaultRateLimits
+	}
+	if opts.Security.Default == (middleware.SecurityHeaders{}) {
+		opts.Security = middleware.DefaultSecurityPolicy
+	}
+	providerMap := make(map[string]*oidc.Provider, len(opts.Providers))
+	for _, provider := range opts.Providers {
+		providerMap[provider.Name()] = provider
+	}
+	logger := slog.Default().WithGroup("http")
+	s := &Server{
+		addr:            opts.Addr,
+		domain:          opts.Domain,
+		log:             logger,
+		bookstore:       opts.Entries,
+		userstore:       opts.Users,
+		tokenstore:      opts.Tokens,
+		credentialstore: opts.Credentials,
+		invitationstore: opts.Invitations,
+		auditstore:      opts.Audit,
+		webauthn:        opts.WebAuthn,
+		ceremonies:      newCeremonyStore[*webauthn.SessionData](ceremonyTimeout),
+		providers:       providerMap,
+		oidcLogins:      newCeremonyStore[*oidcLogin](ceremonyTimeout),
+		magicLink:       opts.MagicLink,
+		magicLimiter:    newAddressLimiter(magicLinkLimit, magicLinkWindow),
+		accountGuard:    lockout.NewGuard("account", lockout.AccountPolicy),
+		ipGuard:         lockout.NewGuard("ip", lockout.IPPolicy),
+		clientIP:        opts.ClientIP,
+		hasher:          opts.Hasher,
+		policy:          opts.Policy,
+		registration:    opts.Registration,
+		entryPolicy:     opts.EntryPolicy,
+		deletionGrace:   opts.DeletionGrace,
+		digestInterval:  opts.DigestInterval,
+		scheduler:       scheduler.New(logger.WithGroup("scheduler")),
+		drainTimeout:    opts.DrainTimeout,
+		health:          opts.Health,
+		shutdownDelay:   opts.ShutdownDelay,
+		loader:          opts.Loader,
+		tls:             opts.TLS,
+		security:        opts.Security,
+	}
+	s.settings.Store(&Settings{Mailer: opts.Mailer, Templates: opts.Templates, RateLimits: opts.RateLimits, Theme: opts.Theme})
+	s.limiters = s.newLimiters(opts.RateLimits)
+	reloads, err := meter.Int64Counter(
+		"server.reloads",
+		metric.WithDescription("Reloads of the settings by result"),
+	)
+	if err != nil {
+		logger.Error("metrics", "error", err)
 	}
+	s.reloads = reloads
+	s.registerJobs()
+	s.health.Register(health.Check{Name: "templates", Critical: true, Liveness: true, Run: func(context.Context) error {
+		return s.templates().Loaded()
+	}})
+	return s
 }
 
-func (s *Server) ServeHTTP() {
+// ServeHTTP serves the guestbook and runs the periodic jobs until ctx is done,
+// then it waits up to the drain timeout for running requests and jobs
+func (s *Server) ServeHTTP(ctx context.Context) error {
 	r := http.NewServeMux()
 
 	otelmw := otelhttp.NewMiddleware("guestbook")
@@ -71,47 +229,159 @@ func (s *Server) ServeHTTP() {
 		},
 	)
 	traceAttrmw := middleware.SlogAddTraceAttributes()
+	schememw := middleware.Scheme(s.clientIP)
+	hstsmw := middleware.HSTS(s.tls.HSTS)
+	securitymw := middleware.Security(s.security)
+	localemw := middleware.Localize()
+	apimw := middleware.API
+	apiauthmw := s.apiAuth(false)
+	apiadminmw := s.apiAuth(true)
+
+	authLimit := s.limiters.auth.Middleware
+	signupLimit := s.limiters.signup.Middleware
+	searchLimit := s.limiters.search.Middleware
+	entryLimit := s.limiters.entry.Middleware
 
 	r.Handle("GET /", http.HandlerFunc(s.handlePage))
+	r.Handle("GET /static/", http.StripPrefix("/static/", http.HandlerFunc(s.staticHandler)))
 	//NOTE: register /metrics
 	r.Handle("GET /metrics", promhttp.Handler())
+	r.Handle("GET /healthz", s.health.LivenessHandler())
+	r.Handle("GET /readyz", s.health.ReadinessHandler())
+	r.Handle("POST /csp-report", searchLimit(http.HandlerFunc(s.cspReportHandler)))
+	r.Handle("POST /locale", searchLimit(http.HandlerFunc(s.localeHandler)))
 	r.Handle("GET /login", http.HandlerFunc(s.loginHandler))
-	r.Handle("POST /login", http.HandlerFunc(s.loginAuth))
+	r.Handle("POST /login", authLimit(http.HandlerFunc(s.loginAuth)))
 	r.Handle("GET /logout", http.HandlerFunc(s.logoutAuth))
 	r.Handle("GET /signup", http.HandlerFunc(s.signupHandler))
-	r.Handle("POST /signup", http.HandlerFunc(s.signupAuth))
+	r.Handle("POST /signup", signupLimit(http.HandlerFunc(s.signupAuth)))
 	r.Handle("GET /forgot-pw", http.HandlerFunc(s.forgotHandler))
-	r.Handle("POST /forgot-pw", http.HandlerFunc(s.forgotPW))
-
+	r.Handle("POST /forgot-pw", signupLimit(http.HandlerFunc(s.forgotPW)))
+	r.Handle("POST /login/passkey/begin", authLimit(http.HandlerFunc(s.beginPasskeyLogin)))
+	r.Handle("POST /login/passkey/finish", authLimit(http.HandlerFunc(s.finishPasskeyLogin)))
+	r.Handle("POST /login/magic", signupLimit(http.HandlerFunc(s.requestMagicLink)))
+	r.Handle("GET /login/magic", http.HandlerFunc(s.magicLinkHandler))
+	r.Handle("POST /login/magic/confirm", authLimit(http.HandlerFunc(s.confirmMagicLink)))
+	r.Handle("GET /login/oidc/{provider}", authLimit(http.HandlerFunc(s.oidcLogin)))
+	r.Handle("GET /login/oidc/{provider}/callback", authLimit(http.HandlerFunc(s.oidcCallback)))
+
+	r.Handle("GET /user/email/confirm", authLimit(http.HandlerFunc(s.confirmEmailChange)))
 	r.Handle("GET /user/verify", authmw(http.HandlerFunc(s.verifyHandler)))
-	r.Handle("POST /user/verify", authmw(http.HandlerFunc(s.verifyAuth)))
+	r.Handle("POST /user/verify", authmw(authLimit(http.HandlerFunc(s.verifyAuth))))
 	r.Handle("GET /user/dashboard", authmw(http.HandlerFunc(s.dashboardHandler)))
 	r.Handle("POST /user/dashboard/{ID}", authmw(http.HandlerFunc(s.changeUserData)))
 	r.Handle("PUT /user/dashboard/{ID}", authmw(http.HandlerFunc(s.submitUserData)))
 	r.Handle("GET /user/create", authmw(http.HandlerFunc(s.createHandler)))
 	r.Handle("GET /user/search", authmw(http.HandlerFunc(s.searchHandler)))
-	r.Handle("GET /user/search/", authmw(http.HandlerFunc(s.search)))
-	r.Handle("POST /user/create", authmw(http.HandlerFunc(s.createEntry)))
+	r.Handle("GET /user/search/", authmw(searchLimit(http.HandlerFunc(s.search))))
+	r.Handle("POST /user/create", authmw(entryLimit(http.HandlerFunc(s.createEntry))))
 	r.Handle("PUT /user/dashboard/{ID}/password-reset", authmw(http.HandlerFunc(s.passwordReset)))
+	r.Handle("GET /user/dashboard/{ID}/password", authmw(http.HandlerFunc(s.passwordChangeHandler)))
+	r.Handle("PUT /user/dashboard/{ID}/password", authmw(authLimit(http.HandlerFunc(s.changePassword))))
+	r.Handle("GET /user/dashboard/{ID}/export", authmw(http.HandlerFunc(s.exportAccount)))
+	r.Handle("GET /user/dashboard/{ID}/delete", authmw(http.HandlerFunc(s.accountDeleteHandler)))
+	r.Handle("POST /user/dashboard/{ID}/delete", authmw(authLimit(http.HandlerFunc(s.requestAccountDeletion))))
+	r.Handle("DELETE /user/dashboard/{ID}/delete", authmw(http.HandlerFunc(s.cancelAccountDeletion)))
+	r.Handle("POST /user/passkey/register/begin", authmw(http.HandlerFunc(s.beginPasskeyRegistration)))
+	r.Handle("POST /user/passkey/register/finish", authmw(http.HandlerFunc(s.finishPasskeyRegistration)))
 
 	r.Handle("GET /admin/dashboard", adminmw(http.HandlerFunc(s.adminHandler)))
 	r.Handle("DELETE /admin/dashboard/{ID}", adminmw(http.HandlerFunc(s.deleteUser)))
 	r.Handle("POST /admin/dashboard/{ID}", adminmw(http.HandlerFunc(s.updateUser)))
 	r.Handle("PUT /admin/dashboard/{ID}", adminmw(http.HandlerFunc(s.saveUser)))
 	r.Handle("PUT /admin/dashboard/{ID}/verify", adminmw(http.HandlerFunc(s.resendVer)))
-	r.Handle("PUT /admin/dashboard/{ID}/password-reset", adminmw(http.HandlerFunc(s.passwordReset)))
+	r.Handle("PUT /admin/dashboard/{ID}/password-reset", adminmw(http.HandlerFunc(s.adminPasswordReset)))
+	r.Handle("PUT /admin/dashboard/{ID}/unlock", adminmw(http.HandlerFunc(s.unlockUser)))
+	r.Handle("GET /admin/audit", adminmw(http.HandlerFunc(s.auditHandler)))
+	r.Handle("GET /admin/audit/export", adminmw(http.HandlerFunc(s.exportAudit)))
+	r.Handle("GET /admin/invitations", adminmw(http.HandlerFunc(s.invitationsHandler)))
+	r.Handle("POST /admin/invitations", adminmw(http.HandlerFunc(s.createInvitation)))
+	r.Handle("DELETE /admin/invitations/{ID}", adminmw(http.HandlerFunc(s.deleteInvitation)))
+
+	r.Handle("GET /admin/jobs", adminmw(http.HandlerFunc(s.jobsHandler)))
+	r.Handle("POST /admin/jobs/{name}", adminmw(http.HandlerFunc(s.runJob)))
+	r.Handle("POST /admin/reload", adminmw(http.HandlerFunc(s.reloadHandler)))
+	//NOTE: JSON API, authenticated by the session token as bearer token or cookie
+	r.Handle("POST /api/v1/sessions", apimw(authLimit(http.HandlerFunc(s.apiLogin))))
+	r.Handle("GET /api/v1/sessions", apimw(apiauthmw(http.HandlerFunc(s.apiSessions))))
+	r.Handle("DELETE /api/v1/sessions/current", apimw(apiauthmw(http.HandlerFunc(s.apiLogout))))
+	r.Handle("GET /api/v1/entries", apimw(http.HandlerFunc(s.apiListEntries)))
+	r.Handle("GET /api/v1/entries/{ID}", apimw(http.HandlerFunc(s.apiGetEntry)))
+	r.Handle("POST /api/v1/entries", apimw(apiauthmw(entryLimit(http.HandlerFunc(s.apiCreateEntry)))))
+	r.Handle("PATCH /api/v1/entries/{ID}", apimw(apiauthmw(entryLimit(http.HandlerFunc(s.apiUpdateEntry)))))
+	r.Handle("DELETE /api/v1/entries/{ID}", apimw(apiauthmw(entryLimit(http.HandlerFunc(s.apiDeleteEntry)))))
+	r.Handle("GET /api/v1/user", apimw(apiauthmw(http.HandlerFunc(s.apiProfile))))
+	r.Handle("PATCH /api/v1/user", apimw(apiauthmw(http.HandlerFunc(s.apiUpdateProfile))))
+	r.Handle("GET /api/v1/users", apimw(apiadminmw(http.HandlerFunc(s.apiListUsers))))
+	r.Handle("GET /api/v1/users/{ID}", apimw(apiadminmw(http.HandlerFunc(s.apiGetUser))))
+	r.Handle("PATCH /api/v1/users/{ID}", apimw(apiadminmw(http.HandlerFunc(s.apiUpdateUser))))
+	r.Handle("DELETE /api/v1/users/{ID}", apimw(apiadminmw(http.HandlerFunc(s.apiDeleteUser))))
+	//NOTE: patterns without a method would conflict with GET /
+	for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		r.Handle(method+" /api/", apimw(http.HandlerFunc(s.apiNotFound)))
+	}
 
-	s.log.Info("listening to", "addr", s.addr)
+	s.scheduler.Start(ctx)
 
 	srv := &http.Server{
-		Addr:    s.addr,
-		Handler: slogmw(traceAttrmw(otelmw(r))),
+		Addr:      s.addr,
+		Handler:   slogmw(traceAttrmw(otelmw(schememw(hstsmw(securitymw(localemw(r))))))),
+		TLSConfig: s.tls.Config,
 	}
-	err := srv.ListenAndServe()
-	if err != nil {
-		s.log.Error("error during listen and server", "error", err)
-		os.Exit(1)
+	servers := []*http.Server{srv}
+	serveErr := make(chan error, 2)
+	go func() {
+		if srv.TLSConfig != nil {
+			s.log.Info("listening to", "addr", s.addr, "tls", true)
+			serveErr <- srv.ListenAndServeTLS("", "")
+			return
+		}
+		s.log.Info("listening to", "addr", s.addr)
+		serveErr <- srv.ListenAndServe()
+	}()
+	if s.tls.RedirectAddr != "" {
+		redirect := &http.Server{Addr: s.tls.RedirectAddr, Handler: s.redirectHandler()}
+		servers = append(servers, redirect)
+		go func() {
+			s.log.Info("redirecting to https", "addr", s.tls.RedirectAddr)
+			serveErr <- redirect.ListenAndServe()
+		}()
+	}
+
+	var err error
+	select {
+	case err = <-serveErr:
+		s.log.Error("error during listen and serve", "error", err)
+	case <-ctx.Done():
+		s.log.Info("shutting down", "drain", s.drainTimeout)
+		//NOTE: load balancers stop routing to the server once it is not ready
+		s.health.SetDraining(true)
+		time.Sleep(s.shutdownDelay)
 	}
+
+	//NOTE: the drain must not be canceled by ctx, which is already done
+	drainCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.drainTimeout)
+	defer cancel()
+	for _, server := range servers {
+		if shutdownErr := server.Shutdown(drainCtx); shutdownErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to drain requests: %w", shutdownErr))
+		}
+	}
+	if stopErr := s.scheduler.Stop(drainCtx); stopErr != nil {
+		err = errors.Join(err, fmt.Errorf("failed to stop jobs: %w", stopErr))
+	}
+	return err
+}
+
+// redirectHandler answers the probes in plaintext and redirects everything
+// else to HTTPS
+func (s *Server) redirectHandler() http.Handler {
+	_, port, _ := net.SplitHostPort(s.addr)
+	r := http.NewServeMux()
+	r.Handle("GET /healthz", s.health.LivenessHandler())
+	r.Handle("GET /readyz", s.health.ReadinessHandler())
+	r.Handle("/", middleware.RedirectHTTPS(port))
+	return r
 }
 
 // hands over Entries to Handler and prints them out in template
@@ -138,7 +408,7 @@ func (s *Server) handlePage(w http.ResponseWriter, r *http.Request) {
 		span.SetStatus(codes.Error, err.Error())
 		s.log.ErrorContext(ctx, "failed to list entries", "error", err)
 	}
-	err = s.templates.TmplHome.Execute(w, &entries)
+	err = s.render(w, r, s.templates().TmplHome, "", &entries)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
@@ -163,7 +433,7 @@ func (s *Server) searchHandler(w http.ResponseWriter, r *http.Request) {
 		s.log.ErrorContext(ctx, "failed to list entries", "error", err)
 		return
 	}
-	err = s.templates.TmplSearch.Execute(w, entries)
+	err = s.render(w, r, s.templates().TmplSearch, "", entries)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
@@ -179,7 +449,10 @@ func (s *Server) loginHandler(w http.ResponseWriter, r *http.Request) {
 	_, span = tracer.Start(ctx, "server.loginHandler")
 	defer span.End()
 
-	err := s.templates.TmplLogin.Execute(w, nil)
+	err := s.render(w, r, s.templates().TmplLogin, "", &loginPage{
+		Providers: s.providerNames(),
+		MagicLink: s.magicLink,
+	})
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
@@ -196,7 +469,12 @@ func (s *Server) signupHandler(w http.ResponseWriter, r *http.Request) {
 	_, span = tracer.Start(ctx, "server.signupHandler")
 	defer span.End()
 
-	err := s.templates.TmplSignUp.Execute(w, nil)
+	err := s.render(w, r, s.templates().TmplSignUp, "", &signupPage{
+		Rules:        s.policy.Describe(),
+		Invite:       r.URL.Query().Get("invite"),
+		Registration: s.registration,
+		Providers:    s.providerNames(),
+	})
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
@@ -245,7 +523,7 @@ func (s *Server) dashboardHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = s.templates.TmplDashboard.Execute(w, user)
+	err = s.render(w, r, s.templates().TmplDashboard, "", user)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
@@ -261,7 +539,7 @@ func (s *Server) createHandler(w http.ResponseWriter, r *http.Request) {
 	_, span = tracer.Start(ctx, "server.createHandler")
 	defer span.End()
 
-	err := s.templates.TmplCreate.Execute(w, nil)
+	err := s.render(w, r, s.templates().TmplCreate, "", nil)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
@@ -277,7 +555,7 @@ func (s *Server) verifyHandler(w http.ResponseWriter, r *http.Request) {
 	_, span = tracer.Start(ctx, "server.verifyHandler")
 	defer span.End()
 
-	err := s.templates.TmplVerification.Execute(w, nil)
+	err := s.render(w, r, s.templates().TmplVerification, "", nil)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
@@ -301,7 +579,10 @@ func (s *Server) adminHandler(w http.ResponseWriter, r *http.Request) {
 		s.log.ErrorContext(ctx, "failed to list user", "error", err)
 		return
 	}
-	err = s.templates.TmplAdmin.Execute(w, &users)
+	for _, user := range users {
+		user.LockedUntil = s.lockedUntil(user)
+	}
+	err = s.render(w, r, s.templates().TmplAdmin, "", &users)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
@@ -317,7 +598,7 @@ func (s *Server) forgotHandler(w http.ResponseWriter, r *http.Request) {
 	_, span = tracer.Start(ctx, "server.forgotHandler")
 	defer span.End()
 
-	err := s.templates.TmplForgot.Execute(w, nil)
+	err := s.render(w, r, s.templates().TmplForgot, "", nil)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
@@ -361,15 +642,16 @@ func (s *Server) createEntry(w http.ResponseWriter, r *http.Request) {
 		s.log.ErrorContext(ctx, "failed to get user", "error", err)
 		return
 	}
-	newEntry := model.GuestbookEntry{Name: user.Name, Message: html.EscapeString(r.FormValue("message")), UserID: user.ID}
+	newEntry := model.GuestbookEntry{Name: user.Name, Message: r.FormValue("message"), UserID: user.ID}
 
-	_, err = s.bookstore.CreateEntry(ctx, &newEntry)
+	entryID, err := s.bookstore.CreateEntry(ctx, &newEntry)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
 		s.log.ErrorContext(ctx, "failed to create entry", "error", err)
 		return
 	}
+	s.record(ctx, r, &model.AuditEvent{Action: model.ActionEntryCreate, Actor: user.ID, Target: entryID, TargetType: targetEntry}, nil, &newEntry)
 	http.Redirect(w, r, "/user/dashboard", http.StatusFound)
 }
 
@@ -393,7 +675,7 @@ func (s *Server) changeUserData(w http.ResponseWriter, r *http.Request) {
 		s.log.ErrorContext(ctx, "failed to get user", "error", err)
 		return
 	}
-	err = s.templates.TmplDashboardUser.ExecuteTemplate(w, "user-update", user)
+	err = s.render(w, r, s.templates().TmplDashboardUser, "user-update", &userPage{User: user})
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, err.Error())
@@ -0,0 +1,125 @@
+package v1
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/led0nk/guestbook/internal/certificate"
+	"github.com/led0nk/guestbook/internal/scheduler"
+)
+
+func TestServeHTTPShutdown(t *testing.T) {
+	f := newFixture(t, func(o *Options) {
+		o.Addr = "127.0.0.1:0"
+		o.DrainTimeout = time.Second
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- f.server.ServeHTTP(ctx) }()
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected clean shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected ServeHTTP to return after the context is done")
+	}
+	if err := f.server.scheduler.RunNow(context.Background(), jobPurgeSessions); !errors.Is(err, scheduler.ErrStopped) {
+		t.Errorf("Expected jobs to be stopped, got %v", err)
+	}
+}
+
+func TestServeHTTPListenError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	defer listener.Close()
+
+	f := newFixture(t, func(o *Options) { o.Addr = listener.Addr().String() })
+	if err := f.server.ServeHTTP(context.Background()); err == nil {
+		t.Errorf("Expected error for an address in use")
+	}
+}
+
+func freeAddr(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+func TestServeHTTPTLS(t *testing.T) {
+	cert, err := certificate.SelfSigned("127.0.0.1")
+	if err != nil {
+		t.Fatalf("Error creating certificate: %v", err)
+	}
+	f := newFixture(t, func(o *Options) {
+		o.Addr = freeAddr(t)
+		o.DrainTimeout = time.Second
+		o.TLS = TLS{
+			Config: certificate.Config(func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+				return cert, nil
+			}),
+			RedirectAddr: freeAddr(t),
+			HSTS:         time.Hour,
+		}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- f.server.ServeHTTP(ctx) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Expected clean shutdown, got %v", err)
+		}
+	}()
+
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+		Timeout: time.Second,
+	}
+	get := func(url string) *http.Response {
+		var lastErr error
+		for i := 0; i < 50; i++ {
+			resp, err := client.Get(url)
+			if err == nil {
+				resp.Body.Close()
+				return resp
+			}
+			lastErr = err
+			time.Sleep(20 * time.Millisecond)
+		}
+		t.Fatalf("Error requesting %s: %v", url, lastErr)
+		return nil
+	}
+
+	resp := get("https://" + f.server.addr + "/healthz")
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if hsts := resp.Header.Get("Strict-Transport-Security"); hsts != "max-age=3600; includeSubDomains" {
+		t.Errorf("Expected HSTS header, got %q", hsts)
+	}
+
+	resp = get("http://" + f.server.tls.RedirectAddr + "/login?next=1")
+	_, port, _ := net.SplitHostPort(f.server.addr)
+	if resp.StatusCode != http.StatusPermanentRedirect || resp.Header.Get("Location") != "https://127.0.0.1:"+port+"/login?next=1" {
+		t.Errorf("Expected redirect to https, got %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	if resp = get("http://" + f.server.tls.RedirectAddr + "/healthz"); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected probes without redirect, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,401 @@
+package v1
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"github.com/led0nk/guestbook/internal/middleware"
+	"github.com/led0nk/guestbook/internal/model"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// passkeyUser links a model.User and its credentials to webauthn.User
+type passkeyUser struct {
+	user        *model.User
+	credentials []*model.Credential
+}
+
+func (p *passkeyUser) WebAuthnID() []byte {
+	return p.user.ID[:]
+}
+
+func (p *passkeyUser) WebAuthnName() string {
+	return p.user.Email
+}
+
+func (p *passkeyUser) WebAuthnDisplayName() string {
+	return p.user.Name
+}
+
+func (p *passkeyUser) WebAuthnIcon() string {
+	return ""
+}
+
+func (p *passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, 0, len(p.credentials))
+	for _, credential := range p.credentials {
+		credentials = append(credentials, toWebAuthnCredential(credential))
+	}
+	return credentials
+}
+
+func toWebAuthnCredential(c *model.Credential) webauthn.Credential {
+	transport := make([]protocol.AuthenticatorTransport, 0, len(c.Transport))
+	for _, t := range c.Transport {
+		transport = append(transport, protocol.AuthenticatorTransport(t))
+	}
+	return webauthn.Credential{
+		ID:              c.CredentialID,
+		PublicKey:       c.PublicKey,
+		AttestationType: c.AttestationType,
+		Transport:       transport,
+		Flags: webauthn.CredentialFlags{
+			BackupEligible: c.BackupEligible,
+			BackupState:    c.BackupState,
+		},
+		Authenticator: webauthn.Authenticator{
+			AAGUID:       c.AAGUID,
+			SignCount:    c.SignCount,
+			CloneWarning: c.CloneWarning,
+		},
+	}
+}
+
+func fromWebAuthnCredential(userID uuid.UUID, c *webauthn.Credential) *model.Credential {
+	transport := make([]string, 0, len(c.Transport))
+	for _, t := range c.Transport {
+		transport = append(transport, string(t))
+	}
+	return &model.Credential{
+		UserID:          userID,
+		CredentialID:    c.ID,
+		PublicKey:       c.PublicKey,
+		AttestationType: c.AttestationType,
+		Transport:       transport,
+		AAGUID:          c.Authenticator.AAGUID,
+		SignCount:       c.Authenticator.SignCount,
+		CloneWarning:    c.Authenticator.CloneWarning,
+		BackupEligible:  c.Flags.BackupEligible,
+		BackupState:     c.Flags.BackupState,
+	}
+}
+
+func (s *Server) setCeremonyCookie(w http.ResponseWriter, r *http.Request, session *webauthn.SessionData) error {
+	key, err := s.ceremonies.put(session)
+	if err != nil {
+		return err
+	}
+	middleware.SetCookie(w, r, &http.Cookie{
+		Name:     "passkey",
+		Value:    key,
+		Path:     "/",
+		MaxAge:   int(ceremonyTimeout.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return nil
+}
+
+func (s *Server) takeCeremony(w http.ResponseWriter, r *http.Request) (*webauthn.SessionData, error) {
+	cookie, err := r.Cookie("passkey")
+	if err != nil {
+		return nil, err
+	}
+	middleware.SetCookie(w, r, &http.Cookie{
+		Name:     "passkey",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return s.ceremonies.take(cookie.Value)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(v)
+}
+
+// start registration of a new passkey for the logged in user
+func (s *Server) beginPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.beginPasskeyRegistration")
+	defer span.End()
+
+	session, err := r.Cookie("session")
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "could not find cookie", "error", err)
+		http.Error(w, "cookie not found", http.StatusUnauthorized)
+		return
+	}
+	userID, err := s.tokenstore.GetTokenValue(ctx, session)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to get token value", "error", err)
+		http.Error(w, "invalid session", http.StatusUnauthorized)
+		return
+	}
+	user, err := s.userstore.GetUserByID(ctx, userID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to get user", "error", err)
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+	credentials, err := s.credentialstore.GetCredentialsByUserID(ctx, user.ID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to get credentials", "error", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	pUser := &passkeyUser{user: user, credentials: credentials}
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(credentials))
+	for _, credential := range pUser.WebAuthnCredentials() {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+
+	creation, ceremony, err := s.webauthn.BeginRegistration(
+		pUser,
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+		webauthn.WithExclusions(exclusions),
+	)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to begin passkey registration", "error", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	err = s.setCeremonyCookie(w, r, ceremony)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to store passkey ceremony", "error", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	err = writeJSON(w, http.StatusOK, creation)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to write response", "error", err)
+		return
+	}
+}
+
+// verify attestation of the authenticator and store the new credential
+func (s *Server) finishPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.finishPasskeyRegistration")
+	defer span.End()
+
+	ceremony, err := s.takeCeremony(w, r)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to get passkey ceremony", "error", err)
+		http.Error(w, "passkey registration expired", http.StatusBadRequest)
+		return
+	}
+	session, err := r.Cookie("session")
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "could not find cookie", "error", err)
+		http.Error(w, "cookie not found", http.StatusUnauthorized)
+		return
+	}
+	userID, err := s.tokenstore.GetTokenValue(ctx, session)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to get token value", "error", err)
+		http.Error(w, "invalid session", http.StatusUnauthorized)
+		return
+	}
+	user, err := s.userstore.GetUserByID(ctx, userID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to get user", "error", err)
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+	credentials, err := s.credentialstore.GetCredentialsByUserID(ctx, user.ID)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to get credentials", "error", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+
+	credential, err := s.webauthn.FinishRegistration(&passkeyUser{user: user, credentials: credentials}, *ceremony, r)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to finish passkey registration", "error", err)
+		http.Error(w, "passkey registration failed", http.StatusBadRequest)
+		return
+	}
+	credentialID, err := s.credentialstore.CreateCredential(ctx, fromWebAuthnCredential(user.ID, credential))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to create credential", "error", err)
+		http.Error(w, "passkey registration failed", http.StatusBadRequest)
+		return
+	}
+	s.log.InfoContext(ctx, "registered passkey", "user", user.ID)
+	s.record(ctx, r, &model.AuditEvent{Action: model.ActionPasskeyRegister, Actor: user.ID, Target: credentialID, TargetType: targetCredential}, nil, nil)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// start a discoverable passkey login, the user is determined by the
+// authenticator response
+func (s *Server) beginPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.beginPasskeyLogin")
+	defer span.End()
+
+	assertion, ceremony, err := s.webauthn.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationPreferred),
+	)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to begin passkey login", "error", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	err = s.setCeremonyCookie(w, r, ceremony)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to store passkey ceremony", "error", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	err = writeJSON(w, http.StatusOK, assertion)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to write response", "error", err)
+		return
+	}
+}
+
+// verify the assertion of the authenticator and create the session
+func (s *Server) finishPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	var span trace.Span
+	ctx := r.Context()
+	ctx, span = tracer.Start(ctx, "server.finishPasskeyLogin")
+	defer span.End()
+
+	ceremony, err := s.takeCeremony(w, r)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to get passkey ceremony", "error", err)
+		http.Error(w, "passkey login expired", http.StatusBadRequest)
+		return
+	}
+
+	var (
+		user       *model.User
+		credential *model.Credential
+	)
+	handler := func(rawID, userHandle []byte) (webauthn.User, error) {
+		c, err := s.credentialstore.GetCredentialByCredentialID(ctx, rawID)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(c.UserID[:], userHandle) {
+			return nil, errors.New("user handle doesn't match credential")
+		}
+		u, err := s.userstore.GetUserByID(ctx, c.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if u.ID == uuid.Nil {
+			return nil, errors.New("user doesn't exist")
+		}
+		user, credential = u, c
+		return &passkeyUser{user: u, credentials: []*model.Credential{c}}, nil
+	}
+	validated, err := s.webauthn.FinishDiscoverableLogin(handler, *ceremony, r)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to finish passkey login", "error", err)
+		http.Error(w, "passkey login failed", http.StatusUnauthorized)
+		return
+	}
+
+	credential.SignCount = validated.Authenticator.SignCount
+	credential.CloneWarning = validated.Authenticator.CloneWarning
+	credential.BackupState = validated.Flags.BackupState
+	credential.LastUsedAt = time.Now()
+	err = s.credentialstore.UpdateCredential(ctx, credential)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to update credential", "error", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	if credential.CloneWarning {
+		err = errors.New("signature counter did not increase")
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.WarnContext(ctx, "possibly cloned authenticator", "user", user.ID, "error", err)
+		http.Error(w, "passkey login failed", http.StatusUnauthorized)
+		return
+	}
+
+	cookie, err := s.tokenstore.CreateToken(ctx, "session", s.domain, user.ID, false)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to create token", "error", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	event := userEvent(model.ActionLogin, user.ID)
+	event.Actor = user.ID
+	s.record(ctx, r, event, nil, nil)
+	middleware.SetCookie(w, r, cookie)
+	setPreferredLocale(w, r, user)
+
+	redirect := "/user/dashboard"
+	switch {
+	case user.IsAdmin:
+		redirect = "/admin/dashboard"
+	case !user.IsVerified:
+		redirect = "/user/verify"
+	}
+	err = writeJSON(w, http.StatusOK, map[string]string{"redirect": redirect})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		s.log.ErrorContext(ctx, "failed to write response", "error", err)
+		return
+	}
+}
@@ -0,0 +1,305 @@
+package v1
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+)
+
+const (
+	testRPID   = "localhost"
+	testOrigin = "http://localhost:8080"
+)
+
+// softAuthenticator emulates a platform authenticator holding a single
+// ES256 passkey, so ceremonies can be tested without hardware
+type softAuthenticator struct {
+	origin       string
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+}
+
+func newSoftAuthenticator(t *testing.T, origin string) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	credentialID := make([]byte, 32)
+	if _, err := rand.Read(credentialID); err != nil {
+		t.Fatalf("Error generating credential ID: %v", err)
+	}
+	return &softAuthenticator{origin: origin, key: key, credentialID: credentialID}
+}
+
+func (a *softAuthenticator) clientData(t *testing.T, ceremony string, challenge []byte) []byte {
+	data, err := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":    a.origin,
+	})
+	if err != nil {
+		t.Fatalf("Error marshaling client data: %v", err)
+	}
+	return data
+}
+
+func (a *softAuthenticator) authData(rpID string, flags protocol.AuthenticatorFlags) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append([]byte{}, rpIDHash[:]...)
+	data = append(data, byte(flags))
+	return binary.BigEndian.AppendUint32(data, a.signCount)
+}
+
+// create answers navigator.credentials.create() with a "none" attestation
+func (a *softAuthenticator) create(t *testing.T, options *protocol.CredentialCreation) []byte {
+	userID, ok := options.Response.User.ID.(string)
+	if !ok {
+		t.Fatalf("Expected user ID to be encoded as string, got %T", options.Response.User.ID)
+	}
+	userHandle, err := base64.RawURLEncoding.DecodeString(userID)
+	if err != nil {
+		t.Fatalf("Error decoding user ID: %v", err)
+	}
+	a.userHandle = userHandle
+
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  1,
+		XCoord: a.key.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatalf("Error marshaling public key: %v", err)
+	}
+	authData := a.authData(options.Response.RelyingParty.ID, protocol.FlagUserPresent|protocol.FlagUserVerified|protocol.FlagAttestedCredentialData)
+	authData = append(authData, make([]byte, 16)...)
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.credentialID)))
+	authData = append(authData, a.credentialID...)
+	authData = append(authData, publicKey...)
+
+	attestationObject, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": authData,
+	})
+	if err != nil {
+		t.Fatalf("Error marshaling attestation object: %v", err)
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"id":    base64.RawURLEncoding.EncodeToString(a.credentialID),
+		"rawId": base64.RawURLEncoding.EncodeToString(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]any{
+			"attestationObject": base64.RawURLEncoding.EncodeToString(attestationObject),
+			"clientDataJSON":    base64.RawURLEncoding.EncodeToString(a.clientData(t, "webauthn.create", options.Response.Challenge)),
+			"transports":        []string{"internal"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Error marshaling attestation response: %v", err)
+	}
+	return body
+}
+
+// get answers navigator.credentials.get() with a signed assertion
+func (a *softAuthenticator) get(t *testing.T, options *protocol.CredentialAssertion) []byte {
+	a.signCount++
+	authData := a.authData(options.Response.RelyingPartyID, protocol.FlagUserPresent|protocol.FlagUserVerified)
+	clientData := a.clientData(t, "webauthn.get", options.Response.Challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatalf("Error signing assertion: %v", err)
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"id":    base64.RawURLEncoding.EncodeToString(a.credentialID),
+		"rawId": base64.RawURLEncoding.EncodeToString(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]any{
+			"authenticatorData": base64.RawURLEncoding.EncodeToString(authData),
+			"clientDataJSON":    base64.RawURLEncoding.EncodeToString(clientData),
+			"signature":         base64.RawURLEncoding.EncodeToString(signature),
+			"userHandle":        base64.RawURLEncoding.EncodeToString(a.userHandle),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Error marshaling assertion response: %v", err)
+	}
+	return body
+}
+
+func (f *fixture) registerPasskey(t *testing.T, authenticator *softAuthenticator) {
+	session, err := f.tStore.CreateToken(context.Background(), "session", testRPID, f.user.ID, false)
+	if err != nil {
+		t.Fatalf("Error creating session: %v", err)
+	}
+
+	rec := f.do(f.server.beginPasskeyRegistration, nil, session)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d on registration begin, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var creation protocol.CredentialCreation
+	if err := json.Unmarshal(rec.Body.Bytes(), &creation); err != nil {
+		t.Fatalf("Error unmarshaling creation options: %v", err)
+	}
+	if creation.Response.AuthenticatorSelection.ResidentKey != protocol.ResidentKeyRequirementRequired {
+		t.Errorf("Expected resident key to be required, got %q", creation.Response.AuthenticatorSelection.ResidentKey)
+	}
+	ceremony := findCookie(rec, "passkey")
+	if ceremony == nil {
+		t.Fatalf("Expected passkey ceremony cookie to be set")
+	}
+
+	rec = f.do(f.server.finishPasskeyRegistration, authenticator.create(t, &creation), session, ceremony)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d on registration finish, got %d: %s", http.StatusCreated, rec.Code, rec.Body.String())
+	}
+}
+
+func (f *fixture) loginPasskey(t *testing.T, authenticator *softAuthenticator) *httptest.ResponseRecorder {
+	rec := f.do(f.server.beginPasskeyLogin, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d on login begin, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var assertion protocol.CredentialAssertion
+	if err := json.Unmarshal(rec.Body.Bytes(), &assertion); err != nil {
+		t.Fatalf("Error unmarshaling assertion options: %v", err)
+	}
+	ceremony := findCookie(rec, "passkey")
+	if ceremony == nil {
+		t.Fatalf("Expected passkey ceremony cookie to be set")
+	}
+	return f.do(f.server.finishPasskeyLogin, authenticator.get(t, &assertion), ceremony)
+}
+
+func TestPasskeyRegistrationAndLogin(t *testing.T) {
+	f := newFixture(t)
+	authenticator := newSoftAuthenticator(t, testOrigin)
+
+	f.registerPasskey(t, authenticator)
+
+	credentials, err := f.cStore.GetCredentialsByUserID(context.Background(), f.user.ID)
+	if err != nil {
+		t.Fatalf("Error getting credentials: %v", err)
+	}
+	if len(credentials) != 1 {
+		t.Fatalf("Expected 1 credential for user, got %d", len(credentials))
+	}
+	if !bytes.Equal(credentials[0].CredentialID, authenticator.credentialID) {
+		t.Errorf("Expected stored credential ID to match authenticator")
+	}
+
+	rec := f.loginPasskey(t, authenticator)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d on login finish, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	session := findCookie(rec, "session")
+	if session == nil {
+		t.Fatalf("Expected session cookie to be set")
+	}
+	userID, err := f.tStore.GetTokenValue(context.Background(), session)
+	if err != nil {
+		t.Fatalf("Error getting token value: %v", err)
+	}
+	if userID != f.user.ID {
+		t.Errorf("Expected session for user %s, got %s", f.user.ID, userID)
+	}
+	var result map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Error unmarshaling login result: %v", err)
+	}
+	if result["redirect"] != "/user/dashboard" {
+		t.Errorf("Expected redirect to /user/dashboard, got %q", result["redirect"])
+	}
+
+	credential, err := f.cStore.GetCredentialByCredentialID(context.Background(), authenticator.credentialID)
+	if err != nil {
+		t.Fatalf("Error getting credential: %v", err)
+	}
+	if credential.SignCount != authenticator.signCount {
+		t.Errorf("Expected sign count %d, got %d", authenticator.signCount, credential.SignCount)
+	}
+}
+
+func TestPasskeyLoginFailures(t *testing.T) {
+	t.Run("Unknown credential", func(t *testing.T) {
+		f := newFixture(t)
+		f.registerPasskey(t, newSoftAuthenticator(t, testOrigin))
+
+		rec := f.loginPasskey(t, newSoftAuthenticator(t, testOrigin))
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, rec.Code)
+		}
+	})
+
+	t.Run("Foreign origin", func(t *testing.T) {
+		f := newFixture(t)
+		authenticator := newSoftAuthenticator(t, testOrigin)
+		f.registerPasskey(t, authenticator)
+
+		authenticator.origin = "http://evil.example"
+		rec := f.loginPasskey(t, authenticator)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, rec.Code)
+		}
+	})
+
+	t.Run("Cloned authenticator", func(t *testing.T) {
+		f := newFixture(t)
+		authenticator := newSoftAuthenticator(t, testOrigin)
+		f.registerPasskey(t, authenticator)
+
+		if rec := f.loginPasskey(t, authenticator); rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+		authenticator.signCount = 0
+		rec := f.loginPasskey(t, authenticator)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, rec.Code)
+		}
+		if findCookie(rec, "session") != nil {
+			t.Errorf("Expected no session cookie for cloned authenticator")
+		}
+	})
+
+	t.Run("Replayed ceremony", func(t *testing.T) {
+		f := newFixture(t)
+		authenticator := newSoftAuthenticator(t, testOrigin)
+		f.registerPasskey(t, authenticator)
+
+		rec := f.do(f.server.beginPasskeyLogin, nil)
+		var assertion protocol.CredentialAssertion
+		if err := json.Unmarshal(rec.Body.Bytes(), &assertion); err != nil {
+			t.Fatalf("Error unmarshaling assertion options: %v", err)
+		}
+		ceremony := findCookie(rec, "passkey")
+		body := authenticator.get(t, &assertion)
+		if rec := f.do(f.server.finishPasskeyLogin, body, ceremony); rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+		}
+		if rec := f.do(f.server.finishPasskeyLogin, body, ceremony); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d on replay, got %d", http.StatusBadRequest, rec.Code)
+		}
+	})
+}
@@ -2,18 +2,31 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
+	"fmt"
 	"log/slog"
+	"net"
 	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
+	"github.com/go-webauthn/webauthn/webauthn"
 	v1 "github.com/led0nk/guestbook/api/v1"
-	"github.com/led0nk/guestbook/cmd/utils"
 	templates "github.com/led0nk/guestbook/internal"
+	"github.com/led0nk/guestbook/internal/certificate"
+	"github.com/led0nk/guestbook/internal/config"
 	db "github.com/led0nk/guestbook/internal/database"
 	"github.com/led0nk/guestbook/internal/database/jsondb"
+	"github.com/led0nk/guestbook/internal/health"
 	"github.com/led0nk/guestbook/internal/mailer"
+	"github.com/led0nk/guestbook/internal/middleware"
+	"github.com/led0nk/guestbook/internal/oidc"
+	"github.com/led0nk/guestbook/internal/password"
 	"github.com/led0nk/guestbook/token"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
@@ -21,107 +34,345 @@ import (
 	"go.opentelemetry.io/otel/sdk/metric"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
 func main() {
+	if err := run(); err != nil {
+		slog.Error("server stopped", "error", err)
+		os.Exit(1)
+	}
+}
+
+// run sets up the guestbook and serves it until SIGINT or SIGTERM, on
+// shutdown the server drains, then telemetry is flushed and the stores are
+// closed
+func run() (err error) {
 	var (
-		addr        = flag.String("addr", "localhost:8080", "server port")
-		grpcaddr    = flag.String("grpcaddr", "", "grpc address, e.g. localhost:4317")
-		dbase       = flag.String("db", "file://testdata", "path to database")
-		envStr      = flag.String("env", "testdata/.env", "path to .env-file")
-		domain      = flag.String("domain", "127.0.0.1", "given domain for cookies/mail")
-		logLevelStr = flag.String("loglevel", "INFO", "define the level for logs")
-		bStore      db.GuestBookStore
-		uStore      db.UserStore
-		tStore      db.TokenStore
+		bStore db.GuestBookStore
+		uStore db.UserStore
+		tStore db.TokenStore
+		cStore db.CredentialStore
+		iStore db.InvitationStore
+		aStore db.AuditStore
+		// run in reverse order after the server stopped: telemetry is
+		// flushed first, so the stores are closed last
+		telemetry []func(context.Context) error
+		stores    []func(context.Context) error
 	)
-	flag.Parse()
-	var logLevel slog.Level
-	err := logLevel.UnmarshalText([]byte(*logLevelStr))
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel}))
+	cfg, configFile, printConfig, err := loadConfig()
 	if err != nil {
-		logger.Error("error parsing loglevel", "loglevel", *logLevelStr, "error", err)
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if printConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			return err
+		}
+		return cfg.Validate()
+	}
+	if err := cfg.CreateDotEnv(); err != nil {
+		return fmt.Errorf("failed to create .env-file: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
 	}
+	//NOTE: the level can change on reload
+	level := new(slog.LevelVar)
+	level.Set(cfg.Level())
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level}))
 	slog.SetDefault(logger)
 
-	logger.Info("server address", "addr", *addr)
-	logger.Info("otlp/grpc", "gprcaddr", *grpcaddr)
-	logger.Info("path to data", "db", *dbase)
-	logger.Info("path to .env", "env", *envStr)
+	logger.Info("config", "file", configFile)
+	logger.Info("server address", "addr", cfg.Addr)
+	logger.Info("otlp/grpc", "gprcaddr", cfg.GRPCAddr)
+	logger.Info("path to data", "db", cfg.DB)
+	logger.Info("path to .env", "env", cfg.Env)
+
+	defer func() {
+		//NOTE: the shutdown gets its own time, the signal context is done
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.DrainTimeout)
+		defer cancel()
+		closers := append(stores, telemetry...)
+		for i := len(closers) - 1; i >= 0; i-- {
+			err = errors.Join(err, closers[i](ctx))
+		}
+	}()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 
-	if *grpcaddr != "" {
+	registry := health.NewRegistry()
+
+	if cfg.GRPCAddr != "" {
 		//NOTE: grpc configuration
-		grpcOptions := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock()}
-		conn, err := grpc.NewClient(*grpcaddr, grpcOptions...)
+		grpcOptions := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+		conn, err := grpc.NewClient(cfg.GRPCAddr, grpcOptions...)
 		if err != nil {
-			logger.Error("failed to create grpc client", err)
-			os.Exit(1)
+			return fmt.Errorf("failed to create grpc client: %w", err)
 		}
-		defer conn.Close()
+		telemetry = append(telemetry, func(context.Context) error { return conn.Close() })
+		registry.Register(health.Check{Name: "otlp", Run: func(context.Context) error {
+			switch state := conn.GetState(); state {
+			case connectivity.TransientFailure, connectivity.Shutdown:
+				conn.Connect()
+				return fmt.Errorf("otlp connection is %s", state)
+			}
+			return nil
+		}})
 
 		//NOTE: tracing configuration
 		oteltraceExporter, err := otlptracegrpc.New(ctx, otlptracegrpc.WithGRPCConn(conn))
 		if err != nil {
-			logger.Error("failed to create otlp trace exporter", err)
-			os.Exit(1)
+			return fmt.Errorf("failed to create otlp trace exporter: %w", err)
 		}
 		tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(oteltraceExporter))
 		otel.SetTracerProvider(tp)
+		telemetry = append(telemetry, tp.Shutdown)
 
 		//NOTE: metrics configuration
 		otelmetricsExporter, err := otlpmetricgrpc.New(ctx, otlpmetricgrpc.WithGRPCConn(conn))
 		if err != nil {
-			logger.Error("failed to create otlp metrics exporter", err)
-			os.Exit(1)
+			return fmt.Errorf("failed to create otlp metrics exporter: %w", err)
 		}
 		mp := metric.NewMeterProvider(metric.WithReader(metric.NewPeriodicReader(otelmetricsExporter)))
 		otel.SetMeterProvider(mp)
+		telemetry = append(telemetry, mp.Shutdown)
 	}
 
-	//NOTE: load .env file / creates if none provided
-	envmap, err := utils.LoadEnv(logger, *envStr)
+	tStore, err = token.CreateTokenService(string(cfg.TokenSecret))
 	if err != nil {
-		logger.Error("failed to load .env variables", err)
+		return fmt.Errorf("failed to create token service: %w", err)
 	}
 
-	tStore, err = token.CreateTokenService(envmap["TOKENSECRET"])
+	u, err := url.Parse(cfg.DB)
 	if err != nil {
-		logger.Error("failed to create token service", err)
-	}
-
-	u, err := url.Parse(*dbase)
-	if err != nil {
-		panic(err)
+		return fmt.Errorf("failed to parse database: %w", err)
 	}
 	switch u.Scheme {
 	case "file":
 		filepath := u.Host + u.Path
-		bStore, err = jsondb.CreateBookStorage(filepath + "/entries.json")
+		registry.Register(health.Check{Name: "storage", Critical: true, Run: health.Writable(filepath)})
+		migrations, err := jsondb.Migrate(context.Background(), filepath)
+		if err != nil {
+			return fmt.Errorf("failed to migrate storage: %w", err)
+		}
+		for _, name := range migrations {
+			logger.Info("applied migration", "name", name)
+		}
+		entryStorage, err := jsondb.CreateBookStorage(filepath + "/entries.json")
+		if err != nil {
+			return fmt.Errorf("couldn't create entry storage: %w", err)
+		}
+		bStore = entryStorage
+		stores = append(stores, closeStore(entryStorage))
+
+		userStorage, err := jsondb.CreateUserStorage(filepath + "/user.json")
 		if err != nil {
-			logger.Error("couldn't create entry storage", err)
+			return fmt.Errorf("couldn't create user storage: %w", err)
 		}
+		for _, duplicate := range userStorage.Duplicates() {
+			logger.Warn("user shares the email with another account and can't be found by it", "email", duplicate.Email, "user", duplicate.User, "kept", duplicate.Kept)
+		}
+		uStore = userStorage
+		stores = append(stores, closeStore(userStorage))
+
+		credentialStorage, err := jsondb.CreateCredentialStorage(filepath + "/credentials.json")
+		if err != nil {
+			return fmt.Errorf("couldn't create credential storage: %w", err)
+		}
+		cStore = credentialStorage
+		stores = append(stores, closeStore(credentialStorage))
+
+		invitationStorage, err := jsondb.CreateInvitationStorage(filepath + "/invitations.json")
+		if err != nil {
+			return fmt.Errorf("couldn't create invitation storage: %w", err)
+		}
+		iStore = invitationStorage
+		stores = append(stores, closeStore(invitationStorage))
 
-		uStore, err = jsondb.CreateUserStorage(filepath + "/user.json")
+		auditStorage, err := jsondb.CreateAuditStorage(filepath + "/audit.jsonl")
 		if err != nil {
-			logger.Error("couldn't create user storage", err)
+			return fmt.Errorf("couldn't create audit storage: %w", err)
 		}
+		aStore = auditStorage
+		stores = append(stores, closeStore(auditStorage))
 	default:
-		logger.Error("no database provided", "dbase", u.Scheme)
-		os.Exit(1)
+		return fmt.Errorf("no database provided: %s", u.Scheme)
+	}
+
+	templateHandler, err := templates.ParseTheme(cfg.SelectedTheme())
+	if err != nil {
+		return fmt.Errorf("failed to parse templates: %w", err)
+	}
+
+	//NOTE: passkeys are bound to the host of the origin
+	o, err := url.Parse(cfg.Origin)
+	if err != nil {
+		return fmt.Errorf("failed to parse origin %s: %w", cfg.Origin, err)
+	}
+	wAuthn, err := webauthn.New(&webauthn.Config{
+		RPID:          o.Hostname(),
+		RPDisplayName: "guestbook",
+		RPOrigins:     []string{cfg.Origin},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create webauthn config: %w", err)
 	}
 
-	templates := templates.NewTemplateHandler()
+	//NOTE: identity providers which are not reachable are skipped
+	providers := []*oidc.Provider{}
+	for _, providerConfig := range cfg.OIDCConfigs() {
+		provider, err := oidc.NewProvider(ctx, providerConfig)
+		if err != nil {
+			logger.Error("failed to create oidc provider", "provider", providerConfig.Name, "error", err)
+			continue
+		}
+		providers = append(providers, provider)
+	}
 
-	mailer := mailer.NewMailer(
-		envmap["EMAIL"],
-		envmap["SMTPPW"],
-		envmap["HOST"],
-		envmap["PORT"])
+	clientIP, err := middleware.NewClientIP(cfg.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("failed to parse trusted proxies: %w", err)
+	}
+
+	hasher, err := cfg.Hasher()
+	if err != nil {
+		return fmt.Errorf("failed to create password hasher: %w", err)
+	}
+
+	policy := password.DefaultPolicy
+	policy.MinLength = cfg.Password.MinLength
+	policy.MinClasses = cfg.Password.MinClasses
+	if cfg.Password.Blocklist != "" {
+		policy.Blocklist, err = password.LoadBlocklist(cfg.Password.Blocklist)
+		if err != nil {
+			return fmt.Errorf("failed to load password blocklist: %w", err)
+		}
+	}
+
+	registrationMode, err := v1.ParseRegistrationMode(cfg.Registration)
+	if err != nil {
+		return fmt.Errorf("failed to parse registration mode: %w", err)
+	}
+
+	entries, err := v1.ParseEntryPolicy(cfg.EntryPolicy)
+	if err != nil {
+		return fmt.Errorf("failed to parse entry policy: %w", err)
+	}
+
+	registry.Register(health.Check{Name: "mailer", Run: health.Reachable(net.JoinHostPort(cfg.Mail.Host, strconv.Itoa(cfg.Mail.Port)))})
+
+	//NOTE: mail settings, rate limits, templates, the theme and the log level
+	// are reloaded, other settings need a restart
+	loader := func(ctx context.Context) (*v1.Settings, error) {
+		cfg, _, _, err := loadConfig()
+		if err != nil {
+			return nil, err
+		}
+		if err := cfg.Validate(); err != nil {
+			return nil, err
+		}
+		parsed, err := templates.ParseTheme(cfg.SelectedTheme())
+		if err != nil {
+			return nil, err
+		}
+		apply := func() {
+			level.Set(cfg.Level())
+			registry.Register(health.Check{Name: "mailer", Run: health.Reachable(net.JoinHostPort(cfg.Mail.Host, strconv.Itoa(cfg.Mail.Port)))})
+		}
+		return &v1.Settings{Mailer: newMailer(cfg), Templates: parsed, RateLimits: cfg.RateLimits, Theme: cfg.SelectedTheme(), OnSwap: apply}, nil
+	}
+
+	transport := v1.TLS{RedirectAddr: cfg.TLS.RedirectAddr, HSTS: cfg.TLS.HSTS}
+	switch {
+	case cfg.TLS.SelfSigned:
+		cert, err := certificate.SelfSigned(o.Hostname(), cfg.Domain, "localhost")
+		if err != nil {
+			return fmt.Errorf("failed to create self-signed certificate: %w", err)
+		}
+		logger.Warn("serving a self-signed certificate, only use it for development")
+		transport.Config = certificate.Config(func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return cert, nil
+		})
+	case cfg.TLS.Cert != "":
+		reloader, err := certificate.NewReloader(cfg.TLS.Cert, cfg.TLS.Key, logger.WithGroup("tls"))
+		if err != nil {
+			return fmt.Errorf("failed to load certificate: %w", err)
+		}
+		transport.Config = certificate.Config(reloader.GetCertificate)
+	}
+
+	server := v1.NewServer(v1.Options{
+		Addr:           cfg.Addr,
+		Domain:         cfg.Domain,
+		Mailer:         newMailer(cfg),
+		Templates:      templateHandler,
+		Theme:          cfg.SelectedTheme(),
+		Entries:        bStore,
+		Users:          uStore,
+		Tokens:         tStore,
+		Credentials:    cStore,
+		Invitations:    iStore,
+		Audit:          aStore,
+		WebAuthn:       wAuthn,
+		Providers:      providers,
+		MagicLink:      cfg.MagicLink,
+		ClientIP:       clientIP,
+		Hasher:         hasher,
+		Policy:         &policy,
+		Registration:   registrationMode,
+		EntryPolicy:    entries,
+		DeletionGrace:  cfg.DeletionGrace,
+		DigestInterval: cfg.DigestInterval,
+		DrainTimeout:   cfg.DrainTimeout,
+		ShutdownDelay:  cfg.ShutdownDelay,
+		Health:         registry,
+		RateLimits:     cfg.RateLimits,
+		Loader:         loader,
+		TLS:            transport,
+		Security:       cfg.SecurityPolicy(),
+	})
+
+	serveCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+	go func() {
+		for {
+			select {
+			case <-serveCtx.Done():
+				return
+			case <-hangup:
+				//NOTE: failures are logged and the old settings are kept
+				_ = server.Reload(serveCtx)
+			}
+		}
+	}()
+
+	return server.ServeHTTP(serveCtx)
+}
+
+// loadConfig parses the command line into a new flag set, so it can be loaded
+// again on reload
+func loadConfig() (cfg *config.Config, file string, printConfig bool, err error) {
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	fs.BoolVar(&printConfig, "print-config", false, "print the configuration with redacted secrets and exit")
+	cfg, file, err = config.Load(fs, os.Args[1:], os.Environ())
+	return cfg, file, printConfig, err
+}
+
+func newMailer(cfg *config.Config) *mailer.Mailer {
+	return mailer.NewMailer(
+		cfg.Mail.Address,
+		string(cfg.Mail.Password),
+		cfg.Mail.Host,
+		strconv.Itoa(cfg.Mail.Port))
+}
 
-	server := v1.NewServer(*addr, mailer, *domain, templates, bStore, uStore, tStore)
-	server.ServeHTTP()
+// closeStore adapts the Close of a storage to the shutdown
+func closeStore(store interface{ Close() error }) func(context.Context) error {
+	return func(context.Context) error { return store.Close() }
 }
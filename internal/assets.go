@@ -0,0 +1,229 @@
+package templates
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Assets are the static assets of a theme, kept in memory with a fingerprint
+// of their content and their compressed variants
+type Assets struct {
+	// by name, e.g. img/logo.svg
+	files map[string]*asset
+	// by fingerprinted name, e.g. img/logo.3e1f0a2b9c.svg
+	fingerprinted map[string]*asset
+}
+
+type asset struct {
+	name        string
+	contentType string
+	fingerprint string
+	content     []byte
+	// content by Content-Encoding, read from name.br and name.gz next to the
+	// asset or compressed while loading
+	encodings map[string][]byte
+}
+
+// encodings in the order they are preferred, with the extension of their
+// precompressed files
+var encodings = []struct{ name, ext string }{
+	{name: "br", ext: ".br"},
+	{name: "gzip", ext: ".gz"},
+}
+
+// cache lifetime of fingerprinted assets, their content never changes
+const immutable = "public, max-age=31536000, immutable"
+
+// LoadAssets reads the static assets of the theme, a file of the theme
+// replaces the embedded one together with its precompressed variants
+func LoadAssets(theme Theme) (*Assets, error) {
+	a := &Assets{files: make(map[string]*asset), fingerprinted: make(map[string]*asset)}
+	static, err := fs.Sub(embedded, "static")
+	if err != nil {
+		return nil, err
+	}
+	layers := []fs.FS{static}
+	if theme.Dir != "" {
+		layers = append(layers, os.DirFS(filepath.Join(theme.Dir, "static")))
+	}
+	for _, layer := range layers {
+		if err := a.load(layer); err != nil {
+			return nil, err
+		}
+	}
+	for _, file := range a.files {
+		a.fingerprinted[fingerprinted(file.name, file.fingerprint)] = file
+	}
+	return a, nil
+}
+
+// load adds the files of layer, precompressed variants are no assets of
+// their own
+func (a *Assets) load(layer fs.FS) error {
+	return fs.WalkDir(layer, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			//NOTE: a theme doesn't need static assets
+			if name == "." && errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if d.IsDir() || variant(layer, name) {
+			return nil
+		}
+		content, err := fs.ReadFile(layer, name)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(content)
+		file := &asset{
+			name:        name,
+			contentType: mime.TypeByExtension(path.Ext(name)),
+			fingerprint: hex.EncodeToString(sum[:])[:10],
+			content:     content,
+			encodings:   make(map[string][]byte),
+		}
+		if file.contentType == "" {
+			file.contentType = http.DetectContentType(content)
+		}
+		for _, encoding := range encodings {
+			if compressed, err := fs.ReadFile(layer, name+encoding.ext); err == nil {
+				file.encodings[encoding.name] = compressed
+			}
+		}
+		if _, ok := file.encodings["gzip"]; !ok && compressible(file.contentType) {
+			compressed, err := gzipped(content)
+			if err != nil {
+				return err
+			}
+			if len(compressed) < len(content) {
+				file.encodings["gzip"] = compressed
+			}
+		}
+		a.files[name] = file
+		return nil
+	})
+}
+
+// variant reports if name is the precompressed variant of another file of
+// layer, e.g. style.css.br
+func variant(layer fs.FS, name string) bool {
+	for _, encoding := range encodings {
+		if strings.HasSuffix(name, encoding.ext) {
+			_, err := fs.Stat(layer, strings.TrimSuffix(name, encoding.ext))
+			return err == nil
+		}
+	}
+	return false
+}
+
+// compressible reports if the content type is text, images and fonts are
+// compressed already
+func compressible(contentType string) bool {
+	for _, kind := range []string{"text/", "javascript", "json", "xml", "svg"} {
+		if strings.Contains(contentType, kind) {
+			return true
+		}
+	}
+	return false
+}
+
+func gzipped(content []byte) ([]byte, error) {
+	var b bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&b, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(content); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// fingerprinted inserts the fingerprint before the extension of name
+func fingerprinted(name, fingerprint string) string {
+	ext := path.Ext(path.Base(name))
+	return strings.TrimSuffix(name, ext) + "." + fingerprint + ext
+}
+
+// Path returns the fingerprinted URL of the asset, which changes with its
+// content. Unknown assets keep their name, so a missing file shows up as not
+// found instead of failing the page.
+func (a *Assets) Path(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	if a != nil {
+		if file, ok := a.files[name]; ok {
+			return "/static/" + fingerprinted(file.name, file.fingerprint)
+		}
+	}
+	return "/static/" + name
+}
+
+// ServeHTTP serves the asset of the path below /static/. Fingerprinted URLs
+// are cached forever, the plain names are revalidated by their ETag.
+// Directories are not listed.
+func (a *Assets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	file, cache := a.fingerprinted[name], immutable
+	if file == nil {
+		file, cache = a.files[name], "no-cache"
+	}
+	if file == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	content, etag := file.content, file.fingerprint
+	if len(file.encodings) > 0 {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+	for _, encoding := range encodings {
+		compressed, ok := file.encodings[encoding.name]
+		if ok && accepts(r.Header.Get("Accept-Encoding"), encoding.name) {
+			content, etag = compressed, etag+"-"+encoding.name
+			w.Header().Set("Content-Encoding", encoding.name)
+			break
+		}
+	}
+	w.Header().Set("Cache-Control", cache)
+	w.Header().Set("Content-Type", file.contentType)
+	w.Header().Set("ETag", `"`+etag+`"`)
+	http.ServeContent(w, r, file.name, time.Time{}, bytes.NewReader(content))
+}
+
+// accepts reports if the Accept-Encoding header allows the encoding, by its
+// name or by *
+func accepts(header, encoding string) bool {
+	allowed := map[string]bool{}
+	for _, accepted := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(accepted, ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		allowed[strings.ToLower(strings.TrimSpace(name))] = q > 0
+	}
+	if ok, found := allowed[encoding]; found {
+		return ok
+	}
+	return allowed["*"]
+}
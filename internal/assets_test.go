@@ -0,0 +1,144 @@
+package templates_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	templates "github.com/led0nk/guestbook/internal"
+)
+
+var fingerprinted = regexp.MustCompile(`^/static/style\.[0-9a-f]{10}\.css$`)
+
+func TestAssetPath(t *testing.T) {
+	dir := writeTheme(t, map[string]string{"static/style.css": "body { background: ivory; }"})
+	embedded, err := templates.LoadAssets(templates.Theme{})
+	if err != nil {
+		t.Fatalf("Error loading assets: %v", err)
+	}
+	theme, err := templates.LoadAssets(templates.Theme{Dir: dir})
+	if err != nil {
+		t.Fatalf("Error loading assets: %v", err)
+	}
+
+	path := embedded.Path("style.css")
+	if !fingerprinted.MatchString(path) {
+		t.Errorf("Expected a fingerprinted path, got %q", path)
+	}
+	if embedded.Path("/style.css") != path {
+		t.Errorf("Expected the same path with a leading slash, got %q", embedded.Path("/style.css"))
+	}
+	if theme.Path("style.css") == path {
+		t.Error("Expected another fingerprint for other content")
+	}
+	if got := embedded.Path("missing.js"); got != "/static/missing.js" {
+		t.Errorf("Expected the plain path of a missing asset, got %q", got)
+	}
+
+	handler, err := templates.ParseTheme(templates.Theme{})
+	if err != nil {
+		t.Fatalf("Error parsing templates: %v", err)
+	}
+	var body bytes.Buffer
+	if err := templates.Execute(&body, handler.TmplLogin, "", templates.Request{}, nil); err != nil {
+		t.Fatalf("Error executing template: %v", err)
+	}
+	if !strings.Contains(body.String(), `href="`+path+`"`) {
+		t.Errorf("Expected the page to link %s", path)
+	}
+}
+
+func TestAssetCaching(t *testing.T) {
+	dir := writeTheme(t, map[string]string{
+		"static/app.js":    strings.Repeat("console.log('guestbook');\n", 20),
+		"static/app.js.br": "brotli",
+		"static/logo.png":  "\x89PNG\r\n\x1a\n",
+		"static/data.gz":   "archive",
+	})
+	assets, err := templates.LoadAssets(templates.Theme{Dir: dir})
+	if err != nil {
+		t.Fatalf("Error loading assets: %v", err)
+	}
+	serve := func(path string, header http.Header) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.URL.Path = strings.TrimPrefix(path, "/static")
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		assets.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name           string
+		path           string
+		acceptEncoding string
+		status         int
+		cacheControl   string
+		encoding       string
+	}{
+		{name: "Fingerprinted", path: assets.Path("app.js"), status: http.StatusOK, cacheControl: "public, max-age=31536000, immutable"},
+		{name: "Plain name", path: "/app.js", status: http.StatusOK, cacheControl: "no-cache"},
+		{name: "Brotli", path: assets.Path("app.js"), acceptEncoding: "gzip, deflate, br", status: http.StatusOK, cacheControl: "public, max-age=31536000, immutable", encoding: "br"},
+		{name: "Gzip", path: assets.Path("app.js"), acceptEncoding: "gzip, br;q=0", status: http.StatusOK, cacheControl: "public, max-age=31536000, immutable", encoding: "gzip"},
+		{name: "Any", path: assets.Path("app.js"), acceptEncoding: "*", status: http.StatusOK, cacheControl: "public, max-age=31536000, immutable", encoding: "br"},
+		{name: "Image", path: assets.Path("logo.png"), acceptEncoding: "gzip, br", status: http.StatusOK, cacheControl: "public, max-age=31536000, immutable"},
+		{name: "Archive", path: "/data.gz", acceptEncoding: "gzip", status: http.StatusOK, cacheControl: "no-cache"},
+		{name: "Variant", path: "/app.js.br", status: http.StatusNotFound},
+		{name: "Old fingerprint", path: "/app.0123456789.js", status: http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := serve(test.path, http.Header{"Accept-Encoding": {test.acceptEncoding}})
+			if rec.Code != test.status {
+				t.Fatalf("Expected status %d, got %d", test.status, rec.Code)
+			}
+			if test.status != http.StatusOK {
+				return
+			}
+			if got := rec.Header().Get("Cache-Control"); got != test.cacheControl {
+				t.Errorf("Expected Cache-Control %q, got %q", test.cacheControl, got)
+			}
+			if got := rec.Header().Get("Content-Encoding"); got != test.encoding {
+				t.Errorf("Expected Content-Encoding %q, got %q", test.encoding, got)
+			}
+
+			etag := rec.Header().Get("ETag")
+			if etag == "" {
+				t.Fatal("Expected an ETag")
+			}
+			rec = serve(test.path, http.Header{"Accept-Encoding": {test.acceptEncoding}, "If-None-Match": {etag}})
+			if rec.Code != http.StatusNotModified {
+				t.Errorf("Expected status %d for a matching ETag, got %d", http.StatusNotModified, rec.Code)
+			}
+		})
+	}
+
+	rec := serve("/app.js", http.Header{"Accept-Encoding": {"gzip"}})
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("Error reading gzip: %v", err)
+	}
+	content, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("Error reading gzip: %v", err)
+	}
+	if !strings.HasPrefix(string(content), "console.log") {
+		t.Errorf("Expected the compressed script, got %q", content)
+	}
+	if got := rec.Header().Get("Content-Type"); !strings.Contains(got, "javascript") {
+		t.Errorf("Expected the type of the script, got %q", got)
+	}
+	if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+		t.Errorf("Expected Vary on Accept-Encoding, got %q", got)
+	}
+	if serve("/app.js", http.Header{"Accept-Encoding": {"br"}}).Header().Get("ETag") == rec.Header().Get("ETag") {
+		t.Error("Expected another ETag for another encoding")
+	}
+}
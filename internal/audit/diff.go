@@ -0,0 +1,103 @@
+// Package audit computes the changes recorded in audit events
+package audit
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/led0nk/guestbook/internal/model"
+)
+
+// Redacted replaces the values of fields tagged with `audit:"secret"`
+const Redacted = "[redacted]"
+
+// Diff returns the changed fields of two structs of the same type, nil
+// stands for a created or deleted object. Fields are named by their json tag, fields
+// tagged with `audit:"-"` or `json:"-"` are skipped and the values of fields
+// tagged with `audit:"secret"` are redacted.
+func Diff(before, after any) []model.Change {
+	b, a := indirect(before), indirect(after)
+	if !b.IsValid() && !a.IsValid() {
+		return nil
+	}
+	if b.IsValid() && a.IsValid() && b.Type() != a.Type() {
+		return nil
+	}
+	var typ reflect.Type
+	if a.IsValid() {
+		typ = a.Type()
+	} else {
+		typ = b.Type()
+	}
+
+	changes := []model.Change{}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name, skip, secret := tags(field)
+		if skip || !field.IsExported() {
+			continue
+		}
+		var prev, next any
+		if b.IsValid() {
+			prev = b.Field(i).Interface()
+		}
+		if a.IsValid() {
+			next = a.Field(i).Interface()
+		}
+		//NOTE: zero fields of created or deleted objects are no changes
+		if reflect.DeepEqual(prev, next) || (isZero(prev) && isZero(next)) {
+			continue
+		}
+		if secret {
+			prev, next = redact(prev), redact(next)
+		}
+		changes = append(changes, model.Change{Field: name, Before: prev, After: next})
+	}
+	return changes
+}
+
+// indirect dereferences pointers to the struct, the result is invalid for nil
+func indirect(v any) reflect.Value {
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return reflect.Value{}
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return reflect.Value{}
+	}
+	return value
+}
+
+func tags(field reflect.StructField) (name string, skip bool, secret bool) {
+	name = field.Name
+	if tag, ok := field.Tag.Lookup("json"); ok {
+		jsonName, _, _ := strings.Cut(tag, ",")
+		if jsonName == "-" {
+			return "", true, false
+		}
+		if jsonName != "" {
+			name = jsonName
+		}
+	}
+	switch field.Tag.Get("audit") {
+	case "-":
+		return name, true, false
+	case "secret":
+		return name, false, true
+	}
+	return name, false, false
+}
+
+func isZero(v any) bool {
+	return v == nil || reflect.ValueOf(v).IsZero()
+}
+
+func redact(v any) any {
+	if isZero(v) {
+		return nil
+	}
+	return Redacted
+}
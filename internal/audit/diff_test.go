@@ -0,0 +1,73 @@
+package audit_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/led0nk/guestbook/internal/audit"
+	"github.com/led0nk/guestbook/internal/model"
+)
+
+func TestDiff(t *testing.T) {
+	user := &model.User{Name: "Jon Doe", Email: "jon@doe.com", Password: []byte("hash")}
+	renamed := *user
+	renamed.Name = "Jon Roe"
+	rehashed := *user
+	rehashed.Password = []byte("other hash")
+
+	tests := []struct {
+		name     string
+		before   any
+		after    any
+		expected []model.Change
+	}{
+		{
+			name:     "Unchanged",
+			before:   user,
+			after:    user,
+			expected: []model.Change{},
+		},
+		{
+			name:     "Changed field",
+			before:   user,
+			after:    &renamed,
+			expected: []model.Change{{Field: "name", Before: "Jon Doe", After: "Jon Roe"}},
+		},
+		{
+			name:     "Secret field",
+			before:   user,
+			after:    &rehashed,
+			expected: []model.Change{{Field: "password", Before: audit.Redacted, After: audit.Redacted}},
+		},
+		{
+			name:   "Created object",
+			before: nil,
+			after:  &model.Invitation{Code: "ABCD", MaxUses: 1},
+			expected: []model.Change{
+				{Field: "code", Before: nil, After: "ABCD"},
+				{Field: "maxuses", Before: nil, After: 1},
+			},
+		},
+		{
+			name:   "Deleted object",
+			before: &model.Invitation{Code: "ABCD", MaxUses: 1},
+			after:  nil,
+			expected: []model.Change{
+				{Field: "code", Before: "ABCD", After: nil},
+				{Field: "maxuses", Before: 1, After: nil},
+			},
+		},
+		{
+			name:     "No objects",
+			expected: nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			changes := audit.Diff(test.before, test.after)
+			if !reflect.DeepEqual(changes, test.expected) {
+				t.Errorf("Expected %v, got %v", test.expected, changes)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+// Package certificate provides the TLS certificates of the guestbook, either
+// loaded from files which are watched for changes or self-signed for
+// development
+package certificate
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// CheckInterval is the minimum time between two checks of the files
+const CheckInterval = 10 * time.Second
+
+// Reloader serves the certificate of a cert and key file and loads it again
+// once the files changed, e.g. after a renewal. If the new files are invalid
+// the previous certificate is kept.
+type Reloader struct {
+	certFile string
+	keyFile  string
+	logger   *slog.Logger
+	interval time.Duration
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+// NewReloader loads the certificate, it fails if the files are invalid
+func NewReloader(certFile, keyFile string, logger *slog.Logger) (*Reloader, error) {
+	r := &Reloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		logger:   logger,
+		interval: CheckInterval,
+	}
+	modTime, err := r.modified()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	r.cert, r.modTime, r.lastCheck = &cert, modTime, time.Now()
+	return r, nil
+}
+
+// modified returns the latest modification time of the files
+func (r *Reloader) modified() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// GetCertificate is used as tls.Config.GetCertificate
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(r.lastCheck) < r.interval {
+		return r.cert, nil
+	}
+	r.lastCheck = now
+
+	modTime, err := r.modified()
+	if err != nil {
+		r.logger.Error("failed to check certificate, keeping the current one", "error", err)
+		return r.cert, nil
+	}
+	if modTime.Equal(r.modTime) {
+		return r.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		//NOTE: cert and key might be replaced one after the other, the
+		// next check tries again
+		r.logger.Error("failed to reload certificate, keeping the current one", "error", err)
+		return r.cert, nil
+	}
+	r.cert, r.modTime = &cert, modTime
+	r.logger.Info("certificate reloaded", "cert", r.certFile)
+	return r.cert, nil
+}
+
+// Config returns a TLS configuration serving the certificates of get
+func Config(get func(*tls.ClientHelloInfo) (*tls.Certificate, error)) *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: get,
+	}
+}
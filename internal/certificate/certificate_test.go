@@ -0,0 +1,91 @@
+package certificate
+
+import (
+	"crypto/x509"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writePair(t *testing.T, dir string, host string) (string, string) {
+	certPEM, keyPEM, err := GenerateSelfSigned(host)
+	if err != nil {
+		t.Fatalf("Error generating certificate: %v", err)
+	}
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	if err := os.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatalf("Error writing certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatalf("Error writing key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+func TestSelfSigned(t *testing.T) {
+	cert, err := SelfSigned("guestbook.test", "127.0.0.1", "")
+	if err != nil {
+		t.Fatalf("Error generating certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("Error parsing certificate: %v", err)
+	}
+	if err := leaf.VerifyHostname("guestbook.test"); err != nil {
+		t.Errorf("Expected certificate for guestbook.test: %v", err)
+	}
+	if err := leaf.VerifyHostname("127.0.0.1"); err != nil {
+		t.Errorf("Expected certificate for 127.0.0.1: %v", err)
+	}
+	if err := leaf.VerifyHostname("other.test"); err == nil {
+		t.Error("Expected no certificate for other.test")
+	}
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writePair(t, dir, "first.test")
+
+	r, err := NewReloader(certFile, keyFile, slog.Default())
+	if err != nil {
+		t.Fatalf("Error loading certificate: %v", err)
+	}
+	r.interval = 0
+	hostname := func() string {
+		cert, err := r.GetCertificate(nil)
+		if err != nil {
+			t.Fatalf("Error getting certificate: %v", err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatalf("Error parsing certificate: %v", err)
+		}
+		return leaf.DNSNames[0]
+	}
+	if name := hostname(); name != "first.test" {
+		t.Fatalf("Expected first.test, got %s", name)
+	}
+
+	//NOTE: the modification time has to change for the reload
+	later := time.Now().Add(time.Minute)
+	if err := os.WriteFile(certFile, []byte("invalid"), 0600); err != nil {
+		t.Fatalf("Error writing certificate: %v", err)
+	}
+	os.Chtimes(certFile, later, later)
+	if name := hostname(); name != "first.test" {
+		t.Errorf("Expected invalid files to keep first.test, got %s", name)
+	}
+
+	writePair(t, dir, "second.test")
+	later = later.Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	if name := hostname(); name != "second.test" {
+		t.Errorf("Expected reloaded second.test, got %s", name)
+	}
+
+	if _, err := NewReloader(filepath.Join(dir, "missing.crt"), keyFile, slog.Default()); err == nil {
+		t.Error("Expected error for missing certificate")
+	}
+}
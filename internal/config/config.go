@@ -0,0 +1,640 @@
+// Package config loads the settings of the guestbook from a YAML or TOML
+// file, the legacy .env-file, GUESTBOOK_* environment variables and flags
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	templates "github.com/led0nk/guestbook/internal"
+	"github.com/led0nk/guestbook/internal/middleware"
+	"github.com/led0nk/guestbook/internal/oidc"
+	"github.com/led0nk/guestbook/internal/password"
+	"github.com/led0nk/guestbook/internal/secrets"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is prepended to the names of the environment variables, e.g.
+// GUESTBOOK_MAIL_HOST sets mail.host
+const EnvPrefix = "GUESTBOOK_"
+
+// Redacted replaces secrets when the configuration is printed
+const Redacted = "REDACTED"
+
+// Secret is a value which must not show up in logs or printed configurations
+type Secret string
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return Redacted
+}
+
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *Secret) UnmarshalText(text []byte) error {
+	*s = Secret(text)
+	return nil
+}
+
+// Config of the guestbook, the yaml names are used for TOML and the
+// environment variables as well
+type Config struct {
+	Addr     string `yaml:"addr" toml:"addr"`
+	GRPCAddr string `yaml:"grpcaddr" toml:"grpcaddr"`
+	DB       string `yaml:"db" toml:"db"`
+	// legacy .env-file, its values are overridden by environment variables
+	Env            string   `yaml:"env" toml:"env"`
+	Domain         string   `yaml:"domain" toml:"domain"`
+	Origin         string   `yaml:"origin" toml:"origin"`
+	LogLevel       string   `yaml:"loglevel" toml:"loglevel"`
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies"`
+
+	TokenSecret     Secret `yaml:"token_secret" toml:"token_secret"`
+	TokenSecretFile string `yaml:"token_secret_file" toml:"token_secret_file"`
+
+	MagicLink      bool          `yaml:"magiclink" toml:"magiclink"`
+	Registration   string        `yaml:"registration" toml:"registration"`
+	EntryPolicy    string        `yaml:"entry_policy" toml:"entry_policy"`
+	DeletionGrace  time.Duration `yaml:"deletion_grace" toml:"deletion_grace"`
+	DigestInterval time.Duration `yaml:"digest_interval" toml:"digest_interval"`
+	DrainTimeout   time.Duration `yaml:"drain_timeout" toml:"drain_timeout"`
+	ShutdownDelay  time.Duration `yaml:"shutdown_delay" toml:"shutdown_delay"`
+
+	TLS        TLS                   `yaml:"tls" toml:"tls"`
+	Password   Password              `yaml:"password" toml:"password"`
+	Mail       Mail                  `yaml:"mail" toml:"mail"`
+	RateLimits middleware.RateLimits `yaml:"rate_limits" toml:"rate_limits"`
+	Security   Security              `yaml:"security" toml:"security"`
+	Theme      Theme                 `yaml:"theme" toml:"theme"`
+	// external identity providers, in the environment they are listed in
+	// GUESTBOOK_OIDC_PROVIDERS and configured by GUESTBOOK_OIDC_<NAME>_*
+	OIDC []Provider `yaml:"oidc" toml:"oidc"`
+}
+
+// TLS configures HTTPS, it is enabled by a cert and key or self_signed
+type TLS struct {
+	Cert string `yaml:"cert" toml:"cert"`
+	Key  string `yaml:"key" toml:"key"`
+	// generates a certificate at startup, browsers warn about it
+	SelfSigned bool `yaml:"self_signed" toml:"self_signed"`
+	// address of a plaintext listener redirecting to HTTPS
+	RedirectAddr string `yaml:"redirect_addr" toml:"redirect_addr"`
+	// max-age of Strict-Transport-Security, 0 disables it
+	HSTS time.Duration `yaml:"hsts" toml:"hsts"`
+}
+
+// Enabled reports whether the server listens with TLS
+func (t TLS) Enabled() bool {
+	return t.SelfSigned || t.Cert != ""
+}
+
+// Password configures the hashing and the policy of passwords
+type Password struct {
+	Hash         string `yaml:"hash" toml:"hash"`
+	BcryptCost   int    `yaml:"bcrypt_cost" toml:"bcrypt_cost"`
+	Argon2Time   uint   `yaml:"argon2_time" toml:"argon2_time"`
+	Argon2Memory uint   `yaml:"argon2_memory" toml:"argon2_memory"`
+	MinLength    int    `yaml:"min_length" toml:"min_length"`
+	MinClasses   int    `yaml:"min_classes" toml:"min_classes"`
+	Blocklist    string `yaml:"blocklist" toml:"blocklist"`
+}
+
+// Mail configures the SMTP server the mails are sent with
+type Mail struct {
+	Address      string `yaml:"address" toml:"address"`
+	Password     Secret `yaml:"password" toml:"password"`
+	PasswordFile string `yaml:"password_file" toml:"password_file"`
+	Host         string `yaml:"host" toml:"host"`
+	Port         int    `yaml:"port" toml:"port"`
+}
+
+// registration modes and entry policies, see v1.ParseRegistrationMode and
+// v1.ParseEntryPolicy
+var (
+	registrationModes = []string{"open", "invite", "closed"}
+	entryPolicies     = []string{"delete", "anonymize"}
+)
+
+// Theme selects the theme of the guestbook among the subdirectories of dir,
+// an empty name uses the embedded templates
+type Theme struct {
+	Dir  string `yaml:"dir" toml:"dir"`
+	Name string `yaml:"name" toml:"name"`
+	// re-parses the templates once their files changed
+	Dev bool `yaml:"dev" toml:"dev"`
+}
+
+// SecurityHeaders of the responses, a value of "-" removes a header of a
+// route, see middleware.SecurityHeaders
+type SecurityHeaders struct {
+	// {nonce} is replaced by the nonce of the request
+	CSP                string `yaml:"csp" toml:"csp"`
+	FrameOptions       string `yaml:"frame_options" toml:"frame_options"`
+	ReferrerPolicy     string `yaml:"referrer_policy" toml:"referrer_policy"`
+	PermissionsPolicy  string `yaml:"permissions_policy" toml:"permissions_policy"`
+	ContentTypeOptions string `yaml:"content_type_options" toml:"content_type_options"`
+}
+
+// Security configures the headers of all responses, the headers of the
+// matching path prefixes in routes override them, longer prefixes last
+type Security struct {
+	Headers SecurityHeaders            `yaml:"headers" toml:"headers"`
+	Routes  map[string]SecurityHeaders `yaml:"routes" toml:"routes"`
+}
+
+// Provider configures an external identity provider
+type Provider struct {
+	Name             string   `yaml:"name" toml:"name"`
+	Issuer           string   `yaml:"issuer" toml:"issuer"`
+	ClientID         string   `yaml:"client_id" toml:"client_id"`
+	ClientSecret     Secret   `yaml:"client_secret" toml:"client_secret"`
+	ClientSecretFile string   `yaml:"client_secret_file" toml:"client_secret_file"`
+	Scopes           []string `yaml:"scopes" toml:"scopes"`
+}
+
+// Default returns the configuration used if nothing else is set
+func Default() *Config {
+	return &Config{
+		Addr:          "localhost:8080",
+		DB:            "file://testdata",
+		Env:           "testdata/.env",
+		Domain:        "127.0.0.1",
+		Origin:        "http://localhost:8080",
+		LogLevel:      "INFO",
+		Registration:  "open",
+		EntryPolicy:   "delete",
+		DeletionGrace: 7 * 24 * time.Hour,
+		DrainTimeout:  15 * time.Second,
+		Password: Password{
+			Hash:         "argon2id",
+			BcryptCost:   password.DefaultBcrypt.Cost,
+			Argon2Time:   uint(password.DefaultArgon2id.Time),
+			Argon2Memory: uint(password.DefaultArgon2id.Memory),
+			MinLength:    password.DefaultPolicy.MinLength,
+			MinClasses:   password.DefaultPolicy.MinClasses,
+		},
+		Mail:       Mail{Port: 587},
+		RateLimits: middleware.DefaultRateLimits,
+		Security:   Security{Headers: SecurityHeaders(middleware.DefaultSecurityPolicy.Default)},
+	}
+}
+
+// RegisterFlags binds the flags to the fields of the configuration, secrets
+// have no flags as the command line is visible to every user
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Addr, "addr", c.Addr, "server port")
+	fs.StringVar(&c.GRPCAddr, "grpcaddr", c.GRPCAddr, "grpc address, e.g. localhost:4317")
+	fs.StringVar(&c.DB, "db", c.DB, "path to database")
+	fs.StringVar(&c.Env, "env", c.Env, "path to .env-file")
+	fs.StringVar(&c.Domain, "domain", c.Domain, "given domain for cookies/mail")
+	fs.StringVar(&c.Origin, "origin", c.Origin, "origin of the guestbook for passkeys")
+	fs.BoolVar(&c.MagicLink, "magiclink", c.MagicLink, "allow login with a link sent by mail")
+	fs.Var((*list)(&c.TrustedProxies), "trustedproxies", "comma separated addresses or CIDRs of trusted reverse proxies")
+	fs.StringVar(&c.TLS.Cert, "tlscert", c.TLS.Cert, "path to the TLS certificate, it is reloaded on change")
+	fs.StringVar(&c.TLS.Key, "tlskey", c.TLS.Key, "path to the TLS key, it is reloaded on change")
+	fs.BoolVar(&c.TLS.SelfSigned, "tlsselfsigned", c.TLS.SelfSigned, "serve a self-signed certificate for development")
+	fs.StringVar(&c.TLS.RedirectAddr, "redirectaddr", c.TLS.RedirectAddr, "address of a plaintext listener redirecting to HTTPS")
+	fs.DurationVar(&c.TLS.HSTS, "hsts", c.TLS.HSTS, "max-age of Strict-Transport-Security, 0 disables it")
+	fs.StringVar(&c.Theme.Dir, "themes", c.Theme.Dir, "directory of the themes")
+	fs.StringVar(&c.Theme.Name, "theme", c.Theme.Name, "theme in the themes directory, its files override the embedded templates and static assets")
+	fs.BoolVar(&c.Theme.Dev, "themedev", c.Theme.Dev, "re-parse the templates once their files changed to develop a theme")
+	fs.StringVar(&c.Password.Hash, "passwordhash", c.Password.Hash, "algorithm for new password hashes, argon2id or bcrypt")
+	fs.IntVar(&c.Password.BcryptCost, "bcryptcost", c.Password.BcryptCost, "cost of bcrypt password hashes")
+	fs.UintVar(&c.Password.Argon2Time, "argon2time", c.Password.Argon2Time, "iterations of argon2id password hashes")
+	fs.UintVar(&c.Password.Argon2Memory, "argon2memory", c.Password.Argon2Memory, "memory in KiB of argon2id password hashes")
+	fs.IntVar(&c.Password.MinLength, "passwordminlength", c.Password.MinLength, "minimum length of passwords")
+	fs.IntVar(&c.Password.MinClasses, "passwordclasses", c.Password.MinClasses, "minimum number of character classes in passwords")
+	fs.StringVar(&c.Password.Blocklist, "passwordblocklist", c.Password.Blocklist, "path to a file of common passwords which are rejected")
+	fs.StringVar(&c.Registration, "registration", c.Registration, "who may sign up: open, invite or closed")
+	fs.StringVar(&c.EntryPolicy, "entrypolicy", c.EntryPolicy, "what happens to the entries of deleted accounts: delete or anonymize")
+	fs.DurationVar(&c.DeletionGrace, "deletiongrace", c.DeletionGrace, "time until a deleted account is removed, it can be restored until then")
+	fs.DurationVar(&c.DigestInterval, "digestinterval", c.DigestInterval, "interval of the activity digest mailed to the admins, 0 disables it")
+	fs.DurationVar(&c.DrainTimeout, "draintimeout", c.DrainTimeout, "time to finish running requests and jobs on shutdown")
+	fs.DurationVar(&c.ShutdownDelay, "shutdowndelay", c.ShutdownDelay, "time between failing the readiness probe and closing the listener on shutdown")
+	fs.StringVar(&c.LogLevel, "loglevel", c.LogLevel, "define the level for logs")
+}
+
+// list is a comma separated flag
+type list []string
+
+func (l *list) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *list) Set(value string) error {
+	*l = split(value)
+	return nil
+}
+
+// Load parses the flags in args and builds the configuration from, in
+// increasing precedence, the defaults, the config file given by -config or
+// GUESTBOOK_CONFIG, the .env-file, the environment and the flags which are
+// set explicitly. Secrets given as files are read, the result is not
+// validated yet
+func Load(fs *flag.FlagSet, args []string, environ []string) (*Config, string, error) {
+	env := fromEnviron(environ)
+	c := Default()
+	path := fs.String("config", env["CONFIG"], "path to a YAML or TOML config file")
+	c.RegisterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, "", err
+	}
+
+	//NOTE: the flags write into c, so the explicitly set ones are
+	// remembered and applied again on top of the other sources
+	flags := map[string]string{}
+	fs.Visit(func(f *flag.Flag) { flags[f.Name] = f.Value.String() })
+	*c = *Default()
+
+	if *path != "" {
+		if err := c.ReadFile(*path); err != nil {
+			return nil, *path, err
+		}
+	}
+
+	envFile := c.Env
+	if value, ok := env["ENV"]; ok {
+		envFile = value
+	}
+	if value, ok := flags["env"]; ok {
+		envFile = value
+	}
+	if envFile != "" {
+		dotenv, err := readDotEnv(envFile)
+		if err != nil {
+			return nil, *path, fmt.Errorf("failed to load .env-file: %w", err)
+		}
+		if err := c.apply(dotenv); err != nil {
+			return nil, *path, fmt.Errorf("invalid value in .env-file %s: %w", envFile, err)
+		}
+	}
+	if err := c.apply(env); err != nil {
+		return nil, *path, fmt.Errorf("invalid environment variable: %w", err)
+	}
+	for name, value := range flags {
+		if err := fs.Set(name, value); err != nil {
+			return nil, *path, err
+		}
+	}
+
+	if err := c.ReadSecrets(); err != nil {
+		return nil, *path, err
+	}
+	return c, *path, nil
+}
+
+// ReadFile reads a YAML or TOML file depending on its extension, unknown keys
+// are rejected to catch typos
+func (c *Config) ReadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	case ".toml":
+		meta, err := toml.Decode(string(data), c)
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("failed to parse %s: unknown key %s", path, undecoded[0])
+		}
+	default:
+		return fmt.Errorf("unknown config format: %s", path)
+	}
+	return nil
+}
+
+// fromEnviron returns the variables starting with EnvPrefix without it
+func fromEnviron(environ []string) map[string]string {
+	env := map[string]string{}
+	for _, variable := range environ {
+		key, value, ok := strings.Cut(variable, "=")
+		if ok && strings.HasPrefix(key, EnvPrefix) {
+			env[strings.TrimPrefix(key, EnvPrefix)] = value
+		}
+	}
+	return env
+}
+
+// legacy names of the .env-file
+var dotEnvKeys = map[string]string{
+	"TOKENSECRET": "TOKEN_SECRET",
+	"EMAIL":       "MAIL_ADDRESS",
+	"SMTPPW":      "MAIL_PASSWORD",
+	"HOST":        "MAIL_HOST",
+	"PORT":        "MAIL_PORT",
+}
+
+// legacyTokenSecret was written to missing .env-files by older versions, it
+// is publicly known
+const legacyTokenSecret = "secret"
+
+// readDotEnv reads the .env-file and renames its keys to the names of the
+// environment variables, a missing file is skipped, see CreateDotEnv
+func readDotEnv(path string) (map[string]string, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	envmap, err := godotenv.Read(path)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(envmap))
+	for key, value := range envmap {
+		if renamed, ok := dotEnvKeys[key]; ok {
+			key = renamed
+		}
+		if strings.HasPrefix(key, "OIDC_") {
+			key = strings.Replace(key, "_CLIENTID", "_CLIENT_ID", 1)
+			key = strings.Replace(key, "_CLIENTSECRET", "_CLIENT_SECRET", 1)
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+// CreateDotEnv creates the missing .env-file with a random token secret to
+// keep the development setup working, it is only done at the startup of the
+// server if no token secret is configured, so loading the configuration has
+// no side effects
+func (c *Config) CreateDotEnv() error {
+	if c.Env == "" || c.TokenSecret != "" {
+		return nil
+	}
+	if _, err := os.Stat(c.Env); !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.Env), 0777); err != nil {
+		return err
+	}
+	secret, err := secrets.TokenSecret.Generate()
+	if err != nil {
+		return err
+	}
+	if err := godotenv.Write(map[string]string{"TOKENSECRET": secret}, c.Env); err != nil {
+		return err
+	}
+	if err := os.Chmod(c.Env, 0600); err != nil {
+		return err
+	}
+	c.TokenSecret = Secret(secret)
+	return nil
+}
+
+// ReadSecrets replaces the secrets which are given as files by their content
+func (c *Config) ReadSecrets() error {
+	var errs []error
+	read := func(name string, secret *Secret, path string) {
+		if path == "" {
+			return
+		}
+		if *secret != "" {
+			errs = append(errs, fmt.Errorf("%s: set either the secret or its file", name))
+			return
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			return
+		}
+		*secret = Secret(strings.TrimRight(string(data), "\r\n"))
+	}
+
+	read("token_secret", &c.TokenSecret, c.TokenSecretFile)
+	read("mail.password", &c.Mail.Password, c.Mail.PasswordFile)
+	for i := range c.OIDC {
+		read("oidc."+c.OIDC[i].Name+".client_secret", &c.OIDC[i].ClientSecret, c.OIDC[i].ClientSecretFile)
+	}
+	return errors.Join(errs...)
+}
+
+// Validate reports every invalid setting at once
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(name string, err error) {
+		errs = append(errs, fmt.Errorf("%s: %w", name, err))
+	}
+
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		invalid("addr", err)
+	}
+	if c.GRPCAddr != "" {
+		if _, _, err := net.SplitHostPort(c.GRPCAddr); err != nil {
+			invalid("grpcaddr", err)
+		}
+	}
+	if u, err := url.Parse(c.DB); err != nil {
+		invalid("db", err)
+	} else if u.Scheme != "file" {
+		invalid("db", fmt.Errorf("unsupported database: %s", u.Scheme))
+	}
+	if c.Domain == "" {
+		invalid("domain", errors.New("must not be empty"))
+	}
+	if u, err := url.Parse(c.Origin); err != nil {
+		invalid("origin", err)
+	} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		invalid("origin", errors.New("must be an absolute http or https URL"))
+	}
+	if c.TLS.Enabled() && strings.HasPrefix(c.Origin, "http:") {
+		invalid("origin", errors.New("must use https with tls"))
+	}
+	switch {
+	case c.TLS.SelfSigned && c.TLS.Cert != "":
+		invalid("tls", errors.New("set either a certificate or self_signed"))
+	case (c.TLS.Cert == "") != (c.TLS.Key == ""):
+		invalid("tls", errors.New("cert and key must be set together"))
+	case c.TLS.Cert != "":
+		if _, err := tls.LoadX509KeyPair(c.TLS.Cert, c.TLS.Key); err != nil {
+			invalid("tls", err)
+		}
+	}
+	if c.TLS.RedirectAddr != "" {
+		if !c.TLS.Enabled() {
+			invalid("tls.redirect_addr", errors.New("needs tls"))
+		} else if _, _, err := net.SplitHostPort(c.TLS.RedirectAddr); err != nil {
+			invalid("tls.redirect_addr", err)
+		}
+	}
+	if c.TLS.HSTS < 0 {
+		invalid("tls.hsts", errors.New("must not be negative"))
+	}
+	if c.Theme.Name != "" {
+		if c.Theme.Name != filepath.Base(c.Theme.Name) || c.Theme.Name == "." || c.Theme.Name == ".." {
+			invalid("theme.name", errors.New("must be a directory name"))
+		} else if err := c.SelectedTheme().Validate(); err != nil {
+			invalid("theme", err)
+		}
+	}
+	for prefix := range c.Security.Routes {
+		if !strings.HasPrefix(prefix, "/") {
+			invalid("security.routes", fmt.Errorf("%q is not a path", prefix))
+		}
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		invalid("loglevel", err)
+	}
+	if _, err := middleware.NewClientIP(c.TrustedProxies); err != nil {
+		invalid("trusted_proxies", err)
+	}
+	switch c.TokenSecret {
+	case "":
+		invalid("token_secret", errors.New("must not be empty"))
+	case legacyTokenSecret:
+		invalid("token_secret", errors.New("is the publicly known default of older versions, set a random secret"))
+	}
+
+	if !slices.Contains(registrationModes, c.Registration) {
+		invalid("registration", fmt.Errorf("unknown registration mode: %s", c.Registration))
+	}
+	if !slices.Contains(entryPolicies, c.EntryPolicy) {
+		invalid("entry_policy", fmt.Errorf("unknown entry policy: %s", c.EntryPolicy))
+	}
+	if c.DeletionGrace < 0 {
+		invalid("deletion_grace", errors.New("must not be negative"))
+	}
+	if c.DigestInterval < 0 {
+		invalid("digest_interval", errors.New("must not be negative"))
+	}
+	if c.DrainTimeout <= 0 {
+		invalid("drain_timeout", errors.New("must be positive"))
+	}
+	if c.ShutdownDelay < 0 {
+		invalid("shutdown_delay", errors.New("must not be negative"))
+	}
+
+	if _, err := c.Hasher(); err != nil {
+		invalid("password", err)
+	}
+	if c.Password.MinLength < 1 {
+		invalid("password.min_length", errors.New("must be at least 1"))
+	}
+	if c.Password.MinClasses < 1 || c.Password.MinClasses > 4 {
+		invalid("password.min_classes", errors.New("must be between 1 and 4"))
+	}
+
+	if c.Mail.Host != "" {
+		if c.Mail.Address == "" {
+			invalid("mail.address", errors.New("must not be empty"))
+		}
+		if c.Mail.Port < 1 || c.Mail.Port > 65535 {
+			invalid("mail.port", fmt.Errorf("invalid port %d", c.Mail.Port))
+		}
+	}
+
+	if err := c.RateLimits.Validate(); err != nil {
+		invalid("rate_limits", err)
+	}
+
+	names := map[string]bool{}
+	for _, provider := range c.OIDC {
+		name := "oidc." + provider.Name
+		switch {
+		case provider.Name == "":
+			invalid("oidc", errors.New("provider without name"))
+		case names[provider.Name]:
+			invalid(name, errors.New("configured twice"))
+		}
+		names[provider.Name] = true
+		if u, err := url.Parse(provider.Issuer); err != nil || u.Scheme == "" || u.Host == "" {
+			invalid(name+".issuer", errors.New("must be an absolute URL"))
+		}
+		if provider.ClientID == "" {
+			invalid(name+".client_id", errors.New("must not be empty"))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Hasher creates the password hasher of the configuration
+func (c *Config) Hasher() (*password.Hasher, error) {
+	argon := password.DefaultArgon2id
+	argon.Time = uint32(c.Password.Argon2Time)
+	argon.Memory = uint32(c.Password.Argon2Memory)
+	return password.New(c.Password.Hash, c.Password.BcryptCost, argon)
+}
+
+// SelectedTheme returns the theme of the templates
+func (c *Config) SelectedTheme() templates.Theme {
+	theme := templates.Theme{Dev: c.Theme.Dev}
+	if c.Theme.Name != "" {
+		theme.Dir = filepath.Join(c.Theme.Dir, c.Theme.Name)
+	}
+	return theme
+}
+
+// SecurityPolicy returns the security headers of the routes
+func (c *Config) SecurityPolicy() middleware.SecurityPolicy {
+	policy := middleware.SecurityPolicy{
+		Default: middleware.SecurityHeaders(c.Security.Headers),
+		Routes:  make(map[string]middleware.SecurityHeaders, len(c.Security.Routes)),
+	}
+	for prefix, headers := range c.Security.Routes {
+		policy.Routes[prefix] = middleware.SecurityHeaders(headers)
+	}
+	return policy
+}
+
+// Level returns the parsed log level, INFO if it is invalid
+func (c *Config) Level() slog.Level {
+	var level slog.Level
+	_ = level.UnmarshalText([]byte(c.LogLevel))
+	return level
+}
+
+// OIDCConfigs returns the configurations of the identity providers, the
+// redirect URLs are derived from the origin
+func (c *Config) OIDCConfigs() []oidc.Config {
+	configs := make([]oidc.Config, 0, len(c.OIDC))
+	for _, provider := range c.OIDC {
+		configs = append(configs, oidc.Config{
+			Name:         provider.Name,
+			Issuer:       provider.Issuer,
+			ClientID:     provider.ClientID,
+			ClientSecret: string(provider.ClientSecret),
+			RedirectURL:  strings.TrimSuffix(c.Origin, "/") + "/login/oidc/" + provider.Name + "/callback",
+			Scopes:       provider.Scopes,
+		})
+	}
+	return configs
+}
+
+// Print writes the configuration as YAML with redacted secrets
+func (c *Config) Print(w io.Writer) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
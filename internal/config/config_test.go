@@ -0,0 +1,376 @@
+package config_test
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "github.com/led0nk/guestbook/api/v1"
+	"github.com/led0nk/guestbook/internal/config"
+)
+
+func write(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Error writing %s: %v", name, err)
+	}
+	return path
+}
+
+func load(args []string, environ ...string) (*config.Config, error) {
+	cfg, _, err := config.Load(flag.NewFlagSet("guestbook", flag.ContinueOnError), args, environ)
+	return cfg, err
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	yamlFile := write(t, dir, "guestbook.yaml", `
+addr: 0.0.0.0:80
+domain: file.example.com
+env: ""
+token_secret: fromfile
+drain_timeout: 30s
+mail:
+  address: jon@doe.com
+  host: smtp.example.com
+  port: 465
+oidc:
+  - name: google
+    issuer: https://accounts.google.com
+    client_id: id
+    scopes: [openid, email]
+`)
+	tomlFile := write(t, dir, "guestbook.toml", `
+addr = "0.0.0.0:80"
+domain = "file.example.com"
+env = ""
+token_secret = "fromfile"
+drain_timeout = "30s"
+
+[mail]
+address = "jon@doe.com"
+host = "smtp.example.com"
+port = 465
+
+[[oidc]]
+name = "google"
+issuer = "https://accounts.google.com"
+client_id = "id"
+scopes = ["openid", "email"]
+`)
+
+	for _, file := range []string{yamlFile, tomlFile} {
+		t.Run(filepath.Ext(file), func(t *testing.T) {
+			cfg, err := load([]string{"-config", file, "-domain", "flag.example.com"},
+				"GUESTBOOK_DOMAIN=env.example.com",
+				"GUESTBOOK_MAIL_PORT=587",
+				"GUESTBOOK_OIDC_PROVIDERS=google",
+				"GUESTBOOK_OIDC_GOOGLE_CLIENT_SECRET=envsecret",
+				"GUESTBOOK_RATE_LIMITS_AUTH_BURST=3",
+				"OTHER_ADDR=ignored",
+			)
+			if err != nil {
+				t.Fatalf("Error loading config: %v", err)
+			}
+			if cfg.Addr != "0.0.0.0:80" {
+				t.Errorf("Expected addr from file, got %s", cfg.Addr)
+			}
+			if cfg.Domain != "flag.example.com" {
+				t.Errorf("Expected domain from flag, got %s", cfg.Domain)
+			}
+			if cfg.Mail.Host != "smtp.example.com" || cfg.Mail.Port != 587 {
+				t.Errorf("Expected mail host from file and port from env, got %s:%d", cfg.Mail.Host, cfg.Mail.Port)
+			}
+			if cfg.DrainTimeout != 30*time.Second {
+				t.Errorf("Expected drain timeout of 30s, got %s", cfg.DrainTimeout)
+			}
+			if limits := cfg.RateLimits; limits.Auth.Burst != 3 || limits.Auth.Interval != 6*time.Second {
+				t.Errorf("Expected auth burst from env and default interval, got %+v", limits.Auth)
+			}
+			if cfg.Origin != config.Default().Origin {
+				t.Errorf("Expected default origin, got %s", cfg.Origin)
+			}
+			if len(cfg.OIDC) != 1 || cfg.OIDC[0].ClientSecret != "envsecret" || len(cfg.OIDC[0].Scopes) != 2 {
+				t.Errorf("Expected google provider merged with env, got %+v", cfg.OIDC)
+			}
+			if err := cfg.Validate(); err != nil {
+				t.Errorf("Expected valid config, got %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadDotEnv(t *testing.T) {
+	dir := t.TempDir()
+	envFile := write(t, dir, ".env", strings.Join([]string{
+		`TOKENSECRET="dotenv"`,
+		`EMAIL="jon@doe.com"`,
+		`SMTPPW="smtp"`,
+		`HOST="smtp.example.com"`,
+		`PORT="25"`,
+		`OIDC_PROVIDERS="google"`,
+		`OIDC_GOOGLE_ISSUER="https://accounts.google.com"`,
+		`OIDC_GOOGLE_CLIENTID="id"`,
+		`OIDC_GOOGLE_CLIENTSECRET="secret"`,
+		`OIDC_GOOGLE_SCOPES="openid email"`,
+	}, "\n"))
+
+	cfg, err := load([]string{"-env", envFile}, "GUESTBOOK_TOKEN_SECRET=env")
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	if cfg.TokenSecret != "env" {
+		t.Errorf("Expected token secret from env to override .env, got %s", string(cfg.TokenSecret))
+	}
+	if cfg.Mail.Address != "jon@doe.com" || cfg.Mail.Password != "smtp" || cfg.Mail.Host != "smtp.example.com" || cfg.Mail.Port != 25 {
+		t.Errorf("Expected mail from .env, got %+v", cfg.Mail)
+	}
+	if len(cfg.OIDC) != 1 || cfg.OIDC[0].ClientID != "id" || cfg.OIDC[0].ClientSecret != "secret" || len(cfg.OIDC[0].Scopes) != 2 {
+		t.Errorf("Expected google provider from .env, got %+v", cfg.OIDC)
+	}
+
+	missing := filepath.Join(dir, "new", ".env")
+	cfg, err = load([]string{"-env", missing})
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("Expected missing .env-file not to be created by loading, got %v", err)
+	}
+	if cfg.TokenSecret != "" {
+		t.Errorf("Expected no token secret without .env-file, got %s", string(cfg.TokenSecret))
+	}
+}
+
+func TestCreateDotEnv(t *testing.T) {
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "new", ".env")
+	cfg, err := load([]string{"-env", missing})
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	if err := cfg.CreateDotEnv(); err != nil {
+		t.Fatalf("Error creating .env-file: %v", err)
+	}
+	if _, err := os.Stat(missing); err != nil {
+		t.Errorf("Expected missing .env-file to be created: %v", err)
+	}
+	if cfg.TokenSecret == "" || cfg.Validate() != nil {
+		t.Errorf("Expected valid token secret of created .env-file, got %v", cfg.Validate())
+	}
+
+	reloaded, err := load([]string{"-env", missing})
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	if reloaded.TokenSecret != cfg.TokenSecret {
+		t.Errorf("Expected token secret of created .env-file on reload, got %s", string(reloaded.TokenSecret))
+	}
+
+	configured := filepath.Join(dir, "configured", ".env")
+	cfg, err = load([]string{"-env", configured}, "GUESTBOOK_TOKEN_SECRET=env")
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	if err := cfg.CreateDotEnv(); err != nil {
+		t.Fatalf("Error creating .env-file: %v", err)
+	}
+	if _, err := os.Stat(configured); !os.IsNotExist(err) {
+		t.Errorf("Expected no .env-file with a configured token secret, got %v", err)
+	}
+	if cfg.TokenSecret != "env" {
+		t.Errorf("Expected configured token secret, got %s", string(cfg.TokenSecret))
+	}
+}
+
+func TestSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := write(t, dir, "token", "filesecret\n")
+	mailFile := write(t, dir, "smtp", "smtpsecret")
+
+	cfg, err := load([]string{"-env", ""},
+		"GUESTBOOK_TOKEN_SECRET_FILE="+tokenFile,
+		"GUESTBOOK_MAIL_PASSWORD_FILE="+mailFile,
+	)
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	if cfg.TokenSecret != "filesecret" {
+		t.Errorf("Expected token secret without newline from file, got %q", string(cfg.TokenSecret))
+	}
+	if cfg.Mail.Password != "smtpsecret" {
+		t.Errorf("Expected mail password from file, got %q", string(cfg.Mail.Password))
+	}
+
+	_, err = load([]string{"-env", ""}, "GUESTBOOK_TOKEN_SECRET=plain", "GUESTBOOK_TOKEN_SECRET_FILE="+tokenFile)
+	if err == nil {
+		t.Error("Expected error for secret and secret file")
+	}
+	_, err = load([]string{"-env", ""}, "GUESTBOOK_TOKEN_SECRET_FILE="+filepath.Join(dir, "missing"))
+	if err == nil {
+		t.Error("Expected error for missing secret file")
+	}
+}
+
+func TestPrintRedactsSecrets(t *testing.T) {
+	cfg := config.Default()
+	cfg.TokenSecret = "tokensecret"
+	cfg.Mail.Password = "smtpsecret"
+	cfg.Mail.PasswordFile = "/run/secrets/smtp"
+	cfg.OIDC = []config.Provider{{Name: "google", ClientSecret: "clientsecret"}}
+
+	var buf bytes.Buffer
+	if err := cfg.Print(&buf); err != nil {
+		t.Fatalf("Error printing config: %v", err)
+	}
+	out := buf.String()
+	for _, secret := range []string{"tokensecret", "smtpsecret", "clientsecret"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("Expected %s to be redacted:\n%s", secret, out)
+		}
+	}
+	if strings.Count(out, config.Redacted) != 3 {
+		t.Errorf("Expected 3 redacted secrets:\n%s", out)
+	}
+	if !strings.Contains(out, "/run/secrets/smtp") || !strings.Contains(out, "drain_timeout: 15s") {
+		t.Errorf("Expected other settings to be printed:\n%s", out)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*config.Config)
+		errors []string
+	}{
+		{name: "Valid", modify: func(*config.Config) {}},
+		{
+			name: "Missing token secret",
+			modify: func(c *config.Config) {
+				c.TokenSecret = ""
+			},
+			errors: []string{"token_secret"},
+		},
+		{
+			name: "Default token secret of older versions",
+			modify: func(c *config.Config) {
+				c.TokenSecret = "secret"
+			},
+			errors: []string{"token_secret: is the publicly known default"},
+		},
+		{
+			name: "Invalid values",
+			modify: func(c *config.Config) {
+				c.Addr = "localhost"
+				c.DB = "postgres://db"
+				c.Origin = "localhost:8080"
+				c.LogLevel = "LOUD"
+				c.Registration = "everyone"
+				c.Password.Hash = "md5"
+				c.DrainTimeout = 0
+				c.TrustedProxies = []string{"proxy"}
+				c.RateLimits.Search.Burst = 0
+				c.Security.Routes = map[string]config.SecurityHeaders{"admin": {FrameOptions: "SAMEORIGIN"}}
+				c.Theme = config.Theme{Dir: "/missing", Name: "wedding"}
+			},
+			errors: []string{"addr", "db", "origin", "loglevel", "registration", "password", "drain_timeout", "trusted_proxies", "rate_limits: rate limit search", "security.routes", "theme"},
+		},
+		{
+			name: "Theme outside the themes directory",
+			modify: func(c *config.Config) {
+				c.Theme = config.Theme{Dir: ".", Name: "../wedding"}
+			},
+			errors: []string{"theme.name"},
+		},
+		{
+			name: "Self-signed TLS",
+			modify: func(c *config.Config) {
+				c.Origin = "https://localhost:8443"
+				c.TLS = config.TLS{SelfSigned: true, RedirectAddr: "localhost:8080", HSTS: time.Hour}
+			},
+		},
+		{
+			name: "Invalid TLS",
+			modify: func(c *config.Config) {
+				c.TLS = config.TLS{Cert: "/missing/tls.crt", RedirectAddr: "localhost"}
+			},
+			errors: []string{"origin: must use https", "tls: cert and key", "tls.redirect_addr"},
+		},
+		{
+			name: "Missing certificate",
+			modify: func(c *config.Config) {
+				c.Origin = "https://localhost:8443"
+				c.TLS = config.TLS{Cert: "/missing/tls.crt", Key: "/missing/tls.key"}
+			},
+			errors: []string{"tls: open /missing/tls.crt"},
+		},
+		{
+			name: "Redirect without TLS",
+			modify: func(c *config.Config) {
+				c.TLS.RedirectAddr = "localhost:80"
+			},
+			errors: []string{"tls.redirect_addr: needs tls"},
+		},
+		{
+			name: "Incomplete mail",
+			modify: func(c *config.Config) {
+				c.Mail.Host = "smtp.example.com"
+				c.Mail.Port = 0
+			},
+			errors: []string{"mail.address", "mail.port"},
+		},
+		{
+			name: "Invalid providers",
+			modify: func(c *config.Config) {
+				c.OIDC = []config.Provider{
+					{Name: "google", Issuer: "https://accounts.google.com", ClientID: "id"},
+					{Name: "google", Issuer: "accounts.google.com"},
+				}
+			},
+			errors: []string{"oidc.google: configured twice", "oidc.google.issuer", "oidc.google.client_id"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.TokenSecret = "s3cr3t"
+			test.modify(cfg)
+			err := cfg.Validate()
+			if len(test.errors) == 0 {
+				if err != nil {
+					t.Errorf("Expected valid config, got %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("Expected validation error")
+			}
+			for _, expected := range test.errors {
+				if !strings.Contains(err.Error(), expected) {
+					t.Errorf("Expected error for %s, got %v", expected, err)
+				}
+			}
+		})
+	}
+}
+
+func TestServerDefaults(t *testing.T) {
+	if err := config.Default().RateLimits.Validate(); err != nil {
+		t.Errorf("Expected valid default rate limits, got %v", err)
+	}
+	for _, mode := range []string{"open", "invite", "closed"} {
+		if _, err := v1.ParseRegistrationMode(mode); err != nil {
+			t.Errorf("Expected registration mode %s to be known by the server: %v", mode, err)
+		}
+	}
+	for _, policy := range []string{"delete", "anonymize"} {
+		if _, err := v1.ParseEntryPolicy(policy); err != nil {
+			t.Errorf("Expected entry policy %s to be known by the server: %v", policy, err)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apply sets the fields named by the keys of values, the key of a field is
+// its uppercased yaml name prefixed by the names of the enclosing structs,
+// e.g. MAIL_HOST
+func (c *Config) apply(values map[string]string) error {
+	errs := []error{setFields(reflect.ValueOf(c).Elem(), "", values)}
+
+	for _, name := range split(values["OIDC_PROVIDERS"]) {
+		name = strings.ToLower(name)
+		var provider *Provider
+		for i := range c.OIDC {
+			if c.OIDC[i].Name == name {
+				provider = &c.OIDC[i]
+			}
+		}
+		if provider == nil {
+			c.OIDC = append(c.OIDC, Provider{Name: name})
+			provider = &c.OIDC[len(c.OIDC)-1]
+		}
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		errs = append(errs, setFields(reflect.ValueOf(provider).Elem(), prefix, values))
+	}
+	return errors.Join(errs...)
+}
+
+func setFields(v reflect.Value, prefix string, values map[string]string) error {
+	var errs []error
+	for i := 0; i < v.NumField(); i++ {
+		name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		key := prefix + strings.ToUpper(name)
+		field := v.Field(i)
+		switch {
+		case field.Kind() == reflect.Struct:
+			errs = append(errs, setFields(field, key+"_", values))
+			continue
+		case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.Struct:
+			// providers are listed explicitly, see apply
+			continue
+		case field.Kind() == reflect.Map:
+			// only set in the config file
+			continue
+		}
+		value, ok := values[key]
+		if !ok {
+			continue
+		}
+		if err := set(field, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func set(field reflect.Value, value string) error {
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int:
+		i, err := strconv.ParseInt(value, 10, 0)
+		if err != nil {
+			return err
+		}
+		field.SetInt(i)
+	case reflect.Uint:
+		u, err := strconv.ParseUint(value, 10, 0)
+		if err != nil {
+			return err
+		}
+		field.SetUint(u)
+	case reflect.Slice:
+		field.Set(reflect.ValueOf(split(value)))
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
+
+// split separates a list by commas or whitespace
+func split(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	})
+}
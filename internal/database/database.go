@@ -2,7 +2,9 @@ package db
 
 import (
 	"context"
+	"errors"
 	"net/http"
+	"strings"
 
 	"github.com/led0nk/guestbook/internal/model"
 
@@ -12,10 +14,22 @@ import (
 type GuestBookStore interface {
 	CreateEntry(context.Context, *model.GuestbookEntry) (uuid.UUID, error)
 	ListEntries(context.Context) ([]*model.GuestbookEntry, error)
+	UpdateEntry(context.Context, *model.GuestbookEntry) error
 	DeleteEntry(context.Context, uuid.UUID) error
 	GetEntryByName(context.Context, string) ([]*model.GuestbookEntry, error)
 	GetEntryByID(context.Context, uuid.UUID) ([]*model.GuestbookEntry, error)
 	GetEntryBySnippet(context.Context, string) ([]*model.GuestbookEntry, error)
+	DeleteEntriesByUser(context.Context, uuid.UUID) error
+	AnonymizeEntriesByUser(context.Context, uuid.UUID, string) error
+}
+
+// ErrEmailTaken is returned by a UserStore if the email belongs to another user
+var ErrEmailTaken = errors.New("email is already in use")
+
+// NormalizeEmail returns the form in which a UserStore stores and compares
+// emails, lookups of emails are case-insensitive in every backend
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
 }
 
 type UserStore interface {
@@ -23,6 +37,8 @@ type UserStore interface {
 	GetUserByEmail(context.Context, string) (*model.User, error)
 	GetUserByID(context.Context, uuid.UUID) (*model.User, error)
 	UpdateUser(context.Context, *model.User) error
+	RequestEmailChange(context.Context, uuid.UUID, string) (string, error)
+	ConfirmEmailChange(context.Context, uuid.UUID, string) (*model.User, error)
 	CreateVerificationCode(context.Context, uuid.UUID) error
 	CodeValidation(context.Context, uuid.UUID, string) (bool, error)
 	ListUser(context.Context) ([]*model.User, error)
@@ -35,4 +51,31 @@ type TokenStore interface {
 	GetTokenValue(context.Context, *http.Cookie) (uuid.UUID, error)
 	Valid(context.Context, string) (bool, error)
 	Refresh(context.Context, string) (*http.Cookie, error)
+	ListSessions(context.Context, uuid.UUID) ([]model.Session, error)
+	CreateMagicLink(context.Context, uuid.UUID) (string, error)
+	RedeemMagicLink(context.Context, string) (uuid.UUID, error)
+	PurgeExpired(context.Context) (int, error)
+}
+
+type CredentialStore interface {
+	CreateCredential(context.Context, *model.Credential) (uuid.UUID, error)
+	GetCredentialByCredentialID(context.Context, []byte) (*model.Credential, error)
+	GetCredentialsByUserID(context.Context, uuid.UUID) ([]*model.Credential, error)
+	UpdateCredential(context.Context, *model.Credential) error
+	DeleteCredential(context.Context, uuid.UUID) error
+}
+
+type InvitationStore interface {
+	CreateInvitation(context.Context, *model.Invitation) (uuid.UUID, error)
+	GetInvitationByCode(context.Context, string) (*model.Invitation, error)
+	ListInvitations(context.Context) ([]*model.Invitation, error)
+	RedeemInvitation(context.Context, string, string) error
+	RefundInvitation(context.Context, string) error
+	DeleteInvitation(context.Context, uuid.UUID) error
+}
+
+// AuditStore is append-only, events can't be changed or deleted
+type AuditStore interface {
+	AppendEvent(context.Context, *model.AuditEvent) error
+	ListEvents(context.Context, model.AuditFilter) ([]*model.AuditEvent, error)
 }
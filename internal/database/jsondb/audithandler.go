@@ -0,0 +1,142 @@
+package jsondb
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/led0nk/guestbook/internal/model"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// AuditStorage keeps audit events in a file with one JSON event per line,
+// events are only ever appended to it
+type AuditStorage struct {
+	filename string
+	events   []*model.AuditEvent
+	mu       sync.Mutex
+	// set by Close, writes fail afterwards
+	closed bool
+}
+
+// creates new Storage for audit events
+func CreateAuditStorage(filename string) (*AuditStorage, error) {
+	storage := &AuditStorage{
+		filename: filename,
+		events:   []*model.AuditEvent{},
+	}
+	if err := storage.readAuditJSON(); err != nil {
+		return nil, err
+	}
+	return storage, nil
+}
+
+// append the event as JSON line to file = filename
+func (a *AuditStorage) appendAuditJSON(event *model.AuditEvent) error {
+	if a.closed {
+		return ErrClosed
+	}
+
+	as_json, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(a.filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(as_json, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// read JSON lines from file = filename
+func (a *AuditStorage) readAuditJSON() error {
+	if _, err := os.Stat(a.filename); os.IsNotExist(err) {
+		return os.MkdirAll(filepath.Dir(a.filename), 0777)
+	}
+	file, err := os.Open(a.filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		event := &model.AuditEvent{}
+		if err := json.Unmarshal(scanner.Bytes(), event); err != nil {
+			return err
+		}
+		a.events = append(a.events, event)
+	}
+	return scanner.Err()
+}
+
+func (a *AuditStorage) AppendEvent(ctx context.Context, event *model.AuditEvent) error {
+	var span trace.Span
+	_, span = tracer.Start(ctx, "AppendEvent")
+	defer span.End()
+
+	span.AddEvent("Lock")
+	a.mu.Lock()
+	defer span.AddEvent("Unlock")
+	defer a.mu.Unlock()
+
+	if event.Action == "" {
+		return errors.New("event requires an action")
+	}
+	if event.ID == uuid.Nil {
+		event.ID = uuid.New()
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if err := a.appendAuditJSON(event); err != nil {
+		return err
+	}
+	a.events = append(a.events, event)
+	return nil
+}
+
+// ListEvents returns the events selected by the filter, the newest first
+func (a *AuditStorage) ListEvents(ctx context.Context, filter model.AuditFilter) ([]*model.AuditEvent, error) {
+	var span trace.Span
+	_, span = tracer.Start(ctx, "ListEvents")
+	defer span.End()
+
+	span.AddEvent("Lock")
+	a.mu.Lock()
+	defer span.AddEvent("Unlock")
+	defer a.mu.Unlock()
+
+	events := []*model.AuditEvent{}
+	span.AddEvent("filter events")
+	for i := len(a.events) - 1; i >= 0; i-- {
+		if filter.Match(a.events[i]) {
+			events = append(events, a.events[i])
+		}
+	}
+	return events, nil
+}
+
+// Close waits for a running write and rejects further writes
+func (a *AuditStorage) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.closed = true
+	return nil
+}
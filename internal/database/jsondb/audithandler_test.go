@@ -0,0 +1,106 @@
+package jsondb_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/led0nk/guestbook/internal/database/jsondb"
+	"github.com/led0nk/guestbook/internal/model"
+)
+
+func TestAuditStorage(t *testing.T) {
+	ctx := context.Background()
+	filename := t.TempDir() + "/audit.jsonl"
+	storage, err := jsondb.CreateAuditStorage(filename)
+	if err != nil {
+		t.Fatalf("Error creating audit storage: %v", err)
+	}
+	admin, user := uuid.New(), uuid.New()
+	now := time.Now()
+	events := []*model.AuditEvent{
+		{Action: model.ActionSignup, Actor: user, Target: user, Time: now.Add(-48 * time.Hour)},
+		{Action: model.ActionUserUpdate, Actor: admin, Target: user, Time: now.Add(-time.Hour),
+			Changes: []model.Change{{Field: "name", Before: "Jon Doe", After: "Jon Roe"}}},
+		{Action: model.ActionAuditExport, Actor: admin, Time: now},
+	}
+	for _, event := range events {
+		if err := storage.AppendEvent(ctx, event); err != nil {
+			t.Fatalf("Error appending event: %v", err)
+		}
+	}
+	if err := storage.AppendEvent(ctx, &model.AuditEvent{}); err == nil {
+		t.Errorf("Expected error for event without action")
+	}
+
+	// the events have to survive a restart
+	storage, err = jsondb.CreateAuditStorage(filename)
+	if err != nil {
+		t.Fatalf("Error reopening audit storage: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		filter   model.AuditFilter
+		expected []model.AuditAction
+	}{
+		{name: "All events", expected: []model.AuditAction{model.ActionAuditExport, model.ActionUserUpdate, model.ActionSignup}},
+		{name: "By actor", filter: model.AuditFilter{Actor: admin}, expected: []model.AuditAction{model.ActionAuditExport, model.ActionUserUpdate}},
+		{name: "By target", filter: model.AuditFilter{Target: user}, expected: []model.AuditAction{model.ActionUserUpdate, model.ActionSignup}},
+		{name: "Since", filter: model.AuditFilter{Since: now.Add(-24 * time.Hour)}, expected: []model.AuditAction{model.ActionAuditExport, model.ActionUserUpdate}},
+		{name: "Until", filter: model.AuditFilter{Until: now.Add(-time.Hour)}, expected: []model.AuditAction{model.ActionSignup}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			events, err := storage.ListEvents(ctx, test.filter)
+			if err != nil {
+				t.Fatalf("Error listing events: %v", err)
+			}
+			actions := []model.AuditAction{}
+			for _, event := range events {
+				actions = append(actions, event.Action)
+			}
+			if len(actions) != len(test.expected) {
+				t.Fatalf("Expected %v, got %v", test.expected, actions)
+			}
+			for i := range actions {
+				if actions[i] != test.expected[i] {
+					t.Errorf("Expected %v, got %v", test.expected, actions)
+				}
+			}
+		})
+	}
+
+	updates, _ := storage.ListEvents(ctx, model.AuditFilter{Actor: admin, Target: user})
+	if len(updates) != 1 || len(updates[0].Changes) != 1 || updates[0].Changes[0].After != "Jon Roe" {
+		t.Errorf("Expected the update with its changes, got %+v", updates)
+	}
+}
+
+func TestClose(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	users, err := jsondb.CreateUserStorage(dir + "/user.json")
+	if err != nil {
+		t.Fatalf("Error creating user storage: %v", err)
+	}
+	events, err := jsondb.CreateAuditStorage(dir + "/audit.jsonl")
+	if err != nil {
+		t.Fatalf("Error creating audit storage: %v", err)
+	}
+	if err := users.Close(); err != nil {
+		t.Fatalf("Error closing user storage: %v", err)
+	}
+	if err := events.Close(); err != nil {
+		t.Fatalf("Error closing audit storage: %v", err)
+	}
+
+	if _, err := users.CreateUser(ctx, &model.User{Name: "Jon Doe", Email: "jon@doe.com"}); !errors.Is(err, jsondb.ErrClosed) {
+		t.Errorf("Expected %v, got %v", jsondb.ErrClosed, err)
+	}
+	if err := events.AppendEvent(ctx, &model.AuditEvent{Action: model.ActionLogin}); !errors.Is(err, jsondb.ErrClosed) {
+		t.Errorf("Expected %v, got %v", jsondb.ErrClosed, err)
+	}
+}
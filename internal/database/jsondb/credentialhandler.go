@@ -0,0 +1,210 @@
+package jsondb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/led0nk/guestbook/internal/model"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type CredentialStorage struct {
+	filename    string
+	credentials map[uuid.UUID]*model.Credential
+	mu          sync.Mutex
+	// set by Close, writes fail afterwards
+	closed bool
+}
+
+// creates new Storage for passkey credentials
+func CreateCredentialStorage(filename string) (*CredentialStorage, error) {
+	storage := &CredentialStorage{
+		filename:    filename,
+		credentials: make(map[uuid.UUID]*model.Credential),
+	}
+	if err := storage.readCredentialJSON(); err != nil {
+		return nil, err
+	}
+	return storage, nil
+}
+
+// write JSON data into readable format in file = filename
+func (c *CredentialStorage) writeCredentialJSON() error {
+	if c.closed {
+		return ErrClosed
+	}
+
+	as_json, err := json.MarshalIndent(c.credentials, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(c.filename, as_json, 0644)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// read JSON data from file = filename
+func (c *CredentialStorage) readCredentialJSON() error {
+	if _, err := os.Stat(c.filename); os.IsNotExist(err) {
+		err = os.MkdirAll(filepath.Dir(c.filename), 0777)
+		if err != nil {
+			return err
+		}
+		err = c.writeCredentialJSON()
+		if err != nil {
+			return err
+		}
+	}
+	data, err := os.ReadFile(c.filename)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &c.credentials)
+}
+
+func (c *CredentialStorage) CreateCredential(ctx context.Context, credential *model.Credential) (uuid.UUID, error) {
+	var span trace.Span
+	_, span = tracer.Start(ctx, "CreateCredential")
+	defer span.End()
+
+	span.AddEvent("Lock")
+	c.mu.Lock()
+	defer span.AddEvent("Unlock")
+	defer c.mu.Unlock()
+
+	if credential.UserID == uuid.Nil {
+		return uuid.Nil, errors.New("credential requires an userID")
+	}
+	if len(credential.CredentialID) == 0 {
+		return uuid.Nil, errors.New("credential requires a credentialID")
+	}
+	span.AddEvent("check for credentialID")
+	for _, existing := range c.credentials {
+		if bytes.Equal(existing.CredentialID, credential.CredentialID) {
+			return uuid.Nil, errors.New("credential is already registered")
+		}
+	}
+	if credential.ID == uuid.Nil {
+		credential.ID = uuid.New()
+	}
+	credential.CreatedAt = time.Now()
+	c.credentials[credential.ID] = credential
+
+	if err := c.writeCredentialJSON(); err != nil {
+		return uuid.Nil, err
+	}
+	return credential.ID, nil
+}
+
+func (c *CredentialStorage) GetCredentialByCredentialID(ctx context.Context, credentialID []byte) (*model.Credential, error) {
+	var span trace.Span
+	_, span = tracer.Start(ctx, "GetCredentialByCredentialID")
+	defer span.End()
+
+	span.AddEvent("Lock")
+	c.mu.Lock()
+	defer span.AddEvent("Unlock")
+	defer c.mu.Unlock()
+
+	if len(credentialID) == 0 {
+		return nil, errors.New("requires a credentialID")
+	}
+	span.AddEvent("range over credentials")
+	for _, credential := range c.credentials {
+		if bytes.Equal(credential.CredentialID, credentialID) {
+			return credential, nil
+		}
+	}
+	return nil, errors.New("credential doesn't exist")
+}
+
+func (c *CredentialStorage) GetCredentialsByUserID(ctx context.Context, userID uuid.UUID) ([]*model.Credential, error) {
+	var span trace.Span
+	_, span = tracer.Start(ctx, "GetCredentialsByUserID")
+	defer span.End()
+
+	span.AddEvent("Lock")
+	c.mu.Lock()
+	defer span.AddEvent("Unlock")
+	defer c.mu.Unlock()
+
+	if userID == uuid.Nil {
+		return nil, errors.New("requires an userID")
+	}
+	credentials := []*model.Credential{}
+	span.AddEvent("create slice by userID")
+	for _, credential := range c.credentials {
+		if credential.UserID == userID {
+			credentials = append(credentials, credential)
+		}
+	}
+	sort.Slice(credentials, func(i, j int) bool { return credentials[i].CreatedAt.Before(credentials[j].CreatedAt) })
+	return credentials, nil
+}
+
+func (c *CredentialStorage) UpdateCredential(ctx context.Context, credential *model.Credential) error {
+	var span trace.Span
+	_, span = tracer.Start(ctx, "UpdateCredential")
+	defer span.End()
+
+	span.AddEvent("Lock")
+	c.mu.Lock()
+	defer span.AddEvent("Unlock")
+	defer c.mu.Unlock()
+
+	if _, exists := c.credentials[credential.ID]; !exists {
+		return errors.New("credential doesn't exist")
+	}
+	c.credentials[credential.ID] = credential
+	if err := c.writeCredentialJSON(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// delete Credential from storage and write to JSON
+func (c *CredentialStorage) DeleteCredential(ctx context.Context, ID uuid.UUID) error {
+	var span trace.Span
+	_, span = tracer.Start(ctx, "DeleteCredential")
+	defer span.End()
+
+	span.AddEvent("Lock")
+	c.mu.Lock()
+	defer span.AddEvent("Unlock")
+	defer c.mu.Unlock()
+
+	if ID == uuid.Nil {
+		return errors.New("requires a credentialID")
+	}
+	if _, exists := c.credentials[ID]; !exists {
+		return errors.New("credential doesn't exist")
+	}
+
+	delete(c.credentials, ID)
+
+	span.AddEvent("delete credential from json")
+	if err := c.writeCredentialJSON(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Close waits for a running write and rejects further writes
+func (c *CredentialStorage) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	return nil
+}
@@ -20,10 +20,15 @@ import (
 
 var tracer = otel.GetTracerProvider().Tracer("github.com/led0nk/guestbook/intern/db/jsondb")
 
+// ErrClosed is returned by writes to a closed storage
+var ErrClosed = errors.New("storage is closed")
+
 type BookStorage struct {
 	filename string
 	entries  map[uuid.UUID]*model.GuestbookEntry
 	mu       sync.Mutex
+	// set by Close, writes fail afterwards
+	closed bool
 }
 
 // creates new Storage for entries
@@ -90,6 +95,9 @@ func (b *BookStorage) ListEntries(ctx context.Context) ([]*model.GuestbookEntry,
 
 // write JSON data into readable format in file = filename
 func (b *BookStorage) writeJSON() error {
+	if b.closed {
+		return ErrClosed
+	}
 
 	as_json, err := json.MarshalIndent(b.entries, "", "\t")
 	if err != nil {
@@ -122,6 +130,34 @@ func (b *BookStorage) readJSON() error {
 	return json.Unmarshal(data, &b.entries)
 }
 
+// UpdateEntry replaces the message of an existing entry, its author and
+// creation time are kept
+func (b *BookStorage) UpdateEntry(ctx context.Context, entry *model.GuestbookEntry) error {
+	var span trace.Span
+	_, span = tracer.Start(ctx, "UpdateEntry")
+	defer span.End()
+
+	span.AddEvent("Lock")
+	b.mu.Lock()
+	defer span.AddEvent("Unlock")
+	defer b.mu.Unlock()
+
+	existing, exists := b.entries[entry.ID]
+	if !exists {
+		return errors.New("entry doesn't exist")
+	}
+	updated := *existing
+	updated.Message = entry.Message
+	b.entries[entry.ID] = &updated
+
+	if err := b.writeJSON(); err != nil {
+		b.entries[entry.ID] = existing
+		return err
+	}
+	*entry = updated
+	return nil
+}
+
 // delete Entry from storage and write to JSON
 func (b *BookStorage) DeleteEntry(ctx context.Context, entryID uuid.UUID) error {
 	var span trace.Span
@@ -227,3 +263,60 @@ func (b *BookStorage) GetEntryBySnippet(ctx context.Context, snippet string) ([]
 	sort.Slice(entries, func(i, j int) bool { return entries[i].CreatedAt > entries[j].CreatedAt })
 	return entries, nil
 }
+
+// DeleteEntriesByUser deletes all entries of the user
+func (b *BookStorage) DeleteEntriesByUser(ctx context.Context, userID uuid.UUID) error {
+	var span trace.Span
+	_, span = tracer.Start(ctx, "DeleteEntriesByUser")
+	defer span.End()
+
+	span.AddEvent("Lock")
+	b.mu.Lock()
+	defer span.AddEvent("Unlock")
+	defer b.mu.Unlock()
+
+	if userID == uuid.Nil {
+		return errors.New("requires an userID")
+	}
+	span.AddEvent("delete entries of user")
+	for ID, entry := range b.entries {
+		if entry.UserID == userID {
+			delete(b.entries, ID)
+		}
+	}
+	return b.writeJSON()
+}
+
+// AnonymizeEntriesByUser detaches all entries from the user and replaces the
+// name of the author
+func (b *BookStorage) AnonymizeEntriesByUser(ctx context.Context, userID uuid.UUID, name string) error {
+	var span trace.Span
+	_, span = tracer.Start(ctx, "AnonymizeEntriesByUser")
+	defer span.End()
+
+	span.AddEvent("Lock")
+	b.mu.Lock()
+	defer span.AddEvent("Unlock")
+	defer b.mu.Unlock()
+
+	if userID == uuid.Nil {
+		return errors.New("requires an userID")
+	}
+	span.AddEvent("anonymize entries of user")
+	for _, entry := range b.entries {
+		if entry.UserID == userID {
+			entry.UserID = uuid.Nil
+			entry.Name = name
+		}
+	}
+	return b.writeJSON()
+}
+
+// Close waits for a running write and rejects further writes
+func (b *BookStorage) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	return nil
+}
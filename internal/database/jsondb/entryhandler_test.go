@@ -0,0 +1,42 @@
+package jsondb_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/led0nk/guestbook/internal/database/jsondb"
+	"github.com/led0nk/guestbook/internal/model"
+)
+
+func TestUpdateEntry(t *testing.T) {
+	ctx := context.Background()
+	storage, err := jsondb.CreateBookStorage(t.TempDir() + "/entries.json")
+	if err != nil {
+		t.Fatalf("Error creating entry storage: %v", err)
+	}
+	userID := uuid.New()
+	entryID, err := storage.CreateEntry(ctx, &model.GuestbookEntry{Name: "Jon Doe", Message: "hello", UserID: userID})
+	if err != nil {
+		t.Fatalf("Error creating entry: %v", err)
+	}
+
+	update := &model.GuestbookEntry{ID: entryID, Name: "Jane Roe", Message: "hello again", UserID: uuid.New()}
+	if err := storage.UpdateEntry(ctx, update); err != nil {
+		t.Fatalf("Error updating entry: %v", err)
+	}
+	if update.Name != "Jon Doe" || update.UserID != userID || update.CreatedAt == "" {
+		t.Errorf("Expected only the message to change, got %+v", update)
+	}
+	entries, err := storage.ListEntries(ctx)
+	if err != nil {
+		t.Fatalf("Error listing entries: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Message != "hello again" {
+		t.Errorf("Expected the updated message, got %+v", entries)
+	}
+
+	if err := storage.UpdateEntry(ctx, &model.GuestbookEntry{ID: uuid.New(), Message: "unknown"}); err == nil {
+		t.Errorf("Expected an error updating an unknown entry")
+	}
+}
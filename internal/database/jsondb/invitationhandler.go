@@ -0,0 +1,246 @@
+package jsondb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/led0nk/guestbook/internal/model"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type InvitationStorage struct {
+	filename    string
+	invitations map[uuid.UUID]*model.Invitation
+	mu          sync.Mutex
+	// set by Close, writes fail afterwards
+	closed bool
+}
+
+// creates new Storage for invitations
+func CreateInvitationStorage(filename string) (*InvitationStorage, error) {
+	storage := &InvitationStorage{
+		filename:    filename,
+		invitations: make(map[uuid.UUID]*model.Invitation),
+	}
+	if err := storage.readInvitationJSON(); err != nil {
+		return nil, err
+	}
+	return storage, nil
+}
+
+// write JSON data into readable format in file = filename
+func (i *InvitationStorage) writeInvitationJSON() error {
+	if i.closed {
+		return ErrClosed
+	}
+
+	as_json, err := json.MarshalIndent(i.invitations, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(i.filename, as_json, 0644)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// read JSON data from file = filename
+func (i *InvitationStorage) readInvitationJSON() error {
+	if _, err := os.Stat(i.filename); os.IsNotExist(err) {
+		err = os.MkdirAll(filepath.Dir(i.filename), 0777)
+		if err != nil {
+			return err
+		}
+		err = i.writeInvitationJSON()
+		if err != nil {
+			return err
+		}
+	}
+	data, err := os.ReadFile(i.filename)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &i.invitations)
+}
+
+func (i *InvitationStorage) CreateInvitation(ctx context.Context, invitation *model.Invitation) (uuid.UUID, error) {
+	var span trace.Span
+	_, span = tracer.Start(ctx, "CreateInvitation")
+	defer span.End()
+
+	span.AddEvent("Lock")
+	i.mu.Lock()
+	defer span.AddEvent("Unlock")
+	defer i.mu.Unlock()
+
+	if invitation.Code == "" {
+		return uuid.Nil, errors.New("invitation requires a code")
+	}
+	if invitation.MaxUses < 1 {
+		return uuid.Nil, errors.New("invitation requires at least one use")
+	}
+	span.AddEvent("check for code")
+	for _, existing := range i.invitations {
+		if existing.Code == invitation.Code {
+			return uuid.Nil, errors.New("invitation code already exists")
+		}
+	}
+	if invitation.ID == uuid.Nil {
+		invitation.ID = uuid.New()
+	}
+	invitation.CreatedAt = time.Now()
+	i.invitations[invitation.ID] = invitation
+
+	if err := i.writeInvitationJSON(); err != nil {
+		return uuid.Nil, err
+	}
+	return invitation.ID, nil
+}
+
+func (i *InvitationStorage) GetInvitationByCode(ctx context.Context, code string) (*model.Invitation, error) {
+	var span trace.Span
+	_, span = tracer.Start(ctx, "GetInvitationByCode")
+	defer span.End()
+
+	span.AddEvent("Lock")
+	i.mu.Lock()
+	defer span.AddEvent("Unlock")
+	defer i.mu.Unlock()
+
+	return i.invitationByCode(code)
+}
+
+// invitationByCode expects the lock to be held
+func (i *InvitationStorage) invitationByCode(code string) (*model.Invitation, error) {
+	if code == "" {
+		return nil, errors.New("requires an invitation code")
+	}
+	for _, invitation := range i.invitations {
+		if invitation.Code == code {
+			return invitation, nil
+		}
+	}
+	return nil, errors.New("invitation doesn't exist")
+}
+
+func (i *InvitationStorage) ListInvitations(ctx context.Context) ([]*model.Invitation, error) {
+	var span trace.Span
+	_, span = tracer.Start(ctx, "ListInvitations")
+	defer span.End()
+
+	span.AddEvent("Lock")
+	i.mu.Lock()
+	defer span.AddEvent("Unlock")
+	defer i.mu.Unlock()
+
+	invitations := []*model.Invitation{}
+	for _, invitation := range i.invitations {
+		invitations = append(invitations, invitation)
+	}
+	sort.Slice(invitations, func(a, b int) bool { return invitations[a].CreatedAt.After(invitations[b].CreatedAt) })
+	return invitations, nil
+}
+
+// RedeemInvitation uses the invitation with code for a signup of email
+func (i *InvitationStorage) RedeemInvitation(ctx context.Context, code string, email string) error {
+	var span trace.Span
+	_, span = tracer.Start(ctx, "RedeemInvitation")
+	defer span.End()
+
+	span.AddEvent("Lock")
+	i.mu.Lock()
+	defer span.AddEvent("Unlock")
+	defer i.mu.Unlock()
+
+	invitation, err := i.invitationByCode(code)
+	if err != nil {
+		return err
+	}
+	if !invitation.Valid() {
+		return errors.New("invitation is expired or used up")
+	}
+	if invitation.Email != "" && !strings.EqualFold(invitation.Email, email) {
+		return errors.New("invitation belongs to another email")
+	}
+	invitation.Uses++
+
+	if err := i.writeInvitationJSON(); err != nil {
+		invitation.Uses--
+		return err
+	}
+	return nil
+}
+
+// RefundInvitation gives back a use of the invitation with code, e.g. if the
+// signup failed after the invitation was redeemed
+func (i *InvitationStorage) RefundInvitation(ctx context.Context, code string) error {
+	var span trace.Span
+	_, span = tracer.Start(ctx, "RefundInvitation")
+	defer span.End()
+
+	span.AddEvent("Lock")
+	i.mu.Lock()
+	defer span.AddEvent("Unlock")
+	defer i.mu.Unlock()
+
+	invitation, err := i.invitationByCode(code)
+	if err != nil {
+		return err
+	}
+	if invitation.Uses == 0 {
+		return errors.New("invitation wasn't used")
+	}
+	invitation.Uses--
+
+	if err := i.writeInvitationJSON(); err != nil {
+		invitation.Uses++
+		return err
+	}
+	return nil
+}
+
+// delete Invitation from storage and write to JSON
+func (i *InvitationStorage) DeleteInvitation(ctx context.Context, ID uuid.UUID) error {
+	var span trace.Span
+	_, span = tracer.Start(ctx, "DeleteInvitation")
+	defer span.End()
+
+	span.AddEvent("Lock")
+	i.mu.Lock()
+	defer span.AddEvent("Unlock")
+	defer i.mu.Unlock()
+
+	if ID == uuid.Nil {
+		return errors.New("requires an invitationID")
+	}
+	if _, exists := i.invitations[ID]; !exists {
+		return errors.New("invitation doesn't exist")
+	}
+
+	delete(i.invitations, ID)
+
+	span.AddEvent("delete invitation from json")
+	if err := i.writeInvitationJSON(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Close waits for a running write and rejects further writes
+func (i *InvitationStorage) Close() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.closed = true
+	return nil
+}
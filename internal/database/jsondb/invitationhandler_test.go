@@ -0,0 +1,79 @@
+package jsondb_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/led0nk/guestbook/internal/database/jsondb"
+	"github.com/led0nk/guestbook/internal/model"
+)
+
+func TestRedeemInvitation(t *testing.T) {
+	ctx := context.Background()
+	storage, err := jsondb.CreateInvitationStorage(t.TempDir() + "/invitations.json")
+	if err != nil {
+		t.Fatalf("Error creating invitation storage: %v", err)
+	}
+	invitations := []*model.Invitation{
+		{Code: "twice", MaxUses: 2, ExpiresAt: time.Now().Add(time.Hour)},
+		{Code: "expired", MaxUses: 1, ExpiresAt: time.Now().Add(-time.Hour)},
+		{Code: "personal", Email: "jon@doe.com", MaxUses: 1, ExpiresAt: time.Now().Add(time.Hour)},
+	}
+	for _, invitation := range invitations {
+		if _, err := storage.CreateInvitation(ctx, invitation); err != nil {
+			t.Fatalf("Error creating invitation: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		code    string
+		email   string
+		wantErr bool
+	}{
+		{name: "First use", code: "twice", email: "a@b.com"},
+		{name: "Second use", code: "twice", email: "c@d.com"},
+		{name: "Used up", code: "twice", email: "e@f.com", wantErr: true},
+		{name: "Expired", code: "expired", email: "a@b.com", wantErr: true},
+		{name: "Unknown code", code: "unknown", email: "a@b.com", wantErr: true},
+		{name: "Other email", code: "personal", email: "jane@roe.com", wantErr: true},
+		{name: "Matching email", code: "personal", email: "Jon@Doe.com"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := storage.RedeemInvitation(ctx, test.code, test.email)
+			if (err != nil) != test.wantErr {
+				t.Errorf("Expected error: %v, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestRefundInvitation(t *testing.T) {
+	ctx := context.Background()
+	storage, err := jsondb.CreateInvitationStorage(t.TempDir() + "/invitations.json")
+	if err != nil {
+		t.Fatalf("Error creating invitation storage: %v", err)
+	}
+	invitation := &model.Invitation{Code: "once", MaxUses: 1, ExpiresAt: time.Now().Add(time.Hour)}
+	if _, err := storage.CreateInvitation(ctx, invitation); err != nil {
+		t.Fatalf("Error creating invitation: %v", err)
+	}
+
+	if err := storage.RefundInvitation(ctx, "once"); err == nil {
+		t.Errorf("Expected refund of an unused invitation to fail")
+	}
+	if err := storage.RedeemInvitation(ctx, "once", "a@b.com"); err != nil {
+		t.Fatalf("Error redeeming invitation: %v", err)
+	}
+	if err := storage.RefundInvitation(ctx, "once"); err != nil {
+		t.Fatalf("Error refunding invitation: %v", err)
+	}
+	if err := storage.RedeemInvitation(ctx, "once", "c@d.com"); err != nil {
+		t.Errorf("Expected refunded invitation to be usable again, got %v", err)
+	}
+	if err := storage.RefundInvitation(ctx, "unknown"); err == nil {
+		t.Errorf("Expected refund of an unknown invitation to fail")
+	}
+}
@@ -0,0 +1,137 @@
+package jsondb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"html"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+	"github.com/led0nk/guestbook/internal/model"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Migration changes the files of a storage directory once
+type Migration struct {
+	Name string
+	Run  func(dir string) error
+}
+
+// Migrations in the order they are applied, names must not change
+var Migrations = []Migration{
+	// names, emails and messages were escaped before they were stored, the
+	// templates escape them now
+	{Name: "unescape-html", Run: unescapeHTML},
+}
+
+// file in the storage directory which lists the applied migrations
+const migrationsFile = "migrations.json"
+
+// Migrate applies the Migrations which were not applied to dir yet and
+// returns their names, it has to run before the storages are created
+func Migrate(ctx context.Context, dir string) ([]string, error) {
+	var span trace.Span
+	_, span = tracer.Start(ctx, "Migrate")
+	defer span.End()
+
+	applied := map[string]bool{}
+	if err := readFile(filepath.Join(dir, migrationsFile), &applied); err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, migration := range Migrations {
+		if applied[migration.Name] {
+			continue
+		}
+		if err := migration.Run(dir); err != nil {
+			return names, errors.Join(errors.New("migration "+migration.Name+" failed"), err)
+		}
+		applied[migration.Name] = true
+		if err := writeFile(filepath.Join(dir, migrationsFile), applied); err != nil {
+			return names, err
+		}
+		names = append(names, migration.Name)
+	}
+	return names, nil
+}
+
+func unescapeHTML(dir string) error {
+	entries := map[uuid.UUID]*model.GuestbookEntry{}
+	if err := readFile(filepath.Join(dir, "entries.json"), &entries); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		entry.Name = html.UnescapeString(entry.Name)
+		entry.Message = html.UnescapeString(entry.Message)
+	}
+
+	users := map[uuid.UUID]*model.User{}
+	if err := readFile(filepath.Join(dir, "user.json"), &users); err != nil {
+		return err
+	}
+	for _, user := range users {
+		user.Name = html.UnescapeString(user.Name)
+		user.Email = html.UnescapeString(user.Email)
+		user.PendingEmail = html.UnescapeString(user.PendingEmail)
+		for _, entry := range user.Entry {
+			entry.Name = html.UnescapeString(entry.Name)
+			entry.Message = html.UnescapeString(entry.Message)
+		}
+	}
+
+	//NOTE: the audit log is append-only and keeps the escaped values
+	files := map[string]any{}
+	if len(entries) > 0 {
+		files[filepath.Join(dir, "entries.json")] = entries
+	}
+	if len(users) > 0 {
+		files[filepath.Join(dir, "user.json")] = users
+	}
+	return replaceFiles(files)
+}
+
+// replaceFiles writes the values to temporary files and renames them to their
+// filenames once all are written, so a failed migration can run again
+func replaceFiles(files map[string]any) error {
+	written := []string{}
+	for filename, v := range files {
+		if err := writeFile(filename+".tmp", v); err != nil {
+			for _, filename := range written {
+				os.Remove(filename + ".tmp")
+			}
+			return err
+		}
+		written = append(written, filename)
+	}
+	for _, filename := range written {
+		if err := os.Rename(filename+".tmp", filename); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readFile decodes the JSON file into v, a missing file leaves v unchanged
+func readFile(filename string, v any) error {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func writeFile(filename string, v any) error {
+	as_json, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0777); err != nil {
+		return err
+	}
+	return os.WriteFile(filename, as_json, 0644)
+}
@@ -0,0 +1,149 @@
+package jsondb_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/led0nk/guestbook/internal/database/jsondb"
+	"github.com/led0nk/guestbook/internal/model"
+)
+
+func TestMigrateUnescapeHTML(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	bStore, err := jsondb.CreateBookStorage(dir + "/entries.json")
+	if err != nil {
+		t.Fatalf("Error creating entry storage: %v", err)
+	}
+	uStore, err := jsondb.CreateUserStorage(dir + "/user.json")
+	if err != nil {
+		t.Fatalf("Error creating user storage: %v", err)
+	}
+	userID, err := uStore.CreateUser(ctx, &model.User{Name: "Tom &amp; Jerry", Email: "o&#39;brien@example.com"})
+	if err != nil {
+		t.Fatalf("Error creating user: %v", err)
+	}
+	_, err = bStore.CreateEntry(ctx, &model.GuestbookEntry{Name: "Tom &amp; Jerry", Message: "a &lt;b&gt; &amp;amp;", UserID: userID})
+	if err != nil {
+		t.Fatalf("Error creating entry: %v", err)
+	}
+
+	for i, expected := range [][]string{{"unescape-html"}, {}} {
+		applied, err := jsondb.Migrate(ctx, dir)
+		if err != nil {
+			t.Fatalf("Error migrating: %v", err)
+		}
+		if len(applied) != len(expected) {
+			t.Fatalf("Expected migrations %v in run %d, got %v", expected, i, applied)
+		}
+	}
+
+	bStore, err = jsondb.CreateBookStorage(dir + "/entries.json")
+	if err != nil {
+		t.Fatalf("Error reading entry storage: %v", err)
+	}
+	entries, err := bStore.GetEntryByID(ctx, userID)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("Error getting entry: %v", err)
+	}
+	if entries[0].Name != "Tom & Jerry" || entries[0].Message != "a <b> &amp;" {
+		t.Errorf("Expected entry to be unescaped once, got %q %q", entries[0].Name, entries[0].Message)
+	}
+	uStore, err = jsondb.CreateUserStorage(dir + "/user.json")
+	if err != nil {
+		t.Fatalf("Error reading user storage: %v", err)
+	}
+	user, err := uStore.GetUserByID(ctx, userID)
+	if err != nil {
+		t.Fatalf("Error getting user: %v", err)
+	}
+	if user.Name != "Tom & Jerry" || user.Email != "o'brien@example.com" {
+		t.Errorf("Expected user to be unescaped, got %q %q", user.Name, user.Email)
+	}
+	if _, err := uStore.GetUserByEmail(ctx, "o'brien@example.com"); err != nil {
+		t.Errorf("Expected lookup by the unescaped email, got %v", err)
+	}
+}
+
+func TestMigrateEmptyDirectory(t *testing.T) {
+	dir := t.TempDir() + "/new"
+	applied, err := jsondb.Migrate(context.Background(), dir)
+	if err != nil {
+		t.Fatalf("Error migrating: %v", err)
+	}
+	if len(applied) != len(jsondb.Migrations) {
+		t.Errorf("Expected all migrations to be recorded, got %v", applied)
+	}
+	if _, err := jsondb.CreateUserStorage(dir + "/user.json"); err != nil {
+		t.Errorf("Error creating storage after migration: %v", err)
+	}
+}
+
+func TestMigrateFailedWrite(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	bStore, err := jsondb.CreateBookStorage(dir + "/entries.json")
+	if err != nil {
+		t.Fatalf("Error creating entry storage: %v", err)
+	}
+	uStore, err := jsondb.CreateUserStorage(dir + "/user.json")
+	if err != nil {
+		t.Fatalf("Error creating user storage: %v", err)
+	}
+	userID, err := uStore.CreateUser(ctx, &model.User{Name: "Tom &amp; Jerry", Email: "tom@example.com"})
+	if err != nil {
+		t.Fatalf("Error creating user: %v", err)
+	}
+	_, err = bStore.CreateEntry(ctx, &model.GuestbookEntry{Name: "Tom &amp; Jerry", Message: "a &amp;amp;", UserID: userID})
+	if err != nil {
+		t.Fatalf("Error creating entry: %v", err)
+	}
+	before, err := os.ReadFile(dir + "/entries.json")
+	if err != nil {
+		t.Fatalf("Error reading entries: %v", err)
+	}
+
+	// the users can't be written while a directory takes their place
+	blocked := dir + "/user.json.tmp"
+	if err := os.Mkdir(blocked, 0o755); err != nil {
+		t.Fatalf("Error creating directory: %v", err)
+	}
+	if _, err := jsondb.Migrate(ctx, dir); err == nil {
+		t.Fatal("Expected migration to fail")
+	}
+	after, err := os.ReadFile(dir + "/entries.json")
+	if err != nil {
+		t.Fatalf("Error reading entries: %v", err)
+	}
+	if string(after) != string(before) {
+		t.Errorf("Expected entries to be unchanged by the failed migration, got %s", after)
+	}
+
+	if err := os.Remove(blocked); err != nil {
+		t.Fatalf("Error removing directory: %v", err)
+	}
+	applied, err := jsondb.Migrate(ctx, dir)
+	if err != nil {
+		t.Fatalf("Error migrating: %v", err)
+	}
+	if len(applied) != 1 {
+		t.Fatalf("Expected the migration to run again, got %v", applied)
+	}
+	bStore, err = jsondb.CreateBookStorage(dir + "/entries.json")
+	if err != nil {
+		t.Fatalf("Error reading entry storage: %v", err)
+	}
+	entries, err := bStore.GetEntryByID(ctx, userID)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("Error getting entry: %v", err)
+	}
+	if entries[0].Name != "Tom & Jerry" || entries[0].Message != "a &amp;" {
+		t.Errorf("Expected entry to be unescaped once, got %q %q", entries[0].Name, entries[0].Message)
+	}
+	if _, err := os.Stat(dir + "/entries.json.tmp"); !os.IsNotExist(err) {
+		t.Errorf("Expected no temporary file to be left, got %v", err)
+	}
+}
@@ -2,33 +2,63 @@ package jsondb
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"net/mail"
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/led0nk/guestbook/cmd/utils"
+	db "github.com/led0nk/guestbook/internal/database"
+	"github.com/led0nk/guestbook/internal/i18n"
 	"github.com/led0nk/guestbook/internal/model"
+	"github.com/led0nk/guestbook/internal/secrets"
 	"go.opentelemetry.io/otel/trace"
 )
 
+// MaxVerificationAttempts is the number of wrong codes after which a
+// verification code is invalidated
+const MaxVerificationAttempts = 5
+
+// EmailChangeExpiration is the time to confirm a new email address
+const EmailChangeExpiration = 24 * time.Hour
+
 type UserStorage struct {
 	filename string
 	user     map[uuid.UUID]*model.User
-	mu       sync.Mutex
+	// secondary index of the normalized emails, maintained under mu
+	byEmail map[string]uuid.UUID
+	emailOf map[uuid.UUID]string
+	mu      sync.Mutex
+	// set by Close, writes fail afterwards
+	closed bool
+	// accounts of the file which share a normalized email with another one
+	duplicates []DuplicateEmail
+}
+
+// DuplicateEmail is an account whose email normalizes to the one of another
+// account, it was stored before emails were normalized and can't be found by
+// its email
+type DuplicateEmail struct {
+	Email string
+	// the account which is found by the email
+	Kept uuid.UUID
+	User uuid.UUID
 }
 
 func CreateUserStorage(filename string) (*UserStorage, error) {
 	storage := &UserStorage{
 		filename: filename,
 		user:     make(map[uuid.UUID]*model.User),
+		byEmail:  make(map[string]uuid.UUID),
+		emailOf:  make(map[uuid.UUID]string),
 	}
 	if err := storage.readUserJSON(); err != nil {
 		return nil, err
@@ -38,6 +68,9 @@ func CreateUserStorage(filename string) (*UserStorage, error) {
 
 // write JSON data into readable format in file = filename
 func (u *UserStorage) writeUserJSON() error {
+	if u.closed {
+		return ErrClosed
+	}
 
 	as_json, err := json.MarshalIndent(u.user, "", "\t")
 	if err != nil {
@@ -67,7 +100,58 @@ func (u *UserStorage) readUserJSON() error {
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(data, &u.user)
+	if err := json.Unmarshal(data, &u.user); err != nil {
+		return err
+	}
+	// sorted to keep the same account of a duplicate email on every start
+	users := make([]*model.User, 0, len(u.user))
+	for _, user := range u.user {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].IsVerified != users[j].IsVerified {
+			return users[i].IsVerified
+		}
+		return users[i].ID.String() < users[j].ID.String()
+	})
+	for _, user := range users {
+		email := db.NormalizeEmail(user.Email)
+		if owner, exists := u.byEmail[email]; exists {
+			u.duplicates = append(u.duplicates, DuplicateEmail{Email: email, Kept: owner, User: user.ID})
+			continue
+		}
+		user.Email = email
+		u.index(user)
+	}
+	return nil
+}
+
+// Duplicates returns the accounts of the file which share a normalized email
+// with another one, verified accounts are kept before unverified ones
+func (u *UserStorage) Duplicates() []DuplicateEmail {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return slices.Clone(u.duplicates)
+}
+
+// emailTaken reports whether another user than ID uses the normalized email,
+// it expects the lock to be held
+func (u *UserStorage) emailTaken(email string, ID uuid.UUID) bool {
+	owner, exists := u.byEmail[email]
+	return exists && owner != ID
+}
+
+// index updates the email index for user, it expects the lock to be held
+func (u *UserStorage) index(user *model.User) {
+	if old, exists := u.emailOf[user.ID]; exists && old != user.Email {
+		delete(u.byEmail, old)
+		delete(u.emailOf, user.ID)
+	}
+	if user.Email != "" {
+		u.byEmail[user.Email] = user.ID
+		u.emailOf[user.ID] = user.Email
+	}
 }
 
 func (u *UserStorage) CreateUser(ctx context.Context, user *model.User) (uuid.UUID, error) {
@@ -83,14 +167,14 @@ func (u *UserStorage) CreateUser(ctx context.Context, user *model.User) (uuid.UU
 		user.ID = uuid.New()
 	}
 
+	user.Email = db.NormalizeEmail(user.Email)
 	span.AddEvent("Check for Email")
-	for _, userexist := range u.user {
-		if userexist.Email == user.Email {
-			return uuid.Nil, errors.New("email cannot be used more than once")
-		}
+	if u.emailTaken(user.Email, user.ID) {
+		return uuid.Nil, db.ErrEmailTaken
 	}
 
 	u.user[user.ID] = user
+	u.index(user)
 	if err := u.writeUserJSON(); err != nil {
 		return uuid.Nil, err
 	}
@@ -130,7 +214,11 @@ func (u *UserStorage) CreateVerificationCode(ctx context.Context, userID uuid.UU
 	if userID == uuid.Nil {
 		return errors.New("User ID is empty")
 	}
-	u.user[userID].VerificationCode = utils.RandomString(6)
+	code, err := secrets.VerificationCode.Generate()
+	if err != nil {
+		return err
+	}
+	u.user[userID].VerificationCode = code
 	u.user[userID].ExpirationTime = time.Now().Add(time.Minute * 5)
 	return nil
 }
@@ -145,13 +233,100 @@ func (u *UserStorage) UpdateUser(ctx context.Context, user *model.User) error {
 	defer span.AddEvent("Unlock")
 	defer u.mu.Unlock()
 
+	user.Email = db.NormalizeEmail(user.Email)
+	span.AddEvent("Check for Email")
+	if u.emailTaken(user.Email, user.ID) {
+		return db.ErrEmailTaken
+	}
 	u.user[user.ID] = user
+	u.index(user)
 	if err := u.writeUserJSON(); err != nil {
 		return err
 	}
 	return nil
 }
 
+// RequestEmailChange stores email as pending address of the user and returns
+// the token which confirms the change
+func (u *UserStorage) RequestEmailChange(ctx context.Context, ID uuid.UUID, email string) (string, error) {
+	var span trace.Span
+	_, span = tracer.Start(ctx, "RequestEmailChange")
+	defer span.End()
+
+	span.AddEvent("Lock")
+	u.mu.Lock()
+	defer span.AddEvent("Unlock")
+	defer u.mu.Unlock()
+
+	user, exists := u.user[ID]
+	if !exists {
+		return "", errors.New("user doesn't exist")
+	}
+	email = db.NormalizeEmail(email)
+	if email == "" || email == user.Email {
+		return "", errors.New("requires a new email")
+	}
+	span.AddEvent("Check for Email")
+	if u.emailTaken(email, ID) {
+		return "", db.ErrEmailTaken
+	}
+	token, err := secrets.ResetToken.Generate()
+	if err != nil {
+		return "", err
+	}
+	user.PendingEmail = email
+	user.EmailChangeToken = token
+	user.EmailChangeExpiration = time.Now().Add(EmailChangeExpiration)
+	if err := u.writeUserJSON(); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ConfirmEmailChange replaces the email of the user by the pending address if
+// the token matches the one of RequestEmailChange
+func (u *UserStorage) ConfirmEmailChange(ctx context.Context, ID uuid.UUID, token string) (*model.User, error) {
+	var span trace.Span
+	_, span = tracer.Start(ctx, "ConfirmEmailChange")
+	defer span.End()
+
+	span.AddEvent("Lock")
+	u.mu.Lock()
+	defer span.AddEvent("Unlock")
+	defer u.mu.Unlock()
+
+	user, exists := u.user[ID]
+	if !exists {
+		return nil, errors.New("user doesn't exist")
+	}
+	if user.EmailChangeToken == "" || subtle.ConstantTimeCompare([]byte(user.EmailChangeToken), []byte(token)) != 1 {
+		return nil, errors.New("invalid email change token")
+	}
+	email := user.PendingEmail
+	expired := !time.Now().Before(user.EmailChangeExpiration)
+	user.PendingEmail = ""
+	user.EmailChangeToken = ""
+	user.EmailChangeExpiration = time.Time{}
+
+	var err error
+	switch {
+	case expired:
+		err = errors.New("email change expired")
+	case u.emailTaken(email, ID):
+		err = db.ErrEmailTaken
+	default:
+		user.Email = email
+		u.index(user)
+	}
+	if werr := u.writeUserJSON(); werr != nil {
+		return nil, werr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
 func (u *UserStorage) GetUserByEmail(ctx context.Context, email string) (*model.User, error) {
 	var span trace.Span
 	_, span = tracer.Start(ctx, "GetUserByEmail")
@@ -165,14 +340,11 @@ func (u *UserStorage) GetUserByEmail(ctx context.Context, email string) (*model.
 		return nil, errors.New("requires an email input")
 	}
 
-	users := &model.User{}
-	span.AddEvent("range over user")
-	for _, user := range u.user {
-		if user.Email == email {
-			users = user
-		}
+	span.AddEvent("lookup email")
+	if ID, exists := u.byEmail[db.NormalizeEmail(email)]; exists {
+		return u.user[ID], nil
 	}
-	return users, nil
+	return &model.User{}, nil
 }
 
 func (u *UserStorage) GetUserByID(ctx context.Context, ID uuid.UUID) (*model.User, error) {
@@ -197,55 +369,68 @@ func (u *UserStorage) GetUserByID(ctx context.Context, ID uuid.UUID) (*model.Use
 	return users, nil
 }
 
-func ValidateUserInput(v url.Values) error {
+// ValidateUserInput checks the signup form, maxLength is the maximum password
+// length supported by the password hash, the minimum is left to the policy
+func ValidateUserInput(v url.Values, maxLength int) error {
 
 	if v.Get("firstname") == "" || v.Get("lastname") == "" {
-		return errors.New("fields cannot be empty")
+		return i18n.M("error.fields.empty")
 	}
 	if strings.ContainsAny(v.Get("firstname"), "0123456789") || strings.ContainsAny(v.Get("lastname"), "01234567890") {
-		return errors.New("no numbers allowed")
+		return i18n.M("error.fields.numbers")
 	}
 	if v["password"][0] != v["password"][1] {
-		return errors.New("password doesn't match, please try again")
-	}
-	if len(v["password"][0]) > 72 || len(v["password"][1]) > 72 {
-		return errors.New("password is too long, only 72 characters allowed")
+		return i18n.M("password.error.mismatch")
 	}
-	if len(v["password"][0]) < 8 || len(v["password"][1]) < 8 {
-		return errors.New("password is too short, should be at least 8 characters long")
+	if len(v["password"][0]) > maxLength || len(v["password"][1]) > maxLength {
+		return i18n.M("password.error.max", maxLength)
 	}
 
 	_, emailValid := mail.ParseAddress(v.Get("email"))
 	if emailValid != nil {
-		return errors.New("email is not in correct format, please try again")
+		return i18n.M("error.email.format")
 	}
 	return nil
 }
 
+// CodeValidation verifies the user with the code, wrong codes are counted
+// under the lock, so concurrent attempts can't exceed MaxVerificationAttempts
 func (u *UserStorage) CodeValidation(ctx context.Context, ID uuid.UUID, code string) (bool, error) {
 	var span trace.Span
-	ctx, span = tracer.Start(ctx, "CodeValidation")
+	_, span = tracer.Start(ctx, "CodeValidation")
 	defer span.End()
 
-	span.AddEvent("get user")
-	user, err := u.GetUserByID(ctx, ID)
-	if err != nil {
-		return false, err
+	span.AddEvent("Lock")
+	u.mu.Lock()
+	defer span.AddEvent("Unlock")
+	defer u.mu.Unlock()
+
+	user, exists := u.user[ID]
+	if !exists {
+		return false, errors.New("user doesn't exist")
 	}
 	if !time.Now().Before(user.ExpirationTime) {
-		err := u.DeleteUser(ctx, ID)
-		if err != nil {
+		u.remove(ID)
+		if err := u.writeUserJSON(); err != nil {
 			return false, err
 		}
 		return false, errors.New("Verification Code expired")
 	}
+	if user.VerificationAttempts >= MaxVerificationAttempts {
+		return false, errors.New("Too many attempts, request a new Verification Code")
+	}
 	if user.VerificationCode != code {
+		user.VerificationAttempts++
+		span.AddEvent("update user")
+		if err := u.writeUserJSON(); err != nil {
+			return false, err
+		}
 		return false, errors.New("Wrong Verification Code")
 	}
 	user.IsVerified = true
+	user.VerificationAttempts = 0
 	span.AddEvent("update user")
-	err = u.UpdateUser(ctx, user)
-	if err != nil {
+	if err := u.writeUserJSON(); err != nil {
 		return false, err
 	}
 	return true, nil
@@ -269,7 +454,7 @@ func (u *UserStorage) DeleteUser(ctx context.Context, ID uuid.UUID) error {
 		return err
 	}
 
-	delete(u.user, ID)
+	u.remove(ID)
 
 	span.AddEvent("delete user from json")
 	if err := u.writeUserJSON(); err != nil {
@@ -278,3 +463,20 @@ func (u *UserStorage) DeleteUser(ctx context.Context, ID uuid.UUID) error {
 
 	return nil
 }
+
+// remove deletes the user and its email from the index, the caller holds the
+// lock
+func (u *UserStorage) remove(ID uuid.UUID) {
+	delete(u.user, ID)
+	delete(u.byEmail, u.emailOf[ID])
+	delete(u.emailOf, ID)
+}
+
+// Close waits for a running write and rejects further writes
+func (u *UserStorage) Close() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.closed = true
+	return nil
+}
@@ -6,18 +6,22 @@ import (
 	"errors"
 	"net/url"
 	"os"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
+	db "github.com/led0nk/guestbook/internal/database"
 	"github.com/led0nk/guestbook/internal/database/jsondb"
 	"github.com/led0nk/guestbook/internal/model"
 )
 
 func TestValidateUserInput(t *testing.T) {
 	tests := []struct {
-		name     string
-		input    url.Values
-		expected error
+		name      string
+		input     url.Values
+		maxLength int
+		expected  error
 	}{
 		{
 			name: "Valid input",
@@ -55,11 +59,19 @@ func TestValidateUserInput(t *testing.T) {
 			expected: errors.New("password is too long, only 72 characters allowed"),
 		},
 		{
-			name: "Short password",
+			name: "Long password with argon2",
+			input: url.Values{"firstname": {"John"}, "lastname": {"Doe"},
+				"password": {"averylongpasswordthatexceedsseventytwocharactersandistoolongforthistestcase", "averylongpasswordthatexceedsseventytwocharactersandistoolongforthistestcase"},
+				"email":    {"john@doe.com"}},
+			maxLength: 1024,
+			expected:  nil,
+		},
+		{
+			name: "Short password is left to the policy",
 			input: url.Values{"firstname": {"John"}, "lastname": {"Doe"},
 				"password": {"short", "short"},
 				"email":    {"john@doe.com"}},
-			expected: errors.New("password is too short, should be at least 8 characters long"),
+			expected: nil,
 		},
 		{
 			name: "Email format",
@@ -72,7 +84,11 @@ func TestValidateUserInput(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			err := jsondb.ValidateUserInput(test.input)
+			maxLength := test.maxLength
+			if maxLength == 0 {
+				maxLength = 72
+			}
+			err := jsondb.ValidateUserInput(test.input, maxLength)
 			if (err != nil && test.expected == nil) ||
 				(err == nil && test.expected != nil) ||
 				(err != nil && test.expected != nil &&
@@ -149,3 +165,245 @@ func TestCreateUser(t *testing.T) {
 		t.Errorf("Expected user with ID %s in storage, not found", id)
 	}
 }
+
+func TestCodeValidationAttempts(t *testing.T) {
+	ctx := context.Background()
+	storage, err := jsondb.CreateUserStorage(t.TempDir() + "/user.json")
+	if err != nil {
+		t.Fatalf("Error creating user storage: %v", err)
+	}
+	user := &model.User{
+		Name:             "Test User",
+		VerificationCode: "abcdef",
+		ExpirationTime:   time.Now().Add(time.Minute),
+	}
+	id, err := storage.CreateUser(ctx, user)
+	if err != nil {
+		t.Fatalf("Error creating user: %v", err)
+	}
+
+	for i := 0; i < jsondb.MaxVerificationAttempts; i++ {
+		ok, err := storage.CodeValidation(ctx, id, "wrong")
+		if ok || err == nil {
+			t.Fatalf("Expected wrong code to be rejected")
+		}
+	}
+	ok, err := storage.CodeValidation(ctx, id, "abcdef")
+	if ok || err == nil {
+		t.Errorf("Expected correct code to be rejected after %d failed attempts", jsondb.MaxVerificationAttempts)
+	}
+	user, err = storage.GetUserByID(ctx, id)
+	if err != nil {
+		t.Fatalf("Error getting user: %v", err)
+	}
+	if user.IsVerified {
+		t.Errorf("Expected user to stay unverified")
+	}
+}
+
+func TestCodeValidationConcurrent(t *testing.T) {
+	ctx := context.Background()
+	storage, err := jsondb.CreateUserStorage(t.TempDir() + "/user.json")
+	if err != nil {
+		t.Fatalf("Error creating user storage: %v", err)
+	}
+	id, err := storage.CreateUser(ctx, &model.User{
+		Name:             "Test User",
+		VerificationCode: "abcdef",
+		ExpirationTime:   time.Now().Add(time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("Error creating user: %v", err)
+	}
+
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		wrong int
+	)
+	for i := 0; i < 4*jsondb.MaxVerificationAttempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := storage.CodeValidation(ctx, id, "wrong")
+			if err != nil && err.Error() == "Wrong Verification Code" {
+				mu.Lock()
+				wrong++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if wrong != jsondb.MaxVerificationAttempts {
+		t.Errorf("Expected %d attempts to be checked, got %d", jsondb.MaxVerificationAttempts, wrong)
+	}
+}
+
+func TestEmailChange(t *testing.T) {
+	ctx := context.Background()
+	storage, err := jsondb.CreateUserStorage(t.TempDir() + "/user.json")
+	if err != nil {
+		t.Fatalf("Error creating user storage: %v", err)
+	}
+	jon := &model.User{Name: "Jon Doe", Email: "jon@doe.com"}
+	jane := &model.User{Name: "Jane Roe", Email: "jane@roe.com"}
+	for _, user := range []*model.User{jon, jane} {
+		if _, err := storage.CreateUser(ctx, user); err != nil {
+			t.Fatalf("Error creating user: %v", err)
+		}
+	}
+
+	if _, err := storage.RequestEmailChange(ctx, jon.ID, "jane@roe.com"); err == nil {
+		t.Errorf("Expected taken email to be rejected")
+	}
+	updated := *jon
+	updated.Email = "jane@roe.com"
+	if err := storage.UpdateUser(ctx, &updated); err == nil {
+		t.Errorf("Expected update to a taken email to be rejected")
+	}
+
+	token, err := storage.RequestEmailChange(ctx, jon.ID, "jon@new.com")
+	if err != nil {
+		t.Fatalf("Error requesting email change: %v", err)
+	}
+	if _, err := storage.ConfirmEmailChange(ctx, jon.ID, "wrong"); err == nil {
+		t.Errorf("Expected wrong token to be rejected")
+	}
+	user, err := storage.ConfirmEmailChange(ctx, jon.ID, token)
+	if err != nil {
+		t.Fatalf("Error confirming email change: %v", err)
+	}
+	if user.Email != "jon@new.com" || user.PendingEmail != "" {
+		t.Errorf("Expected email jon@new.com, got %q pending %q", user.Email, user.PendingEmail)
+	}
+	if _, err := storage.ConfirmEmailChange(ctx, jon.ID, token); err == nil {
+		t.Errorf("Expected token to be usable once")
+	}
+
+	//NOTE: the address may be taken while the change is pending
+	token, err = storage.RequestEmailChange(ctx, jane.ID, "jane@new.com")
+	if err != nil {
+		t.Fatalf("Error requesting email change: %v", err)
+	}
+	if _, err := storage.CreateUser(ctx, &model.User{Name: "Jane New", Email: "jane@new.com"}); err != nil {
+		t.Fatalf("Error creating user: %v", err)
+	}
+	if _, err := storage.ConfirmEmailChange(ctx, jane.ID, token); err == nil {
+		t.Errorf("Expected confirmation of a taken email to be rejected")
+	}
+}
+
+func TestEmailIndex(t *testing.T) {
+	ctx := context.Background()
+	filename := t.TempDir() + "/user.json"
+	storage, err := jsondb.CreateUserStorage(filename)
+	if err != nil {
+		t.Fatalf("Error creating user storage: %v", err)
+	}
+	bob := &model.User{Name: "Bob", Email: " Bob@X.com"}
+	if _, err := storage.CreateUser(ctx, bob); err != nil {
+		t.Fatalf("Error creating user: %v", err)
+	}
+	if bob.Email != "bob@x.com" {
+		t.Errorf("Expected normalized email bob@x.com, got %q", bob.Email)
+	}
+	if _, err := storage.CreateUser(ctx, &model.User{Name: "Other Bob", Email: "bob@x.com"}); !errors.Is(err, db.ErrEmailTaken) {
+		t.Errorf("Expected ErrEmailTaken, got %v", err)
+	}
+
+	// reopen the storage to check the index built from the file
+	storage, err = jsondb.CreateUserStorage(filename)
+	if err != nil {
+		t.Fatalf("Error opening user storage: %v", err)
+	}
+	tests := []struct {
+		name  string
+		email string
+		found bool
+	}{
+		{name: "Same case", email: "bob@x.com", found: true},
+		{name: "Other case", email: "BOB@x.COM", found: true},
+		{name: "Unknown", email: "alice@x.com", found: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			user, err := storage.GetUserByEmail(ctx, test.email)
+			if err != nil {
+				t.Fatalf("Error getting user: %v", err)
+			}
+			if (user.ID == bob.ID) != test.found {
+				t.Errorf("Expected found: %v, got user %s", test.found, user.ID)
+			}
+		})
+	}
+
+	if err := storage.DeleteUser(ctx, bob.ID); err != nil {
+		t.Fatalf("Error deleting user: %v", err)
+	}
+	if _, err := storage.CreateUser(ctx, &model.User{Name: "New Bob", Email: "BOB@x.com"}); err != nil {
+		t.Errorf("Expected email to be free after deletion, got %v", err)
+	}
+}
+
+func TestDuplicateEmails(t *testing.T) {
+	ctx := context.Background()
+	filename := t.TempDir() + "/user.json"
+	// stored before emails were normalized
+	verified := &model.User{ID: uuid.MustParse("ffffffff-0000-4000-8000-000000000000"), Name: "Bob", Email: "Bob@X.com", IsVerified: true}
+	unverified := &model.User{ID: uuid.MustParse("00000000-0000-4000-8000-000000000000"), Name: "Other Bob", Email: "bob@x.com"}
+	first := &model.User{ID: uuid.MustParse("11111111-0000-4000-8000-000000000000"), Name: "Alice", Email: "alice@x.com"}
+	second := &model.User{ID: uuid.MustParse("22222222-0000-4000-8000-000000000000"), Name: "Other Alice", Email: "ALICE@x.com"}
+	users := map[uuid.UUID]*model.User{verified.ID: verified, unverified.ID: unverified, first.ID: first, second.ID: second}
+	data, err := json.Marshal(users)
+	if err != nil {
+		t.Fatalf("Error marshaling users: %v", err)
+	}
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		t.Fatalf("Error writing users: %v", err)
+	}
+
+	storage, err := jsondb.CreateUserStorage(filename)
+	if err != nil {
+		t.Fatalf("Expected duplicates to be resolved, got %v", err)
+	}
+	tests := []struct {
+		name     string
+		email    string
+		expected uuid.UUID
+	}{
+		{name: "Verified kept", email: "bob@x.com", expected: verified.ID},
+		{name: "Lower ID kept", email: "alice@x.com", expected: first.ID},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			user, err := storage.GetUserByEmail(ctx, test.email)
+			if err != nil {
+				t.Fatalf("Error getting user: %v", err)
+			}
+			if user.ID != test.expected {
+				t.Errorf("Expected user %s, got %s", test.expected, user.ID)
+			}
+		})
+	}
+
+	duplicates := storage.Duplicates()
+	expected := map[uuid.UUID]jsondb.DuplicateEmail{
+		unverified.ID: {Email: "bob@x.com", Kept: verified.ID, User: unverified.ID},
+		second.ID:     {Email: "alice@x.com", Kept: first.ID, User: second.ID},
+	}
+	if len(duplicates) != len(expected) {
+		t.Fatalf("Expected %d duplicates, got %v", len(expected), duplicates)
+	}
+	for _, duplicate := range duplicates {
+		if duplicate != expected[duplicate.User] {
+			t.Errorf("Expected %v, got %v", expected[duplicate.User], duplicate)
+		}
+	}
+	user, err := storage.GetUserByID(ctx, unverified.ID)
+	if err != nil {
+		t.Fatalf("Error getting user: %v", err)
+	}
+	if user.ID != unverified.ID {
+		t.Errorf("Expected the duplicate account to be kept in the storage")
+	}
+}
@@ -0,0 +1,44 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+)
+
+// Writable checks that files can be created in dir
+func Writable(dir string) func(context.Context) error {
+	return func(context.Context) error {
+		file, err := os.CreateTemp(dir, ".health-*")
+		if err != nil {
+			return err
+		}
+		name := file.Name()
+		if _, err := file.WriteString("ok"); err != nil {
+			file.Close()
+			os.Remove(name)
+			return err
+		}
+		if err := file.Close(); err != nil {
+			os.Remove(name)
+			return err
+		}
+		return os.Remove(name)
+	}
+}
+
+// Reachable checks that a TCP connection to address can be opened
+func Reachable(address string) func(context.Context) error {
+	return func(ctx context.Context) error {
+		if address == "" || address == ":" {
+			return errors.New("no address configured")
+		}
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
@@ -0,0 +1,165 @@
+// Package health reports the state of the guestbook and its dependencies to
+// liveness and readiness probes
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Timeout limits every check of a probe
+const Timeout = 2 * time.Second
+
+const (
+	StatusOK       = "ok"
+	StatusDegraded = "degraded"
+	StatusFailing  = "failing"
+)
+
+// ErrDraining is reported by the readiness probe during the shutdown
+var ErrDraining = errors.New("server is shutting down")
+
+// Check tests one part of the guestbook
+type Check struct {
+	Name string
+	// a failing critical check fails the probe, other failures only degrade it
+	Critical bool
+	// liveness checks are part of /healthz as well, they should only fail if a
+	// restart helps
+	Liveness bool
+	Run      func(context.Context) error
+}
+
+// Result is the outcome of a single check
+type Result struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// Report is the outcome of a probe
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// Registry holds the checks of the probes
+type Registry struct {
+	checks   []Check
+	mu       sync.Mutex
+	draining atomic.Bool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds the check, checks with the same name are replaced
+func (r *Registry) Register(check Check) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.checks {
+		if r.checks[i].Name == check.Name {
+			r.checks[i] = check
+			return
+		}
+	}
+	r.checks = append(r.checks, check)
+	sort.Slice(r.checks, func(i, j int) bool { return r.checks[i].Name < r.checks[j].Name })
+}
+
+// SetDraining makes the readiness probe fail, so no new requests are routed
+// to the server while it shuts down
+func (r *Registry) SetDraining(draining bool) {
+	r.draining.Store(draining)
+}
+
+// Run executes the liveness checks or all checks concurrently
+func (r *Registry) Run(ctx context.Context, livenessOnly bool) *Report {
+	r.mu.Lock()
+	checks := make([]Check, 0, len(r.checks))
+	for _, check := range r.checks {
+		if !livenessOnly || check.Liveness {
+			checks = append(checks, check)
+		}
+	}
+	r.mu.Unlock()
+
+	report := &Report{Status: StatusOK, Checks: make(map[string]Result, len(checks))}
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = run(ctx, check)
+		}()
+	}
+	wg.Wait()
+
+	for i, check := range checks {
+		report.Checks[check.Name] = results[i]
+		switch {
+		case results[i].Status == StatusOK:
+		case check.Critical:
+			report.Status = StatusFailing
+		case report.Status == StatusOK:
+			report.Status = StatusDegraded
+		}
+	}
+	return report
+}
+
+func run(ctx context.Context, check Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check.Run(ctx)
+	result := Result{Status: StatusOK, Duration: time.Since(start).String()}
+	if err != nil {
+		result.Status = StatusFailing
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// LivenessHandler answers /healthz with the liveness checks
+func (r *Registry) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		write(w, r.Run(req.Context(), true))
+	})
+}
+
+// ReadinessHandler answers /readyz with all checks, it fails while the
+// server is draining
+func (r *Registry) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		report := r.Run(req.Context(), false)
+		if r.draining.Load() {
+			report.Status = StatusFailing
+			report.Checks["shutdown"] = Result{Status: StatusFailing, Error: ErrDraining.Error(), Duration: "0s"}
+		}
+		write(w, report)
+	})
+}
+
+func write(w http.ResponseWriter, report *Report) {
+	status := http.StatusOK
+	if report.Status == StatusFailing {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "\t")
+	_ = encoder.Encode(report)
+}
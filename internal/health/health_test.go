@@ -0,0 +1,128 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/led0nk/guestbook/internal/health"
+)
+
+func ok(context.Context) error { return nil }
+
+func broken(context.Context) error { return errors.New("broken") }
+
+func TestProbes(t *testing.T) {
+	tests := []struct {
+		name      string
+		checks    []health.Check
+		draining  bool
+		liveness  int
+		readiness int
+		status    string
+	}{
+		{
+			name: "Healthy",
+			checks: []health.Check{
+				{Name: "templates", Critical: true, Liveness: true, Run: ok},
+				{Name: "storage", Critical: true, Run: ok},
+			},
+			liveness: http.StatusOK, readiness: http.StatusOK, status: health.StatusOK,
+		},
+		{
+			name: "Degraded",
+			checks: []health.Check{
+				{Name: "storage", Critical: true, Run: ok},
+				{Name: "mailer", Run: broken},
+			},
+			liveness: http.StatusOK, readiness: http.StatusOK, status: health.StatusDegraded,
+		},
+		{
+			name: "Critical readiness check failing",
+			checks: []health.Check{
+				{Name: "templates", Critical: true, Liveness: true, Run: ok},
+				{Name: "storage", Critical: true, Run: broken},
+			},
+			liveness: http.StatusOK, readiness: http.StatusServiceUnavailable, status: health.StatusFailing,
+		},
+		{
+			name: "Critical liveness check failing",
+			checks: []health.Check{
+				{Name: "templates", Critical: true, Liveness: true, Run: broken},
+			},
+			liveness: http.StatusServiceUnavailable, readiness: http.StatusServiceUnavailable, status: health.StatusFailing,
+		},
+		{
+			name:     "Draining",
+			checks:   []health.Check{{Name: "storage", Critical: true, Run: ok}},
+			draining: true,
+			liveness: http.StatusOK, readiness: http.StatusServiceUnavailable, status: health.StatusFailing,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			registry := health.NewRegistry()
+			for _, check := range test.checks {
+				registry.Register(check)
+			}
+			registry.SetDraining(test.draining)
+
+			rec := httptest.NewRecorder()
+			registry.LivenessHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			if rec.Code != test.liveness {
+				t.Errorf("Expected liveness %d, got %d", test.liveness, rec.Code)
+			}
+
+			rec = httptest.NewRecorder()
+			registry.ReadinessHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if rec.Code != test.readiness {
+				t.Errorf("Expected readiness %d, got %d", test.readiness, rec.Code)
+			}
+			var report health.Report
+			if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+				t.Fatalf("Error decoding report: %v", err)
+			}
+			if report.Status != test.status {
+				t.Errorf("Expected status %s, got %s", test.status, report.Status)
+			}
+			for _, check := range test.checks {
+				if _, exists := report.Checks[check.Name]; !exists {
+					t.Errorf("Expected result of %s, got %v", check.Name, report.Checks)
+				}
+			}
+		})
+	}
+}
+
+func TestWritable(t *testing.T) {
+	ctx := context.Background()
+	if err := health.Writable(t.TempDir())(ctx); err != nil {
+		t.Errorf("Expected temporary dir to be writable, got %v", err)
+	}
+	if err := health.Writable(t.TempDir() + "/missing")(ctx); err == nil {
+		t.Errorf("Expected error for a missing dir")
+	}
+}
+
+func TestReachable(t *testing.T) {
+	ctx := context.Background()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	address := listener.Addr().String()
+	if err := health.Reachable(address)(ctx); err != nil {
+		t.Errorf("Expected %s to be reachable, got %v", address, err)
+	}
+	listener.Close()
+	if err := health.Reachable(address)(ctx); err == nil {
+		t.Errorf("Expected error for a closed port")
+	}
+	if err := health.Reachable(":")(ctx); err == nil {
+		t.Errorf("Expected error without address")
+	}
+}
@@ -0,0 +1,160 @@
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Locale is the language of a catalog, e.g. "en" or "de"
+type Locale string
+
+const (
+	English Locale = "en"
+	German  Locale = "de"
+)
+
+// Default is used if no supported locale is requested and for keys missing
+// in a catalog
+var Default = English
+
+//go:embed locales/*.json
+var files embed.FS
+
+// messages by key of every supported locale
+var catalogs = mustLoad()
+
+func mustLoad() map[Locale]map[string]string {
+	entries, err := files.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+	catalogs := make(map[Locale]map[string]string, len(entries))
+	for _, entry := range entries {
+		data, err := files.ReadFile("locales/" + entry.Name())
+		if err != nil {
+			panic(err)
+		}
+		messages := map[string]string{}
+		if err := json.Unmarshal(data, &messages); err != nil {
+			panic(fmt.Errorf("catalog %s: %w", entry.Name(), err))
+		}
+		catalogs[Locale(strings.TrimSuffix(entry.Name(), path.Ext(entry.Name())))] = messages
+	}
+	return catalogs
+}
+
+// Supported returns the locales which have a catalog
+func Supported() []Locale {
+	locales := make([]Locale, 0, len(catalogs))
+	for locale := range catalogs {
+		locales = append(locales, locale)
+	}
+	sort.Slice(locales, func(i, j int) bool { return locales[i] < locales[j] })
+	return locales
+}
+
+// Keys returns the keys of the catalog of the locale
+func Keys(locale Locale) []string {
+	keys := make([]string, 0, len(catalogs[locale]))
+	for key := range catalogs[locale] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Parse returns the supported locale of a language tag, regions are ignored,
+// e.g. de-AT is German
+func Parse(tag string) (Locale, bool) {
+	language, _, _ := strings.Cut(strings.TrimSpace(tag), "-")
+	locale := Locale(strings.ToLower(language))
+	_, ok := catalogs[locale]
+	return locale, ok
+}
+
+// For returns the supported locale of a language tag or Default, e.g. for the
+// stored preference of a user
+func For(tag string) Locale {
+	if locale, ok := Parse(tag); ok {
+		return locale
+	}
+	return Default
+}
+
+// Negotiate returns the supported locale with the highest quality in an
+// Accept-Language header
+func Negotiate(acceptLanguage string) (Locale, bool) {
+	best, bestQuality := Locale(""), 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		quality := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+		if locale, ok := Parse(tag); ok && quality > bestQuality {
+			best, bestQuality = locale, quality
+		}
+	}
+	return best, best != ""
+}
+
+// T returns the message of key formatted with args, it falls back to the
+// Default catalog and then to the key
+func (l Locale) T(key string, args ...any) string {
+	message, ok := catalogs[l][key]
+	if !ok {
+		message, ok = catalogs[Default][key]
+	}
+	if !ok {
+		return key
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(message, args...)
+	}
+	return message
+}
+
+// Date formats t in the layout of the catalog with localized month names
+func (l Locale) Date(t time.Time) string {
+	month := l.T("month." + strings.ToLower(t.Month().String()))
+	layout := strings.ReplaceAll(l.T("format.date"), "January", "\x00")
+	return strings.ReplaceAll(t.Format(layout), "\x00", month)
+}
+
+// Message is a key of the catalogs with its arguments, which is translated
+// once the locale of the reader is known. It is an error with the text of
+// the Default catalog, so it can be returned where errors are shown to users.
+type Message struct {
+	Key  string
+	Args []any
+}
+
+// M returns the message of key with args
+func M(key string, args ...any) Message {
+	return Message{Key: key, Args: args}
+}
+
+func (m Message) Error() string {
+	return Default.T(m.Key, m.Args...)
+}
+
+// From returns the message of err, errors without one are shown with their
+// text
+func From(err error) Message {
+	var message Message
+	if errors.As(err, &message) {
+		return message
+	}
+	return Message{Key: err.Error()}
+}
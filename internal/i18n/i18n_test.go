@@ -0,0 +1,93 @@
+package i18n_test
+
+import (
+	"errors"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/led0nk/guestbook/internal/i18n"
+)
+
+var verbs = regexp.MustCompile(`%[a-z]`)
+
+func TestCatalogs(t *testing.T) {
+	keys := i18n.Keys(i18n.Default)
+	for _, locale := range i18n.Supported() {
+		t.Run(string(locale), func(t *testing.T) {
+			if !slices.Equal(i18n.Keys(locale), keys) {
+				t.Errorf("Expected the keys of %s, got %v", i18n.Default, i18n.Keys(locale))
+			}
+			for _, key := range keys {
+				expected := verbs.FindAllString(i18n.Default.T(key), -1)
+				if got := verbs.FindAllString(locale.T(key), -1); !slices.Equal(got, expected) {
+					t.Errorf("Expected arguments %v of %s, got %v", expected, key, got)
+				}
+			}
+			for _, other := range i18n.Supported() {
+				if key := "locale." + string(other); locale.T(key) == key {
+					t.Errorf("Expected a name of locale %s", other)
+				}
+			}
+		})
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected i18n.Locale
+		ok       bool
+	}{
+		{header: "de-DE,de;q=0.9,en;q=0.8", expected: i18n.German, ok: true},
+		{header: "en-US", expected: i18n.English, ok: true},
+		{header: "fr, de;q=0.5, en;q=0.7", expected: i18n.English, ok: true},
+		{header: "DE", expected: i18n.German, ok: true},
+		{header: "fr, es;q=0.5"},
+		{header: "de;q=x"},
+		{header: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.header, func(t *testing.T) {
+			locale, ok := i18n.Negotiate(test.header)
+			if ok != test.ok || (ok && locale != test.expected) {
+				t.Errorf("Expected %q %v, got %q %v", test.expected, test.ok, locale, ok)
+			}
+		})
+	}
+}
+
+func TestDate(t *testing.T) {
+	date := time.Date(2026, time.March, 7, 14, 5, 0, 0, time.UTC)
+	tests := []struct {
+		locale   i18n.Locale
+		expected string
+	}{
+		{locale: i18n.English, expected: "March 7, 2026 14:05"},
+		{locale: i18n.German, expected: "7. März 2026, 14:05"},
+	}
+	for _, test := range tests {
+		if got := test.locale.Date(date); got != test.expected {
+			t.Errorf("Expected %q, got %q", test.expected, got)
+		}
+	}
+}
+
+func TestMessage(t *testing.T) {
+	message := i18n.M("password.rule.length", 8)
+	if got := message.Error(); got != "at least 8 characters" {
+		t.Errorf("Expected the text of the default catalog, got %q", got)
+	}
+	if got := i18n.German.T(message.Key, message.Args...); got != "mindestens 8 Zeichen" {
+		t.Errorf("Expected the German text, got %q", got)
+	}
+	wrapped := errors.Join(errors.New("context"), message)
+	if got := i18n.From(wrapped); got.Key != message.Key {
+		t.Errorf("Expected the wrapped message, got %v", got)
+	}
+	plain := i18n.From(errors.New("storage failed"))
+	if got := i18n.German.T(plain.Key, plain.Args...); got != "storage failed" {
+		t.Errorf("Expected errors without a message to be shown unchanged, got %q", got)
+	}
+}
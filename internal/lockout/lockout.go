@@ -0,0 +1,160 @@
+package lockout
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+var meter = otel.GetMeterProvider().Meter("github.com/led0nk/guestbook/internal/lockout")
+
+// Policy defines how failed attempts are throttled
+type Policy struct {
+	// failed attempts before backoff starts
+	FreeAttempts int
+	// delay after the first throttled attempt, doubled for every further one
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// failed attempts until the key is locked for LockDuration
+	LockAfter    int
+	LockDuration time.Duration
+}
+
+// AccountPolicy throttles attempts against a single account
+var AccountPolicy = Policy{
+	FreeAttempts: 3,
+	BaseDelay:    time.Second,
+	MaxDelay:     time.Minute,
+	LockAfter:    10,
+	LockDuration: 15 * time.Minute,
+}
+
+// IPPolicy throttles attempts from a single client, which may be shared by
+// several users
+var IPPolicy = Policy{
+	FreeAttempts: 10,
+	BaseDelay:    time.Second,
+	MaxDelay:     time.Minute,
+	LockAfter:    50,
+	LockDuration: 15 * time.Minute,
+}
+
+type attempts struct {
+	failures    int
+	lastFailure time.Time
+	// no further attempts are allowed before blockedUntil
+	blockedUntil time.Time
+	locked       bool
+}
+
+// Guard counts failed attempts per key
+type Guard struct {
+	name     string
+	policy   Policy
+	attempts map[string]*attempts
+	mu       sync.Mutex
+	lockouts metric.Int64Counter
+}
+
+func NewGuard(name string, policy Policy) *Guard {
+	lockouts, _ := meter.Int64Counter(
+		"lockout.lockouts",
+		metric.WithDescription("Number of temporary lockouts"),
+	)
+	return &Guard{
+		name:     name,
+		policy:   policy,
+		attempts: make(map[string]*attempts),
+		lockouts: lockouts,
+	}
+}
+
+// Check returns how long the key has to wait before the next attempt
+func (g *Guard) Check(key string) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	a, exists := g.attempts[key]
+	if !exists {
+		return 0
+	}
+	wait := time.Until(a.blockedUntil)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// Fail records a failed attempt and reports whether the key got locked by it
+func (g *Guard) Fail(ctx context.Context, key string) (time.Time, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	g.purge(now)
+	a, exists := g.attempts[key]
+	if !exists {
+		a = &attempts{}
+		g.attempts[key] = a
+	}
+	a.lastFailure = now
+	if a.locked {
+		if now.Before(a.blockedUntil) {
+			return a.blockedUntil, false
+		}
+		//NOTE: after a lock the backoff starts over, otherwise a single
+		// failure would lock the key again
+		a.locked = false
+		a.failures = g.policy.FreeAttempts
+	}
+	a.failures++
+
+	if a.failures >= g.policy.LockAfter {
+		a.blockedUntil = now.Add(g.policy.LockDuration)
+		a.locked = true
+		if g.lockouts != nil {
+			g.lockouts.Add(ctx, 1, metric.WithAttributes(attribute.String("guard", g.name)))
+		}
+		return a.blockedUntil, true
+	}
+	if a.failures > g.policy.FreeAttempts {
+		delay := g.policy.BaseDelay << (a.failures - g.policy.FreeAttempts - 1)
+		if delay > g.policy.MaxDelay || delay <= 0 {
+			delay = g.policy.MaxDelay
+		}
+		a.blockedUntil = now.Add(delay)
+	}
+	return a.blockedUntil, false
+}
+
+// Reset forgets the failed attempts of key after a successful attempt
+func (g *Guard) Reset(key string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.attempts, key)
+}
+
+// Locked returns the end of the lockout if key is locked
+func (g *Guard) Locked(key string) (time.Time, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	a, exists := g.attempts[key]
+	if !exists || !a.locked || !time.Now().Before(a.blockedUntil) {
+		return time.Time{}, false
+	}
+	return a.blockedUntil, true
+}
+
+// forgets keys whose last failure is older than the lock duration
+func (g *Guard) purge(now time.Time) {
+	for key, a := range g.attempts {
+		if now.After(a.blockedUntil) && now.Sub(a.lastFailure) > g.policy.LockDuration {
+			delete(g.attempts, key)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package lockout_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/led0nk/guestbook/internal/lockout"
+)
+
+func TestGuard(t *testing.T) {
+	ctx := context.Background()
+	policy := lockout.Policy{
+		FreeAttempts: 2,
+		BaseDelay:    time.Second,
+		MaxDelay:     4 * time.Second,
+		LockAfter:    7,
+		LockDuration: time.Hour,
+	}
+	guard := lockout.NewGuard("test", policy)
+
+	tests := []struct {
+		name     string
+		expected time.Duration
+		locked   bool
+	}{
+		{name: "First free attempt", expected: 0},
+		{name: "Second free attempt", expected: 0},
+		{name: "First backoff", expected: time.Second},
+		{name: "Doubled backoff", expected: 2 * time.Second},
+		{name: "Doubled again", expected: 4 * time.Second},
+		{name: "Capped at max delay", expected: 4 * time.Second},
+	}
+	for _, test := range tests {
+		guard.Fail(ctx, "jon")
+		wait := guard.Check("jon")
+		if wait > test.expected || wait < test.expected-100*time.Millisecond {
+			t.Errorf("%s: Expected wait of %v, got %v", test.name, test.expected, wait)
+		}
+	}
+
+	until, locked := guard.Fail(ctx, "jon")
+	if !locked {
+		t.Fatalf("Expected key to be locked after %d failures", policy.LockAfter)
+	}
+	if _, locked := guard.Fail(ctx, "jon"); locked {
+		t.Errorf("Expected lockout to be reported only once")
+	}
+	if lockedUntil, ok := guard.Locked("jon"); !ok || !lockedUntil.Equal(until) {
+		t.Errorf("Expected key to be locked until %v, got %v", until, lockedUntil)
+	}
+	if wait := guard.Check("jon"); wait < 59*time.Minute {
+		t.Errorf("Expected wait of the lock duration, got %v", wait)
+	}
+	if wait := guard.Check("jane"); wait != 0 {
+		t.Errorf("Expected other keys not to be throttled, got %v", wait)
+	}
+
+	guard.Reset("jon")
+	if _, locked := guard.Locked("jon"); locked {
+		t.Errorf("Expected key to be unlocked after reset")
+	}
+	if wait := guard.Check("jon"); wait != 0 {
+		t.Errorf("Expected no wait after reset, got %v", wait)
+	}
+}
+
+func TestGuardAfterLock(t *testing.T) {
+	ctx := context.Background()
+	policy := lockout.Policy{
+		FreeAttempts: 1,
+		BaseDelay:    time.Millisecond,
+		MaxDelay:     time.Millisecond,
+		LockAfter:    3,
+		LockDuration: 300 * time.Millisecond,
+	}
+	guard := lockout.NewGuard("test", policy)
+	for i := 0; i < policy.LockAfter; i++ {
+		guard.Fail(ctx, "jon")
+	}
+	if _, locked := guard.Locked("jon"); !locked {
+		t.Fatalf("Expected key to be locked after %d failures", policy.LockAfter)
+	}
+
+	//NOTE: failures during the lock keep the key from being forgotten
+	time.Sleep(200 * time.Millisecond)
+	guard.Fail(ctx, "jon")
+	time.Sleep(150 * time.Millisecond)
+	if _, locked := guard.Locked("jon"); locked {
+		t.Fatalf("Expected lock to expire")
+	}
+
+	if _, locked := guard.Fail(ctx, "jon"); locked {
+		t.Errorf("Expected a single failure after the lock not to lock the key again")
+	}
+	if wait := guard.Check("jon"); wait > policy.MaxDelay {
+		t.Errorf("Expected the backoff to start over, got a wait of %v", wait)
+	}
+	if _, locked := guard.Fail(ctx, "jon"); !locked {
+		t.Errorf("Expected key to be locked again after %d failures", policy.LockAfter-policy.FreeAttempts)
+	}
+}
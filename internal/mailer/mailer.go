@@ -2,9 +2,11 @@ package mailer
 
 import (
 	"bytes"
+	"mime"
 	"net/smtp"
 
 	templates "github.com/led0nk/guestbook/internal"
+	"github.com/led0nk/guestbook/internal/i18n"
 	"github.com/led0nk/guestbook/internal/model"
 )
 
@@ -32,9 +34,24 @@ func NewMailer(
 type data struct {
 	User   *model.User
 	Domain string
+	Link   string
+	// new password of a reset, it is never stored in the user
+	Password string
+}
+
+type digestData struct {
+	User   *model.User
+	Digest *model.Digest
+	Link   string
+}
+
+type invitationData struct {
+	Invitation *model.Invitation
+	Link       string
 }
 
-func (m *Mailer) SendVerMail(user *model.User, domain string, tmpl *templates.TemplateHandler) error {
+func (m *Mailer) SendVerMail(user *model.User, domain string, tmpl *templates.MailTemplates) error {
+	locale := i18n.For(user.Locale)
 	var body bytes.Buffer
 
 	data := &data{
@@ -42,39 +59,130 @@ func (m *Mailer) SendVerMail(user *model.User, domain string, tmpl *templates.Te
 		Domain: domain,
 	}
 
-	err := tmpl.TmplVerMail.Execute(&body, data)
+	err := templates.Execute(&body, tmpl.TmplVerMail, "", templates.Request{Locale: locale}, data)
 	if err != nil {
 		return err
 	}
-	headers := "MIME-version: 1.0;\nContent-Type: text/html; charset=\"UTF-8\";"
-	msg := "Subject: Email Validation" + "\n" + headers + "\n\n" + body.String()
-	err = smtp.SendMail(
-		m.Host+":"+m.Port,
-		smtp.PlainAuth(
-			"",
-			m.Email,
-			m.Password,
-			m.Host,
-		),
-		m.Email,
-		[]string{user.Email},
-		[]byte(msg),
-	)
+	return m.send(user.Email, locale.T("mail.verification.subject"), body.String())
+}
+
+// SendPWMail sends the new password of a reset to the user
+func (m *Mailer) SendPWMail(user *model.User, password string, tmpl *templates.MailTemplates) error {
+	locale := i18n.For(user.Locale)
+	body, err := passwordMail(user, password, locale, tmpl)
+	if err != nil {
+		return err
+	}
+	return m.send(user.Email, locale.T("mail.password.subject"), body)
+}
+
+// passwordMail renders the body of the password reset mail
+func passwordMail(user *model.User, password string, locale i18n.Locale, tmpl *templates.MailTemplates) (string, error) {
+	var body bytes.Buffer
+
+	data := &data{
+		User:     user,
+		Password: password,
+	}
+
+	err := templates.Execute(&body, tmpl.TmplPasswordMail, "", templates.Request{Locale: locale}, data)
+	if err != nil {
+		return "", err
+	}
+	return body.String(), nil
+}
+
+// SendMagicLinkMail sends the login link to the user
+func (m *Mailer) SendMagicLinkMail(user *model.User, link string, tmpl *templates.MailTemplates) error {
+	locale := i18n.For(user.Locale)
+	var body bytes.Buffer
+
+	data := &data{
+		User: user,
+		Link: link,
+	}
+
+	err := templates.Execute(&body, tmpl.TmplMagicLinkMail, "", templates.Request{Locale: locale}, data)
+	if err != nil {
+		return err
+	}
+	return m.send(user.Email, locale.T("mail.magiclink.subject"), body.String())
+}
+
+// SendInvitationMail sends the signup link of the invitation to its address
+func (m *Mailer) SendInvitationMail(invitation *model.Invitation, link string, tmpl *templates.MailTemplates) error {
+	locale := i18n.For(invitation.Locale)
+	var body bytes.Buffer
+
+	data := &invitationData{
+		Invitation: invitation,
+		Link:       link,
+	}
+
+	err := templates.Execute(&body, tmpl.TmplInvitationMail, "", templates.Request{Locale: locale}, data)
+	if err != nil {
+		return err
+	}
+	return m.send(invitation.Email, locale.T("mail.invitation.subject"), body.String())
+}
+
+// SendEmailChangeMail sends the link which confirms the pending email to the
+// new address
+func (m *Mailer) SendEmailChangeMail(user *model.User, link string, tmpl *templates.MailTemplates) error {
+	locale := i18n.For(user.Locale)
+	var body bytes.Buffer
+
+	data := &data{
+		User: user,
+		Link: link,
+	}
+
+	err := templates.Execute(&body, tmpl.TmplEmailChangeMail, "", templates.Request{Locale: locale}, data)
 	if err != nil {
 		return err
 	}
-	return nil
+	return m.send(user.PendingEmail, locale.T("mail.emailchange.subject"), body.String())
 }
 
-func (m *Mailer) SendPWMail(user *model.User, tmpl *templates.TemplateHandler) error {
+// SendEmailChangeNotice informs the current address about a requested change
+func (m *Mailer) SendEmailChangeNotice(user *model.User, tmpl *templates.MailTemplates) error {
+	locale := i18n.For(user.Locale)
 	var body bytes.Buffer
-	err := tmpl.TmplVerMail.Execute(&body, user)
+
+	data := &data{
+		User: user,
+	}
+
+	err := templates.Execute(&body, tmpl.TmplEmailChangeNotice, "", templates.Request{Locale: locale}, data)
 	if err != nil {
 		return err
 	}
+	return m.send(user.Email, locale.T("mail.emailnotice.subject"), body.String())
+}
+
+// SendDigestMail sends the summary of the recent activity to the admin
+func (m *Mailer) SendDigestMail(admin *model.User, digest *model.Digest, link string, tmpl *templates.MailTemplates) error {
+	locale := i18n.For(admin.Locale)
+	var body bytes.Buffer
+
+	data := &digestData{
+		User:   admin,
+		Digest: digest,
+		Link:   link,
+	}
+
+	err := templates.Execute(&body, tmpl.TmplDigestMail, "", templates.Request{Locale: locale}, data)
+	if err != nil {
+		return err
+	}
+	return m.send(admin.Email, locale.T("mail.digest.subject"), body.String())
+}
+
+func (m *Mailer) send(to string, subject string, body string) error {
 	headers := "MIME-version: 1.0;\nContent-Type: text/html; charset=\"UTF-8\";"
-	msg := "Subject: Email Validation" + "\n" + headers + "\n\n" + body.String()
-	err = smtp.SendMail(
+	//NOTE: translated subjects may contain non-ASCII characters
+	msg := "Subject: " + mime.QEncoding.Encode("UTF-8", subject) + "\n" + headers + "\n\n" + body
+	return smtp.SendMail(
 		m.Host+":"+m.Port,
 		smtp.PlainAuth(
 			"",
@@ -83,11 +191,7 @@ func (m *Mailer) SendPWMail(user *model.User, tmpl *templates.TemplateHandler) e
 			m.Host,
 		),
 		m.Email,
-		[]string{user.Email},
+		[]string{to},
 		[]byte(msg),
 	)
-	if err != nil {
-		return err
-	}
-	return nil
 }
@@ -0,0 +1,51 @@
+package mailer
+
+import (
+	"strings"
+	"testing"
+
+	templates "github.com/led0nk/guestbook/internal"
+	"github.com/led0nk/guestbook/internal/i18n"
+	"github.com/led0nk/guestbook/internal/model"
+)
+
+func TestPasswordMail(t *testing.T) {
+	handler, err := templates.ParseTemplates()
+	if err != nil {
+		t.Fatalf("Error parsing templates: %v", err)
+	}
+	user := &model.User{Name: "John Doe", Email: "john@doe.com", Password: []byte("hash")}
+
+	tests := []struct {
+		name     string
+		locale   i18n.Locale
+		expected []string
+	}{
+		{
+			name:     "English",
+			locale:   i18n.English,
+			expected: []string{"Hello John Doe", "Xy7-new-password", "change it"},
+		},
+		{
+			name:     "German",
+			locale:   i18n.German,
+			expected: []string{"Hallo John Doe", "Xy7-new-password", "ändere es"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, err := passwordMail(user, "Xy7-new-password", test.locale, handler.Mail)
+			if err != nil {
+				t.Fatalf("Error rendering mail: %v", err)
+			}
+			for _, expected := range test.expected {
+				if !strings.Contains(body, expected) {
+					t.Errorf("Expected %q in body, got %s", expected, body)
+				}
+			}
+			if strings.Contains(body, "hash") {
+				t.Errorf("Expected no password hash in body, got %s", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// APIError is the body of every failed request of the JSON API
+type APIError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+	// reasons why fields of the request are invalid, by the name of the field
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+type apiKey struct{}
+
+// API marks the requests of the JSON API, the middlewares of this package
+// answer them with an APIError instead of text. The responses are not cached.
+func API(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKey{}, true)))
+	})
+}
+
+// IsAPI reports if r is a request of the JSON API
+func IsAPI(r *http.Request) bool {
+	api, _ := r.Context().Value(apiKey{}).(bool)
+	return api
+}
+
+// WriteAPIError writes the error as JSON with its status
+func WriteAPIError(w http.ResponseWriter, apiErr *APIError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(apiErr.Status)
+	_ = json.NewEncoder(w).Encode(apiErr)
+}
+
+// Error answers requests of the API with an APIError and others with the
+// message as text
+func Error(w http.ResponseWriter, r *http.Request, message string, status int) {
+	if IsAPI(r) {
+		WriteAPIError(w, &APIError{Status: status, Message: message})
+		return
+	}
+	http.Error(w, message, status)
+}
+
+// SessionToken returns the token of the session of r, API clients send it as
+// bearer token and browsers as cookie
+func SessionToken(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	if session, err := r.Cookie("session"); err == nil {
+		return session.Value
+	}
+	return ""
+}
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/led0nk/guestbook/internal/i18n"
+)
+
+// LocaleCookie stores the language chosen by the user
+const LocaleCookie = "locale"
+
+// lifetime of the locale cookie
+const localeMaxAge = 365 * 24 * time.Hour
+
+type localeKey struct{}
+
+// Localize negotiates the locale of the request, which is returned by Locale
+func Localize() func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Language, Cookie")
+			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), localeKey{}, negotiate(r))))
+		})
+	}
+}
+
+// negotiate returns the locale of the cookie, then the best of
+// Accept-Language and Default otherwise
+func negotiate(r *http.Request) i18n.Locale {
+	if cookie, err := r.Cookie(LocaleCookie); err == nil {
+		if locale, ok := i18n.Parse(cookie.Value); ok {
+			return locale
+		}
+	}
+	if locale, ok := i18n.Negotiate(r.Header.Get("Accept-Language")); ok {
+		return locale
+	}
+	return i18n.Default
+}
+
+// Locale returns the locale the response to r is written in
+func Locale(r *http.Request) i18n.Locale {
+	if locale, ok := r.Context().Value(localeKey{}).(i18n.Locale); ok {
+		return locale
+	}
+	return negotiate(r)
+}
+
+// SetLocale stores the chosen locale in a cookie, so it is preferred over
+// Accept-Language
+func SetLocale(w http.ResponseWriter, r *http.Request, locale i18n.Locale) {
+	SetCookie(w, r, &http.Cookie{
+		Name:     LocaleCookie,
+		Value:    string(locale),
+		Path:     "/",
+		MaxAge:   int(localeMaxAge.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -48,7 +48,7 @@ func Auth(t db.TokenStore, logger *slog.Logger) func(h http.Handler) http.Handle
 				return
 			}
 
-			http.SetCookie(w, cookie)
+			SetCookie(w, r, cookie)
 			logger.Info("authentication middleware", "status", "done")
 			h.ServeHTTP(w, r)
 		})
@@ -90,7 +90,7 @@ func AdminAuth(t db.TokenStore, u db.UserStore, logger *slog.Logger) func(h http
 				return
 			}
 
-			http.SetCookie(w, cookie)
+			SetCookie(w, r, cookie)
 
 			logger.Info("admin middleware", "status", "done")
 
@@ -0,0 +1,281 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	db "github.com/led0nk/guestbook/internal/database"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var meter = otel.GetMeterProvider().Meter("github.com/led0nk/guestbook/internal/middleware")
+
+// ClientIP extracts the address of the client, X-Forwarded-For is only
+// honored for requests coming from a trusted proxy
+type ClientIP struct {
+	trusted []*net.IPNet
+}
+
+// NewClientIP parses the trusted proxies given as CIDR or single address
+func NewClientIP(trustedProxies []string) (*ClientIP, error) {
+	c := &ClientIP{}
+	for _, proxy := range trustedProxies {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, errors.New("invalid trusted proxy: " + proxy)
+			}
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			c.trusted = append(c.trusted, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, err
+		}
+		c.trusted = append(c.trusted, network)
+	}
+	return c, nil
+}
+
+func (c *ClientIP) isTrusted(ip net.IP) bool {
+	for _, network := range c.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IP returns the address of the client of r, walking X-Forwarded-For from the
+// right as long as the hops are trusted proxies
+func (c *ClientIP) IP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remote := net.ParseIP(host)
+	if c == nil || remote == nil || !c.isTrusted(remote) {
+		return host
+	}
+
+	hops := []string{}
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break
+		}
+		if !c.isTrusted(ip) {
+			return ip.String()
+		}
+		host = ip.String()
+	}
+	return host
+}
+
+// KeyFunc returns the key of the bucket a request is counted against
+type KeyFunc func(*http.Request) string
+
+// ByIP counts requests per client address
+func ByIP(c *ClientIP) KeyFunc {
+	return func(r *http.Request) string {
+		return "ip:" + c.IP(r)
+	}
+}
+
+// ByUser counts requests per logged in user and falls back to the client
+// address for anonymous requests
+func ByUser(t db.TokenStore, c *ClientIP) KeyFunc {
+	return func(r *http.Request) string {
+		if token := SessionToken(r); token != "" {
+			if userID, err := t.GetTokenValue(r.Context(), &http.Cookie{Value: token}); err == nil {
+				return "user:" + userID.String()
+			}
+		}
+		return "ip:" + c.IP(r)
+	}
+}
+
+// ByRoute counts all requests of a route against one bucket, routes are told
+// apart by their pattern
+func ByRoute() KeyFunc {
+	return func(r *http.Request) string {
+		return "route:" + r.Pattern
+	}
+}
+
+// RatePolicy configures a token bucket, Rate tokens are added per second up
+// to Burst and every request takes one
+type RatePolicy struct {
+	Name  string
+	Rate  float64
+	Burst int
+	Key   KeyFunc
+}
+
+// Limit allows Burst requests at once and one more every Interval
+type Limit struct {
+	Interval time.Duration `json:"interval" yaml:"interval" toml:"interval"`
+	Burst    int           `json:"burst" yaml:"burst" toml:"burst"`
+}
+
+// RateLimits of the handlers, handlers sharing a limit share its buckets, it
+// is read from the configuration and replaced by a reload
+type RateLimits struct {
+	Auth   Limit `json:"auth" yaml:"auth" toml:"auth"`
+	Signup Limit `json:"signup" yaml:"signup" toml:"signup"`
+	Search Limit `json:"search" yaml:"search" toml:"search"`
+	Entry  Limit `json:"entry" yaml:"entry" toml:"entry"`
+}
+
+var DefaultRateLimits = RateLimits{
+	Auth:   Limit{Interval: 6 * time.Second, Burst: 10},
+	Signup: Limit{Interval: time.Minute, Burst: 3},
+	Search: Limit{Interval: 200 * time.Millisecond, Burst: 20},
+	Entry:  Limit{Interval: 10 * time.Second, Burst: 5},
+}
+
+func (l RateLimits) Validate() error {
+	var errs []error
+	for name, limit := range map[string]Limit{"auth": l.Auth, "signup": l.Signup, "search": l.Search, "entry": l.Entry} {
+		if limit.Interval <= 0 || limit.Burst < 1 {
+			errs = append(errs, fmt.Errorf("rate limit %s needs a positive interval and burst", name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter is a token bucket per key whose rate can be changed at runtime
+type RateLimiter struct {
+	policy    RatePolicy
+	buckets   map[string]*bucket
+	lastPurge time.Time
+	mu        sync.Mutex
+	requests  metric.Int64Counter
+	logger    *slog.Logger
+}
+
+// take removes a token from the bucket of key, if the bucket is empty it
+// returns the time until the next token is available
+func (l *RateLimiter) take(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastPurge) > time.Minute {
+		l.purge(now)
+	}
+	b, exists := l.buckets[key]
+	if !exists {
+		b = &bucket{tokens: float64(l.policy.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(l.policy.Burst), b.tokens+now.Sub(b.last).Seconds()*l.policy.Rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.policy.Rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// forgets buckets which are full again
+func (l *RateLimiter) purge(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.policy.Rate >= float64(l.policy.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastPurge = now
+}
+
+// NewRateLimiter creates the limiter of the policy, all handlers wrapped by
+// its middleware share the buckets
+func NewRateLimiter(policy RatePolicy, logger *slog.Logger) *RateLimiter {
+	requests, err := meter.Int64Counter(
+		"middleware.ratelimit.requests",
+		metric.WithDescription("Requests checked by the rate limiter"),
+	)
+	if err != nil {
+		logger.Error("metrics", "error", err)
+	}
+	return &RateLimiter{
+		policy:   policy,
+		buckets:  make(map[string]*bucket),
+		requests: requests,
+		logger:   logger,
+	}
+}
+
+// SetRate changes the rate and burst, the buckets keep their tokens up to the
+// new burst
+func (l *RateLimiter) SetRate(rate float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.policy.Rate = rate
+	l.policy.Burst = burst
+}
+
+// Middleware rejects requests exceeding the policy with 429 and Retry-After
+func (l *RateLimiter) Middleware(h http.Handler) http.Handler {
+	name := l.policy.Name
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var span trace.Span
+		ctx := r.Context()
+		ctx, span = tracer.Start(ctx, "middleware.RateLimit")
+
+		key := l.policy.Key(r)
+		allowed, wait := l.take(key, time.Now())
+		result := "allowed"
+		if !allowed {
+			result = "limited"
+		}
+		if l.requests != nil {
+			l.requests.Add(ctx, 1, metric.WithAttributes(
+				attribute.String("policy", name),
+				attribute.String("result", result),
+			))
+		}
+		span.SetAttributes(attribute.String("ratelimit.policy", name), attribute.String("ratelimit.result", result))
+		span.End()
+
+		if !allowed {
+			l.logger.WarnContext(ctx, "rate limit exceeded", "policy", name, "key", key)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			Error(w, r, "too many requests, try again later", http.StatusTooManyRequests)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// RateLimit rejects requests exceeding the policy with 429 and Retry-After,
+// all handlers wrapped by the returned middleware share the buckets
+func RateLimit(policy RatePolicy, logger *slog.Logger) func(h http.Handler) http.Handler {
+	return NewRateLimiter(policy, logger).Middleware
+}
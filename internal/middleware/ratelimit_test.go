@@ -0,0 +1,181 @@
+package middleware_test
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/led0nk/guestbook/internal/middleware"
+)
+
+func TestClientIP(t *testing.T) {
+	clientIP, err := middleware.NewClientIP([]string{"10.0.0.0/8", "192.168.1.1"})
+	if err != nil {
+		t.Fatalf("Error creating client ip: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded []string
+		expected  string
+	}{
+		{
+			name:     "Direct client",
+			remote:   "203.0.113.7:1234",
+			expected: "203.0.113.7",
+		},
+		{
+			name:      "Forwarded header from untrusted client is ignored",
+			remote:    "203.0.113.7:1234",
+			forwarded: []string{"198.51.100.1"},
+			expected:  "203.0.113.7",
+		},
+		{
+			name:      "Trusted proxy",
+			remote:    "10.1.2.3:1234",
+			forwarded: []string{"198.51.100.1"},
+			expected:  "198.51.100.1",
+		},
+		{
+			name:      "Chain of trusted proxies",
+			remote:    "10.1.2.3:1234",
+			forwarded: []string{"6.6.6.6, 198.51.100.1, 192.168.1.1"},
+			expected:  "198.51.100.1",
+		},
+		{
+			name:      "Multiple headers",
+			remote:    "10.1.2.3:1234",
+			forwarded: []string{"6.6.6.6", "198.51.100.1"},
+			expected:  "198.51.100.1",
+		},
+		{
+			name:      "Only trusted hops",
+			remote:    "10.1.2.3:1234",
+			forwarded: []string{"10.0.0.1"},
+			expected:  "10.0.0.1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = test.remote
+			for _, header := range test.forwarded {
+				req.Header.Add("X-Forwarded-For", header)
+			}
+			if ip := clientIP.IP(req); ip != test.expected {
+				t.Errorf("Expected %s, got %s", test.expected, ip)
+			}
+		})
+	}
+
+	if _, err := middleware.NewClientIP([]string{"not-an-ip"}); err == nil {
+		t.Errorf("Expected error for invalid trusted proxy")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	limit := middleware.RateLimit(middleware.RatePolicy{
+		Name:  "test",
+		Rate:  0.001,
+		Burst: 2,
+		Key:   middleware.ByIP(nil),
+	}, slog.Default())
+	handler := limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	do := func(remote string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remote
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := do("203.0.113.7:1234"); rec.Code != http.StatusNoContent {
+			t.Fatalf("Expected status %d for request %d, got %d", http.StatusNoContent, i, rec.Code)
+		}
+	}
+	rec := do("203.0.113.7:4321")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status %d, got %d", http.StatusTooManyRequests, rec.Code)
+	}
+	if rec.Header().Get("Retry-After") != "1000" {
+		t.Errorf("Expected Retry-After of 1000 seconds, got %q", rec.Header().Get("Retry-After"))
+	}
+	if rec := do("203.0.113.8:1234"); rec.Code != http.StatusNoContent {
+		t.Errorf("Expected other clients not to be limited, got %d", rec.Code)
+	}
+}
+
+func TestRateLimiterSetRate(t *testing.T) {
+	limiter := middleware.NewRateLimiter(middleware.RatePolicy{
+		Name:  "test",
+		Rate:  0.001,
+		Burst: 1,
+		Key:   middleware.ByRoute(),
+	}, slog.Default())
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	do := func() int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec.Code
+	}
+
+	if code := do(); code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d", http.StatusNoContent, code)
+	}
+	if code := do(); code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status %d, got %d", http.StatusTooManyRequests, code)
+	}
+
+	limiter.SetRate(1000, 1)
+	time.Sleep(10 * time.Millisecond)
+	if code := do(); code != http.StatusNoContent {
+		t.Errorf("Expected the new rate to refill the bucket, got %d", code)
+	}
+}
+
+func TestByRoute(t *testing.T) {
+	limiter := middleware.NewRateLimiter(middleware.RatePolicy{
+		Name:  "test",
+		Rate:  0.001,
+		Burst: 1,
+		Key:   middleware.ByRoute(),
+	}, slog.Default())
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux := http.NewServeMux()
+	mux.Handle("GET /login/oidc/{provider}", limiter.Middleware(ok))
+	mux.Handle("GET /login/oidc/{provider}/callback", limiter.Middleware(ok))
+
+	do := func(target string) int {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec.Code
+	}
+
+	tests := []struct {
+		name     string
+		target   string
+		expected int
+	}{
+		{name: "First route", target: "/login/oidc/google", expected: http.StatusNoContent},
+		{name: "Second route", target: "/login/oidc/google/callback", expected: http.StatusNoContent},
+		{name: "Same pattern", target: "/login/oidc/github", expected: http.StatusTooManyRequests},
+	}
+	for _, test := range tests {
+		if code := do(test.target); code != test.expected {
+			t.Errorf("%s: Expected status %d, got %d", test.name, test.expected, code)
+		}
+	}
+}
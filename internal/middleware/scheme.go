@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type secureKey struct{}
+
+// Scheme records whether the client connected via HTTPS, X-Forwarded-Proto
+// is only trusted from trusted proxies
+func Scheme(c *ClientIP) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			secure := r.TLS != nil
+			if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" && c.trustsRemote(r) {
+				secure = strings.EqualFold(strings.TrimSpace(strings.Split(proto, ",")[0]), "https")
+			}
+			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), secureKey{}, secure)))
+		})
+	}
+}
+
+// trustsRemote reports whether the direct peer of r is a trusted proxy
+func (c *ClientIP) trustsRemote(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remote := net.ParseIP(host)
+	return c != nil && remote != nil && c.isTrusted(remote)
+}
+
+// IsSecure reports whether the client of r connected via HTTPS
+func IsSecure(r *http.Request) bool {
+	if secure, ok := r.Context().Value(secureKey{}).(bool); ok {
+		return secure
+	}
+	return r.TLS != nil
+}
+
+// SetCookie sets the cookie with the Secure flag of the scheme of r, so
+// cookies keep working over plain HTTP in development
+func SetCookie(w http.ResponseWriter, r *http.Request, cookie *http.Cookie) {
+	cookie.Secure = IsSecure(r)
+	http.SetCookie(w, cookie)
+}
+
+// HSTS tells browsers to only use HTTPS for maxAge, it is only sent on secure
+// requests and disabled by a maxAge of 0
+func HSTS(maxAge time.Duration) func(http.Handler) http.Handler {
+	value := "max-age=" + strconv.Itoa(int(maxAge.Seconds())) + "; includeSubDomains"
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if maxAge > 0 && IsSecure(r) {
+				w.Header().Set("Strict-Transport-Security", value)
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RedirectHTTPS redirects to the same URL on HTTPS, port is the port of the
+// HTTPS listener and omitted if it is 443
+func RedirectHTTPS(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		} else {
+			// IPv6 without port, it is bracketed again below
+			host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			// IPv6 needs brackets
+			host = "[" + host + "]"
+		}
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,83 @@
+package middleware_test
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/led0nk/guestbook/internal/middleware"
+)
+
+func TestScheme(t *testing.T) {
+	clientIP, err := middleware.NewClientIP([]string{"10.0.0.1"})
+	if err != nil {
+		t.Fatalf("Error creating client IP: %v", err)
+	}
+	handler := middleware.Scheme(clientIP)(middleware.HSTS(time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		middleware.SetCookie(w, r, &http.Cookie{Name: "session", Value: "token"})
+	})))
+
+	tests := []struct {
+		name   string
+		remote string
+		tls    bool
+		proto  string
+		secure bool
+	}{
+		{name: "Plain", remote: "203.0.113.7:1234"},
+		{name: "TLS", remote: "203.0.113.7:1234", tls: true, secure: true},
+		{name: "Trusted proxy", remote: "10.0.0.1:1234", proto: "https", secure: true},
+		{name: "Trusted proxy over HTTP", remote: "10.0.0.1:1234", tls: true, proto: "http"},
+		{name: "Untrusted proxy", remote: "203.0.113.7:1234", proto: "https"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = test.remote
+			if test.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			if test.proto != "" {
+				req.Header.Set("X-Forwarded-Proto", test.proto)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			cookies := rec.Result().Cookies()
+			if len(cookies) != 1 || cookies[0].Secure != test.secure {
+				t.Errorf("Expected secure cookie %t, got %+v", test.secure, cookies)
+			}
+			if hsts := rec.Header().Get("Strict-Transport-Security"); (hsts != "") != test.secure {
+				t.Errorf("Expected HSTS %t, got %q", test.secure, hsts)
+			}
+		})
+	}
+}
+
+func TestRedirectHTTPS(t *testing.T) {
+	tests := []struct {
+		name     string
+		port     string
+		host     string
+		expected string
+	}{
+		{name: "Default port", port: "443", host: "guestbook.test", expected: "https://guestbook.test/login?next=1"},
+		{name: "Other port", port: "8443", host: "guestbook.test:8080", expected: "https://guestbook.test:8443/login?next=1"},
+		{name: "IPv6", port: "443", host: "[::1]:8080", expected: "https://[::1]/login?next=1"},
+		{name: "IPv6 without port", port: "443", host: "[::1]", expected: "https://[::1]/login?next=1"},
+		{name: "IPv6 without port on other port", port: "8443", host: "[::1]", expected: "https://[::1]:8443/login?next=1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/login?next=1", nil)
+			req.Host = test.host
+			rec := httptest.NewRecorder()
+			middleware.RedirectHTTPS(test.port).ServeHTTP(rec, req)
+			if rec.Code != http.StatusPermanentRedirect || rec.Header().Get("Location") != test.expected {
+				t.Errorf("Expected redirect to %s, got %d %s", test.expected, rec.Code, rec.Header().Get("Location"))
+			}
+		})
+	}
+}
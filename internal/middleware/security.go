@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/led0nk/guestbook/internal/secrets"
+)
+
+type nonceKey struct{}
+
+// NoncePlaceholder in a Content-Security-Policy is replaced by the nonce of the
+// request
+const NoncePlaceholder = "{nonce}"
+
+// SecurityHeaders of a response, empty fields are not sent
+type SecurityHeaders struct {
+	CSP                string
+	FrameOptions       string
+	ReferrerPolicy     string
+	PermissionsPolicy  string
+	ContentTypeOptions string
+}
+
+// Merge returns h with the non-empty fields of override, a field of "-"
+// removes the header
+func (h SecurityHeaders) Merge(override SecurityHeaders) SecurityHeaders {
+	merge := func(value, override string) string {
+		switch override {
+		case "":
+			return value
+		case "-":
+			return ""
+		}
+		return override
+	}
+	return SecurityHeaders{
+		CSP:                merge(h.CSP, override.CSP),
+		FrameOptions:       merge(h.FrameOptions, override.FrameOptions),
+		ReferrerPolicy:     merge(h.ReferrerPolicy, override.ReferrerPolicy),
+		PermissionsPolicy:  merge(h.PermissionsPolicy, override.PermissionsPolicy),
+		ContentTypeOptions: merge(h.ContentTypeOptions, override.ContentTypeOptions),
+	}
+}
+
+// SecurityPolicy sends the Default headers, merged with the Routes entries
+// whose path prefixes match the request, from the shortest to the longest
+type SecurityPolicy struct {
+	Default SecurityHeaders
+	Routes  map[string]SecurityHeaders
+}
+
+var DefaultSecurityPolicy = SecurityPolicy{
+	Default: SecurityHeaders{
+		CSP: "default-src 'self'; " +
+			// only the pinned htmx of index.html, its integrity is checked as well
+			"script-src 'self' 'nonce-" + NoncePlaceholder + "' https://unpkg.com/htmx.org@1.9.10/dist/htmx.min.js; " +
+			// htmx injects styles
+			"style-src 'self' 'unsafe-inline' https://cdnjs.cloudflare.com; " +
+			"font-src 'self' https://cdnjs.cloudflare.com; " +
+			"img-src 'self' data: https:; " +
+			"connect-src 'self'; " +
+			"frame-ancestors 'none'; base-uri 'self'; form-action 'self'; object-src 'none'; " +
+			"report-uri /csp-report",
+		FrameOptions:       "DENY",
+		ReferrerPolicy:     "same-origin",
+		PermissionsPolicy:  "camera=(), microphone=(), geolocation=(), payment=(), publickey-credentials-get=(self), publickey-credentials-create=(self)",
+		ContentTypeOptions: "nosniff",
+	},
+}
+
+// For returns the headers of the route of path
+func (p SecurityPolicy) For(path string) SecurityHeaders {
+	prefixes := []string{}
+	for prefix := range p.Routes {
+		if strings.HasPrefix(path, prefix) {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	sort.Slice(prefixes, func(i, j int) bool { return len(prefixes[i]) < len(prefixes[j]) })
+	headers := p.Default
+	for _, prefix := range prefixes {
+		headers = headers.Merge(p.Routes[prefix])
+	}
+	return headers
+}
+
+// Security sets the headers of policy and creates the nonce of the
+// Content-Security-Policy, which is returned by Nonce
+func Security(policy SecurityPolicy) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			nonce, err := secrets.Nonce.Generate()
+			if err != nil {
+				http.Error(w, "failed to create nonce", http.StatusInternalServerError)
+				return
+			}
+			headers := policy.For(r.URL.Path)
+			set := func(name, value string) {
+				if value != "" {
+					w.Header().Set(name, value)
+				}
+			}
+			set("Content-Security-Policy", strings.ReplaceAll(headers.CSP, NoncePlaceholder, nonce))
+			set("X-Frame-Options", headers.FrameOptions)
+			set("Referrer-Policy", headers.ReferrerPolicy)
+			set("Permissions-Policy", headers.PermissionsPolicy)
+			set("X-Content-Type-Options", headers.ContentTypeOptions)
+			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), nonceKey{}, nonce)))
+		})
+	}
+}
+
+// Nonce returns the nonce which allows inline scripts in the response to r
+func Nonce(r *http.Request) string {
+	nonce, _ := r.Context().Value(nonceKey{}).(string)
+	return nonce
+}
@@ -0,0 +1,68 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/led0nk/guestbook/internal/middleware"
+)
+
+func TestSecurity(t *testing.T) {
+	policy := middleware.SecurityPolicy{
+		Default: middleware.SecurityHeaders{
+			CSP:                "script-src 'nonce-{nonce}'",
+			FrameOptions:       "DENY",
+			ReferrerPolicy:     "same-origin",
+			ContentTypeOptions: "nosniff",
+		},
+		Routes: map[string]middleware.SecurityHeaders{
+			"/embed":       {FrameOptions: "-", CSP: "frame-ancestors *"},
+			"/embed/admin": {FrameOptions: "SAMEORIGIN"},
+		},
+	}
+	var nonce string
+	handler := middleware.Security(policy)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce = middleware.Nonce(r)
+	}))
+
+	tests := []struct {
+		name    string
+		path    string
+		csp     string
+		options string
+	}{
+		{name: "Default", path: "/login", csp: "script-src 'nonce-{nonce}'", options: "DENY"},
+		{name: "Route", path: "/embed/1", csp: "frame-ancestors *"},
+		{name: "Longest prefix", path: "/embed/admin", csp: "frame-ancestors *", options: "SAMEORIGIN"},
+	}
+	seen := map[string]bool{}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.path, nil))
+
+			if nonce == "" || seen[nonce] {
+				t.Fatalf("Expected a new nonce, got %q", nonce)
+			}
+			seen[nonce] = true
+			csp := strings.ReplaceAll(test.csp, middleware.NoncePlaceholder, nonce)
+			if got := rec.Header().Get("Content-Security-Policy"); got != csp {
+				t.Errorf("Expected CSP %q, got %q", csp, got)
+			}
+			if got := rec.Header().Get("X-Frame-Options"); got != test.options {
+				t.Errorf("Expected X-Frame-Options %q, got %q", test.options, got)
+			}
+			if _, ok := rec.Header()["X-Frame-Options"]; !ok && test.options != "" {
+				t.Errorf("Expected X-Frame-Options to be sent")
+			}
+			if got := rec.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+				t.Errorf("Expected X-Content-Type-Options nosniff, got %q", got)
+			}
+			if got := rec.Header().Get("Permissions-Policy"); got != "" {
+				t.Errorf("Expected no Permissions-Policy, got %q", got)
+			}
+		})
+	}
+}
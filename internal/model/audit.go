@@ -0,0 +1,82 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuditAction names what happened in an AuditEvent
+type AuditAction string
+
+const (
+	ActionLogin             AuditAction = "login"
+	ActionLoginFailed       AuditAction = "login.failed"
+	ActionLogout            AuditAction = "logout"
+	ActionSignup            AuditAction = "signup"
+	ActionVerify            AuditAction = "verify"
+	ActionUserUpdate        AuditAction = "user.update"
+	ActionUserDelete        AuditAction = "user.delete"
+	ActionUserUnlock        AuditAction = "user.unlock"
+	ActionEmailChange       AuditAction = "email.change"
+	ActionEmailConfirm      AuditAction = "email.confirm"
+	ActionPasswordChange    AuditAction = "password.change"
+	ActionPasswordReset     AuditAction = "password.reset"
+	ActionPasskeyRegister   AuditAction = "passkey.register"
+	ActionDeletionRequest   AuditAction = "deletion.request"
+	ActionDeletionCancel    AuditAction = "deletion.cancel"
+	ActionInvitationCreate  AuditAction = "invitation.create"
+	ActionInvitationDelete  AuditAction = "invitation.delete"
+	ActionEntryCreate       AuditAction = "entry.create"
+	ActionEntryUpdate       AuditAction = "entry.update"
+	ActionEntryDelete       AuditAction = "entry.delete"
+	ActionAuditExport       AuditAction = "audit.export"
+	ActionAccountDataExport AuditAction = "account.export"
+	ActionConfigReload      AuditAction = "config.reload"
+)
+
+// AuditEvent records who did what to which object, events are never changed
+// after they were appended
+type AuditEvent struct {
+	ID     uuid.UUID   `json:"id"`
+	Time   time.Time   `json:"time"`
+	Action AuditAction `json:"action"`
+	// user who caused the event, empty for the system
+	Actor uuid.UUID `json:"actor"`
+	// object of the event, e.g. a user or an invitation
+	Target     uuid.UUID `json:"target"`
+	TargetType string    `json:"targettype"`
+	Changes    []Change  `json:"changes,omitempty"`
+	IP         string    `json:"ip,omitempty"`
+	TraceID    string    `json:"traceid,omitempty"`
+}
+
+// Change is the difference of a field before and after an AuditEvent
+type Change struct {
+	Field  string `json:"field"`
+	Before any    `json:"before"`
+	After  any    `json:"after"`
+}
+
+// AuditFilter selects AuditEvents, zero fields match every event
+type AuditFilter struct {
+	Actor  uuid.UUID
+	Target uuid.UUID
+	Since  time.Time
+	Until  time.Time
+}
+
+// Match reports whether the event is selected by the filter
+func (f AuditFilter) Match(event *AuditEvent) bool {
+	switch {
+	case f.Actor != uuid.Nil && event.Actor != f.Actor:
+		return false
+	case f.Target != uuid.Nil && event.Target != f.Target:
+		return false
+	case !f.Since.IsZero() && event.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !event.Time.Before(f.Until):
+		return false
+	}
+	return true
+}
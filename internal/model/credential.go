@@ -0,0 +1,23 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Credential struct {
+	ID              uuid.UUID `json:"id" form:"-"`
+	UserID          uuid.UUID `json:"userid" form:"-"`
+	CredentialID    []byte    `json:"credentialid"`
+	PublicKey       []byte    `json:"publickey"`
+	AttestationType string    `json:"attestationtype"`
+	Transport       []string  `json:"transport"`
+	AAGUID          []byte    `json:"aaguid"`
+	SignCount       uint32    `json:"signcount"`
+	CloneWarning    bool      `json:"clonewarning"`
+	BackupEligible  bool      `json:"backupeligible"`
+	BackupState     bool      `json:"backupstate"`
+	CreatedAt       time.Time `json:"createdat"`
+	LastUsedAt      time.Time `json:"lastusedat"`
+}
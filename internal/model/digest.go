@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// Digest summarizes the activity in the guestbook since a point in time
+type Digest struct {
+	Since   time.Time
+	Entries []*GuestbookEntry
+	Signups int
+}
+
+// Empty reports whether nothing happened since the last digest
+func (d *Digest) Empty() bool {
+	return len(d.Entries) == 0 && d.Signups == 0
+}
@@ -0,0 +1,27 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Invitation allows to sign up while the registration is invite-only
+type Invitation struct {
+	ID   uuid.UUID `json:"id"`
+	Code string    `json:"code"`
+	// restricts the invitation to this address if set
+	Email string `json:"email,omitempty"`
+	// language of the invitation mail
+	Locale    string    `json:"locale,omitempty"`
+	MaxUses   int       `json:"maxuses"`
+	Uses      int       `json:"uses"`
+	ExpiresAt time.Time `json:"expiresat"`
+	CreatedBy uuid.UUID `json:"createdby"`
+	CreatedAt time.Time `json:"createdat"`
+}
+
+// Valid reports whether the invitation can still be used
+func (i *Invitation) Valid() bool {
+	return i.Uses < i.MaxUses && time.Now().Before(i.ExpiresAt)
+}
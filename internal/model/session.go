@@ -0,0 +1,13 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session describes a login of a user without its secret token
+type Session struct {
+	UserID    uuid.UUID `json:"userid"`
+	ExpiresAt time.Time `json:"expiresat"`
+}
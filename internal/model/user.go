@@ -7,13 +7,31 @@ import (
 )
 
 type User struct {
-	ID               uuid.UUID         `json:"id" form:"-"`
-	Email            string            `json:"email"`
-	Name             string            `json:"name"`
-	Password         []byte            `json:"password"`
-	Entry            []*GuestbookEntry `json:"entry"`
-	IsAdmin          bool              `json:"isadmin"`
-	IsVerified       bool              `json:"isverified"`
-	VerificationCode string            `json:"verificationstring"`
-	ExpirationTime   time.Time         `json:"expirationtime"`
+	ID                   uuid.UUID         `json:"id" form:"-"`
+	Email                string            `json:"email"`
+	Name                 string            `json:"name"`
+	Password             []byte            `json:"password" audit:"secret"`
+	Entry                []*GuestbookEntry `json:"entry" audit:"-"`
+	IsAdmin              bool              `json:"isadmin"`
+	IsVerified           bool              `json:"isverified"`
+	VerificationCode     string            `json:"verificationstring" audit:"secret"`
+	ExpirationTime       time.Time         `json:"expirationtime"`
+	VerificationAttempts int               `json:"verificationattempts"`
+	Identities           []Identity        `json:"identities,omitempty"`
+	// address which replaces Email once the change is confirmed
+	PendingEmail          string    `json:"pendingemail,omitempty"`
+	EmailChangeToken      string    `json:"emailchangetoken,omitempty" audit:"secret"`
+	EmailChangeExpiration time.Time `json:"emailchangeexpiration,omitempty"`
+	// preferred language, the locale of the request if empty
+	Locale string `json:"locale,omitempty"`
+	// the account is deleted after this time if set
+	DeleteAt time.Time `json:"deleteat,omitempty"`
+	// end of a temporary lockout after failed attempts, not persisted
+	LockedUntil time.Time `json:"-"`
+}
+
+// Identity links a User to an account of an external identity provider
+type Identity struct {
+	Provider string `json:"provider"`
+	Subject  string `json:"subject"`
 }
@@ -0,0 +1,120 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/oauth2"
+)
+
+var tracer = otel.GetTracerProvider().Tracer("github.com/led0nk/guestbook/internal/oidc")
+
+// Config of a single external identity provider
+type Config struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// Claims of a verified ID token which are needed to create or link a user
+type Claims struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	GivenName     string `json:"given_name"`
+	FamilyName    string `json:"family_name"`
+}
+
+// Provider is an OpenID Connect relying party for one identity provider
+type Provider struct {
+	name     string
+	oauth2   oauth2.Config
+	verifier *gooidc.IDTokenVerifier
+}
+
+// NewProvider runs the discovery against the issuer of cfg
+func NewProvider(ctx context.Context, cfg Config) (*Provider, error) {
+	var span trace.Span
+	ctx, span = tracer.Start(ctx, "NewProvider")
+	defer span.End()
+
+	if cfg.Name == "" || cfg.Issuer == "" || cfg.ClientID == "" {
+		return nil, errors.New("provider requires a name, issuer and client id")
+	}
+	span.AddEvent("discovery")
+	provider, err := gooidc.NewProvider(ctx, cfg.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	scopes := cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"profile", "email"}
+	}
+	return &Provider{
+		name: cfg.Name,
+		oauth2: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       append([]string{gooidc.ScopeOpenID}, scopes...),
+		},
+		verifier: provider.Verifier(&gooidc.Config{ClientID: cfg.ClientID}),
+	}, nil
+}
+
+func (p *Provider) Name() string {
+	return p.name
+}
+
+// GenerateVerifier returns a new PKCE code verifier
+func GenerateVerifier() string {
+	return oauth2.GenerateVerifier()
+}
+
+// AuthCodeURL returns the URL of the identity provider the user is sent to,
+// bound to state, nonce and the PKCE verifier
+func (p *Provider) AuthCodeURL(state string, nonce string, verifier string) string {
+	return p.oauth2.AuthCodeURL(state, gooidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+}
+
+// Exchange redeems the authorization code and verifies the returned ID token
+func (p *Provider) Exchange(ctx context.Context, code string, verifier string, nonce string) (*Claims, error) {
+	var span trace.Span
+	ctx, span = tracer.Start(ctx, "Exchange")
+	defer span.End()
+
+	span.AddEvent("exchange code")
+	token, err := p.oauth2.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, err
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("token response doesn't contain an id_token")
+	}
+
+	span.AddEvent("verify id_token")
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+	if idToken.Nonce != nonce {
+		return nil, errors.New("id_token nonce doesn't match")
+	}
+	claims := &Claims{}
+	if err := idToken.Claims(claims); err != nil {
+		return nil, err
+	}
+	if claims.Email == "" {
+		return nil, errors.New("id_token doesn't contain an email")
+	}
+	return claims, nil
+}
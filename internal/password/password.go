@@ -0,0 +1,230 @@
+package password
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrMismatch      = errors.New("password doesn't match")
+	ErrUnknownFormat = errors.New("unknown password hash format")
+)
+
+// Algorithm hashes passwords into an encoding which carries its parameters
+type Algorithm interface {
+	Name() string
+	Hash(password []byte) ([]byte, error)
+	// Identifies reports whether hash was created by this algorithm
+	Identifies(hash []byte) bool
+	Verify(hash []byte, password []byte) error
+	// NeedsRehash reports whether hash was created with other parameters
+	NeedsRehash(hash []byte) bool
+	// MaxLength is the maximum length of a password in bytes
+	MaxLength() int
+}
+
+// Argon2id hashes in the PHC string format
+// $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<key>
+type Argon2id struct {
+	Time uint32
+	// memory in KiB
+	Memory  uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+// DefaultArgon2id follows the recommendation of RFC 9106 for memory
+// constrained environments
+var DefaultArgon2id = Argon2id{Time: 3, Memory: 64 * 1024, Threads: 4, SaltLen: 16, KeyLen: 32}
+
+const argon2Prefix = "$argon2id$"
+
+func (a Argon2id) Name() string {
+	return "argon2id"
+}
+
+func (a Argon2id) Hash(password []byte) ([]byte, error) {
+	salt := make([]byte, a.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key := argon2.IDKey(password, salt, a.Time, a.Memory, a.Threads, a.KeyLen)
+	return []byte(fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2Prefix, argon2.Version, a.Memory, a.Time, a.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)), nil
+}
+
+func (a Argon2id) Identifies(hash []byte) bool {
+	return bytes.HasPrefix(hash, []byte(argon2Prefix))
+}
+
+// decode returns the parameters, salt and key of an encoded hash
+func (a Argon2id) decode(hash []byte) (Argon2id, []byte, []byte, error) {
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return Argon2id{}, nil, nil, ErrUnknownFormat
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return Argon2id{}, nil, nil, err
+	}
+	if version != argon2.Version {
+		return Argon2id{}, nil, nil, errors.New("unsupported argon2 version")
+	}
+	params := Argon2id{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return Argon2id{}, nil, nil, err
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2id{}, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return Argon2id{}, nil, nil, err
+	}
+	params.SaltLen = uint32(len(salt))
+	params.KeyLen = uint32(len(key))
+	return params, salt, key, nil
+}
+
+func (a Argon2id) Verify(hash []byte, password []byte) error {
+	params, salt, key, err := a.decode(hash)
+	if err != nil {
+		return err
+	}
+	other := argon2.IDKey(password, salt, params.Time, params.Memory, params.Threads, params.KeyLen)
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+func (a Argon2id) NeedsRehash(hash []byte) bool {
+	params, _, _, err := a.decode(hash)
+	if err != nil {
+		return true
+	}
+	return params != a
+}
+
+// argon2 has no inherent limit, this only bounds the work per request
+func (a Argon2id) MaxLength() int {
+	return 1024
+}
+
+// Bcrypt hashes in the modular crypt format $2a$<cost>$<salt+hash>
+type Bcrypt struct {
+	Cost int
+}
+
+// DefaultBcrypt keeps the cost used for existing hashes
+var DefaultBcrypt = Bcrypt{Cost: 14}
+
+func (b Bcrypt) Name() string {
+	return "bcrypt"
+}
+
+func (b Bcrypt) Hash(password []byte) ([]byte, error) {
+	return bcrypt.GenerateFromPassword(password, b.Cost)
+}
+
+func (b Bcrypt) Identifies(hash []byte) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		if bytes.HasPrefix(hash, []byte(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b Bcrypt) Verify(hash []byte, password []byte) error {
+	err := bcrypt.CompareHashAndPassword(hash, password)
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrMismatch
+	}
+	return err
+}
+
+func (b Bcrypt) NeedsRehash(hash []byte) bool {
+	cost, err := bcrypt.Cost(hash)
+	return err != nil || cost != b.Cost
+}
+
+func (b Bcrypt) MaxLength() int {
+	return 72
+}
+
+// Hasher creates new hashes with the preferred algorithm and verifies hashes
+// of every known algorithm
+type Hasher struct {
+	preferred  Algorithm
+	algorithms []Algorithm
+}
+
+func NewHasher(preferred Algorithm, others ...Algorithm) *Hasher {
+	return &Hasher{
+		preferred:  preferred,
+		algorithms: append([]Algorithm{preferred}, others...),
+	}
+}
+
+// New returns a Hasher preferring the algorithm with name, which still
+// verifies hashes of the other algorithm
+func New(name string, bcryptCost int, argon Argon2id) (*Hasher, error) {
+	if bcryptCost < bcrypt.MinCost || bcryptCost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	if argon.Time < 1 || argon.Threads < 1 || argon.Memory < 8*uint32(argon.Threads) {
+		return nil, errors.New("argon2id needs at least one iteration and thread and 8 KiB memory per thread")
+	}
+	bcryptAlgorithm := Bcrypt{Cost: bcryptCost}
+	switch name {
+	case "argon2id":
+		return NewHasher(argon, bcryptAlgorithm), nil
+	case "bcrypt":
+		return NewHasher(bcryptAlgorithm, argon), nil
+	}
+	return nil, errors.New("unknown password hash algorithm: " + name)
+}
+
+func (h *Hasher) Hash(password []byte) ([]byte, error) {
+	if len(password) > h.preferred.MaxLength() {
+		return nil, errors.New("password is too long")
+	}
+	return h.preferred.Hash(password)
+}
+
+// Verify checks password against hash and reports whether the hash should be
+// replaced by a new one of the preferred algorithm
+func (h *Hasher) Verify(hash []byte, password []byte) (bool, error) {
+	for _, algorithm := range h.algorithms {
+		if !algorithm.Identifies(hash) {
+			continue
+		}
+		if len(password) > algorithm.MaxLength() {
+			return false, ErrMismatch
+		}
+		if err := algorithm.Verify(hash, password); err != nil {
+			return false, err
+		}
+		return algorithm != h.preferred || h.preferred.NeedsRehash(hash), nil
+	}
+	return false, ErrUnknownFormat
+}
+
+// MaxLength is the maximum password length of the preferred algorithm
+func (h *Hasher) MaxLength() int {
+	return h.preferred.MaxLength()
+}
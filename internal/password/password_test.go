@@ -0,0 +1,104 @@
+package password_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/led0nk/guestbook/internal/password"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	testArgon2id = password.Argon2id{Time: 1, Memory: 64, Threads: 1, SaltLen: 16, KeyLen: 32}
+	testBcrypt   = password.Bcrypt{Cost: bcrypt.MinCost}
+)
+
+func TestHasher(t *testing.T) {
+	argonHasher := password.NewHasher(testArgon2id, testBcrypt)
+	bcryptHasher := password.NewHasher(testBcrypt, testArgon2id)
+	argonHash, err := argonHasher.Hash([]byte("password123"))
+	if err != nil {
+		t.Fatalf("Error hashing password: %v", err)
+	}
+	bcryptHash, err := bcryptHasher.Hash([]byte("password123"))
+	if err != nil {
+		t.Fatalf("Error hashing password: %v", err)
+	}
+	strongerHash, err := password.NewHasher(password.Argon2id{Time: 2, Memory: 64, Threads: 1, SaltLen: 16, KeyLen: 32}).Hash([]byte("password123"))
+	if err != nil {
+		t.Fatalf("Error hashing password: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		hasher   *password.Hasher
+		hash     []byte
+		password string
+		rehash   bool
+		expected error
+	}{
+		{name: "Argon2id", hasher: argonHasher, hash: argonHash, password: "password123"},
+		{name: "Bcrypt", hasher: bcryptHasher, hash: bcryptHash, password: "password123"},
+		{name: "Bcrypt upgraded to argon2id", hasher: argonHasher, hash: bcryptHash, password: "password123", rehash: true},
+		{name: "Argon2id with other parameters", hasher: argonHasher, hash: strongerHash, password: "password123", rehash: true},
+		{name: "Wrong argon2id password", hasher: argonHasher, hash: argonHash, password: "password124", expected: password.ErrMismatch},
+		{name: "Wrong bcrypt password", hasher: argonHasher, hash: bcryptHash, password: "password124", expected: password.ErrMismatch},
+		{name: "Empty hash", hasher: argonHasher, hash: nil, password: "password123", expected: password.ErrUnknownFormat},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rehash, err := test.hasher.Verify(test.hash, []byte(test.password))
+			if !errors.Is(err, test.expected) {
+				t.Fatalf("Expected error %v, got %v", test.expected, err)
+			}
+			if rehash != test.rehash {
+				t.Errorf("Expected rehash %v, got %v", test.rehash, rehash)
+			}
+		})
+	}
+}
+
+func TestArgon2idEncoding(t *testing.T) {
+	hash, err := testArgon2id.Hash([]byte("password123"))
+	if err != nil {
+		t.Fatalf("Error hashing password: %v", err)
+	}
+	if !strings.HasPrefix(string(hash), "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("Expected parameters to be encoded, got %s", hash)
+	}
+	other, err := testArgon2id.Hash([]byte("password123"))
+	if err != nil {
+		t.Fatalf("Error hashing password: %v", err)
+	}
+	if string(hash) == string(other) {
+		t.Errorf("Expected different salts for every hash")
+	}
+}
+
+func TestMaxLength(t *testing.T) {
+	long := []byte(strings.Repeat("a", 100))
+	if _, err := password.NewHasher(testBcrypt).Hash(long); err == nil {
+		t.Errorf("Expected bcrypt to reject passwords longer than 72 bytes")
+	}
+	hash, err := password.NewHasher(testArgon2id).Hash(long)
+	if err != nil {
+		t.Fatalf("Expected argon2id to accept passwords longer than 72 bytes: %v", err)
+	}
+	if _, err := password.NewHasher(testArgon2id).Verify(hash, long[:99]); !errors.Is(err, password.ErrMismatch) {
+		t.Errorf("Expected prefix of a long password not to match, got %v", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := password.New("md5", 10, testArgon2id); err == nil {
+		t.Errorf("Expected error for unknown algorithm")
+	}
+	if _, err := password.New("bcrypt", 40, testArgon2id); err == nil {
+		t.Errorf("Expected error for invalid bcrypt cost")
+	}
+	if _, err := password.New("argon2id", 10, password.Argon2id{Threads: 1, Memory: 64}); err == nil {
+		t.Errorf("Expected error for zero argon2 iterations")
+	}
+}
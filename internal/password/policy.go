@@ -0,0 +1,133 @@
+package password
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/led0nk/guestbook/internal/i18n"
+)
+
+// Policy defines the requirements for passwords chosen by users
+type Policy struct {
+	MinLength int
+	// minimum number of character classes out of lowercase, uppercase,
+	// digits and symbols
+	MinClasses int
+	// lowercased passwords which are rejected
+	Blocklist map[string]bool
+	// rejects passwords containing the name or email of the user
+	CheckSimilarity bool
+}
+
+// DefaultPolicy is used if nothing else is configured
+var DefaultPolicy = Policy{MinLength: 8, MinClasses: 2, CheckSimilarity: true}
+
+// PolicyError lists the explanations of all violated rules
+type PolicyError struct {
+	Violations []i18n.Message
+}
+
+func (e *PolicyError) Error() string {
+	violations := make([]string, len(e.Violations))
+	for i, violation := range e.Violations {
+		violations[i] = violation.Error()
+	}
+	return strings.Join(violations, ", ")
+}
+
+// LoadBlocklist reads one password per line, empty lines and lines starting
+// with # are skipped
+func LoadBlocklist(path string) (map[string]bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	blocklist := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		blocklist[strings.ToLower(line)] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return blocklist, nil
+}
+
+// Describe returns the rules of the policy for the user
+func (p *Policy) Describe() []i18n.Message {
+	rules := []i18n.Message{i18n.M("password.rule.length", p.MinLength)}
+	if p.MinClasses > 1 {
+		rules = append(rules, i18n.M("password.rule.classes", p.MinClasses))
+	}
+	if len(p.Blocklist) > 0 {
+		rules = append(rules, i18n.M("password.rule.common"))
+	}
+	if p.CheckSimilarity {
+		rules = append(rules, i18n.M("password.rule.similar"))
+	}
+	return rules
+}
+
+// Check returns a *PolicyError if password violates the policy, name and
+// email belong to the user choosing the password
+func (p *Policy) Check(password string, name string, email string) error {
+	violations := []i18n.Message{}
+	if len([]rune(password)) < p.MinLength {
+		violations = append(violations, i18n.M("password.error.short", p.MinLength))
+	}
+	if classes := characterClasses(password); classes < p.MinClasses {
+		violations = append(violations, i18n.M("password.error.classes", p.MinClasses))
+	}
+	lowered := strings.ToLower(password)
+	if p.Blocklist[lowered] {
+		violations = append(violations, i18n.M("password.error.common"))
+	}
+	if p.CheckSimilarity && similar(lowered, name, email) {
+		violations = append(violations, i18n.M("password.error.similar"))
+	}
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+	return nil
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	return lower + upper + digit + symbol
+}
+
+// similar reports whether the lowercased password contains a part of the name
+// or the email with at least 3 characters
+func similar(password string, name string, email string) bool {
+	local, domain, _ := strings.Cut(strings.ToLower(email), "@")
+	parts := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	parts = append(parts, local, strings.Split(domain, ".")[0])
+	for _, part := range parts {
+		if len([]rune(part)) >= 3 && strings.Contains(password, part) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package password_test
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/led0nk/guestbook/internal/password"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	blocklistFile := t.TempDir() + "/blocklist.txt"
+	err := os.WriteFile(blocklistFile, []byte("# common\nWelcome2024!\n\nletmein1\n"), 0644)
+	if err != nil {
+		t.Fatalf("Error writing blocklist: %v", err)
+	}
+	blocklist, err := password.LoadBlocklist(blocklistFile)
+	if err != nil {
+		t.Fatalf("Error loading blocklist: %v", err)
+	}
+	if len(blocklist) != 2 {
+		t.Fatalf("Expected 2 blocked passwords, got %d", len(blocklist))
+	}
+	policy := password.Policy{MinLength: 10, MinClasses: 3, Blocklist: blocklist, CheckSimilarity: true}
+
+	tests := []struct {
+		name       string
+		password   string
+		violations int
+	}{
+		{name: "Valid password", password: "correct-Horse7", violations: 0},
+		{name: "Too short", password: "sh0rt-Pw", violations: 1},
+		{name: "Too few classes", password: "onlylowercaseletters", violations: 1},
+		{name: "Blocked case-insensitive", password: "WELCOME2024!", violations: 1},
+		{name: "Contains name", password: "Jonathan-1234", violations: 1},
+		{name: "Contains email", password: "JDoe99!xyzzy", violations: 1},
+		{name: "Several violations", password: "jdoe99", violations: 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := policy.Check(test.password, "Jonathan Smith", "jdoe99@example.com")
+			if test.violations == 0 {
+				if err != nil {
+					t.Errorf("Expected password to be accepted, got %v", err)
+				}
+				return
+			}
+			var policyErr *password.PolicyError
+			if !errors.As(err, &policyErr) {
+				t.Fatalf("Expected policy error, got %v", err)
+			}
+			if len(policyErr.Violations) != test.violations {
+				t.Errorf("Expected %d violations, got %v", test.violations, policyErr.Violations)
+			}
+		})
+	}
+
+	if rules := policy.Describe(); len(rules) != 4 {
+		t.Errorf("Expected 4 rules to be described, got %v", rules)
+	}
+}
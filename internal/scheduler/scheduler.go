@@ -0,0 +1,267 @@
+// Package scheduler runs named jobs periodically within the process
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.GetTracerProvider().Tracer("github.com/led0nk/guestbook/internal/scheduler")
+var meter = otel.GetMeterProvider().Meter("github.com/led0nk/guestbook/internal/scheduler")
+
+// DefaultJitter spreads the runs of a job by up to a tenth of its interval
+const DefaultJitter = 0.1
+
+var (
+	ErrUnknownJob = errors.New("job doesn't exist")
+	ErrRunning    = errors.New("job is already running")
+	ErrStopped    = errors.New("scheduler is stopped")
+)
+
+// Job is a task which runs every Interval
+type Job struct {
+	Name     string
+	Interval time.Duration
+	// fraction of Interval by which every wait is randomly shortened or
+	// prolonged up to 1, DefaultJitter is used if zero and none if negative
+	Jitter float64
+	Run    func(context.Context) error
+}
+
+// Status describes the state of a job
+type Status struct {
+	Name         string
+	Interval     time.Duration
+	Running      bool
+	LastRun      time.Time
+	LastDuration time.Duration
+	LastError    string
+	NextRun      time.Time
+	Runs         int
+	Failures     int
+	// triggers which were dropped because the job was still running
+	Skipped int
+}
+
+type job struct {
+	Job
+	status Status
+	// true while the job runs, a job never runs concurrently to itself
+	running bool
+}
+
+// Scheduler runs the added jobs after Start until Stop
+type Scheduler struct {
+	log     *slog.Logger
+	jobs    map[string]*job
+	mu      sync.Mutex
+	ctx     context.Context
+	cancel  context.CancelFunc
+	loops   sync.WaitGroup
+	runs    sync.WaitGroup
+	stopped bool
+
+	runCounter metric.Int64Counter
+	duration   metric.Float64Histogram
+}
+
+func New(logger *slog.Logger) *Scheduler {
+	runCounter, _ := meter.Int64Counter(
+		"scheduler.runs",
+		metric.WithDescription("Number of job runs by result"),
+	)
+	duration, _ := meter.Float64Histogram(
+		"scheduler.duration",
+		metric.WithDescription("Duration of job runs"),
+		metric.WithUnit("s"),
+	)
+	return &Scheduler{
+		log:        logger,
+		jobs:       make(map[string]*job),
+		runCounter: runCounter,
+		duration:   duration,
+	}
+}
+
+// Add registers the job, jobs added after Start are started immediately
+func (s *Scheduler) Add(j Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if j.Jitter == 0 {
+		j.Jitter = DefaultJitter
+	}
+	added := &job{Job: j, status: Status{Name: j.Name, Interval: j.Interval}}
+	s.jobs[j.Name] = added
+	if s.ctx != nil && !s.stopped {
+		s.loops.Add(1)
+		go s.loop(added)
+	}
+}
+
+// Start runs every job after its first jittered interval until ctx is done or
+// Stop is called
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ctx != nil {
+		return
+	}
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	for _, j := range s.jobs {
+		s.loops.Add(1)
+		go s.loop(j)
+	}
+}
+
+// Stop cancels the scheduled runs and waits until the running jobs returned
+// or ctx is done
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	s.stopped = true
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.loops.Wait()
+		s.runs.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// RunNow starts the job outside of its schedule and waits for its result
+func (s *Scheduler) RunNow(ctx context.Context, name string) error {
+	s.mu.Lock()
+	j, exists := s.jobs[name]
+	s.mu.Unlock()
+	if !exists {
+		return ErrUnknownJob
+	}
+	return s.run(ctx, j)
+}
+
+// Status returns the state of all jobs sorted by name
+func (s *Scheduler) Status() []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := make([]Status, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		status = append(status, j.status)
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].Name < status[j].Name })
+	return status
+}
+
+func (s *Scheduler) loop(j *job) {
+	defer s.loops.Done()
+
+	for {
+		wait := jitter(j.Interval, j.Jitter)
+		s.mu.Lock()
+		j.status.NextRun = time.Now().Add(wait)
+		s.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-s.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if err := s.run(s.ctx, j); err != nil && !errors.Is(err, ErrRunning) {
+			s.log.Error("job failed", "job", j.Name, "error", err)
+		}
+	}
+}
+
+// run executes the job unless it is already running
+func (s *Scheduler) run(ctx context.Context, j *job) error {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return ErrStopped
+	}
+	if j.running {
+		j.status.Skipped++
+		s.mu.Unlock()
+		s.count(ctx, j.Name, "skipped")
+		return ErrRunning
+	}
+	j.running = true
+	j.status.Running = true
+	s.runs.Add(1)
+	s.mu.Unlock()
+	defer s.runs.Done()
+
+	var span trace.Span
+	ctx, span = tracer.Start(ctx, "scheduler."+j.Name)
+	defer span.End()
+
+	start := time.Now()
+	err := j.Run(ctx)
+	elapsed := time.Since(start)
+
+	s.mu.Lock()
+	j.running = false
+	j.status.Running = false
+	j.status.LastRun = start
+	j.status.LastDuration = elapsed
+	j.status.LastError = ""
+	j.status.Runs++
+	if err != nil {
+		j.status.LastError = err.Error()
+		j.status.Failures++
+	}
+	s.mu.Unlock()
+
+	result := "success"
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		result = "failure"
+	}
+	s.count(ctx, j.Name, result)
+	if s.duration != nil {
+		s.duration.Record(ctx, elapsed.Seconds(), metric.WithAttributes(attribute.String("job", j.Name)))
+	}
+	return err
+}
+
+func (s *Scheduler) count(ctx context.Context, name string, result string) {
+	if s.runCounter != nil {
+		s.runCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("job", name), attribute.String("result", result)))
+	}
+}
+
+// jitter returns interval randomly shortened or prolonged by up to the
+// fraction of it
+func jitter(interval time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 {
+		return interval
+	}
+	fraction = min(fraction, 1)
+	spread := float64(interval) * fraction
+	return interval + time.Duration((rand.Float64()*2-1)*spread)
+}
@@ -0,0 +1,106 @@
+package scheduler_test
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/led0nk/guestbook/internal/scheduler"
+)
+
+func TestSingleFlight(t *testing.T) {
+	ctx := context.Background()
+	s := scheduler.New(slog.Default())
+	started, release := make(chan struct{}), make(chan struct{})
+	s.Add(scheduler.Job{Name: "slow", Interval: time.Hour, Run: func(context.Context) error {
+		close(started)
+		<-release
+		return nil
+	}})
+
+	done := make(chan error)
+	go func() { done <- s.RunNow(ctx, "slow") }()
+	<-started
+	if err := s.RunNow(ctx, "slow"); !errors.Is(err, scheduler.ErrRunning) {
+		t.Errorf("Expected %v, got %v", scheduler.ErrRunning, err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("Expected first run to succeed, got %v", err)
+	}
+	if err := s.RunNow(ctx, "unknown"); !errors.Is(err, scheduler.ErrUnknownJob) {
+		t.Errorf("Expected %v, got %v", scheduler.ErrUnknownJob, err)
+	}
+
+	status := s.Status()
+	if len(status) != 1 || status[0].Runs != 1 || status[0].Skipped != 1 || status[0].Running {
+		t.Errorf("Expected 1 run and 1 skipped trigger, got %+v", status)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	s := scheduler.New(slog.Default())
+	var runs atomic.Int32
+	s.Add(scheduler.Job{Name: "tick", Interval: 10 * time.Millisecond, Jitter: 0.5, Run: func(context.Context) error {
+		runs.Add(1)
+		return nil
+	}})
+	s.Add(scheduler.Job{Name: "failing", Interval: 10 * time.Millisecond, Run: func(context.Context) error {
+		return errors.New("broken")
+	}})
+	s.Start(context.Background())
+
+	deadline := time.Now().Add(time.Second)
+	for runs.Load() < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if runs.Load() < 3 {
+		t.Fatalf("Expected at least 3 runs, got %d", runs.Load())
+	}
+
+	stopCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.Stop(stopCtx); err != nil {
+		t.Fatalf("Error stopping scheduler: %v", err)
+	}
+	stopped := runs.Load()
+	time.Sleep(30 * time.Millisecond)
+	if runs.Load() != stopped {
+		t.Errorf("Expected no runs after stop, got %d more", runs.Load()-stopped)
+	}
+	if err := s.RunNow(context.Background(), "tick"); !errors.Is(err, scheduler.ErrStopped) {
+		t.Errorf("Expected %v, got %v", scheduler.ErrStopped, err)
+	}
+	for _, status := range s.Status() {
+		if status.Name == "failing" && (status.Failures == 0 || status.LastError != "broken") {
+			t.Errorf("Expected failures with last error, got %+v", status)
+		}
+	}
+}
+
+func TestStopWaitsForRunningJob(t *testing.T) {
+	s := scheduler.New(slog.Default())
+	started := make(chan struct{})
+	var finished atomic.Bool
+	s.Add(scheduler.Job{Name: "slow", Interval: time.Millisecond, Jitter: -1, Run: func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		time.Sleep(20 * time.Millisecond)
+		finished.Store(true)
+		return ctx.Err()
+	}})
+	s.Start(context.Background())
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.Stop(ctx); err != nil {
+		t.Fatalf("Error stopping scheduler: %v", err)
+	}
+	if !finished.Load() {
+		t.Errorf("Expected Stop to wait for the running job")
+	}
+}
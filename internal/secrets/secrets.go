@@ -0,0 +1,113 @@
+package secrets
+
+import (
+	"crypto/rand"
+	"errors"
+	"math"
+	"math/big"
+	"strings"
+)
+
+const (
+	Alphanumeric = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	Digits       = "0123456789"
+	// URLSafe only contains characters which need no escaping in URLs
+	URLSafe = Alphanumeric + "-_"
+	// Unambiguous leaves out characters which are easily confused when
+	// typed from paper, like 0/O and 1/I/L
+	Unambiguous = "23456789ABCDEFGHJKMNPQRSTUVWXYZ"
+)
+
+// Spec describes a kind of secret by its alphabet and the entropy it needs
+type Spec struct {
+	Alphabet string
+	// minimum entropy in bits, the length is derived from it
+	Bits int
+	// splits the secret into groups of this size separated by "-"
+	Group int
+	// prepended to the secret, e.g. to recognize API keys
+	Prefix string
+}
+
+var (
+	// VerificationCode is entered by the user after signup
+	VerificationCode = Spec{Alphabet: Alphanumeric, Bits: 35}
+	// Password is a generated password sent on reset
+	Password = Spec{Alphabet: Alphanumeric, Bits: 72}
+	// ResetToken is embedded in reset links
+	ResetToken = Spec{Alphabet: URLSafe, Bits: 256}
+	// RecoveryCode is printed or written down by the user
+	RecoveryCode = Spec{Alphabet: Unambiguous, Bits: 50, Group: 5}
+	// InviteCode allows to sign up while the registration is invite-only
+	InviteCode = Spec{Alphabet: Unambiguous, Bits: 60, Group: 4}
+	// APIKey authenticates API clients
+	APIKey = Spec{Alphabet: Alphanumeric, Bits: 256, Prefix: "gb_"}
+	// TokenSecret signs the session tokens
+	TokenSecret = Spec{Alphabet: URLSafe, Bits: 256}
+	// Nonce allows inline scripts of a single response by the
+	// Content-Security-Policy
+	Nonce = Spec{Alphabet: Alphanumeric, Bits: 128}
+)
+
+// Length returns the number of characters needed for the entropy of s
+func (s Spec) Length() int {
+	if len(s.Alphabet) < 2 || s.Bits <= 0 {
+		return 0
+	}
+	return int(math.Ceil(float64(s.Bits) / math.Log2(float64(len(s.Alphabet)))))
+}
+
+// Generate returns a new secret of kind s
+func (s Spec) Generate() (string, error) {
+	secret, err := String(s.Alphabet, s.Length())
+	if err != nil {
+		return "", err
+	}
+	if s.Group > 0 {
+		groups := []string{}
+		for len(secret) > s.Group {
+			groups = append(groups, secret[:s.Group])
+			secret = secret[s.Group:]
+		}
+		secret = strings.Join(append(groups, secret), "-")
+	}
+	return s.Prefix + secret, nil
+}
+
+// String returns length characters drawn uniformly from the ASCII alphabet
+func String(alphabet string, length int) (string, error) {
+	if len(alphabet) < 2 {
+		return "", errors.New("alphabet needs at least two characters")
+	}
+	if length <= 0 {
+		return "", errors.New("length must be positive")
+	}
+	max := big.NewInt(int64(len(alphabet)))
+	secret := make([]byte, length)
+	for i := range secret {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		secret[i] = alphabet[n.Int64()]
+	}
+	return string(secret), nil
+}
+
+// RecoveryCodes returns n distinct recovery codes
+func RecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, 0, n)
+	seen := make(map[string]bool, n)
+	for len(codes) < n {
+		code, err := RecoveryCode.Generate()
+		if err != nil {
+			return nil, err
+		}
+		if seen[code] {
+			continue
+		}
+		seen[code] = true
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
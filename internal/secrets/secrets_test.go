@@ -0,0 +1,149 @@
+package secrets_test
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/led0nk/guestbook/internal/secrets"
+)
+
+func TestSpecLength(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     secrets.Spec
+		expected int
+	}{
+		{name: "Verification code", spec: secrets.VerificationCode, expected: 6},
+		{name: "Password", spec: secrets.Password, expected: 13},
+		{name: "Reset token", spec: secrets.ResetToken, expected: 43},
+		{name: "Recovery code", spec: secrets.RecoveryCode, expected: 11},
+		{name: "Digits", spec: secrets.Spec{Alphabet: secrets.Digits, Bits: 20}, expected: 7},
+		{name: "Single character alphabet", spec: secrets.Spec{Alphabet: "a", Bits: 20}, expected: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if length := test.spec.Length(); length != test.expected {
+				t.Errorf("Expected length %d, got %d", test.expected, length)
+			}
+		})
+	}
+}
+
+func TestSpecGenerate(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    secrets.Spec
+		pattern string
+	}{
+		{name: "Verification code", spec: secrets.VerificationCode, pattern: `^[a-zA-Z0-9]{6}$`},
+		{name: "Reset token", spec: secrets.ResetToken, pattern: `^[a-zA-Z0-9_-]{43}$`},
+		{name: "Recovery code", spec: secrets.RecoveryCode, pattern: `^[2-9A-HJKMNP-Z]{5}-[2-9A-HJKMNP-Z]{5}-[2-9A-HJKMNP-Z]$`},
+		{name: "API key", spec: secrets.APIKey, pattern: `^gb_[a-zA-Z0-9]{43}$`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			secret, err := test.spec.Generate()
+			if err != nil {
+				t.Fatalf("Error generating secret: %v", err)
+			}
+			if !regexp.MustCompile(test.pattern).MatchString(secret) {
+				t.Errorf("Expected secret to match %s, got %q", test.pattern, secret)
+			}
+		})
+	}
+
+	if _, err := (secrets.Spec{Alphabet: "a", Bits: 20}).Generate(); err == nil {
+		t.Errorf("Expected error for alphabet with a single character")
+	}
+}
+
+// chi-squared test for a uniform distribution of the characters, the
+// critical values belong to a significance level of 0.0001
+func TestStringUniform(t *testing.T) {
+	tests := []struct {
+		name     string
+		alphabet string
+		critical float64
+	}{
+		{name: "Digits", alphabet: secrets.Digits, critical: 33.72},
+		{name: "Unambiguous", alphabet: secrets.Unambiguous, critical: 67.63},
+		{name: "Alphanumeric", alphabet: secrets.Alphanumeric, critical: 110.84},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			const perCharacter = 1000
+			samples := perCharacter * len(test.alphabet)
+			secret, err := secrets.String(test.alphabet, samples)
+			if err != nil {
+				t.Fatalf("Error generating string: %v", err)
+			}
+
+			counts := make(map[rune]int)
+			for _, c := range secret {
+				if !strings.ContainsRune(test.alphabet, c) {
+					t.Fatalf("Character %q is not in the alphabet", c)
+				}
+				counts[c]++
+			}
+			chi := 0.0
+			for _, c := range test.alphabet {
+				diff := float64(counts[c] - perCharacter)
+				chi += diff * diff / perCharacter
+			}
+			if chi > test.critical {
+				t.Errorf("Expected uniform distribution, chi-squared %.2f exceeds %.2f", chi, test.critical)
+			}
+		})
+	}
+}
+
+// the first character of consecutive secrets must not depend on the previous
+// one, tested with a chi-squared test on the pairs
+func TestStringIndependent(t *testing.T) {
+	const perPair = 200
+	alphabet := secrets.Digits
+	pairs := make(map[string]int)
+	previous := ""
+	for i := 0; i < perPair*len(alphabet)*len(alphabet); i++ {
+		secret, err := secrets.String(alphabet, 1)
+		if err != nil {
+			t.Fatalf("Error generating string: %v", err)
+		}
+		if previous != "" {
+			pairs[previous+secret]++
+		}
+		previous = secret
+	}
+	chi := 0.0
+	for _, a := range alphabet {
+		for _, b := range alphabet {
+			diff := float64(pairs[string(a)+string(b)] - perPair)
+			chi += diff * diff / perPair
+		}
+	}
+	// 99 degrees of freedom at a significance level of 0.0001
+	if chi > 160.06 {
+		t.Errorf("Expected independent characters, chi-squared %.2f exceeds 160.06", chi)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := secrets.RecoveryCodes(10)
+	if err != nil {
+		t.Fatalf("Error generating recovery codes: %v", err)
+	}
+	if len(codes) != 10 {
+		t.Fatalf("Expected 10 codes, got %d", len(codes))
+	}
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		if seen[code] {
+			t.Errorf("Expected distinct codes, got %q twice", code)
+		}
+		seen[code] = true
+	}
+}
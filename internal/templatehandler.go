@@ -2,10 +2,19 @@ package templates
 
 import (
 	"embed"
-	"text/template"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"path"
+	"reflect"
+	"time"
+
+	"github.com/led0nk/guestbook/internal/i18n"
 )
 
-// struct for storing premade Templates
+// struct for storing premade Templates, the pages are escaped contextually by
+// html/template, so handlers store and pass unescaped data
 type TemplateHandler struct {
 	TmplHome          *template.Template
 	TmplSearch        *template.Template
@@ -17,45 +26,200 @@ type TemplateHandler struct {
 	TmplDashboardUser *template.Template
 	TmplCreate        *template.Template
 	TmplVerification  *template.Template
-	TmplVerMail       *template.Template
+	TmplMagicLink     *template.Template
+	TmplInvitations   *template.Template
+	TmplAudit         *template.Template
+	TmplJobs          *template.Template
 	TmplAdmin         *template.Template
 	TmplAdminUser     *template.Template
+	Mail              *MailTemplates
+	// static assets of the theme, linked by the asset function
+	Assets *Assets
+}
+
+// MailTemplates are a separate set without the page layout, they are executed
+// by the mailer in the locale of the recipient
+type MailTemplates struct {
+	TmplVerMail           *template.Template
+	TmplPasswordMail      *template.Template
+	TmplMagicLinkMail     *template.Template
+	TmplInvitationMail    *template.Template
+	TmplDigestMail        *template.Template
+	TmplEmailChangeMail   *template.Template
+	TmplEmailChangeNotice *template.Template
 }
 
 //go:embed templates/*
 var templates embed.FS
 
+// Request is what the templates use of the request they are executed for
+type Request struct {
+	// nonce of the Content-Security-Policy
+	Nonce string
+	// locale of the reader, Default if empty
+	Locale i18n.Locale
+}
+
+// functions available in all templates, they are replaced by Execute, except
+// for asset which is bound to the assets of the theme by ParseTheme
+var funcs = Request{}.funcs()
+
+// funcs returns the functions of the templates for the request:
+// nonce, locale, locales, t to translate a key with arguments or an
+// i18n.Message and date to format a time or an entry timestamp
+func (req Request) funcs() template.FuncMap {
+	locale := req.Locale
+	if locale == "" {
+		locale = i18n.Default
+	}
+	return template.FuncMap{
+		"nonce":   func() string { return req.Nonce },
+		"locale":  func() i18n.Locale { return locale },
+		"locales": i18n.Supported,
+		"t": func(key any, args ...any) string {
+			if message, ok := key.(i18n.Message); ok {
+				return locale.T(message.Key, message.Args...)
+			}
+			return locale.T(fmt.Sprint(key), args...)
+		},
+		"date": func(value any) string {
+			switch value := value.(type) {
+			case time.Time:
+				return locale.Date(value)
+			case string:
+				//NOTE: entries store their timestamp as text
+				t, err := time.Parse(time.RFC850, value)
+				if err != nil {
+					return value
+				}
+				return locale.Date(t)
+			}
+			return fmt.Sprint(value)
+		},
+	}
+}
+
+// Execute executes the template, or its associated template name if it is not
+// empty, on a clone whose functions belong to req. tmpl itself is never
+// executed.
+func Execute(w io.Writer, tmpl *template.Template, name string, req Request, data any) error {
+	clone, err := tmpl.Clone()
+	if err != nil {
+		return err
+	}
+	clone.Funcs(req.funcs())
+	if name == "" {
+		return clone.Execute(w, data)
+	}
+	return clone.ExecuteTemplate(w, name, data)
+}
+
+// NewTemplateHandler parses the templates and panics if one is invalid
 func NewTemplateHandler() *TemplateHandler {
-	loggedoutTemplates := []string{"templates/index.html", "templates/header.html"}
-	loggedinTemplates := []string{"templates/index.html", "templates/loggedinheader.html"}
-	adminTemplates := []string{"templates/index.html", "templates/admin/adminheader.html"}
-	homeTemplate := "templates/content.html"
-	searchTemplate := "templates/search.html"
-	searchResultTemplate := []string{"templates/searchResult.html"}
-	loginTemplate := "templates/auth/login.html"
-	forgotTemplate := "templates/auth/forgot.html"
-	signupTemplate := "templates/auth/signup.html"
-	dashboardTemplate := "templates/user/dashboard.html"
-	dashboardUserTemplate := []string{"templates/user/userBlocks.html"}
-	createTemplate := "templates/create.html"
-	verificationTemplate := "templates/auth/verification.html"
-	verMailTemplate := []string{"templates/auth/verMail.html"}
-	adminTemplate := "templates/admin/admin.html"
-	adminUserTemplate := []string{"templates/admin/adminUserBlocks.html"}
-
-	return &TemplateHandler{
-		TmplHome:          template.Must(template.ParseFS(templates, append(loggedoutTemplates, homeTemplate)...)),
-		TmplSearch:        template.Must(template.ParseFS(templates, append(loggedinTemplates, searchTemplate)...)),
-		TmplSearchResult:  template.Must(template.ParseFS(templates, searchResultTemplate...)),
-		TmplLogin:         template.Must(template.ParseFS(templates, append(loggedoutTemplates, loginTemplate)...)),
-		TmplForgot:        template.Must(template.ParseFS(templates, append(loggedoutTemplates, forgotTemplate)...)),
-		TmplSignUp:        template.Must(template.ParseFS(templates, append(loggedoutTemplates, signupTemplate)...)),
-		TmplDashboard:     template.Must(template.ParseFS(templates, append(loggedinTemplates, dashboardTemplate)...)),
-		TmplDashboardUser: template.Must(template.ParseFS(templates, dashboardUserTemplate...)),
-		TmplCreate:        template.Must(template.ParseFS(templates, append(loggedinTemplates, createTemplate)...)),
-		TmplVerification:  template.Must(template.ParseFS(templates, append(loggedoutTemplates, verificationTemplate)...)),
-		TmplVerMail:       template.Must(template.ParseFS(templates, verMailTemplate...)),
-		TmplAdmin:         template.Must(template.ParseFS(templates, append(adminTemplates, adminTemplate)...)),
-		TmplAdminUser:     template.Must(template.ParseFS(templates, adminUserTemplate...)),
+	t, err := ParseTemplates()
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// ParseTemplates parses the embedded templates
+func ParseTemplates() (*TemplateHandler, error) {
+	return ParseTheme(Theme{})
+}
+
+// ParseTheme parses all templates and loads the static assets of the theme,
+// e.g. to replace the handler at runtime
+func ParseTheme(theme Theme) (*TemplateHandler, error) {
+	files := theme.FS()
+	assets, err := LoadAssets(theme)
+	errs := []error{err}
+	asset := template.FuncMap{"asset": assets.Path}
+	parse := func(patterns ...string) *template.Template {
+		tmpl, err := template.New(path.Base(patterns[0])).Funcs(funcs).Funcs(asset).ParseFS(files, patterns...)
+		errs = append(errs, err)
+		return tmpl
+	}
+
+	loggedoutTemplates := []string{"index.html", "header.html", "localeSwitch.html"}
+	loggedinTemplates := []string{"index.html", "loggedinheader.html", "localeSwitch.html"}
+	adminTemplates := []string{"index.html", "admin/adminheader.html", "localeSwitch.html"}
+	homeTemplate := "content.html"
+	searchTemplate := "search.html"
+	searchResultTemplate := []string{"searchResult.html"}
+	loginTemplate := []string{"auth/login.html", "auth/passkey.html"}
+	forgotTemplate := "auth/forgot.html"
+	signupTemplate := "auth/signup.html"
+	dashboardTemplate := []string{"user/dashboard.html", "auth/passkey.html"}
+	dashboardUserTemplate := []string{"user/userBlocks.html"}
+	createTemplate := "create.html"
+	verificationTemplate := "auth/verification.html"
+	verMailTemplate := []string{"auth/verMail.html"}
+	passwordMailTemplate := []string{"auth/passwordMail.html"}
+	magicLinkTemplate := "auth/magicLink.html"
+	magicLinkMailTemplate := []string{"auth/magicLinkMail.html"}
+	adminTemplate := "admin/admin.html"
+	adminUserTemplate := []string{"admin/adminUserBlocks.html"}
+	invitationMailTemplate := []string{"auth/invitationMail.html"}
+	emailChangeMailTemplate := []string{"auth/emailChangeMail.html"}
+	emailChangeNoticeTemplate := []string{"auth/emailChangeNotice.html"}
+	invitationsTemplate := "admin/invitations.html"
+	auditTemplate := "admin/audit.html"
+	jobsTemplate := "admin/jobs.html"
+	digestMailTemplate := []string{"admin/digestMail.html"}
+
+	t := &TemplateHandler{
+		TmplHome:          parse(append(loggedoutTemplates, homeTemplate)...),
+		TmplSearch:        parse(append(loggedinTemplates, searchTemplate)...),
+		TmplSearchResult:  parse(searchResultTemplate...),
+		TmplLogin:         parse(append(loggedoutTemplates, loginTemplate...)...),
+		TmplForgot:        parse(append(loggedoutTemplates, forgotTemplate)...),
+		TmplSignUp:        parse(append(loggedoutTemplates, signupTemplate)...),
+		TmplDashboard:     parse(append(loggedinTemplates, dashboardTemplate...)...),
+		TmplDashboardUser: parse(dashboardUserTemplate...),
+		TmplCreate:        parse(append(loggedinTemplates, createTemplate)...),
+		TmplVerification:  parse(append(loggedoutTemplates, verificationTemplate)...),
+		TmplMagicLink:     parse(append(loggedoutTemplates, magicLinkTemplate)...),
+		TmplAdmin:         parse(append(adminTemplates, adminTemplate)...),
+		TmplAdminUser:     parse(adminUserTemplate...),
+		TmplInvitations:   parse(append(adminTemplates, invitationsTemplate)...),
+		TmplAudit:         parse(append(adminTemplates, auditTemplate)...),
+		TmplJobs:          parse(append(adminTemplates, jobsTemplate)...),
+		Mail: &MailTemplates{
+			TmplVerMail:           parse(verMailTemplate...),
+			TmplPasswordMail:      parse(passwordMailTemplate...),
+			TmplMagicLinkMail:     parse(magicLinkMailTemplate...),
+			TmplInvitationMail:    parse(invitationMailTemplate...),
+			TmplDigestMail:        parse(digestMailTemplate...),
+			TmplEmailChangeMail:   parse(emailChangeMailTemplate...),
+			TmplEmailChangeNotice: parse(emailChangeNoticeTemplate...),
+		},
+		Assets: assets,
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Loaded reports an error if a template of the handler is missing
+func (t *TemplateHandler) Loaded() error {
+	if t == nil {
+		return errors.New("templates are not loaded")
+	}
+	if err := loaded(t); err != nil {
+		return err
+	}
+	return loaded(t.Mail)
+}
+
+// loaded reports an error if a field of the struct pointed to by v is nil
+func loaded(v any) error {
+	value := reflect.ValueOf(v).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		if value.Field(i).IsNil() {
+			return errors.New("template " + value.Type().Field(i).Name + " is not loaded")
+		}
 	}
+	return nil
 }
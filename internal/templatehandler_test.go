@@ -0,0 +1,189 @@
+package templates_test
+
+import (
+	"bytes"
+	"io/fs"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	templates "github.com/led0nk/guestbook/internal"
+	"github.com/led0nk/guestbook/internal/i18n"
+	"github.com/led0nk/guestbook/internal/model"
+)
+
+const payload = `<script>alert("x")</script>" onmouseover="alert(1)`
+
+func TestTemplatesEscape(t *testing.T) {
+	handler, err := templates.ParseTemplates()
+	if err != nil {
+		t.Fatalf("Error parsing templates: %v", err)
+	}
+	entries := []*model.GuestbookEntry{{Name: payload, Message: payload + " & more"}}
+	user := &model.User{Name: payload, Email: payload, PendingEmail: payload}
+	mail := struct {
+		User   *model.User
+		Domain string
+		Link   string
+	}{User: user, Domain: payload, Link: "javascript:alert(1)"}
+
+	tests := []struct {
+		name    string
+		execute func(*bytes.Buffer) error
+		// expected in the output once escaped
+		escaped []string
+	}{
+		{
+			name: "Home",
+			execute: func(b *bytes.Buffer) error {
+				return templates.Execute(b, handler.TmplHome, "", templates.Request{}, &entries)
+			},
+			escaped: []string{"&lt;script&gt;", " &amp; more"},
+		},
+		{
+			name: "Search result",
+			execute: func(b *bytes.Buffer) error {
+				return templates.Execute(b, handler.TmplSearchResult, "result", templates.Request{}, entries)
+			},
+			escaped: []string{"&lt;script&gt;"},
+		},
+		{
+			name: "Admin user",
+			execute: func(b *bytes.Buffer) error {
+				return templates.Execute(b, handler.TmplAdminUser, "user", templates.Request{}, user)
+			},
+			escaped: []string{"&lt;script&gt;"},
+		},
+		{
+			name: "Admin user form",
+			execute: func(b *bytes.Buffer) error {
+				return templates.Execute(b, handler.TmplAdminUser, "user-update", templates.Request{}, user)
+			},
+			escaped: []string{`value="&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;&#34; onmouseover=&#34;alert(1)"`},
+		},
+		{
+			name: "Mail",
+			execute: func(b *bytes.Buffer) error {
+				return templates.Execute(b, handler.Mail.TmplMagicLinkMail, "", templates.Request{}, mail)
+			},
+			escaped: []string{"&lt;script&gt;", "#ZgotmplZ"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var body bytes.Buffer
+			if err := test.execute(&body); err != nil {
+				t.Fatalf("Error executing template: %v", err)
+			}
+			output := body.String()
+			for _, unsafe := range []string{`<script>alert`, `" onmouseover="`, "javascript:", "&amp;lt;"} {
+				if strings.Contains(output, unsafe) {
+					t.Errorf("Expected %q to be escaped once", unsafe)
+				}
+			}
+			for _, escaped := range test.escaped {
+				if !strings.Contains(output, escaped) {
+					t.Errorf("Expected output to contain %q", escaped)
+				}
+			}
+		})
+	}
+}
+
+func TestExecuteNonce(t *testing.T) {
+	handler, err := templates.ParseTemplates()
+	if err != nil {
+		t.Fatalf("Error parsing templates: %v", err)
+	}
+	for _, nonce := range []string{"first", "second"} {
+		var body bytes.Buffer
+		if err := templates.Execute(&body, handler.TmplLogin, "", templates.Request{Nonce: nonce}, nil); err != nil {
+			t.Fatalf("Error executing template: %v", err)
+		}
+		if !strings.Contains(body.String(), `<script nonce="`+nonce+`">`) {
+			t.Errorf("Expected script with nonce %q", nonce)
+		}
+	}
+}
+
+func TestTemplateKeys(t *testing.T) {
+	keys := map[string]bool{}
+	for _, key := range i18n.Keys(i18n.Default) {
+		keys[key] = true
+	}
+	used := regexp.MustCompile(`\bt "([^"]+)"`)
+	files := templates.Theme{}.FS()
+	err := fs.WalkDir(files, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || !strings.HasSuffix(name, ".html") {
+			return err
+		}
+		content, err := fs.ReadFile(files, name)
+		if err != nil {
+			return err
+		}
+		for _, match := range used.FindAllStringSubmatch(string(content), -1) {
+			if !keys[match[1]] {
+				t.Errorf("Expected key %q of %s in the catalog", match[1], name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error reading templates: %v", err)
+	}
+}
+
+func TestExecuteLocale(t *testing.T) {
+	handler, err := templates.ParseTemplates()
+	if err != nil {
+		t.Fatalf("Error parsing templates: %v", err)
+	}
+	entries := []*model.GuestbookEntry{{Name: "Anna", Message: "Hi", CreatedAt: time.Date(2026, time.May, 3, 18, 30, 0, 0, time.UTC).Format(time.RFC850)}}
+	invitation := struct {
+		Invitation *model.Invitation
+		Link       string
+	}{Invitation: &model.Invitation{Code: "abc", ExpiresAt: time.Date(2026, time.December, 24, 12, 0, 0, 0, time.UTC)}}
+
+	tests := []struct {
+		name     string
+		execute  func(*bytes.Buffer, templates.Request) error
+		expected map[i18n.Locale][]string
+	}{
+		{
+			name: "Home",
+			execute: func(b *bytes.Buffer, req templates.Request) error {
+				return templates.Execute(b, handler.TmplHome, "", req, &entries)
+			},
+			expected: map[i18n.Locale][]string{
+				i18n.English: {`<html lang="en">`, "May 3, 2026 18:30", "SignUp"},
+				i18n.German:  {`<html lang="de">`, "3. Mai 2026, 18:30", "Registrieren"},
+			},
+		},
+		{
+			name: "Invitation mail",
+			execute: func(b *bytes.Buffer, req templates.Request) error {
+				return templates.Execute(b, handler.Mail.TmplInvitationMail, "", req, invitation)
+			},
+			expected: map[i18n.Locale][]string{
+				i18n.English: {"Your invitation code is abc and is valid until December 24, 2026 12:00"},
+				i18n.German:  {"Dein Einladungscode ist abc und gilt bis 24. Dezember 2026, 12:00"},
+			},
+		},
+	}
+	for _, test := range tests {
+		for locale, expected := range test.expected {
+			t.Run(test.name+"/"+string(locale), func(t *testing.T) {
+				var body bytes.Buffer
+				if err := test.execute(&body, templates.Request{Locale: locale}); err != nil {
+					t.Fatalf("Error executing template: %v", err)
+				}
+				for _, text := range expected {
+					if !strings.Contains(body.String(), text) {
+						t.Errorf("Expected output to contain %q", text)
+					}
+				}
+			})
+		}
+	}
+}
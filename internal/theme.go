@@ -0,0 +1,81 @@
+package templates
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"os"
+	"strconv"
+)
+
+// Theme overrides the embedded templates and static assets by the files of a
+// directory with the same relative path, e.g. auth/login.html or
+// static/style.css, files missing in it fall back to the embedded ones
+type Theme struct {
+	// directory of the theme, empty for the embedded defaults
+	Dir string
+	// re-parses the templates once their files changed, for developing a theme
+	Dev bool
+}
+
+// embedded templates and static assets
+var embedded, _ = fs.Sub(templates, "templates")
+
+// overlay opens the files of dir and falls back to the files of base
+type overlay struct {
+	dir  fs.FS
+	base fs.FS
+}
+
+func (o overlay) Open(name string) (fs.File, error) {
+	f, err := o.dir.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.base.Open(name)
+	}
+	return f, err
+}
+
+// FS returns the files of the theme
+func (t Theme) FS() fs.FS {
+	if t.Dir == "" {
+		return embedded
+	}
+	return overlay{dir: os.DirFS(t.Dir), base: embedded}
+}
+
+// Stamp summarizes the names, sizes and modification times of the files of
+// the theme, it changes whenever a file is added, removed or modified
+func (t Theme) Stamp() (string, error) {
+	if t.Dir == "" {
+		return "", nil
+	}
+	h := fnv.New64a()
+	err := fs.WalkDir(os.DirFS(t.Dir), ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s %d %d\n", name, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	return strconv.FormatUint(h.Sum64(), 16), err
+}
+
+// Validate reports an error if the directory of the theme is missing
+func (t Theme) Validate() error {
+	if t.Dir == "" {
+		return nil
+	}
+	info, err := os.Stat(t.Dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errors.New(t.Dir + " is not a directory")
+	}
+	return nil
+}
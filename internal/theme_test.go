@@ -0,0 +1,128 @@
+package templates_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	templates "github.com/led0nk/guestbook/internal"
+)
+
+// writeTheme creates a theme directory with the files
+func writeTheme(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("Error creating directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("Error writing %s: %v", name, err)
+		}
+	}
+	return dir
+}
+
+func TestThemeTemplates(t *testing.T) {
+	dir := writeTheme(t, map[string]string{
+		"header.html": `{{ define "header" }}<header>Anna &amp; Ben</header>{{ end }}`,
+	})
+	handler, err := templates.ParseTheme(templates.Theme{Dir: dir})
+	if err != nil {
+		t.Fatalf("Error parsing theme: %v", err)
+	}
+	var body bytes.Buffer
+	if err := templates.Execute(&body, handler.TmplLogin, "", templates.Request{}, nil); err != nil {
+		t.Fatalf("Error executing template: %v", err)
+	}
+	if !strings.Contains(body.String(), "<header>Anna &amp; Ben</header>") {
+		t.Error("Expected the header of the theme")
+	}
+	if !strings.Contains(body.String(), `<form action="/login" method="post">`) {
+		t.Error("Expected the embedded login form")
+	}
+
+	broken := writeTheme(t, map[string]string{"header.html": `{{ define "header" }}`})
+	if _, err := templates.ParseTheme(templates.Theme{Dir: broken}); err == nil {
+		t.Error("Expected error for an invalid template")
+	}
+	if err := (templates.Theme{Dir: filepath.Join(dir, "missing")}).Validate(); err == nil {
+		t.Error("Expected error for a missing theme directory")
+	}
+}
+
+func TestThemeStatic(t *testing.T) {
+	dir := writeTheme(t, map[string]string{
+		"static/style.css": "body { background: ivory; }",
+		"static/logo.svg":  "<svg></svg>",
+	})
+	tests := []struct {
+		name   string
+		theme  templates.Theme
+		path   string
+		status int
+		body   string
+	}{
+		{name: "Embedded", path: "/style.css", status: http.StatusOK, body: "styles of the default theme"},
+		{name: "Override", theme: templates.Theme{Dir: dir}, path: "/style.css", status: http.StatusOK, body: "ivory"},
+		{name: "Theme only", theme: templates.Theme{Dir: dir}, path: "/logo.svg", status: http.StatusOK, body: "<svg>"},
+		{name: "Missing", theme: templates.Theme{Dir: dir}, path: "/missing.css", status: http.StatusNotFound},
+		{name: "Directory", theme: templates.Theme{Dir: dir}, path: "/", status: http.StatusNotFound},
+		{name: "Templates", theme: templates.Theme{Dir: dir}, path: "/../index.html", status: http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assets, err := templates.LoadAssets(test.theme)
+			if err != nil {
+				t.Fatalf("Error loading assets: %v", err)
+			}
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.URL.Path = test.path
+			assets.ServeHTTP(rec, req)
+			if rec.Code != test.status {
+				t.Fatalf("Expected status %d, got %d", test.status, rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), test.body) {
+				t.Errorf("Expected body to contain %q, got %q", test.body, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestThemeStamp(t *testing.T) {
+	dir := writeTheme(t, map[string]string{"header.html": "first"})
+	theme := templates.Theme{Dir: dir}
+	stamp := func() string {
+		stamp, err := theme.Stamp()
+		if err != nil {
+			t.Fatalf("Error reading stamp: %v", err)
+		}
+		return stamp
+	}
+
+	first := stamp()
+	if stamp() != first {
+		t.Error("Expected the same stamp for unchanged files")
+	}
+	if err := os.WriteFile(filepath.Join(dir, "header.html"), []byte("second"), 0o644); err != nil {
+		t.Fatalf("Error writing header.html: %v", err)
+	}
+	changed := stamp()
+	if changed == first {
+		t.Error("Expected another stamp for a modified file")
+	}
+	if err := os.WriteFile(filepath.Join(dir, "style.css"), nil, 0o644); err != nil {
+		t.Fatalf("Error writing style.css: %v", err)
+	}
+	if stamp() == changed {
+		t.Error("Expected another stamp for an added file")
+	}
+	if _, err := (templates.Theme{Dir: filepath.Join(dir, "missing")}).Stamp(); err == nil {
+		t.Error("Expected error for a missing theme directory")
+	}
+}
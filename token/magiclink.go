@@ -0,0 +1,95 @@
+package token
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MagicLinkExpiration is the lifetime of a login link sent by mail
+const MagicLinkExpiration = 15 * time.Minute
+
+const magicLinkPurpose = "magiclink"
+
+// CreateMagicLink returns a signed single-use token which logs in the user
+// with ID when redeemed
+func (t *TokenStorage) CreateMagicLink(ctx context.Context, ID uuid.UUID) (string, error) {
+	var span trace.Span
+	_, span = tracer.Start(ctx, "CreateMagicLink")
+	defer span.End()
+
+	if ID == uuid.Nil {
+		return "", errors.New("Cannot create magic link for empty User ID")
+	}
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+
+	span.AddEvent("sign token")
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"id":      ID.String(),
+		"jti":     base64.RawURLEncoding.EncodeToString(jti),
+		"purpose": magicLinkPurpose,
+		"exp":     time.Now().Add(MagicLinkExpiration).Unix(),
+	})
+	return token.SignedString([]byte(t.Secret))
+}
+
+// RedeemMagicLink validates the token and returns the ID of the user, every
+// token can only be redeemed once
+func (t *TokenStorage) RedeemMagicLink(ctx context.Context, tokenString string) (uuid.UUID, error) {
+	var span trace.Span
+	_, span = tracer.Start(ctx, "RedeemMagicLink")
+	defer span.End()
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(t.Secret), nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if claims["purpose"] != magicLinkPurpose {
+		return uuid.Nil, errors.New("token is not a magic link")
+	}
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return uuid.Nil, errors.New("magic link has no id")
+	}
+	exp, err := claims.GetExpirationTime()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	valueString, _ := claims["id"].(string)
+	ID, err := uuid.Parse(valueString)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	span.AddEvent("Lock")
+	t.mu.Lock()
+	defer span.AddEvent("Unlock")
+	defer t.mu.Unlock()
+
+	span.AddEvent("purge redeemed links")
+	for k, expiration := range t.Redeemed {
+		if expiration.Before(time.Now()) {
+			delete(t.Redeemed, k)
+		}
+	}
+	if _, redeemed := t.Redeemed[jti]; redeemed {
+		return uuid.Nil, errors.New("magic link was already used")
+	}
+	t.Redeemed[jti] = exp.Time
+	return ID, nil
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/led0nk/guestbook/internal/model"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/trace"
 )
@@ -22,14 +23,17 @@ type Token struct {
 
 type TokenStorage struct {
 	Tokens map[uuid.UUID]*Token
-	Secret string
-	mu     sync.Mutex
+	// IDs of redeemed magic links until they expire
+	Redeemed map[string]time.Time
+	Secret   string
+	mu       sync.Mutex
 }
 
 func CreateTokenService(secret string) (*TokenStorage, error) {
 	tokenService := &TokenStorage{
-		Tokens: make(map[uuid.UUID]*Token),
-		Secret: secret,
+		Tokens:   make(map[uuid.UUID]*Token),
+		Redeemed: make(map[string]time.Time),
+		Secret:   secret,
 	}
 	return tokenService, nil
 }
@@ -98,6 +102,27 @@ func (t *TokenStorage) DeleteToken(ctx context.Context, ID uuid.UUID) error {
 	return nil
 }
 
+// ListSessions returns the sessions of the user without their tokens
+func (t *TokenStorage) ListSessions(ctx context.Context, ID uuid.UUID) ([]model.Session, error) {
+	var span trace.Span
+	_, span = tracer.Start(ctx, "ListSessions")
+	defer span.End()
+
+	span.AddEvent("Lock")
+	t.mu.Lock()
+	defer span.AddEvent("Unlock")
+	defer t.mu.Unlock()
+	if ID == uuid.Nil {
+		return nil, errors.New("Cannot list sessions for empty User ID")
+	}
+
+	sessions := []model.Session{}
+	if token, exists := t.Tokens[ID]; exists {
+		sessions = append(sessions, model.Session{UserID: ID, ExpiresAt: token.Expiration})
+	}
+	return sessions, nil
+}
+
 func (t *TokenStorage) GetTokenValue(ctx context.Context, c *http.Cookie) (uuid.UUID, error) {
 	var span trace.Span
 	_, span = tracer.Start(ctx, "GetTokenValue")
@@ -124,18 +149,20 @@ func (t *TokenStorage) Valid(ctx context.Context, val string) (bool, error) {
 	_, span = tracer.Start(ctx, "Valid")
 	defer span.End()
 
-	span.AddEvent("range over tokens")
-	for _, token := range t.Tokens {
+	span.AddEvent("Lock")
+	t.mu.Lock()
+	defer span.AddEvent("Unlock")
+	defer t.mu.Unlock()
 
-		if token.Token == val {
-			if token.Expiration.Before(time.Now()) {
-				return false, errors.New("Token expired")
-			}
-			return true, nil
-		}
+	span.AddEvent("range over tokens")
+	token := t.find(val)
+	if token == nil {
+		return false, errors.New("Token was not found")
 	}
-
-	return false, errors.New("Token was not found")
+	if token.Expiration.Before(time.Now()) {
+		return false, errors.New("Token expired")
+	}
+	return true, nil
 }
 
 func (t *TokenStorage) Refresh(ctx context.Context, val string) (*http.Cookie, error) {
@@ -147,6 +174,16 @@ func (t *TokenStorage) Refresh(ctx context.Context, val string) (*http.Cookie, e
 		return nil, errors.New("refresh failed, empty value")
 	}
 
+	span.AddEvent("Lock")
+	t.mu.Lock()
+	defer span.AddEvent("Unlock")
+	defer t.mu.Unlock()
+
+	//NOTE: the session may have been purged since it was validated
+	if t.find(val) == nil {
+		return nil, errors.New("refresh failed, token was not found")
+	}
+
 	span.AddEvent("set cookie values")
 	cookie := http.Cookie{
 		Name:    "session",
@@ -160,3 +197,44 @@ func (t *TokenStorage) Refresh(ctx context.Context, val string) (*http.Cookie, e
 	}
 	return &cookie, nil
 }
+
+// find returns the stored token with the value val or nil, it expects the lock
+// to be held
+func (t *TokenStorage) find(val string) *Token {
+	for _, token := range t.Tokens {
+		if token.Token == val {
+			return token
+		}
+	}
+	return nil
+}
+
+// PurgeExpired deletes expired sessions and redeemed magic links which
+// expired anyway and returns the number of deleted sessions
+func (t *TokenStorage) PurgeExpired(ctx context.Context) (int, error) {
+	var span trace.Span
+	_, span = tracer.Start(ctx, "PurgeExpired")
+	defer span.End()
+
+	span.AddEvent("Lock")
+	t.mu.Lock()
+	defer span.AddEvent("Unlock")
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	purged := 0
+	span.AddEvent("purge sessions")
+	for ID, token := range t.Tokens {
+		if token.Expiration.Before(now) {
+			delete(t.Tokens, ID)
+			purged++
+		}
+	}
+	span.AddEvent("purge redeemed links")
+	for k, expiration := range t.Redeemed {
+		if expiration.Before(now) {
+			delete(t.Redeemed, k)
+		}
+	}
+	return purged, nil
+}
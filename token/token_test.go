@@ -0,0 +1,54 @@
+package token_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/led0nk/guestbook/token"
+)
+
+func TestValidDuringPurge(t *testing.T) {
+	ctx := context.Background()
+	storage, err := token.CreateTokenService("s3cr3t")
+	if err != nil {
+		t.Fatalf("Error creating token service: %v", err)
+	}
+	cookie, err := storage.CreateToken(ctx, "session", "localhost", uuid.New(), false)
+	if err != nil {
+		t.Fatalf("Error creating token: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if _, err := storage.Valid(ctx, cookie.Value); err != nil {
+					t.Errorf("Expected token to be valid, got %v", err)
+				}
+				if _, err := storage.Refresh(ctx, cookie.Value); err != nil {
+					t.Errorf("Expected token to be refreshed, got %v", err)
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if _, err := storage.CreateToken(ctx, "session", "localhost", uuid.New(), false); err != nil {
+					t.Errorf("Error creating token: %v", err)
+				}
+				if _, err := storage.PurgeExpired(ctx); err != nil {
+					t.Errorf("Error purging tokens: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if _, err := storage.Refresh(ctx, "unknown"); err == nil {
+		t.Errorf("Expected refresh of an unknown token to fail")
+	}
+}